@@ -0,0 +1,47 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/detective"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+)
+
+// ClientFactory creates AWS service clients used by inviters. Cross-cutting options like user agent and
+// rate limiter are not set by the factory but by the session, see SessionConfig, so that they reach
+// all clients including STS, Organizations and AWS Config ones which are created outside of the factory.
+type ClientFactory struct{}
+
+// NewClientFactory creates new instance of ClientFactory.
+func NewClientFactory() *ClientFactory {
+	return &ClientFactory{}
+}
+
+// GuardDuty creates GuardDuty client for provided session.
+func (f *ClientFactory) GuardDuty(sess client.ConfigProvider) *guardduty.GuardDuty {
+	return guardduty.New(sess)
+}
+
+// SecurityHub creates Security Hub client for provided session.
+func (f *ClientFactory) SecurityHub(sess client.ConfigProvider) *securityhub.SecurityHub {
+	return securityhub.New(sess)
+}
+
+// Detective creates Detective client for provided session.
+func (f *ClientFactory) Detective(sess client.ConfigProvider) *detective.Detective {
+	return detective.New(sess)
+}
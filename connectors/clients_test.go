@@ -0,0 +1,77 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/detective"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientFactory(t *testing.T) {
+	// user agent is set by sessions, and reaches clients created by the factory
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws",
+		MemberAccountID: "112233445566", MemberRole: "test_role", UserAgent: "mock-agent/1.0"})
+	inviters := NewInviters(masterSess, memberSess, InvitersConfig{
+		GuardDuty:     true,
+		SecurityHub:   true,
+		Detective:     true,
+		ClientFactory: NewClientFactory(),
+	})
+	require.Len(t, inviters, 3)
+
+	gd := inviters[0].(*GuardDutyInviter).masterSvc.(*guardduty.GuardDuty)
+	sh := inviters[1].(*SecurityHubInviter).memberSvc.(*securityhub.SecurityHub)
+	d := inviters[2].(*DetectiveInviter).masterSvc.(*detective.Detective)
+
+	testData := []struct {
+		description string
+		req         *request.Request
+	}{
+		{description: "guardduty", req: func() *request.Request {
+			r, _ := gd.ListDetectorsRequest(&guardduty.ListDetectorsInput{})
+			return r
+		}()},
+		{description: "security hub", req: func() *request.Request {
+			r, _ := sh.ListInvitationsRequest(&securityhub.ListInvitationsInput{})
+			return r
+		}()},
+		{description: "detective", req: func() *request.Request {
+			r, _ := d.ListGraphsRequest(&detective.ListGraphsInput{})
+			return r
+		}()},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			require.NoError(t, x.req.Build(), "Test case %d build check failed", i)
+			assert.Contains(t, x.req.HTTPRequest.Header.Get("User-Agent"), "mock-agent/1.0",
+				"Test case %d user agent check failed", i)
+		})
+	}
+
+	// factory doesn't add anything to user agent by itself
+	r, _ := NewClientFactory().GuardDuty(unit.Session).ListDetectorsRequest(&guardduty.ListDetectorsInput{})
+	require.NoError(t, r.Build())
+	assert.NotContains(t, r.HTTPRequest.Header.Get("User-Agent"), "mock-agent")
+}
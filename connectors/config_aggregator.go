@@ -0,0 +1,110 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/configservice"
+)
+
+// ConfigAggregatorConfigurer is a structure which contains all information for aggregating AWS Config data
+// of all organization accounts in master account, which should be AWS Config delegated administrator
+// of the organization or its management account.
+type ConfigAggregatorConfigurer struct {
+	adminSvc ConfigAggregatorClient
+	name     string
+	roleARN  string
+}
+
+// ConfigAggregatorClient is a subset of aws-sdk-go/service/configservice which is used for configuring
+// organization aggregator.
+type ConfigAggregatorClient interface {
+	DescribeConfigurationAggregators(*configservice.DescribeConfigurationAggregatorsInput) (*configservice.DescribeConfigurationAggregatorsOutput, error)
+	PutConfigurationAggregator(*configservice.PutConfigurationAggregatorInput) (*configservice.PutConfigurationAggregatorOutput, error)
+}
+
+// NewConfigAggregatorConfigurer creates new instance of ConfigAggregatorConfigurer which configures aggregator
+// with provided name in adminSess account and region, AWS Config reads organization using role with provided ARN.
+func NewConfigAggregatorConfigurer(adminSess client.ConfigProvider, name, roleARN string) *ConfigAggregatorConfigurer {
+	return &ConfigAggregatorConfigurer{
+		adminSvc: configservice.New(adminSess),
+		name:     name,
+		roleARN:  roleARN,
+	}
+}
+
+// Name returns "config_aggregator", identifier of the service.
+func (c ConfigAggregatorConfigurer) Name() string {
+	return "config_aggregator"
+}
+
+// ConfigureAggregator creates organization aggregator of provided regions, or updates existing one
+// in case its role or regions differ. In case it's in place already, nothing is done.
+// https://docs.aws.amazon.com/config/latest/developerguide/aggregate-data.html
+func (c ConfigAggregatorConfigurer) ConfigureAggregator(regions []string) (Result, error) {
+	res, err := c.configureAggregator(regions)
+	return res, newServiceError(c.Name(), err)
+}
+
+func (c ConfigAggregatorConfigurer) configureAggregator(regions []string) (Result, error) {
+	regions = sortedCopy(regions)
+	aggregator, err := getConfigAggregator(c.adminSvc, c.name)
+	if err != nil {
+		return Result{Status: StatusFailed}, err
+	}
+	if aggregator != nil {
+		source := aggregator.OrganizationAggregationSource
+		if source != nil && aws.StringValue(source.RoleArn) == c.roleARN && !aws.BoolValue(source.AllAwsRegions) &&
+			strings.Join(sortedCopy(aws.StringValueSlice(source.AwsRegions)), ",") == strings.Join(regions, ",") {
+			return Result{Status: StatusAlreadyConnected}, nil
+		}
+	}
+
+	_, err = c.adminSvc.PutConfigurationAggregator(&configservice.PutConfigurationAggregatorInput{
+		ConfigurationAggregatorName: aws.String(c.name),
+		OrganizationAggregationSource: &configservice.OrganizationAggregationSource{
+			RoleArn:    aws.String(c.roleARN),
+			AwsRegions: aws.StringSlice(regions),
+		},
+	})
+	if err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error putting configuration aggregator: %w", err)
+	}
+	return Result{Status: StatusUpdated}, nil
+}
+
+// getConfigAggregator returns configuration aggregator with provided name, or nil in case there is none
+func getConfigAggregator(c ConfigAggregatorClient, name string) (*configservice.ConfigurationAggregator, error) {
+	input := &configservice.DescribeConfigurationAggregatorsInput{}
+	for {
+		out, err := c.DescribeConfigurationAggregators(input)
+		if err != nil {
+			return nil, fmt.Errorf("error describing configuration aggregators: %w", err)
+		}
+		for _, aggregator := range out.ConfigurationAggregators {
+			if aws.StringValue(aggregator.ConfigurationAggregatorName) == name {
+				return aggregator, nil
+			}
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			return nil, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigAggregatorConfigurer_ConfigureAggregator(t *testing.T) {
+	var (
+		name    = "organization"
+		roleARN = "arn:aws:iam::123456789012:role/config-aggregator"
+		regions = []string{"us-east-1", "eu-west-1"}
+		badDReq = cfgDescribeAggregatorsReq{err: fmt.Errorf("mock err")}
+		noDReq  = cfgDescribeAggregatorsReq{pages: []*configservice.DescribeConfigurationAggregatorsOutput{
+			{ConfigurationAggregators: []*configservice.ConfigurationAggregator{
+				{ConfigurationAggregatorName: aws.String("other")}}}}}
+		aggregatorDReq = func(source *configservice.OrganizationAggregationSource) cfgDescribeAggregatorsReq {
+			return cfgDescribeAggregatorsReq{pages: []*configservice.DescribeConfigurationAggregatorsOutput{
+				{NextToken: aws.String("page2")},
+				{ConfigurationAggregators: []*configservice.ConfigurationAggregator{{
+					ConfigurationAggregatorName: aws.String(name), OrganizationAggregationSource: source}}},
+			}}
+		}
+		equalDReq = aggregatorDReq(&configservice.OrganizationAggregationSource{
+			RoleArn: aws.String(roleARN), AwsRegions: aws.StringSlice([]string{"us-east-1", "eu-west-1"})})
+		otherRoleDReq = aggregatorDReq(&configservice.OrganizationAggregationSource{
+			RoleArn: aws.String("arn:aws:iam::123456789012:role/old"), AwsRegions: aws.StringSlice(regions)})
+		otherRegionsDReq = aggregatorDReq(&configservice.OrganizationAggregationSource{
+			RoleArn: aws.String(roleARN), AwsRegions: aws.StringSlice([]string{"eu-west-1"})})
+		allRegionsDReq = aggregatorDReq(&configservice.OrganizationAggregationSource{
+			RoleArn: aws.String(roleARN), AllAwsRegions: aws.Bool(true)})
+		accountsDReq = aggregatorDReq(nil)
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		status      Status
+		dReq        cfgDescribeAggregatorsReq
+		putErr      error
+		put         bool
+	}{
+		{description: "problem describing aggregators",
+			dReq:  badDReq,
+			error: "error describing configuration aggregators: mock err"},
+		{description: "problem creating aggregator",
+			dReq:   noDReq,
+			putErr: fmt.Errorf("mock err"),
+			put:    true,
+			error:  "error putting configuration aggregator: mock err"},
+		{description: "aggregator created",
+			dReq:   noDReq,
+			put:    true,
+			status: StatusUpdated},
+		{description: "aggregator configured already",
+			dReq:   equalDReq,
+			status: StatusAlreadyConnected},
+		{description: "problem updating aggregator",
+			dReq:   otherRoleDReq,
+			putErr: fmt.Errorf("mock err"),
+			put:    true,
+			error:  "error putting configuration aggregator: mock err"},
+		{description: "aggregator role updated",
+			dReq:   otherRoleDReq,
+			put:    true,
+			status: StatusUpdated},
+		{description: "aggregator regions updated",
+			dReq:   otherRegionsDReq,
+			put:    true,
+			status: StatusUpdated},
+		{description: "aggregator of all regions updated",
+			dReq:   allRegionsDReq,
+			put:    true,
+			status: StatusUpdated},
+		{description: "account aggregator updated",
+			dReq:   accountsDReq,
+			put:    true,
+			status: StatusUpdated},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockConfigAggregatorClient{t: t, name: name, roleARN: roleARN, regions: []string{"eu-west-1", "us-east-1"},
+				dReq: x.dReq, putErr: x.putErr}
+			c := ConfigAggregatorConfigurer{adminSvc: m, name: name, roleARN: roleARN}
+			res, err := c.ConfigureAggregator(regions)
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				assert.Equal(t, StatusFailed, res.Status, "Test case %d status check failed", i)
+				var serviceErr *ServiceError
+				if assert.ErrorAs(t, err, &serviceErr, "Test case %d error type check failed", i) {
+					assert.Equal(t, "config_aggregator", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+				assert.Equal(t, x.status, res.Status, "Test case %d status check failed", i)
+			}
+			assert.Equal(t, x.put, m.put, "Test case %d aggregator put check failed", i)
+		})
+	}
+}
+
+type mockConfigAggregatorClient struct {
+	t       *testing.T
+	name    string
+	roleARN string
+	// regions are expected to be put in sorted order
+	regions []string
+	dReq    cfgDescribeAggregatorsReq
+	dCalls  int
+	putErr  error
+	put     bool
+}
+
+type cfgDescribeAggregatorsReq struct {
+	pages []*configservice.DescribeConfigurationAggregatorsOutput
+	err   error
+}
+
+func (c *mockConfigAggregatorClient) DescribeConfigurationAggregators(input *configservice.DescribeConfigurationAggregatorsInput) (*configservice.DescribeConfigurationAggregatorsOutput, error) {
+	if c.dReq.err != nil {
+		return nil, c.dReq.err
+	}
+	// every page after the first one is requested with the token of the previous page
+	expected := &configservice.DescribeConfigurationAggregatorsInput{}
+	if c.dCalls > 0 {
+		expected.NextToken = c.dReq.pages[c.dCalls-1].NextToken
+	}
+	assert.Equal(c.t, expected, input)
+	page := c.dReq.pages[c.dCalls]
+	c.dCalls++
+	return page, nil
+}
+
+func (c *mockConfigAggregatorClient) PutConfigurationAggregator(input *configservice.PutConfigurationAggregatorInput) (*configservice.PutConfigurationAggregatorOutput, error) {
+	assert.Equal(c.t, &configservice.PutConfigurationAggregatorInput{
+		ConfigurationAggregatorName: aws.String(c.name),
+		OrganizationAggregationSource: &configservice.OrganizationAggregationSource{
+			RoleArn:    aws.String(c.roleARN),
+			AwsRegions: aws.StringSlice(c.regions),
+		},
+	}, input)
+	c.put = true
+	return &configservice.PutConfigurationAggregatorOutput{}, c.putErr
+}
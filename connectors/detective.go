@@ -15,10 +15,14 @@
 package connectors
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/service/detective"
+	log "github.com/sirupsen/logrus"
 )
 
 // DetectiveInviter is a per-region structure which contains all information
@@ -26,14 +30,43 @@ import (
 type DetectiveInviter struct {
 	masterSvc DetectiveMasterClient
 	memberSvc DetectiveMemberClient
+	// packages are optional data source packages, like EKS audit logs, to enable for master graph,
+	// which applies to all its members
+	packages []string
+	// connectedStatuses are member statuses meaning that member is connected to master, "ENABLED" if empty
+	connectedStatuses []string
+	// waiter polls member status after invitation is accepted until it's enabled, no waiting is done if nil
+	waiter *memberWaiter
+	// retryer retries looking for just sent invitation in member account, it's looked for once if nil
+	retryer *invitationRetryer
+	// log is an entry with context fields, like account ID and region, used for all messages
+	log *log.Entry
 }
 
 // DetectiveMasterClient is a subset of aws-sdk-go/service/detective which is used for sending
 // invitations from Detective master.
 type DetectiveMasterClient interface {
 	GetMembers(*detective.GetMembersInput) (*detective.GetMembersOutput, error)
+	ListMembers(*detective.ListMembersInput) (*detective.ListMembersOutput, error)
 	CreateMembers(*detective.CreateMembersInput) (*detective.CreateMembersOutput, error)
 	ListGraphs(*detective.ListGraphsInput) (*detective.ListGraphsOutput, error)
+	ListOrganizationAdminAccounts(*detective.ListOrganizationAdminAccountsInput) (*detective.ListOrganizationAdminAccountsOutput, error)
+	EnableOrganizationAdminAccount(*detective.EnableOrganizationAdminAccountInput) (*detective.EnableOrganizationAdminAccountOutput, error)
+	ListDatasourcePackages(*detective.ListDatasourcePackagesInput) (*detective.ListDatasourcePackagesOutput, error)
+	UpdateDatasourcePackages(*detective.UpdateDatasourcePackagesInput) (*detective.UpdateDatasourcePackagesOutput, error)
+}
+
+// Optional Detective data source packages which can be enabled, in addition to always enabled core one
+const (
+	DetectivePackageEKSAudit = "eks_audit"
+)
+
+// detectivePackageID returns data source package name used by Detective API, or false in case package is unknown
+func detectivePackageID(p string) (string, bool) {
+	if p == DetectivePackageEKSAudit {
+		return detective.DatasourcePackageEksAudit, true
+	}
+	return "", false
 }
 
 // DetectiveMemberClient is a subset of aws-sdk-go/service/detective which is used for accepting
@@ -44,67 +77,246 @@ type DetectiveMemberClient interface {
 }
 
 // NewDetectiveInviter creates new instance of DetectiveInviter which is capable of inviting
-// specified member account to master account Detective
-func NewDetectiveInviter(masterSess, memberSess client.ConfigProvider) *DetectiveInviter {
+// specified member account to master account Detective, and enabling provided data source packages for master graph
+func NewDetectiveInviter(masterSess, memberSess client.ConfigProvider, packages []string) *DetectiveInviter {
+	return NewDetectiveInviterWithFactory(NewClientFactory(), masterSess, memberSess, packages)
+}
+
+// NewDetectiveInviterWithFactory is the same as NewDetectiveInviter, but creates clients with provided factory.
+func NewDetectiveInviterWithFactory(f *ClientFactory, masterSess, memberSess client.ConfigProvider, packages []string) *DetectiveInviter {
 	return &DetectiveInviter{
-		masterSvc: detective.New(masterSess),
-		memberSvc: detective.New(memberSess),
+		masterSvc: f.Detective(masterSess),
+		memberSvc: f.Detective(memberSess),
+		packages:  packages,
+		log:       log.NewEntry(log.StandardLogger()),
+	}
+}
+
+// ParseDetectivePackages parses comma-separated list of Detective data source packages,
+// returning error in case of unknown package
+func ParseDetectivePackages(s string) ([]string, error) {
+	var packages []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, ok := detectivePackageID(p); !ok {
+			return nil, fmt.Errorf("unknown Detective data source package %q", p)
+		}
+		packages = append(packages, p)
 	}
+	return packages, nil
+}
+
+// Name returns "detective", identifier of the service.
+func (d DetectiveInviter) Name() string {
+	return "detective"
 }
 
 // AddMember adds new member account to master, sends invite to it,
 // and then accepts invite from the member account.
 // In case the member is already in place and connected (enabled), nothing is done.
+// Returned error is *ServiceError, which can be inspected with errors.Is and errors.As.
 // https://docs.aws.amazon.com/detective/latest/userguide/detective-accounts.html
-func (d DetectiveInviter) AddMember(accountID, accountEmail, masterAccountID string) error {
+func (d DetectiveInviter) AddMember(accountID, accountEmail, masterAccountID string) (Result, error) {
+	res, err := d.addMember(accountID, accountEmail, masterAccountID)
+	logResult(d.log.WithField("service", d.Name()), res, err)
+	return res, newServiceError(d.Name(), err)
+}
+
+func (d DetectiveInviter) addMember(accountID, accountEmail, masterAccountID string) (Result, error) {
 	graphARN, err := getGraphARN(d.masterSvc)
 	if err != nil {
-		return fmt.Errorf("can't get graphARN of master account: %w", err)
+		return Result{Status: StatusFailed}, fmt.Errorf("can't get graphARN of master account: %w", err)
+	}
+
+	status, err := getDetectiveMemberStatus(d.masterSvc, graphARN, &accountID)
+	if err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return Result{Status: StatusFailed}, fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	connected := connectedStatuses(d.connectedStatuses, detectiveConnectedStatus)
+	if contains(connected, status) {
+		updated, err := enableDetectiveGraphPackages(d.masterSvc, graphARN, d.packages)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error enabling data source packages: %w", err)
+		}
+		if updated {
+			return Result{Status: StatusUpdated}, nil
+		}
+		return Result{Status: StatusAlreadyConnected}, nil
+	}
+
+	// invited member already has the invitation sent, so only accepting it is left
+	if status != detective.MemberStatusInvited {
+		err = setUpDetectiveMaster(d.masterSvc, graphARN, &accountID, &accountEmail)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error setting up master account: %w", err)
+		}
 	}
 
-	connected, err := ifDetectiveMemberAlreadyEnabled(d.masterSvc, graphARN, &accountID)
+	accept := func() error { return acceptDetectiveMemberInvitation(d.memberSvc, &masterAccountID, graphARN) }
+	// invitation which was just sent might not be visible in member account yet, so looking for it is retried
+	var resent bool
+	if status == detective.MemberStatusInvited {
+		err = accept()
+	} else {
+		err = d.retryer.accept(accept)
+	}
+	if errors.Is(err, ErrInvitationMissing) && status == detective.MemberStatusInvited {
+		// invitation might have expired, so it's sent again
+		d.log.WithField("service", d.Name()).Info("Invitation not found, re-sending it")
+		err = setUpDetectiveMaster(d.masterSvc, graphARN, &accountID, &accountEmail)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error re-sending invitation: %w", err)
+		}
+		resent = true
+		err = d.retryer.accept(accept)
+	}
 	if err != nil {
-		return fmt.Errorf("error retrieving information about existing member account: %w", err)
+		return Result{Status: StatusFailed}, fmt.Errorf("error accepting invitation in member account: %w", err)
 	}
-	if connected {
-		return nil
+
+	if d.waiter != nil {
+		err = d.waiter.waitForEnabled(func() (string, error) {
+			return getDetectiveMemberStatus(d.masterSvc, graphARN, &accountID)
+		}, connected, detectivePendingMemberStatuses())
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error waiting for member account to be enabled: %w", err)
+		}
 	}
 
-	err = setUpDetectiveMaster(d.masterSvc, graphARN, &accountID, &accountEmail)
+	if _, err = enableDetectiveGraphPackages(d.masterSvc, graphARN, d.packages); err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error enabling data source packages: %w", err)
+	}
+
+	return addMemberResult(status, detective.MemberStatusInvited, resent), nil
+}
+
+// EnableOrgAdmin registers provided account as Detective delegated administrator of the organization
+// in case it's not registered already. Inviter should be created with the organization management account
+// session as master session for this call.
+// https://docs.aws.amazon.com/detective/latest/adminguide/accounts-designate-admin.html
+func (d DetectiveInviter) EnableOrgAdmin(adminAccountID string) error {
+	input := &detective.ListOrganizationAdminAccountsInput{}
+	for {
+		admins, err := d.masterSvc.ListOrganizationAdminAccounts(input)
+		if err != nil {
+			return newServiceError(d.Name(), fmt.Errorf("error listing delegated administrators: %w", err))
+		}
+		for _, admin := range admins.Administrators {
+			if aws.StringValue(admin.AccountId) == adminAccountID {
+				return nil
+			}
+			return newServiceError(d.Name(),
+				fmt.Errorf("account %s is delegated administrator already", aws.StringValue(admin.AccountId)))
+		}
+		if aws.StringValue(admins.NextToken) == "" {
+			break
+		}
+		input.NextToken = admins.NextToken
+	}
+
+	_, err := d.masterSvc.EnableOrganizationAdminAccount(&detective.EnableOrganizationAdminAccountInput{
+		AccountId: aws.String(adminAccountID),
+	})
 	if err != nil {
-		return fmt.Errorf("error setting up master account: %w", err)
+		return newServiceError(d.Name(), fmt.Errorf("error enabling delegated administrator: %w", err))
 	}
+	return nil
+}
 
-	err = acceptDetectiveMemberInvitation(d.memberSvc, &masterAccountID)
+// MemberStatuses returns relationship statuses of all members of master account graph, keyed by member account ID.
+func (d DetectiveInviter) MemberStatuses() (map[string]string, error) {
+	graphARN, err := getGraphARN(d.masterSvc)
 	if err != nil {
-		return fmt.Errorf("error accepting invitation in member account: %w", err)
+		return nil, newServiceError(d.Name(), fmt.Errorf("can't get graphARN of master account: %w", err))
 	}
+	statuses, err := listDetectiveMemberStatuses(d.masterSvc, graphARN)
+	return statuses, newServiceError(d.Name(), err)
+}
+
+// MemberStatus returns status of member account in master graph, or MemberStatusNotMember
+// in case member account is not present there. Only read-only calls are made.
+func (d DetectiveInviter) MemberStatus(accountID string) (string, error) {
+	status, err := d.memberStatus(accountID)
+	return status, newServiceError(d.Name(), err)
+}
+
+func (d DetectiveInviter) memberStatus(accountID string) (string, error) {
+	graphARN, err := getGraphARN(d.masterSvc)
+	if err != nil {
+		return "", fmt.Errorf("can't get graphARN of master account: %w", err)
+	}
+	status, err := getDetectiveMemberStatus(d.masterSvc, graphARN, &accountID)
+	if err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return "", fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	return memberStatusOrNotMember(status), nil
+}
 
+// Preflight verifies permissions of master and member accounts by performing only read-only calls
+// which AddMember makes, nothing is changed.
+func (d DetectiveInviter) Preflight(accountID string) error {
+	return newServiceError(d.Name(), d.preflight(accountID))
+}
+
+func (d DetectiveInviter) preflight(accountID string) error {
+	graphARN, err := getGraphARN(d.masterSvc)
+	if err != nil {
+		return fmt.Errorf("can't get graphARN of master account: %w", err)
+	}
+	if _, err = getDetectiveMemberStatus(d.masterSvc, graphARN, &accountID); err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	if _, err = d.memberSvc.ListInvitations(nil); err != nil {
+		return fmt.Errorf("error retrieving list of invitations: %w", err)
+	}
 	return nil
 }
 
-// ifDetectiveMemberAlreadyEnabled checks if member account is already present
-// in master and is in Associated state.
-func ifDetectiveMemberAlreadyEnabled(d DetectiveMasterClient, graphARN, memberAccountID *string) (bool, error) {
+// listDetectiveMemberStatuses returns statuses of all graph members
+func listDetectiveMemberStatuses(d DetectiveMasterClient, graphARN *string) (map[string]string, error) {
+	return membersByStatus(func(nextToken *string) (map[string]string, *string, error) {
+		members, err := d.ListMembers(&detective.ListMembersInput{
+			GraphArn:  graphARN,
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		statuses := map[string]string{}
+		for _, m := range members.MemberDetails {
+			statuses[aws.StringValue(m.AccountId)] = aws.StringValue(m.Status)
+		}
+		return statuses, members.NextToken, nil
+	})
+}
+
+// getDetectiveMemberStatus returns status of member account in master,
+// ErrMemberNotFound is returned in case member account is not present there.
+func getDetectiveMemberStatus(d DetectiveMasterClient, graphARN, memberAccountID *string) (string, error) {
 	members, err := d.GetMembers(&detective.GetMembersInput{
 		AccountIds: []*string{memberAccountID},
 		GraphArn:   graphARN,
 	})
 	if err != nil {
-		return false, fmt.Errorf("error getting existing members: %w", err)
+		return "", fmt.Errorf("error getting existing members: %w", err)
 	}
 
 	// Search conditions looking for particular account and we expect to get either zero results
-	// (account is not yet connected) or one result (account is connected with either Invited or Enabled status).
-	// Situation with more than single member in the results is impossible but yet be handled correctly by this code.
-	if len(members.MemberDetails) == 1 {
-		if *members.MemberDetails[0].Status == "Enabled" {
-			return true, nil
-		}
+	// (account is not yet connected, ErrMemberNotFound is returned) or one result
+	// (account is connected with either INVITED or ENABLED status).
+	// More than single member in the results means the service misbehaves, which is reported as an error.
+	switch len(members.MemberDetails) {
+	case 0:
+		return "", ErrMemberNotFound
+	case 1:
+		// member without status is treated as not connected
+		return aws.StringValue(members.MemberDetails[0].Status), nil
+	default:
+		return "", &UnexpectedMembersError{AccountID: aws.StringValue(memberAccountID), Count: len(members.MemberDetails)}
 	}
-
-	// The check didn't fail but didn't found that member account is in Enabled state, returning no error.
-	return false, nil
 }
 
 // setUpDetectiveMaster creates new member account and sends invite to it.
@@ -123,31 +335,82 @@ func setUpDetectiveMaster(d DetectiveMasterClient, graphARN, memberAccountID, em
 	return nil
 }
 
-// acceptDetectiveMemberInvitation looks for invitation from specified master account and accepts it
-func acceptDetectiveMemberInvitation(d DetectiveMemberClient, masterAccountID *string) error {
-	invitations, err := d.ListInvitations(nil)
-	if err != nil {
-		return fmt.Errorf("error retrieving list of invitations: %w", err)
+// enableDetectiveGraphPackages enables provided data source packages for the whole graph, including all its members,
+// in case they are not ingested in the graph yet, and returns if the update was made
+func enableDetectiveGraphPackages(d DetectiveMasterClient, graphARN *string, packages []string) (bool, error) {
+	if len(packages) == 0 {
+		return false, nil
 	}
-	var graphArn *string
-	for _, inv := range invitations.Invitations {
-		if *inv.AccountId == *masterAccountID {
-			graphArn = inv.GraphArn
+
+	states := map[string]string{}
+	input := &detective.ListDatasourcePackagesInput{GraphArn: graphARN}
+	for {
+		res, err := d.ListDatasourcePackages(input)
+		if err != nil {
+			return false, fmt.Errorf("error listing graph data source packages: %w", err)
+		}
+		for id, detail := range res.DatasourcePackages {
+			states[id] = aws.StringValue(detail.DatasourcePackageIngestState)
+		}
+		if res.NextToken == nil {
 			break
 		}
+		input.NextToken = res.NextToken
+	}
+
+	var toEnable []*string
+	for _, p := range packages {
+		id, _ := detectivePackageID(p)
+		if states[id] != detective.DatasourcePackageIngestStateStarted {
+			toEnable = append(toEnable, aws.String(id))
+		}
 	}
-	if graphArn == nil {
-		return fmt.Errorf("can't find invitation from master account")
+	if len(toEnable) == 0 {
+		return false, nil
 	}
 
-	_, err = d.AcceptInvitation(&detective.AcceptInvitationInput{
-		GraphArn: graphArn,
+	_, err := d.UpdateDatasourcePackages(&detective.UpdateDatasourcePackagesInput{
+		DatasourcePackages: toEnable,
+		GraphArn:           graphARN,
 	})
 	if err != nil {
-		return fmt.Errorf("error accepting invitation: %w", err)
+		return false, fmt.Errorf("error updating data source packages: %w", err)
 	}
 
-	return nil
+	return true, nil
+}
+
+// acceptDetectiveMemberInvitation looks for invitation from specified master account to provided graph
+// and accepts it, as the master account might have invitations to other graphs pending
+func acceptDetectiveMemberInvitation(d DetectiveMemberClient, masterAccountID, graphARN *string) error {
+	list := func() ([]memberInvitation, error) {
+		res, err := d.ListInvitations(nil)
+		if err != nil {
+			return nil, err
+		}
+		invitations := make([]memberInvitation, 0, len(res.Invitations))
+		for _, inv := range res.Invitations {
+			invitations = append(invitations, memberInvitation{
+				accountID: aws.StringValue(inv.AccountId),
+				id:        aws.StringValue(inv.GraphArn),
+			})
+		}
+		return invitations, nil
+	}
+	lookup := func(inv memberInvitation) bool {
+		return inv.accountID == *masterAccountID && inv.id == *graphARN
+	}
+	accept := func(memberInvitation) error {
+		_, err := d.AcceptInvitation(&detective.AcceptInvitationInput{
+			GraphArn: graphARN,
+		})
+		if err != nil {
+			return fmt.Errorf("error accepting invitation: %w", err)
+		}
+		return nil
+	}
+
+	return acceptMemberInvitation(list, lookup, accept)
 }
 
 // getGraphARN looks for a single graph and returns its ARN, or error otherwise
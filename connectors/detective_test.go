@@ -15,8 +15,11 @@
 package connectors
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/detective"
@@ -33,14 +36,39 @@ func TestDetectiveInviter_AddMember(t *testing.T) {
 		badGMReq        = dGetMembersReq{err: fmt.Errorf("mock err")}
 		emptyGMReq      = dGetMembersReq{output: &detective.GetMembersOutput{}}
 		associatedGMReq = dGetMembersReq{output: &detective.GetMembersOutput{
-			MemberDetails: []*detective.MemberDetail{{Status: aws.String("Enabled")}}}}
+			MemberDetails: []*detective.MemberDetail{{Status: aws.String(detective.MemberStatusEnabled)}}}}
+		severalGMReq = dGetMembersReq{output: &detective.GetMembersOutput{
+			MemberDetails: []*detective.MemberDetail{{Status: aws.String(detective.MemberStatusEnabled)}, {Status: aws.String(detective.MemberStatusEnabled)}}}}
 		invitedGMReq = dGetMembersReq{output: &detective.GetMembersOutput{
-			MemberDetails: []*detective.MemberDetail{{Status: aws.String("Invited")}}}}
+			MemberDetails: []*detective.MemberDetail{{Status: aws.String(detective.MemberStatusInvited)}}}}
+		verifyingGMReq = dGetMembersReq{output: &detective.GetMembersOutput{
+			MemberDetails: []*detective.MemberDetail{{Status: aws.String(detective.MemberStatusVerificationInProgress)}}}}
+		eksAuditLDPPages = map[string]*detective.ListDatasourcePackagesOutput{
+			"": {
+				DatasourcePackages: map[string]*detective.DatasourcePackageIngestDetail{
+					detective.DatasourcePackageDetectiveCore: {DatasourcePackageIngestState: aws.String(detective.DatasourcePackageIngestStateStarted)},
+				},
+				NextToken: aws.String("next"),
+			},
+			"next": {
+				DatasourcePackages: map[string]*detective.DatasourcePackageIngestDetail{
+					detective.DatasourcePackageEksAudit: {DatasourcePackageIngestState: aws.String(detective.DatasourcePackageIngestStateStarted)},
+				},
+			},
+		}
 		badCMReq   = dCreateMembersReq{err: fmt.Errorf("mock err")}
 		badLIReq   = dListInvitationsReq{err: fmt.Errorf("mock err")}
 		emptyLIReq = dListInvitationsReq{output: &detective.ListInvitationsOutput{}}
 		goodLIReq  = dListInvitationsReq{output: &detective.ListInvitationsOutput{
 			Invitations: []*detective.MemberDetail{{AccountId: &masterAccID, GraphArn: &graphARN}}}}
+		otherGraphARN   = "mock_other_graph"
+		otherGraphLIReq = dListInvitationsReq{output: &detective.ListInvitationsOutput{
+			Invitations: []*detective.MemberDetail{{AccountId: &masterAccID, GraphArn: &otherGraphARN}}}}
+		twoGraphsLIReq = dListInvitationsReq{output: &detective.ListInvitationsOutput{
+			Invitations: []*detective.MemberDetail{
+				{AccountId: &masterAccID, GraphArn: &otherGraphARN},
+				{AccountId: &masterAccID, GraphArn: &graphARN},
+			}}}
 		badAIReq  = dAcceptInvitationReq{err: fmt.Errorf("mock err")}
 		badDReq   = dGraphReq{err: fmt.Errorf("mock err")}
 		emptyDReq = dGraphReq{output: &detective.ListGraphsOutput{}}
@@ -50,16 +78,32 @@ func TestDetectiveInviter_AddMember(t *testing.T) {
 	var testAPIRequestsDataset = []struct {
 		description string
 		error       string
+		errIs       error
+		errMembers  int
+		status      Status
 		gmReq       dGetMembersReq
 		cmReq       dCreateMembersReq
 		liReq       dListInvitationsReq
 		aiReq       dAcceptInvitationReq
 		dReq        dGraphReq
+		ldpPages    map[string]*detective.ListDatasourcePackagesOutput
+		ldpErr      error
+		udpReq      dUpdatePackagesReq
+		packages    []string
+		gmWaitReqs  []dGetMembersReq
+		liRetryReq  dListInvitationsReq
+		attempts    int
+		connected   []string
 	}{
 		{description: "problem checking existing members",
 			dReq:  goodDReq,
 			gmReq: badGMReq,
 			error: "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "several members returned for single account",
+			dReq:       goodDReq,
+			gmReq:      severalGMReq,
+			error:      "error retrieving information about existing member account: 2 members returned instead of one for account 112233445566",
+			errMembers: 2},
 		{description: "error checking graph during check of existing account",
 			gmReq: associatedGMReq,
 			dReq:  badDReq,
@@ -68,7 +112,13 @@ func TestDetectiveInviter_AddMember(t *testing.T) {
 			gmReq: associatedGMReq,
 			dReq:  emptyDReq,
 			error: "can't get graphARN of master account: 0 graphs found instead of one"},
-		{description: "member already enabled", gmReq: associatedGMReq, dReq: goodDReq},
+		{description: "member already enabled", gmReq: associatedGMReq, dReq: goodDReq, status: StatusAlreadyConnected},
+		{description: "member already connected with alternate status",
+			gmReq: dGetMembersReq{output: &detective.GetMembersOutput{
+				MemberDetails: []*detective.MemberDetail{{Status: aws.String(detective.MemberStatusAcceptedButDisabled)}}}},
+			connected: []string{detective.MemberStatusEnabled, detective.MemberStatusAcceptedButDisabled},
+			dReq:      goodDReq,
+			status:    StatusAlreadyConnected},
 		{description: "problem creating member account",
 			dReq:  goodDReq,
 			gmReq: emptyGMReq,
@@ -83,7 +133,19 @@ func TestDetectiveInviter_AddMember(t *testing.T) {
 			dReq:  goodDReq,
 			gmReq: invitedGMReq,
 			liReq: emptyLIReq,
-			error: "error accepting invitation in member account: can't find invitation from master account"},
+			error: "error accepting invitation in member account: can't find invitation from master account",
+			errIs: ErrInvitationMissing},
+		{description: "invitation to other graph of master account only",
+			dReq:  goodDReq,
+			gmReq: invitedGMReq,
+			liReq: otherGraphLIReq,
+			error: "error accepting invitation in member account: can't find invitation from master account",
+			errIs: ErrInvitationMissing},
+		{description: "invitation to master graph accepted among invitations to several graphs",
+			dReq:   goodDReq,
+			gmReq:  invitedGMReq,
+			liReq:  twoGraphsLIReq,
+			status: StatusAccepted},
 		{description: "problem accepting invitation",
 			dReq:  goodDReq,
 			gmReq: invitedGMReq,
@@ -91,12 +153,94 @@ func TestDetectiveInviter_AddMember(t *testing.T) {
 			aiReq: badAIReq,
 			error: "error accepting invitation in member account: error accepting invitation: mock err"},
 		{description: "correctly send and accept invitation",
+			dReq:   goodDReq,
+			gmReq:  invitedGMReq,
+			liReq:  goodLIReq,
+			status: StatusAccepted},
+		{description: "invited member is not created again",
+			dReq:   goodDReq,
+			gmReq:  invitedGMReq,
+			cmReq:  badCMReq,
+			liReq:  goodLIReq,
+			status: StatusAccepted},
+		{description: "invitation of invited member not found, re-sent and accepted",
+			dReq:       goodDReq,
+			gmReq:      invitedGMReq,
+			liReq:      emptyLIReq,
+			liRetryReq: goodLIReq,
+			status:     StatusUpdated},
+		{description: "invitation of invited member not found, problem re-sending it",
 			dReq:  goodDReq,
 			gmReq: invitedGMReq,
-			liReq: goodLIReq},
+			liReq: emptyLIReq,
+			cmReq: badCMReq,
+			error: "error re-sending invitation: error creating member account: mock err"},
+		{description: "correctly create member, send and accept invitation",
+			dReq:   goodDReq,
+			gmReq:  emptyGMReq,
+			liReq:  goodLIReq,
+			status: StatusInvited},
+		{description: "invitation found on second attempt",
+			dReq:       goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      emptyLIReq,
+			liRetryReq: goodLIReq,
+			attempts:   3,
+			status:     StatusInvited},
+		{description: "invitation not found after all attempts",
+			dReq:     goodDReq,
+			gmReq:    emptyGMReq,
+			liReq:    emptyLIReq,
+			attempts: 2,
+			error:    "error accepting invitation in member account: can't find invitation from master account",
+			errIs:    ErrInvitationMissing},
+		{description: "member enabled after waiting",
+			dReq:       goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []dGetMembersReq{verifyingGMReq, associatedGMReq},
+			status:     StatusInvited},
+		{description: "member deleted while waiting",
+			dReq:       goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []dGetMembersReq{verifyingGMReq, emptyGMReq},
+			error:      "error waiting for member account to be enabled: member account is not found in master account",
+			errIs:      ErrMemberNotFound},
+		{description: "packages enabled after accepting invitation",
+			dReq:     goodDReq,
+			gmReq:    emptyGMReq,
+			liReq:    goodLIReq,
+			udpReq:   dUpdatePackagesReq{expected: true},
+			packages: []string{DetectivePackageEKSAudit},
+			status:   StatusInvited},
+		{description: "packages enabled for already connected member",
+			dReq:     goodDReq,
+			gmReq:    associatedGMReq,
+			udpReq:   dUpdatePackagesReq{expected: true},
+			packages: []string{DetectivePackageEKSAudit},
+			status:   StatusUpdated},
+		{description: "packages already enabled in graph",
+			dReq:     goodDReq,
+			gmReq:    associatedGMReq,
+			ldpPages: eksAuditLDPPages,
+			packages: []string{DetectivePackageEKSAudit},
+			status:   StatusAlreadyConnected},
+		{description: "problem listing graph packages",
+			dReq:     goodDReq,
+			gmReq:    associatedGMReq,
+			ldpErr:   fmt.Errorf("mock err"),
+			packages: []string{DetectivePackageEKSAudit},
+			error:    "error enabling data source packages: error listing graph data source packages: mock err"},
+		{description: "problem enabling packages",
+			dReq:     goodDReq,
+			gmReq:    associatedGMReq,
+			udpReq:   dUpdatePackagesReq{expected: true, err: fmt.Errorf("mock err")},
+			packages: []string{DetectivePackageEKSAudit},
+			error:    "error enabling data source packages: error updating data source packages: mock err"},
 	}
 
-	masterSess, memberSess := NewMasterMemberSess("us-west-2", "", "")
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws"})
 	for i, x := range testAPIRequestsDataset {
 		i := i
 		x := x
@@ -107,30 +251,71 @@ func TestDetectiveInviter_AddMember(t *testing.T) {
 				memberAccID: &memberAccID,
 				graphArn:    &graphARN,
 				gmReq:       x.gmReq,
+				gmWaitReqs:  x.gmWaitReqs,
+				gmCalls:     new(int),
 				cmReq:       x.cmReq,
 				dReq:        x.dReq,
+				ldpPages:    x.ldpPages,
+				ldpErr:      x.ldpErr,
+				udpReq:      x.udpReq,
 			}
 			member := &mockDMemberClient{
 				t:               t,
 				masterAccountID: &masterAccID,
 				graphArn:        &graphARN,
 				liReq:           x.liReq,
+				liRetryReq:      x.liRetryReq,
 				aiReq:           x.aiReq,
 			}
-			s := NewDetectiveInviter(masterSess, memberSess)
+			s := NewDetectiveInviter(masterSess, memberSess, x.packages)
 			s.masterSvc = master
 			s.memberSvc = member
-			err := s.AddMember(memberAccID, testEmail, masterAccID)
+			s.connectedStatuses = x.connected
+			if len(x.gmWaitReqs) > 0 {
+				s.waiter = &memberWaiter{pollInterval: time.Minute, timeout: 3 * time.Minute, sleep: func(context.Context, time.Duration) error { return nil }}
+			}
+			if x.attempts > 0 {
+				s.retryer = &invitationRetryer{attempts: x.attempts, delay: time.Second, sleep: func(context.Context, time.Duration) error { return nil }}
+			}
+			res, err := s.AddMember(memberAccID, testEmail, masterAccID)
 
 			if x.error != "" {
 				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				assert.Equal(t, StatusFailed, res.Status, "Test case %d status check failed", i)
+				var serviceErr *ServiceError
+				if assert.True(t, errors.As(err, &serviceErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "detective", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+				if x.errIs != nil {
+					assert.ErrorIs(t, err, x.errIs, "Test case %d errors.Is check failed", i)
+				}
+				if x.errMembers != 0 {
+					var membersErr *UnexpectedMembersError
+					if assert.True(t, errors.As(err, &membersErr), "Test case %d errors.As check failed", i) {
+						assert.Equal(t, x.errMembers, membersErr.Count, "Test case %d members count check failed", i)
+					}
+				}
 			} else {
 				assert.NoError(t, err, "Test case %d error check failed", i)
+				assert.Equal(t, x.status, res.Status, "Test case %d status check failed", i)
 			}
 		})
 	}
 }
 
+func TestParseDetectivePackages(t *testing.T) {
+	packages, err := ParseDetectivePackages("")
+	assert.NoError(t, err)
+	assert.Empty(t, packages)
+
+	packages, err = ParseDetectivePackages(" eks_audit, ")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{DetectivePackageEKSAudit}, packages)
+
+	_, err = ParseDetectivePackages("eks_audit,detective_core")
+	assert.EqualError(t, err, `unknown Detective data source package "detective_core"`)
+}
+
 type mockDMasterClient struct {
 	t           *testing.T
 	email       *string
@@ -139,6 +324,18 @@ type mockDMasterClient struct {
 	gmReq       dGetMembersReq
 	cmReq       dCreateMembersReq
 	dReq        dGraphReq
+	loaReq      dListOrgAdminsReq
+	eoaReq      dEnableOrgAdminReq
+	udpReq      dUpdatePackagesReq
+	// ldpPages are pages of graph data source packages keyed by page token, empty for the first page
+	ldpPages map[string]*detective.ListDatasourcePackagesOutput
+	ldpErr   error
+	// lmPages are pages of members list keyed by page token, empty for the first page
+	lmPages map[string]*detective.ListMembersOutput
+	lmErr   error
+	// gmWaitReqs are responses to GetMembers calls following the first one, made while waiting for member to be enabled
+	gmWaitReqs []dGetMembersReq
+	gmCalls    *int
 }
 
 type dGetMembersReq struct {
@@ -154,6 +351,11 @@ type dGraphReq struct {
 	err    error
 }
 
+func (s mockDMasterClient) ListMembers(input *detective.ListMembersInput) (*detective.ListMembersOutput, error) {
+	assert.Equal(s.t, s.graphArn, input.GraphArn)
+	return s.lmPages[aws.StringValue(input.NextToken)], s.lmErr
+}
+
 func (s mockDMasterClient) ListGraphs(input *detective.ListGraphsInput) (*detective.ListGraphsOutput, error) {
 	assert.Nil(s.t, input)
 	return s.dReq.output, s.dReq.err
@@ -161,6 +363,12 @@ func (s mockDMasterClient) ListGraphs(input *detective.ListGraphsInput) (*detect
 
 func (s mockDMasterClient) GetMembers(input *detective.GetMembersInput) (*detective.GetMembersOutput, error) {
 	assert.Equal(s.t, &detective.GetMembersInput{AccountIds: []*string{s.memberAccID}, GraphArn: s.graphArn}, input)
+	if len(s.gmWaitReqs) > 0 {
+		*s.gmCalls++
+		if *s.gmCalls > 1 {
+			return s.gmWaitReqs[*s.gmCalls-2].output, s.gmWaitReqs[*s.gmCalls-2].err
+		}
+	}
 	return s.gmReq.output, s.gmReq.err
 }
 
@@ -180,6 +388,8 @@ type mockDMemberClient struct {
 	masterAccountID *string
 	graphArn        *string
 	liReq           dListInvitationsReq
+	liRetryReq      dListInvitationsReq
+	liCalls         int
 	aiReq           dAcceptInvitationReq
 }
 
@@ -191,8 +401,13 @@ type dAcceptInvitationReq struct {
 	err error
 }
 
-func (s mockDMemberClient) ListInvitations(input *detective.ListInvitationsInput) (*detective.ListInvitationsOutput, error) {
+func (s *mockDMemberClient) ListInvitations(input *detective.ListInvitationsInput) (*detective.ListInvitationsOutput, error) {
 	assert.Nil(s.t, input)
+	s.liCalls++
+	// invitations listed when the invitation is looked for again
+	if s.liCalls > 1 && s.liRetryReq.output != nil {
+		return s.liRetryReq.output, s.liRetryReq.err
+	}
 	return s.liReq.output, s.liReq.err
 }
 
@@ -200,3 +415,230 @@ func (s mockDMemberClient) AcceptInvitation(input *detective.AcceptInvitationInp
 	assert.Equal(s.t, &detective.AcceptInvitationInput{GraphArn: s.graphArn}, input)
 	return nil, s.aiReq.err
 }
+
+type dUpdatePackagesReq struct {
+	expected bool
+	err      error
+}
+
+func (s mockDMasterClient) ListDatasourcePackages(input *detective.ListDatasourcePackagesInput) (*detective.ListDatasourcePackagesOutput, error) {
+	assert.Equal(s.t, s.graphArn, input.GraphArn)
+	if page, ok := s.ldpPages[aws.StringValue(input.NextToken)]; ok {
+		return page, s.ldpErr
+	}
+	return &detective.ListDatasourcePackagesOutput{}, s.ldpErr
+}
+
+func (s mockDMasterClient) UpdateDatasourcePackages(input *detective.UpdateDatasourcePackagesInput) (*detective.UpdateDatasourcePackagesOutput, error) {
+	assert.True(s.t, s.udpReq.expected, "unexpected data source packages update")
+	assert.Equal(s.t, &detective.UpdateDatasourcePackagesInput{
+		DatasourcePackages: []*string{aws.String(detective.DatasourcePackageEksAudit)},
+		GraphArn:           s.graphArn,
+	}, input)
+	return &detective.UpdateDatasourcePackagesOutput{}, s.udpReq.err
+}
+
+type dListOrgAdminsReq struct {
+	output *detective.ListOrganizationAdminAccountsOutput
+	err    error
+}
+type dEnableOrgAdminReq struct {
+	expected bool
+	err      error
+}
+
+func (s mockDMasterClient) ListOrganizationAdminAccounts(input *detective.ListOrganizationAdminAccountsInput) (*detective.ListOrganizationAdminAccountsOutput, error) {
+	assert.Equal(s.t, &detective.ListOrganizationAdminAccountsInput{}, input)
+	return s.loaReq.output, s.loaReq.err
+}
+
+func (s mockDMasterClient) EnableOrganizationAdminAccount(input *detective.EnableOrganizationAdminAccountInput) (*detective.EnableOrganizationAdminAccountOutput, error) {
+	assert.True(s.t, s.eoaReq.expected, "unexpected delegated administrator enabling")
+	assert.Equal(s.t, &detective.EnableOrganizationAdminAccountInput{AccountId: s.memberAccID}, input)
+	return &detective.EnableOrganizationAdminAccountOutput{}, s.eoaReq.err
+}
+
+func TestDetectiveInviter_EnableOrgAdmin(t *testing.T) {
+	adminAccID := "123456789012"
+	var testData = []struct {
+		description string
+		error       string
+		loaReq      dListOrgAdminsReq
+		eoaReq      dEnableOrgAdminReq
+	}{
+		{description: "problem listing administrators",
+			loaReq: dListOrgAdminsReq{err: fmt.Errorf("mock err")},
+			error:  "error listing delegated administrators: mock err"},
+		{description: "already configured",
+			loaReq: dListOrgAdminsReq{output: &detective.ListOrganizationAdminAccountsOutput{
+				Administrators: []*detective.Administrator{{AccountId: aws.String(adminAccID)}}}}},
+		{description: "other account is administrator",
+			loaReq: dListOrgAdminsReq{output: &detective.ListOrganizationAdminAccountsOutput{
+				Administrators: []*detective.Administrator{{AccountId: aws.String("210987654321")}}}},
+			error: "account 210987654321 is delegated administrator already"},
+		{description: "newly configured",
+			loaReq: dListOrgAdminsReq{output: &detective.ListOrganizationAdminAccountsOutput{}},
+			eoaReq: dEnableOrgAdminReq{expected: true}},
+		{description: "problem enabling administrator",
+			loaReq: dListOrgAdminsReq{output: &detective.ListOrganizationAdminAccountsOutput{}},
+			eoaReq: dEnableOrgAdminReq{expected: true, err: fmt.Errorf("mock err")},
+			error:  "error enabling delegated administrator: mock err"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			s := DetectiveInviter{masterSvc: mockDMasterClient{t: t, memberAccID: &adminAccID, loaReq: x.loaReq, eoaReq: x.eoaReq}}
+			err := s.EnableOrgAdmin(adminAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				var serviceErr *ServiceError
+				if assert.True(t, errors.As(err, &serviceErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "detective", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+		})
+	}
+}
+
+func TestDetectiveInviter_Preflight(t *testing.T) {
+	var (
+		graphARN    = "mock_graph"
+		memberAccID = "112233445566"
+		goodDReq    = dGraphReq{output: &detective.ListGraphsOutput{GraphList: []*detective.Graph{{Arn: &graphARN}}}}
+		goodGMReq   = dGetMembersReq{output: &detective.GetMembersOutput{}}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		dReq        dGraphReq
+		gmReq       dGetMembersReq
+		liReq       dListInvitationsReq
+	}{
+		{description: "problem listing graphs",
+			dReq:  dGraphReq{err: fmt.Errorf("mock err")},
+			error: "can't get graphARN of master account: error listing graphs: mock err"},
+		{description: "problem getting members",
+			dReq:  goodDReq,
+			gmReq: dGetMembersReq{err: fmt.Errorf("mock err")},
+			error: "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "problem listing invitations",
+			dReq:  goodDReq,
+			gmReq: goodGMReq,
+			liReq: dListInvitationsReq{err: fmt.Errorf("mock err")},
+			error: "error retrieving list of invitations: mock err"},
+		{description: "all checks passed",
+			dReq:  goodDReq,
+			gmReq: goodGMReq,
+			liReq: dListInvitationsReq{output: &detective.ListInvitationsOutput{}}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			d := DetectiveInviter{
+				masterSvc: readOnlyDMasterClient{t: t, memberAccID: &memberAccID, graphArn: &graphARN, dReq: x.dReq, gmReq: x.gmReq},
+				memberSvc: readOnlyDMemberClient{t: t, liReq: x.liReq},
+			}
+			err := d.Preflight(memberAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+		})
+	}
+}
+
+func TestDetectiveInviter_MemberStatus(t *testing.T) {
+	var (
+		graphARN    = "mock_graph"
+		memberAccID = "112233445566"
+		goodDReq    = dGraphReq{output: &detective.ListGraphsOutput{GraphList: []*detective.Graph{{Arn: &graphARN}}}}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		dReq        dGraphReq
+		gmReq       dGetMembersReq
+		status      string
+	}{
+		{description: "problem listing graphs",
+			dReq:  dGraphReq{err: fmt.Errorf("mock err")},
+			error: "can't get graphARN of master account: error listing graphs: mock err"},
+		{description: "problem getting members",
+			dReq:  goodDReq,
+			gmReq: dGetMembersReq{err: fmt.Errorf("mock err")},
+			error: "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "not a member",
+			dReq:   goodDReq,
+			gmReq:  dGetMembersReq{output: &detective.GetMembersOutput{}},
+			status: MemberStatusNotMember},
+		{description: "member without status",
+			dReq:   goodDReq,
+			gmReq:  dGetMembersReq{output: &detective.GetMembersOutput{MemberDetails: []*detective.MemberDetail{{}}}},
+			status: MemberStatusNotMember},
+		{description: "enabled member",
+			dReq: goodDReq,
+			gmReq: dGetMembersReq{output: &detective.GetMembersOutput{
+				MemberDetails: []*detective.MemberDetail{{Status: aws.String(detective.MemberStatusEnabled)}}}},
+			status: detective.MemberStatusEnabled},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			// read-only clients panic on any mutating call
+			d := DetectiveInviter{
+				masterSvc: readOnlyDMasterClient{t: t, memberAccID: &memberAccID, graphArn: &graphARN, dReq: x.dReq, gmReq: x.gmReq},
+				memberSvc: readOnlyDMemberClient{t: t},
+			}
+			status, err := d.MemberStatus(memberAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.status, status, "Test case %d status check failed", i)
+		})
+	}
+}
+
+// readOnlyDMasterClient implements only read-only calls, any other call panics on nil embedded interface
+type readOnlyDMasterClient struct {
+	DetectiveMasterClient
+	t           *testing.T
+	memberAccID *string
+	graphArn    *string
+	dReq        dGraphReq
+	gmReq       dGetMembersReq
+}
+
+func (s readOnlyDMasterClient) ListGraphs(input *detective.ListGraphsInput) (*detective.ListGraphsOutput, error) {
+	assert.Nil(s.t, input)
+	return s.dReq.output, s.dReq.err
+}
+
+func (s readOnlyDMasterClient) GetMembers(input *detective.GetMembersInput) (*detective.GetMembersOutput, error) {
+	assert.Equal(s.t, &detective.GetMembersInput{AccountIds: []*string{s.memberAccID}, GraphArn: s.graphArn}, input)
+	return s.gmReq.output, s.gmReq.err
+}
+
+// readOnlyDMemberClient implements only read-only calls, any other call panics on nil embedded interface
+type readOnlyDMemberClient struct {
+	DetectiveMemberClient
+	t     *testing.T
+	liReq dListInvitationsReq
+}
+
+func (s readOnlyDMemberClient) ListInvitations(input *detective.ListInvitationsInput) (*detective.ListInvitationsOutput, error) {
+	assert.Nil(s.t, input)
+	return s.liReq.output, s.liReq.err
+}
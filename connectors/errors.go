@@ -0,0 +1,100 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMemberNotFound is returned in case member account is not present in master account
+var ErrMemberNotFound = errors.New("member account is not found in master account")
+
+// ErrInvitationMissing is returned in case member account has no invitation from master account
+var ErrInvitationMissing = errors.New("can't find invitation from master account")
+
+// ErrPrismaAccountMissing is returned in case account which is expected to exist in Prisma is not present there
+var ErrPrismaAccountMissing = errors.New("account doesn't exist in Prisma")
+
+// ServiceError is returned by AddMember of every Inviter and holds the name of the service
+// which failed to connect the member, so that callers could decide what to do with it
+// without parsing the message.
+type ServiceError struct {
+	Service string
+	Err     error
+}
+
+// Error returns message of the underlying error.
+func (e *ServiceError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// newServiceError wraps provided error into *ServiceError, nil is returned as is
+func newServiceError(service string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ServiceError{Service: service, Err: err}
+}
+
+// PrismaAccountNameConflictError is returned in case Prisma refused to create an account
+// because its name is already used by another cloud account.
+type PrismaAccountNameConflictError struct {
+	Name      string
+	AccountID string
+	Err       error
+}
+
+// Error returns message which names the conflicting account name.
+func (e *PrismaAccountNameConflictError) Error() string {
+	return fmt.Sprintf("Prisma account name %q is already in use by another cloud account, "+
+		"set a unique name for account %s", e.Name, e.AccountID)
+}
+
+// Unwrap returns the underlying error.
+func (e *PrismaAccountNameConflictError) Unwrap() error {
+	return e.Err
+}
+
+// UnexpectedMembersError is returned in case the service returned more than one member
+// for a query about a single member account.
+type UnexpectedMembersError struct {
+	AccountID string
+	Count     int
+}
+
+// Error returns message with the number of returned members.
+func (e *UnexpectedMembersError) Error() string {
+	return fmt.Sprintf("%d members returned instead of one for account %s", e.Count, e.AccountID)
+}
+
+// PrismaAmbiguousAccountNameError is returned in case an AWS account is looked up in Prisma by name,
+// but several accounts have this name.
+type PrismaAmbiguousAccountNameError struct {
+	Name       string
+	AccountIDs []string
+}
+
+// Error returns message which lists IDs of all accounts having the name.
+func (e *PrismaAmbiguousAccountNameError) Error() string {
+	return fmt.Sprintf("Prisma account name %q is used by %d AWS accounts: %s, use account ID instead",
+		e.Name, len(e.AccountIDs), strings.Join(e.AccountIDs, ", "))
+}
@@ -15,11 +15,14 @@
 package connectors
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/service/guardduty"
+	log "github.com/sirupsen/logrus"
 )
 
 // GuardDutyInviter is a per-region structure which contains all information
@@ -27,11 +30,37 @@ import (
 type GuardDutyInviter struct {
 	masterSvc GuardDutyMasterClient
 	memberSvc GuardDutyMemberClient
+	// features are data sources to enable on member detector
+	features []string
+	// publishFrequency is finding publishing frequency to set on master detector, which members inherit,
+	// nothing is changed if empty
+	publishFrequency string
+	// inviteMessage is added to invitation sent to member account, no message is sent if empty
+	inviteMessage string
+	// inviteEmails makes GuardDuty notify member account about invitation by email
+	inviteEmails bool
+	// connectedStatuses are member statuses meaning that member is connected to master, "Enabled" if empty
+	connectedStatuses []string
+	// createDetector makes detector created in member account to accept invitation in case it has none
+	createDetector bool
+	// waiter polls member status after invitation is accepted until it's enabled, no waiting is done if nil
+	waiter *memberWaiter
+	// retryer retries looking for just sent invitation in member account, it's looked for once if nil
+	retryer *invitationRetryer
+	// log is an entry with context fields, like account ID and region, used for all messages
+	log *log.Entry
 }
 
-// GuardDutyListDetectors is interface for ListDetectors function which is used both in master and member.
+// GuardDuty data sources which could be enabled on member detector in addition to the default ones.
+const (
+	GuardDutyFeatureS3Logs              = "s3_logs"
+	GuardDutyFeatureKubernetesAuditLogs = "kubernetes_audit_logs"
+	GuardDutyFeatureMalwareProtection   = "malware_protection"
+)
+
+// GuardDutyListDetectors is interface for ListDetectorsPages function which is used both in master and member.
 type GuardDutyListDetectors interface {
-	ListDetectors(*guardduty.ListDetectorsInput) (*guardduty.ListDetectorsOutput, error)
+	ListDetectorsPages(*guardduty.ListDetectorsInput, func(*guardduty.ListDetectorsOutput, bool) bool) error
 }
 
 // GuardDutyMasterClient is a subset of aws-sdk-go/service/guardduty which is used for sending
@@ -39,8 +68,13 @@ type GuardDutyListDetectors interface {
 type GuardDutyMasterClient interface {
 	GuardDutyListDetectors
 	GetMembers(*guardduty.GetMembersInput) (*guardduty.GetMembersOutput, error)
+	ListMembers(*guardduty.ListMembersInput) (*guardduty.ListMembersOutput, error)
 	CreateMembers(*guardduty.CreateMembersInput) (*guardduty.CreateMembersOutput, error)
 	InviteMembers(*guardduty.InviteMembersInput) (*guardduty.InviteMembersOutput, error)
+	GetMemberDetectors(*guardduty.GetMemberDetectorsInput) (*guardduty.GetMemberDetectorsOutput, error)
+	UpdateMemberDetectors(*guardduty.UpdateMemberDetectorsInput) (*guardduty.UpdateMemberDetectorsOutput, error)
+	GetDetector(*guardduty.GetDetectorInput) (*guardduty.GetDetectorOutput, error)
+	UpdateDetector(*guardduty.UpdateDetectorInput) (*guardduty.UpdateDetectorOutput, error)
 }
 
 // GuardDutyMemberClient is a subset of aws-sdk-go/service/guardduty which is used for accepting
@@ -48,75 +82,255 @@ type GuardDutyMasterClient interface {
 type GuardDutyMemberClient interface {
 	GuardDutyListDetectors
 	ListInvitations(*guardduty.ListInvitationsInput) (*guardduty.ListInvitationsOutput, error)
+	CreateDetector(*guardduty.CreateDetectorInput) (*guardduty.CreateDetectorOutput, error)
 	AcceptAdministratorInvitation(*guardduty.AcceptAdministratorInvitationInput) (*guardduty.AcceptAdministratorInvitationOutput, error)
 }
 
 // NewGuardDutyInviter creates new instance of GuardDutyInviter which is capable of inviting
-// specified member account to master account GuardDuty
-func NewGuardDutyInviter(masterSess, memberSess client.ConfigProvider) *GuardDutyInviter {
+// specified member account to master account GuardDuty and enabling provided features on it.
+// Invitation is sent with provided message and without email notification unless inviteEmails is set.
+func NewGuardDutyInviter(masterSess, memberSess client.ConfigProvider, features []string, inviteMessage string, inviteEmails bool) *GuardDutyInviter {
+	return NewGuardDutyInviterWithFactory(NewClientFactory(), masterSess, memberSess, features, inviteMessage, inviteEmails)
+}
+
+// NewGuardDutyInviterWithFactory is the same as NewGuardDutyInviter, but creates clients with provided factory.
+func NewGuardDutyInviterWithFactory(f *ClientFactory, masterSess, memberSess client.ConfigProvider, features []string, inviteMessage string, inviteEmails bool) *GuardDutyInviter {
 	return &GuardDutyInviter{
-		masterSvc: guardduty.New(masterSess),
-		memberSvc: guardduty.New(memberSess),
+		masterSvc:     f.GuardDuty(masterSess),
+		memberSvc:     f.GuardDuty(memberSess),
+		features:      features,
+		inviteMessage: inviteMessage,
+		inviteEmails:  inviteEmails,
+		log:           log.NewEntry(log.StandardLogger()),
+	}
+}
+
+// ParseGuardDutyFeatures parses comma-separated list of GuardDuty features,
+// returning error in case of unknown feature
+func ParseGuardDutyFeatures(s string) ([]string, error) {
+	var features []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case "":
+			continue
+		case GuardDutyFeatureS3Logs, GuardDutyFeatureKubernetesAuditLogs, GuardDutyFeatureMalwareProtection:
+			features = append(features, f)
+		default:
+			return nil, fmt.Errorf("unknown GuardDuty feature %q", f)
+		}
 	}
+	return features, nil
+}
+
+// ParseGuardDutyPublishFrequency parses GuardDuty finding publishing frequency, which is one of
+// FIFTEEN_MINUTES, ONE_HOUR and SIX_HOURS in any case, returning empty string for empty input.
+func ParseGuardDutyPublishFrequency(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	for _, f := range guardduty.FindingPublishingFrequency_Values() {
+		if strings.EqualFold(s, f) {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown GuardDuty finding publishing frequency %q, should be one of %s",
+		s, strings.Join(guardduty.FindingPublishingFrequency_Values(), ", "))
+}
+
+// Name returns "guardduty", identifier of the service.
+func (g GuardDutyInviter) Name() string {
+	return "guardduty"
 }
 
 // AddMember adds new member account to master, sends invite to it,
 // and then accepts invite from the member account.
 // In case the member is already in place and connected (enabled), nothing is done.
+// Member which is present but not enabled, e.g. Disabled or Removed, is invited again without re-creating it.
+// Returned error is *ServiceError, which can be inspected with errors.Is and errors.As.
 // https://docs.aws.amazon.com/guardduty/latest/ug/guardduty_accounts.html
-func (g GuardDutyInviter) AddMember(accountID, accountEmail, masterAccountID string) error {
+func (g GuardDutyInviter) AddMember(accountID, accountEmail, masterAccountID string) (Result, error) {
+	res, err := g.addMember(accountID, accountEmail, masterAccountID)
+	logResult(g.log.WithField("service", g.Name()), res, err)
+	return res, newServiceError(g.Name(), err)
+}
+
+func (g GuardDutyInviter) addMember(accountID, accountEmail, masterAccountID string) (Result, error) {
 	detectorID, err := getDetectorID(g.masterSvc)
 	if err != nil {
-		return fmt.Errorf("can't get detectorID of master account: %w", err)
+		return Result{Status: StatusFailed}, fmt.Errorf("can't get detectorID of master account: %w", err)
+	}
+
+	status, err := getGuardDutyMemberStatus(g.masterSvc, detectorID, &accountID)
+	if err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return Result{Status: StatusFailed}, fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	connected := connectedStatuses(g.connectedStatuses, guardDutyConnectedStatus)
+	if contains(connected, status) {
+		updated, err := enableGuardDutyMemberFeatures(g.masterSvc, detectorID, &accountID, g.features)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error enabling features on member account: %w", err)
+		}
+		frequencyUpdated, err := setGuardDutyPublishFrequency(g.masterSvc, detectorID, g.publishFrequency)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error setting finding publishing frequency: %w", err)
+		}
+		if updated || frequencyUpdated {
+			return Result{Status: StatusUpdated}, nil
+		}
+		return Result{Status: StatusAlreadyConnected}, nil
 	}
 
-	connected, err := ifGuardDutyMemberAlreadyEnabled(g.masterSvc, detectorID, &accountID)
+	switch status {
+	case "":
+		err = setUpGuardDutyMaster(g.masterSvc, detectorID, &accountID, &accountEmail, g.inviteMessage, g.inviteEmails)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error setting up master account: %w", err)
+		}
+	case "Invited":
+		// invited member already has the invitation sent, so only accepting it is left
+	default:
+		// member is still present in master, e.g. Disabled or Removed, so it's invited again without re-creating it
+		g.log.WithField("service", g.Name()).Infof("Member account is %s, re-inviting it", status)
+		err = inviteGuardDutyMember(g.masterSvc, detectorID, &accountID, g.inviteMessage, g.inviteEmails)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error re-inviting member account: %w", err)
+		}
+	}
+
+	accept := func() error { return acceptGuardDutyMemberInvitation(g.memberSvc, &masterAccountID, g.createDetector) }
+	// invitation which was just sent might not be visible in member account yet, so looking for it is retried
+	var resent bool
+	if status == "Invited" {
+		err = accept()
+	} else {
+		err = g.retryer.accept(accept)
+	}
+	if errors.Is(err, ErrInvitationMissing) && status == "Invited" {
+		// invitation might have expired, so it's sent again
+		g.log.WithField("service", g.Name()).Info("Invitation not found, re-sending it")
+		err = inviteGuardDutyMember(g.masterSvc, detectorID, &accountID, g.inviteMessage, g.inviteEmails)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error re-sending invitation: %w", err)
+		}
+		resent = true
+		err = g.retryer.accept(accept)
+	}
 	if err != nil {
-		return fmt.Errorf("error retrieving information about existing member account: %w", err)
+		return Result{Status: StatusFailed}, fmt.Errorf("error accepting invitation in member account: %w", err)
 	}
-	if connected {
-		return nil
+
+	if g.waiter != nil {
+		err = g.waiter.waitForEnabled(func() (string, error) {
+			return getGuardDutyMemberStatus(g.masterSvc, detectorID, &accountID)
+		}, connected, pendingMemberStatuses())
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error waiting for member account to be enabled: %w", err)
+		}
 	}
 
-	err = setUpGuardDutyMaster(g.masterSvc, detectorID, &accountID, &accountEmail)
+	if _, err = enableGuardDutyMemberFeatures(g.masterSvc, detectorID, &accountID, g.features); err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error enabling features on member account: %w", err)
+	}
+	if _, err = setGuardDutyPublishFrequency(g.masterSvc, detectorID, g.publishFrequency); err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error setting finding publishing frequency: %w", err)
+	}
+
+	return addMemberResult(status, "Invited", resent), nil
+}
+
+// MemberStatuses returns relationship statuses of all members of master account, keyed by member account ID.
+func (g GuardDutyInviter) MemberStatuses() (map[string]string, error) {
+	detectorID, err := getDetectorID(g.masterSvc)
 	if err != nil {
-		return fmt.Errorf("error setting up master account: %w", err)
+		return nil, newServiceError(g.Name(), fmt.Errorf("can't get detectorID of master account: %w", err))
 	}
+	statuses, err := listGuardDutyMemberStatuses(g.masterSvc, detectorID)
+	return statuses, newServiceError(g.Name(), err)
+}
+
+// MemberStatus returns relationship status of member account in master, or MemberStatusNotMember
+// in case member account is not present there. Only read-only calls are made.
+func (g GuardDutyInviter) MemberStatus(accountID string) (string, error) {
+	status, err := g.memberStatus(accountID)
+	return status, newServiceError(g.Name(), err)
+}
 
-	err = acceptGuardDutyMemberInvitation(g.memberSvc, &masterAccountID)
+func (g GuardDutyInviter) memberStatus(accountID string) (string, error) {
+	detectorID, err := getDetectorID(g.masterSvc)
 	if err != nil {
-		return fmt.Errorf("error accepting invitation in member account: %w", err)
+		return "", fmt.Errorf("can't get detectorID of master account: %w", err)
 	}
+	status, err := getGuardDutyMemberStatus(g.masterSvc, detectorID, &accountID)
+	if err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return "", fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	return memberStatusOrNotMember(status), nil
+}
 
+// Preflight verifies permissions of master and member accounts by performing only read-only calls
+// which AddMember makes, nothing is changed.
+func (g GuardDutyInviter) Preflight(accountID string) error {
+	return newServiceError(g.Name(), g.preflight(accountID))
+}
+
+func (g GuardDutyInviter) preflight(accountID string) error {
+	detectorID, err := getDetectorID(g.masterSvc)
+	if err != nil {
+		return fmt.Errorf("can't get detectorID of master account: %w", err)
+	}
+	if _, err = getGuardDutyMemberStatus(g.masterSvc, detectorID, &accountID); err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	if g.publishFrequency != "" {
+		if _, err = g.masterSvc.GetDetector(&guardduty.GetDetectorInput{DetectorId: detectorID}); err != nil {
+			return fmt.Errorf("error getting master detector: %w", err)
+		}
+	}
+	// detector missing in member account is created when invitation is accepted
+	if g.createDetector {
+		if _, err = listAllDetectors(g.memberSvc); err != nil {
+			return fmt.Errorf("error listing detectors of member account: %w", err)
+		}
+	} else if _, err = getDetectorID(g.memberSvc); err != nil {
+		return fmt.Errorf("can't get detectorID of member account: %w", err)
+	}
+	if _, err = g.memberSvc.ListInvitations(nil); err != nil {
+		return fmt.Errorf("error retrieving list of invitations: %w", err)
+	}
 	return nil
 }
 
-// ifGuardDutyMemberAlreadyEnabled checks if member account is already present
-// in master and is in Enabled state.
-func ifGuardDutyMemberAlreadyEnabled(g GuardDutyMasterClient, detectorID, memberAccountID *string) (bool, error) {
+// getGuardDutyMemberStatus returns relationship status of member account in master,
+// ErrMemberNotFound is returned in case member account is not present there.
+func getGuardDutyMemberStatus(g GuardDutyMasterClient, detectorID, memberAccountID *string) (string, error) {
 	members, err := g.GetMembers(&guardduty.GetMembersInput{
 		DetectorId: detectorID,
 		AccountIds: []*string{memberAccountID},
 	})
 	if err != nil {
-		return false, fmt.Errorf("error getting existing members: %w", err)
+		return "", fmt.Errorf("error getting existing members: %w", err)
 	}
 
 	// Search conditions looking for particular account and we expect to get either zero results
-	// (account is not yet connected) or one result (account is connected with either Invited or Enabled status).
-	// Situation with more than single member in the results is impossible but yet be handled correctly by this code.
-	if len(members.Members) == 1 {
-		if *members.Members[0].RelationshipStatus == "Enabled" {
-			return true, nil
-		}
+	// (account is not yet connected, ErrMemberNotFound is returned) or one result
+	// (account is connected with either Invited or Enabled status).
+	// More than single member in the results means the service misbehaves, which is reported as an error.
+	switch len(members.Members) {
+	case 0:
+		return "", ErrMemberNotFound
+	case 1:
+		// member without status is treated as not connected
+		return aws.StringValue(members.Members[0].RelationshipStatus), nil
+	default:
+		return "", &UnexpectedMembersError{AccountID: aws.StringValue(memberAccountID), Count: len(members.Members)}
 	}
-
-	// The check didn't fail but didn't found that member account is in Enabled state, returning no error.
-	return false, nil
 }
 
-// setUpGuardDutyMaster creates new member account and sends invite to it.
-func setUpGuardDutyMaster(g GuardDutyMasterClient, detectorID, memberAccountID, email *string) error {
+// setUpGuardDutyMaster creates new member account and sends invite to it,
+// with email notification only in case sendEmail is set.
+func setUpGuardDutyMaster(g GuardDutyMasterClient, detectorID, memberAccountID, email *string, message string, sendEmail bool) error {
 	_, err := g.CreateMembers(&guardduty.CreateMembersInput{
 		DetectorId: detectorID,
 		AccountDetails: []*guardduty.AccountDetail{{
@@ -128,11 +342,21 @@ func setUpGuardDutyMaster(g GuardDutyMasterClient, detectorID, memberAccountID,
 		return fmt.Errorf("error creating member account: %w", err)
 	}
 
-	_, err = g.InviteMembers(&guardduty.InviteMembersInput{
+	return inviteGuardDutyMember(g, detectorID, memberAccountID, message, sendEmail)
+}
+
+// inviteGuardDutyMember sends invite to already existing member account,
+// with email notification only in case sendEmail is set.
+func inviteGuardDutyMember(g GuardDutyMasterClient, detectorID, memberAccountID *string, message string, sendEmail bool) error {
+	input := &guardduty.InviteMembersInput{
 		DetectorId:               detectorID,
 		AccountIds:               []*string{memberAccountID},
-		DisableEmailNotification: aws.Bool(true),
-	})
+		DisableEmailNotification: aws.Bool(!sendEmail),
+	}
+	if message != "" {
+		input.Message = aws.String(message)
+	}
+	_, err := g.InviteMembers(input)
 	if err != nil {
 		return fmt.Errorf("error sending invitation: %w", err)
 	}
@@ -140,52 +364,212 @@ func setUpGuardDutyMaster(g GuardDutyMasterClient, detectorID, memberAccountID,
 	return nil
 }
 
-// acceptGuardDutyMemberInvitation looks for invitation from specified master account and accepts it
-func acceptGuardDutyMemberInvitation(g GuardDutyMemberClient, masterAccountID *string) error {
-	invitations, err := g.ListInvitations(nil)
+// acceptGuardDutyMemberInvitation looks for invitation from specified master account and accepts it,
+// creating member detector first in case there is none and createDetector is set
+func acceptGuardDutyMemberInvitation(g GuardDutyMemberClient, masterAccountID *string, createDetector bool) error {
+	list := func() ([]memberInvitation, error) {
+		res, err := g.ListInvitations(nil)
+		if err != nil {
+			return nil, err
+		}
+		invitations := make([]memberInvitation, 0, len(res.Invitations))
+		for _, inv := range res.Invitations {
+			invitations = append(invitations, memberInvitation{
+				accountID: aws.StringValue(inv.AccountId),
+				id:        aws.StringValue(inv.InvitationId),
+			})
+		}
+		return invitations, nil
+	}
+	accept := func(inv memberInvitation) error {
+		detector, err := getMemberDetectorID(g, createDetector)
+		if err != nil {
+			return fmt.Errorf("can't get detectorID to accept invitation: %w", err)
+		}
+
+		_, err = g.AcceptAdministratorInvitation(
+			&guardduty.AcceptAdministratorInvitationInput{
+				DetectorId:      detector,
+				InvitationId:    aws.String(inv.id),
+				AdministratorId: masterAccountID,
+			})
+		if err != nil {
+			return fmt.Errorf("error accepting invitation: %w", err)
+		}
+		return nil
+	}
+
+	return acceptMemberInvitation(list, fromAccount(*masterAccountID), accept)
+}
+
+// enableGuardDutyMemberFeatures enables provided features on member detector in case they are not enabled yet,
+// and returns if the update was made
+func enableGuardDutyMemberFeatures(g GuardDutyMasterClient, detectorID, memberAccountID *string, features []string) (bool, error) {
+	if len(features) == 0 {
+		return false, nil
+	}
+
+	detectors, err := g.GetMemberDetectors(&guardduty.GetMemberDetectorsInput{
+		DetectorId: detectorID,
+		AccountIds: []*string{memberAccountID},
+	})
 	if err != nil {
-		return fmt.Errorf("error retrieving list of invitations: %w", err)
+		return false, fmt.Errorf("error getting member detector: %w", err)
+	}
+	if len(detectors.MemberDataSourceConfigurations) == 1 &&
+		guardDutyFeaturesEnabled(detectors.MemberDataSourceConfigurations[0].DataSources, features) {
+		return false, nil
 	}
-	var invitationID *string
-	for _, inv := range invitations.Invitations {
-		if *inv.AccountId == *masterAccountID {
-			invitationID = inv.InvitationId
-			break
+
+	dataSources := &guardduty.DataSourceConfigurations{}
+	for _, f := range features {
+		switch f {
+		case GuardDutyFeatureS3Logs:
+			dataSources.S3Logs = &guardduty.S3LogsConfiguration{Enable: aws.Bool(true)}
+		case GuardDutyFeatureKubernetesAuditLogs:
+			dataSources.Kubernetes = &guardduty.KubernetesConfiguration{
+				AuditLogs: &guardduty.KubernetesAuditLogsConfiguration{Enable: aws.Bool(true)}}
+		case GuardDutyFeatureMalwareProtection:
+			dataSources.MalwareProtection = &guardduty.MalwareProtectionConfiguration{
+				ScanEc2InstanceWithFindings: &guardduty.ScanEc2InstanceWithFindings{EbsVolumes: aws.Bool(true)}}
 		}
 	}
-	if invitationID == nil {
-		return fmt.Errorf("can't find invitation from master account")
+
+	res, err := g.UpdateMemberDetectors(&guardduty.UpdateMemberDetectorsInput{
+		DetectorId:  detectorID,
+		AccountIds:  []*string{memberAccountID},
+		DataSources: dataSources,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error updating member detector: %w", err)
+	}
+	if len(res.UnprocessedAccounts) != 0 {
+		return false, fmt.Errorf("member detector wasn't updated: %s", aws.StringValue(res.UnprocessedAccounts[0].Result))
+	}
+
+	return true, nil
+}
+
+// setGuardDutyPublishFrequency sets finding publishing frequency of master detector, which applies to findings
+// of its members as well, in case it differs from the current one, and returns if the update was made
+func setGuardDutyPublishFrequency(g GuardDutyMasterClient, detectorID *string, frequency string) (bool, error) {
+	if frequency == "" {
+		return false, nil
+	}
+
+	detector, err := g.GetDetector(&guardduty.GetDetectorInput{DetectorId: detectorID})
+	if err != nil {
+		return false, fmt.Errorf("error getting master detector: %w", err)
+	}
+	if aws.StringValue(detector.FindingPublishingFrequency) == frequency {
+		return false, nil
 	}
 
-	detector, err := getDetectorID(g)
+	_, err = g.UpdateDetector(&guardduty.UpdateDetectorInput{
+		DetectorId:                 detectorID,
+		FindingPublishingFrequency: aws.String(frequency),
+	})
 	if err != nil {
-		return fmt.Errorf("can't get detectorID to accept invitation: %w", err)
+		return false, fmt.Errorf("error updating master detector: %w", err)
+	}
+	return true, nil
+}
+
+// guardDutyFeaturesEnabled checks if all provided features are enabled in data sources configuration
+func guardDutyFeaturesEnabled(ds *guardduty.DataSourceConfigurationsResult, features []string) bool {
+	if ds == nil {
+		return false
+	}
+	for _, f := range features {
+		var status *string
+		switch f {
+		case GuardDutyFeatureS3Logs:
+			if ds.S3Logs != nil {
+				status = ds.S3Logs.Status
+			}
+		case GuardDutyFeatureKubernetesAuditLogs:
+			if ds.Kubernetes != nil && ds.Kubernetes.AuditLogs != nil {
+				status = ds.Kubernetes.AuditLogs.Status
+			}
+		case GuardDutyFeatureMalwareProtection:
+			if ds.MalwareProtection != nil && ds.MalwareProtection.ScanEc2InstanceWithFindings != nil &&
+				ds.MalwareProtection.ScanEc2InstanceWithFindings.EbsVolumes != nil {
+				status = ds.MalwareProtection.ScanEc2InstanceWithFindings.EbsVolumes.Status
+			}
+		}
+		if aws.StringValue(status) != guardduty.DataSourceStatusEnabled {
+			return false
+		}
 	}
+	return true
+}
 
-	_, err = g.AcceptAdministratorInvitation(
-		&guardduty.AcceptAdministratorInvitationInput{
-			DetectorId:      detector,
-			InvitationId:    invitationID,
-			AdministratorId: masterAccountID,
+// listGuardDutyMemberStatuses returns relationship statuses of all members, including not associated ones
+func listGuardDutyMemberStatuses(g GuardDutyMasterClient, detectorID *string) (map[string]string, error) {
+	return membersByStatus(func(nextToken *string) (map[string]string, *string, error) {
+		members, err := g.ListMembers(&guardduty.ListMembersInput{
+			DetectorId:     detectorID,
+			NextToken:      nextToken,
+			OnlyAssociated: aws.String("false"),
 		})
+		if err != nil {
+			return nil, nil, err
+		}
+		statuses := map[string]string{}
+		for _, m := range members.Members {
+			statuses[aws.StringValue(m.AccountId)] = aws.StringValue(m.RelationshipStatus)
+		}
+		return statuses, members.NextToken, nil
+	})
+}
+
+// listAllDetectors returns IDs of detectors from all pages of detectors list
+func listAllDetectors(g GuardDutyListDetectors) ([]*string, error) {
+	var detectorIDs []*string
+	err := g.ListDetectorsPages(&guardduty.ListDetectorsInput{}, func(page *guardduty.ListDetectorsOutput, _ bool) bool {
+		detectorIDs = append(detectorIDs, page.DetectorIds...)
+		return true
+	})
 	if err != nil {
-		return fmt.Errorf("error accepting invitation: %w", err)
+		return nil, err
 	}
+	return detectorIDs, nil
+}
 
-	return nil
+// getMemberDetectorID looks for a single detector in member account and returns its ID, creating and enabling
+// detector in case there is none and create is set
+func getMemberDetectorID(g GuardDutyMemberClient, create bool) (*string, error) {
+	detectorIDs, err := listAllDetectors(g)
+	if err != nil {
+		return nil, fmt.Errorf("error listing detectors: %w", err)
+	}
+	if len(detectorIDs) == 0 && create {
+		res, err := g.CreateDetector(&guardduty.CreateDetectorInput{Enable: aws.Bool(true)})
+		if err != nil {
+			return nil, fmt.Errorf("error creating detector: %w", err)
+		}
+		return res.DetectorId, nil
+	}
+	if len(detectorIDs) != 1 {
+		return nil, fmt.Errorf(
+			"%d detectors found instead of one",
+			len(detectorIDs),
+		)
+	}
+	return detectorIDs[0], nil
 }
 
 // getDetectorID looks for a single detector and returns its ID, or error otherwise
 func getDetectorID(g GuardDutyListDetectors) (*string, error) {
-	detectors, err := g.ListDetectors(nil)
+	detectorIDs, err := listAllDetectors(g)
 	if err != nil {
 		return nil, fmt.Errorf("error listing detectors: %w", err)
 	}
-	if len(detectors.DetectorIds) != 1 {
+	if len(detectorIDs) != 1 {
 		return nil, fmt.Errorf(
 			"%d detectors found instead of one",
-			len(detectors.DetectorIds),
+			len(detectorIDs),
 		)
 	}
-	return detectors.DetectorIds[0], nil
+	return detectorIDs[0], nil
 }
@@ -15,8 +15,11 @@
 package connectors
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/guardduty"
@@ -35,8 +38,14 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 		emptyGMReq      = gdGetMembersReq{output: &guardduty.GetMembersOutput{}}
 		associatedGMReq = gdGetMembersReq{output: &guardduty.GetMembersOutput{
 			Members: []*guardduty.Member{{RelationshipStatus: aws.String("Enabled")}}}}
+		severalGMReq = gdGetMembersReq{output: &guardduty.GetMembersOutput{
+			Members: []*guardduty.Member{{RelationshipStatus: aws.String("Enabled")}, {RelationshipStatus: aws.String("Enabled")}}}}
 		invitedGMReq = gdGetMembersReq{output: &guardduty.GetMembersOutput{
 			Members: []*guardduty.Member{{RelationshipStatus: aws.String("Invited")}}}}
+		disabledGMReq = gdGetMembersReq{output: &guardduty.GetMembersOutput{
+			Members: []*guardduty.Member{{RelationshipStatus: aws.String("Disabled")}}}}
+		removedGMReq = gdGetMembersReq{output: &guardduty.GetMembersOutput{
+			Members: []*guardduty.Member{{RelationshipStatus: aws.String("Removed")}}}}
 		badCMReq   = gdCreateMembersReq{err: fmt.Errorf("mock err")}
 		badIMReq   = gdInviteMembersReq{err: fmt.Errorf("mock err")}
 		badLIReq   = gdListInvitationsReq{err: fmt.Errorf("mock err")}
@@ -44,26 +53,78 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 		goodLIReq  = gdListInvitationsReq{output: &guardduty.ListInvitationsOutput{
 			Invitations: []*guardduty.Invitation{{AccountId: &masterAccID, InvitationId: &invitationID}}}}
 		badAIReq  = gdAcceptInvitationReq{err: fmt.Errorf("mock err")}
-		badDReq   = gdDetectorReq{err: fmt.Errorf("mock err")}
-		emptyDReq = gdDetectorReq{output: &guardduty.ListDetectorsOutput{}}
-		goodDReq  = gdDetectorReq{output: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&detectorID}}}
+		enabledDS = &guardduty.DataSourceConfigurationsResult{
+			S3Logs: &guardduty.S3LogsConfigurationResult{Status: aws.String("ENABLED")},
+			MalwareProtection: &guardduty.MalwareProtectionConfigurationResult{
+				ScanEc2InstanceWithFindings: &guardduty.ScanEc2InstanceWithFindingsResult{
+					EbsVolumes: &guardduty.EbsVolumesResult{Status: aws.String("ENABLED")}}}}
+		disabledDS = &guardduty.DataSourceConfigurationsResult{
+			S3Logs: &guardduty.S3LogsConfigurationResult{Status: aws.String("ENABLED")},
+			MalwareProtection: &guardduty.MalwareProtectionConfigurationResult{
+				ScanEc2InstanceWithFindings: &guardduty.ScanEc2InstanceWithFindingsResult{
+					EbsVolumes: &guardduty.EbsVolumesResult{Status: aws.String("DISABLED")}}}}
+		features      = []string{GuardDutyFeatureS3Logs, GuardDutyFeatureMalwareProtection}
+		badGMDReq     = gdGetMemberDetectorsReq{err: fmt.Errorf("mock err")}
+		enabledGMDReq = gdGetMemberDetectorsReq{output: &guardduty.GetMemberDetectorsOutput{
+			MemberDataSourceConfigurations: []*guardduty.MemberDataSourceConfiguration{{DataSources: enabledDS}}}}
+		disabledGMDReq = gdGetMemberDetectorsReq{output: &guardduty.GetMemberDetectorsOutput{
+			MemberDataSourceConfigurations: []*guardduty.MemberDataSourceConfiguration{{DataSources: disabledDS}}}}
+		badUMDReq         = gdUpdateMemberDetectorsReq{err: fmt.Errorf("mock err")}
+		goodUMDReq        = gdUpdateMemberDetectorsReq{output: &guardduty.UpdateMemberDetectorsOutput{}}
+		unprocessedUMDReq = gdUpdateMemberDetectorsReq{output: &guardduty.UpdateMemberDetectorsOutput{
+			UnprocessedAccounts: []*guardduty.UnprocessedAccount{{Result: aws.String("mock reason")}}}}
+		badDReq             = gdDetectorReq{err: fmt.Errorf("mock err")}
+		emptyDReq           = gdDetectorReq{output: &guardduty.ListDetectorsOutput{}}
+		goodDReq            = gdDetectorReq{output: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&detectorID}}}
+		badGDReq            = gdGetDetectorReq{err: fmt.Errorf("mock err")}
+		fifteenMinutesGDReq = gdGetDetectorReq{output: &guardduty.GetDetectorOutput{
+			FindingPublishingFrequency: aws.String(guardduty.FindingPublishingFrequencyFifteenMinutes)}}
+		sixHoursGDReq = gdGetDetectorReq{output: &guardduty.GetDetectorOutput{
+			FindingPublishingFrequency: aws.String(guardduty.FindingPublishingFrequencySixHours)}}
+		badUDReq = gdUpdateDetectorReq{err: fmt.Errorf("mock err")}
 	)
 
 	var testAPIRequestsDataset = []struct {
 		description string
 		error       string
+		errIs       error
+		errMembers  int
+		status      Status
 		gmReq       gdGetMembersReq
 		cmReq       gdCreateMembersReq
 		imReq       gdInviteMembersReq
 		liReq       gdListInvitationsReq
+		liResentReq gdListInvitationsReq
 		aiReq       gdAcceptInvitationReq
 		dReqMember  gdDetectorReq
 		dReqMaster  gdDetectorReq
+		features    []string
+		gmdReq      gdGetMemberDetectorsReq
+		umdReq      gdUpdateMemberDetectorsReq
+		frequency   string
+		gdReq       gdGetDetectorReq
+		udReq       gdUpdateDetectorReq
+		message     string
+		sendEmail   bool
+		gmWaitReqs  []gdGetMembersReq
+		attempts    int
+		connected   []string
+		// apiCalls are expected calls creating and inviting member, not checked when nil
+		apiCalls []string
+		// createDetector enables member detector creation, detectorCreated is if it's expected to be created
+		createDetector  bool
+		cdReq           gdCreateDetectorReq
+		detectorCreated bool
 	}{
 		{description: "problem checking existing members",
 			dReqMaster: goodDReq,
 			gmReq:      badGMReq,
 			error:      "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "several members returned for single account",
+			dReqMaster: goodDReq,
+			gmReq:      severalGMReq,
+			error:      "error retrieving information about existing member account: 2 members returned instead of one for account 112233445566",
+			errMembers: 2},
 		{description: "error checking detector during check of existing account",
 			gmReq:      associatedGMReq,
 			dReqMaster: badDReq,
@@ -72,7 +133,73 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 			gmReq:      associatedGMReq,
 			dReqMaster: emptyDReq,
 			error:      "can't get detectorID of master account: 0 detectors found instead of one"},
-		{description: "member already enabled", gmReq: associatedGMReq, dReqMaster: goodDReq},
+		{description: "member already enabled", gmReq: associatedGMReq, dReqMaster: goodDReq, status: StatusAlreadyConnected},
+		{description: "member already connected with alternate status",
+			gmReq: gdGetMembersReq{output: &guardduty.GetMembersOutput{
+				Members: []*guardduty.Member{{RelationshipStatus: aws.String("Monitored")}}}},
+			connected:  []string{"Enabled", "Monitored"},
+			dReqMaster: goodDReq,
+			status:     StatusAlreadyConnected},
+		{description: "member already enabled with features",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			features:   features,
+			gmdReq:     enabledGMDReq,
+			status:     StatusAlreadyConnected},
+		{description: "member already enabled, features enabled",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			features:   features,
+			gmdReq:     disabledGMDReq,
+			umdReq:     goodUMDReq,
+			status:     StatusUpdated},
+		{description: "problem getting member detector",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			features:   features,
+			gmdReq:     badGMDReq,
+			error:      "error enabling features on member account: error getting member detector: mock err"},
+		{description: "problem updating member detector",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			features:   features,
+			gmdReq:     disabledGMDReq,
+			umdReq:     badUMDReq,
+			error:      "error enabling features on member account: error updating member detector: mock err"},
+		{description: "member detector not updated",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			features:   features,
+			gmdReq:     disabledGMDReq,
+			umdReq:     unprocessedUMDReq,
+			error:      "error enabling features on member account: member detector wasn't updated: mock reason"},
+		{description: "member already enabled with publishing frequency",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			frequency:  guardduty.FindingPublishingFrequencyFifteenMinutes,
+			gdReq:      fifteenMinutesGDReq,
+			apiCalls:   []string{},
+			status:     StatusAlreadyConnected},
+		{description: "member already enabled, publishing frequency updated",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			frequency:  guardduty.FindingPublishingFrequencyFifteenMinutes,
+			gdReq:      sixHoursGDReq,
+			apiCalls:   []string{"UpdateDetector"},
+			status:     StatusUpdated},
+		{description: "problem getting master detector",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			frequency:  guardduty.FindingPublishingFrequencyFifteenMinutes,
+			gdReq:      badGDReq,
+			error:      "error setting finding publishing frequency: error getting master detector: mock err"},
+		{description: "problem updating master detector",
+			gmReq:      associatedGMReq,
+			dReqMaster: goodDReq,
+			frequency:  guardduty.FindingPublishingFrequencyFifteenMinutes,
+			gdReq:      sixHoursGDReq,
+			udReq:      badUDReq,
+			error:      "error setting finding publishing frequency: error updating master detector: mock err"},
 		{description: "problem creating member account",
 			dReqMaster: goodDReq,
 			gmReq:      emptyGMReq,
@@ -92,7 +219,21 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 			dReqMaster: goodDReq,
 			gmReq:      invitedGMReq,
 			liReq:      emptyLIReq,
-			error:      "error accepting invitation in member account: can't find invitation from master account"},
+			error:      "error accepting invitation in member account: can't find invitation from master account",
+			errIs:      ErrInvitationMissing},
+		{description: "invitation not found, re-sent and accepted",
+			dReqMaster:  goodDReq,
+			dReqMember:  goodDReq,
+			gmReq:       invitedGMReq,
+			liReq:       emptyLIReq,
+			liResentReq: goodLIReq,
+			status:      StatusUpdated},
+		{description: "invitation not found, problem re-sending it",
+			dReqMaster: goodDReq,
+			gmReq:      invitedGMReq,
+			liReq:      emptyLIReq,
+			imReq:      badIMReq,
+			error:      "error re-sending invitation: error sending invitation: mock err"},
 		{description: "error checking detector during accepting invitation",
 			dReqMaster: goodDReq,
 			dReqMember: badDReq,
@@ -107,6 +248,31 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 			liReq:      goodLIReq,
 			error: "error accepting invitation in member account: can't get detectorID to accept invitation: " +
 				"0 detectors found instead of one"},
+		{description: "empty detector created during accepting invitation",
+			dReqMaster:      goodDReq,
+			dReqMember:      emptyDReq,
+			gmReq:           invitedGMReq,
+			liReq:           goodLIReq,
+			createDetector:  true,
+			detectorCreated: true,
+			status:          StatusAccepted},
+		{description: "existing detector is used during accepting invitation with detector creation",
+			dReqMaster:     goodDReq,
+			dReqMember:     goodDReq,
+			gmReq:          invitedGMReq,
+			liReq:          goodLIReq,
+			createDetector: true,
+			status:         StatusAccepted},
+		{description: "problem creating detector during accepting invitation",
+			dReqMaster:      goodDReq,
+			dReqMember:      emptyDReq,
+			gmReq:           invitedGMReq,
+			liReq:           goodLIReq,
+			createDetector:  true,
+			cdReq:           gdCreateDetectorReq{err: fmt.Errorf("mock err")},
+			detectorCreated: true,
+			error: "error accepting invitation in member account: can't get detectorID to accept invitation: " +
+				"error creating detector: mock err"},
 		{description: "problem accepting invitation",
 			dReqMaster: goodDReq,
 			dReqMember: goodDReq,
@@ -118,10 +284,117 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 			dReqMaster: goodDReq,
 			dReqMember: goodDReq,
 			gmReq:      invitedGMReq,
-			liReq:      goodLIReq},
+			liReq:      goodLIReq,
+			apiCalls:   []string{},
+			status:     StatusAccepted},
+		{description: "correctly create member, send and accept invitation",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			apiCalls:   []string{"CreateMembers", "InviteMembers"},
+			status:     StatusInvited},
+		{description: "disabled member re-invited and accepted",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      disabledGMReq,
+			liReq:      goodLIReq,
+			apiCalls:   []string{"InviteMembers"},
+			status:     StatusUpdated},
+		{description: "removed member re-invited and accepted",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      removedGMReq,
+			liReq:      goodLIReq,
+			apiCalls:   []string{"InviteMembers"},
+			status:     StatusUpdated},
+		{description: "problem re-inviting disabled member",
+			dReqMaster: goodDReq,
+			gmReq:      disabledGMReq,
+			imReq:      badIMReq,
+			apiCalls:   []string{"InviteMembers"},
+			error:      "error re-inviting member account: error sending invitation: mock err"},
+		{description: "invitation not found, re-sent without re-creating member and accepted",
+			dReqMaster:  goodDReq,
+			dReqMember:  goodDReq,
+			gmReq:       invitedGMReq,
+			liReq:       emptyLIReq,
+			liResentReq: goodLIReq,
+			apiCalls:    []string{"InviteMembers"},
+			status:      StatusUpdated},
+		{description: "correctly create member, send and accept invitation and enable features",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			features:   features,
+			gmdReq:     disabledGMDReq,
+			umdReq:     goodUMDReq,
+			status:     StatusInvited},
+		{description: "correctly create member, send and accept invitation and set publishing frequency",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			frequency:  guardduty.FindingPublishingFrequencyFifteenMinutes,
+			gdReq:      sixHoursGDReq,
+			apiCalls:   []string{"CreateMembers", "InviteMembers", "UpdateDetector"},
+			status:     StatusInvited},
+		{description: "correctly create member, send invitation with message and email and accept it",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			message:    "mock message",
+			sendEmail:  true,
+			status:     StatusInvited},
+		{description: "invitation found on second attempt",
+			dReqMaster:  goodDReq,
+			dReqMember:  goodDReq,
+			gmReq:       emptyGMReq,
+			liReq:       emptyLIReq,
+			liResentReq: goodLIReq,
+			attempts:    3,
+			status:      StatusInvited},
+		{description: "invitation not found after all attempts",
+			dReqMaster: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      emptyLIReq,
+			attempts:   2,
+			error:      "error accepting invitation in member account: can't find invitation from master account",
+			errIs:      ErrInvitationMissing},
+		{description: "member enabled after waiting",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []gdGetMembersReq{invitedGMReq, invitedGMReq, associatedGMReq},
+			status:     StatusInvited},
+		{description: "member deleted while waiting",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []gdGetMembersReq{invitedGMReq, emptyGMReq},
+			error:      "error waiting for member account to be enabled: member account is not found in master account",
+			errIs:      ErrMemberNotFound},
+		{description: "member not enabled after waiting",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []gdGetMembersReq{invitedGMReq, invitedGMReq, invitedGMReq, invitedGMReq},
+			error:      "error waiting for member account to be enabled: member status is Invited instead of Enabled after 3m0s"},
+		{description: "problem getting member status while waiting",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []gdGetMembersReq{invitedGMReq, badGMReq},
+			error:      "error waiting for member account to be enabled: error getting existing members: mock err"},
 	}
 
-	masterSess, memberSess := NewMasterMemberSess("us-west-2", "", "")
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws"})
 	for i, x := range testAPIRequestsDataset {
 		i := i
 		x := x
@@ -131,8 +404,18 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 				memberAccID: &memberAccID,
 				detectorID:  &detectorID,
 				gmReq:       x.gmReq,
+				gmWaitReqs:  x.gmWaitReqs,
+				gmCalls:     new(int),
 				cmReq:       x.cmReq,
 				imReq:       x.imReq,
+				message:     x.message,
+				sendEmail:   x.sendEmail,
+				gmdReq:      x.gmdReq,
+				umdReq:      x.umdReq,
+				frequency:   x.frequency,
+				gdReq:       x.gdReq,
+				udReq:       x.udReq,
+				apiCalls:    &[]string{},
 			}
 			master.t = t               // promoted field
 			master.dReq = x.dReqMaster // promoted field
@@ -141,24 +424,119 @@ func TestGuardDutyInviter_AddMember(t *testing.T) {
 				invitationID:    &invitationID,
 				detectorID:      &detectorID,
 				liReq:           x.liReq,
+				liResentReq:     x.liResentReq,
 				aiReq:           x.aiReq,
+				cdReq:           x.cdReq,
 			}
 			member.t = t               // promoted field
 			member.dReq = x.dReqMember // promoted field
-			s := NewGuardDutyInviter(masterSess, memberSess)
+			s := NewGuardDutyInviter(masterSess, memberSess, x.features, x.message, x.sendEmail)
 			s.masterSvc = master
 			s.memberSvc = member
-			err := s.AddMember(memberAccID, testEmail, masterAccID)
+			s.publishFrequency = x.frequency
+			s.connectedStatuses = x.connected
+			s.createDetector = x.createDetector
+			if len(x.gmWaitReqs) > 0 {
+				s.waiter = &memberWaiter{pollInterval: time.Minute, timeout: 3 * time.Minute, sleep: func(context.Context, time.Duration) error { return nil }}
+			}
+			if x.attempts > 0 {
+				s.retryer = &invitationRetryer{attempts: x.attempts, delay: time.Second, sleep: func(context.Context, time.Duration) error { return nil }}
+			}
+			res, err := s.AddMember(memberAccID, testEmail, masterAccID)
+			assert.Equal(t, x.detectorCreated, member.detectorCreated, "Test case %d detector creation check failed", i)
+			if x.apiCalls != nil {
+				assert.Equal(t, x.apiCalls, *master.apiCalls, "Test case %d API calls check failed", i)
+			}
 
 			if x.error != "" {
 				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				assert.Equal(t, StatusFailed, res.Status, "Test case %d status check failed", i)
+				var serviceErr *ServiceError
+				if assert.True(t, errors.As(err, &serviceErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "guardduty", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+				if x.errIs != nil {
+					assert.ErrorIs(t, err, x.errIs, "Test case %d errors.Is check failed", i)
+				}
+				if x.errMembers != 0 {
+					var membersErr *UnexpectedMembersError
+					if assert.True(t, errors.As(err, &membersErr), "Test case %d errors.As check failed", i) {
+						assert.Equal(t, x.errMembers, membersErr.Count, "Test case %d members count check failed", i)
+					}
+				}
 			} else {
 				assert.NoError(t, err, "Test case %d error check failed", i)
+				assert.Equal(t, x.status, res.Status, "Test case %d status check failed", i)
 			}
 		})
 	}
 }
 
+func TestParseGuardDutyFeatures(t *testing.T) {
+	features, err := ParseGuardDutyFeatures("")
+	assert.NoError(t, err)
+	assert.Empty(t, features)
+
+	features, err = ParseGuardDutyFeatures("s3_logs, kubernetes_audit_logs,malware_protection")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{GuardDutyFeatureS3Logs, GuardDutyFeatureKubernetesAuditLogs, GuardDutyFeatureMalwareProtection},
+		features)
+
+	_, err = ParseGuardDutyFeatures("s3_logs,rds_logs")
+	assert.EqualError(t, err, `unknown GuardDuty feature "rds_logs"`)
+}
+
+func TestGetDetectorID(t *testing.T) {
+	var (
+		firstID  = "detector1"
+		secondID = "detector2"
+	)
+
+	var testData = []struct {
+		description string
+		dReq        gdDetectorReq
+		id          *string
+		error       string
+	}{
+		{description: "problem listing detectors",
+			dReq:  gdDetectorReq{err: fmt.Errorf("mock err")},
+			error: "error listing detectors: mock err"},
+		{description: "no detectors",
+			dReq:  gdDetectorReq{output: &guardduty.ListDetectorsOutput{}},
+			error: "0 detectors found instead of one"},
+		{description: "single detector on the second page",
+			dReq: gdDetectorReq{output: &guardduty.ListDetectorsOutput{NextToken: aws.String("page2")},
+				nextPage: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&firstID}}},
+			id: &firstID},
+		{description: "detectors across two pages",
+			dReq: gdDetectorReq{
+				output:   &guardduty.ListDetectorsOutput{DetectorIds: []*string{&firstID}, NextToken: aws.String("page2")},
+				nextPage: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&secondID}}},
+			error: "2 detectors found instead of one"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			id, err := getDetectorID(mockGDDetectorClient{t: t, dReq: x.dReq})
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.id, id, "Test case %d ID check failed", i)
+		})
+	}
+
+	// all detectors are returned by the listing itself
+	ids, err := listAllDetectors(mockGDDetectorClient{t: t, dReq: gdDetectorReq{
+		output:   &guardduty.ListDetectorsOutput{DetectorIds: []*string{&firstID}, NextToken: aws.String("page2")},
+		nextPage: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&secondID}}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []*string{&firstID, &secondID}, ids)
+}
+
 type mockGDDetectorClient struct {
 	t    *testing.T
 	dReq gdDetectorReq
@@ -166,12 +544,20 @@ type mockGDDetectorClient struct {
 
 type gdDetectorReq struct {
 	output *guardduty.ListDetectorsOutput
-	err    error
+	// nextPage is returned as the second page of detectors list in case it's set
+	nextPage *guardduty.ListDetectorsOutput
+	err      error
 }
 
-func (s mockGDDetectorClient) ListDetectors(input *guardduty.ListDetectorsInput) (*guardduty.ListDetectorsOutput, error) {
-	assert.Nil(s.t, input)
-	return s.dReq.output, s.dReq.err
+func (s mockGDDetectorClient) ListDetectorsPages(input *guardduty.ListDetectorsInput, fn func(*guardduty.ListDetectorsOutput, bool) bool) error {
+	assert.Equal(s.t, &guardduty.ListDetectorsInput{}, input)
+	if s.dReq.err != nil {
+		return s.dReq.err
+	}
+	if fn(s.dReq.output, s.dReq.nextPage == nil) && s.dReq.nextPage != nil {
+		fn(s.dReq.nextPage, true)
+	}
+	return nil
 }
 
 type mockGDMasterClient struct {
@@ -182,6 +568,21 @@ type mockGDMasterClient struct {
 	gmReq       gdGetMembersReq
 	cmReq       gdCreateMembersReq
 	imReq       gdInviteMembersReq
+	message     string
+	sendEmail   bool
+	gmdReq      gdGetMemberDetectorsReq
+	umdReq      gdUpdateMemberDetectorsReq
+	// lmPages are pages of members list keyed by page token, empty for the first page
+	lmPages map[string]*guardduty.ListMembersOutput
+	lmErr   error
+	// gmWaitReqs are responses to GetMembers calls following the first one, made while waiting for member to be enabled
+	gmWaitReqs []gdGetMembersReq
+	gmCalls    *int
+	frequency  string
+	gdReq      gdGetDetectorReq
+	udReq      gdUpdateDetectorReq
+	// apiCalls records names of calls creating and inviting member and updating master detector
+	apiCalls *[]string
 }
 
 type gdGetMembersReq struct {
@@ -194,12 +595,39 @@ type gdCreateMembersReq struct {
 type gdInviteMembersReq struct {
 	err error
 }
+type gdGetMemberDetectorsReq struct {
+	output *guardduty.GetMemberDetectorsOutput
+	err    error
+}
+type gdUpdateMemberDetectorsReq struct {
+	output *guardduty.UpdateMemberDetectorsOutput
+	err    error
+}
+type gdGetDetectorReq struct {
+	output *guardduty.GetDetectorOutput
+	err    error
+}
+type gdUpdateDetectorReq struct {
+	err error
+}
 
 func (s mockGDMasterClient) GetMembers(input *guardduty.GetMembersInput) (*guardduty.GetMembersOutput, error) {
 	assert.Equal(s.t, &guardduty.GetMembersInput{AccountIds: []*string{s.memberAccID}, DetectorId: s.detectorID}, input)
+	if len(s.gmWaitReqs) > 0 {
+		*s.gmCalls++
+		if *s.gmCalls > 1 {
+			return s.gmWaitReqs[*s.gmCalls-2].output, s.gmWaitReqs[*s.gmCalls-2].err
+		}
+	}
 	return s.gmReq.output, s.gmReq.err
 }
 
+func (s mockGDMasterClient) ListMembers(input *guardduty.ListMembersInput) (*guardduty.ListMembersOutput, error) {
+	assert.Equal(s.t, s.detectorID, input.DetectorId)
+	assert.Equal(s.t, "false", aws.StringValue(input.OnlyAssociated))
+	return s.lmPages[aws.StringValue(input.NextToken)], s.lmErr
+}
+
 func (s mockGDMasterClient) CreateMembers(input *guardduty.CreateMembersInput) (*guardduty.CreateMembersOutput, error) {
 	assert.Equal(s.t, &guardduty.CreateMembersInput{
 		DetectorId: s.detectorID,
@@ -208,21 +636,69 @@ func (s mockGDMasterClient) CreateMembers(input *guardduty.CreateMembersInput) (
 			Email:     s.email,
 		}},
 	}, input)
+	if s.apiCalls != nil {
+		*s.apiCalls = append(*s.apiCalls, "CreateMembers")
+	}
 	return nil, s.cmReq.err
 }
 
 func (s mockGDMasterClient) InviteMembers(input *guardduty.InviteMembersInput) (*guardduty.InviteMembersOutput, error) {
-	assert.Equal(s.t, &guardduty.InviteMembersInput{AccountIds: []*string{s.memberAccID}, DetectorId: s.detectorID, DisableEmailNotification: aws.Bool(true)}, input)
+	expected := &guardduty.InviteMembersInput{AccountIds: []*string{s.memberAccID}, DetectorId: s.detectorID, DisableEmailNotification: aws.Bool(!s.sendEmail)}
+	if s.message != "" {
+		expected.Message = &s.message
+	}
+	assert.Equal(s.t, expected, input)
+	if s.apiCalls != nil {
+		*s.apiCalls = append(*s.apiCalls, "InviteMembers")
+	}
 	return nil, s.imReq.err
 }
 
+func (s mockGDMasterClient) GetMemberDetectors(input *guardduty.GetMemberDetectorsInput) (*guardduty.GetMemberDetectorsOutput, error) {
+	assert.Equal(s.t, &guardduty.GetMemberDetectorsInput{AccountIds: []*string{s.memberAccID}, DetectorId: s.detectorID}, input)
+	return s.gmdReq.output, s.gmdReq.err
+}
+
+func (s mockGDMasterClient) UpdateMemberDetectors(input *guardduty.UpdateMemberDetectorsInput) (*guardduty.UpdateMemberDetectorsOutput, error) {
+	assert.Equal(s.t, &guardduty.UpdateMemberDetectorsInput{
+		AccountIds: []*string{s.memberAccID},
+		DetectorId: s.detectorID,
+		DataSources: &guardduty.DataSourceConfigurations{
+			S3Logs: &guardduty.S3LogsConfiguration{Enable: aws.Bool(true)},
+			MalwareProtection: &guardduty.MalwareProtectionConfiguration{
+				ScanEc2InstanceWithFindings: &guardduty.ScanEc2InstanceWithFindings{EbsVolumes: aws.Bool(true)}},
+		},
+	}, input)
+	return s.umdReq.output, s.umdReq.err
+}
+
+func (s mockGDMasterClient) GetDetector(input *guardduty.GetDetectorInput) (*guardduty.GetDetectorOutput, error) {
+	assert.Equal(s.t, &guardduty.GetDetectorInput{DetectorId: s.detectorID}, input)
+	return s.gdReq.output, s.gdReq.err
+}
+
+func (s mockGDMasterClient) UpdateDetector(input *guardduty.UpdateDetectorInput) (*guardduty.UpdateDetectorOutput, error) {
+	assert.Equal(s.t, &guardduty.UpdateDetectorInput{
+		DetectorId:                 s.detectorID,
+		FindingPublishingFrequency: &s.frequency,
+	}, input)
+	if s.apiCalls != nil {
+		*s.apiCalls = append(*s.apiCalls, "UpdateDetector")
+	}
+	return &guardduty.UpdateDetectorOutput{}, s.udReq.err
+}
+
 type mockGDMemberClient struct {
 	mockGDDetectorClient
 	masterAccountID *string
 	invitationID    *string
 	detectorID      *string
 	liReq           gdListInvitationsReq
+	liResentReq     gdListInvitationsReq
+	liCalls         int
 	aiReq           gdAcceptInvitationReq
+	cdReq           gdCreateDetectorReq
+	detectorCreated bool
 }
 
 type gdListInvitationsReq struct {
@@ -232,13 +708,246 @@ type gdListInvitationsReq struct {
 type gdAcceptInvitationReq struct {
 	err error
 }
+type gdCreateDetectorReq struct {
+	err error
+}
 
-func (s mockGDMemberClient) ListInvitations(input *guardduty.ListInvitationsInput) (*guardduty.ListInvitationsOutput, error) {
+func (s *mockGDMemberClient) ListInvitations(input *guardduty.ListInvitationsInput) (*guardduty.ListInvitationsOutput, error) {
 	assert.Nil(s.t, input)
+	s.liCalls++
+	// invitations listed after the invitation is re-sent or looked for again
+	if s.liCalls > 1 && s.liResentReq.output != nil {
+		return s.liResentReq.output, s.liResentReq.err
+	}
 	return s.liReq.output, s.liReq.err
 }
 
+func (s *mockGDMemberClient) CreateDetector(input *guardduty.CreateDetectorInput) (*guardduty.CreateDetectorOutput, error) {
+	assert.Equal(s.t, &guardduty.CreateDetectorInput{Enable: aws.Bool(true)}, input)
+	s.detectorCreated = true
+	if s.cdReq.err != nil {
+		return nil, s.cdReq.err
+	}
+	return &guardduty.CreateDetectorOutput{DetectorId: s.detectorID}, nil
+}
+
 func (s mockGDMemberClient) AcceptAdministratorInvitation(input *guardduty.AcceptAdministratorInvitationInput) (*guardduty.AcceptAdministratorInvitationOutput, error) {
 	assert.Equal(s.t, &guardduty.AcceptAdministratorInvitationInput{InvitationId: s.invitationID, AdministratorId: s.masterAccountID, DetectorId: s.detectorID}, input)
 	return nil, s.aiReq.err
 }
+
+func TestGuardDutyInviter_Preflight(t *testing.T) {
+	var (
+		detectorID  = "detector1"
+		memberAccID = "112233445566"
+		goodDReq    = gdDetectorReq{output: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&detectorID}}}
+		emptyDReq   = gdDetectorReq{output: &guardduty.ListDetectorsOutput{}}
+		goodGMReq   = gdGetMembersReq{output: &guardduty.GetMembersOutput{}}
+		goodLIReq   = gdListInvitationsReq{output: &guardduty.ListInvitationsOutput{}}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		dReqMaster  gdDetectorReq
+		dReqMember  gdDetectorReq
+		gmReq       gdGetMembersReq
+		liReq       gdListInvitationsReq
+		// createDetector enables member detector creation
+		createDetector bool
+	}{
+		{description: "no master detector",
+			dReqMaster: emptyDReq,
+			error:      "can't get detectorID of master account: 0 detectors found instead of one"},
+		{description: "problem getting members",
+			dReqMaster: goodDReq,
+			gmReq:      gdGetMembersReq{err: fmt.Errorf("mock err")},
+			error:      "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "no member detector",
+			dReqMaster: goodDReq,
+			dReqMember: emptyDReq,
+			gmReq:      goodGMReq,
+			error:      "can't get detectorID of member account: 0 detectors found instead of one"},
+		{description: "no member detector with detector creation",
+			dReqMaster:     goodDReq,
+			dReqMember:     emptyDReq,
+			gmReq:          goodGMReq,
+			liReq:          goodLIReq,
+			createDetector: true},
+		{description: "problem listing member detectors with detector creation",
+			dReqMaster:     goodDReq,
+			dReqMember:     gdDetectorReq{err: fmt.Errorf("mock err")},
+			gmReq:          goodGMReq,
+			createDetector: true,
+			error:          "error listing detectors of member account: mock err"},
+		{description: "problem listing invitations",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      goodGMReq,
+			liReq:      gdListInvitationsReq{err: fmt.Errorf("mock err")},
+			error:      "error retrieving list of invitations: mock err"},
+		{description: "all checks passed",
+			dReqMaster: goodDReq,
+			dReqMember: goodDReq,
+			gmReq:      goodGMReq,
+			liReq:      goodLIReq},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			master := readOnlyGDMasterClient{
+				mockGDDetectorClient: mockGDDetectorClient{t: t, dReq: x.dReqMaster},
+				memberAccID:          &memberAccID,
+				detectorID:           &detectorID,
+				gmReq:                x.gmReq,
+			}
+			member := readOnlyGDMemberClient{
+				mockGDDetectorClient: mockGDDetectorClient{t: t, dReq: x.dReqMember},
+				liReq:                x.liReq,
+			}
+			g := GuardDutyInviter{masterSvc: master, memberSvc: member, createDetector: x.createDetector}
+			err := g.Preflight(memberAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+		})
+	}
+}
+
+func TestParseGuardDutyPublishFrequency(t *testing.T) {
+	testData := []struct {
+		description string
+		frequency   string
+		expected    string
+		error       string
+	}{
+		{description: "empty frequency"},
+		{description: "frequency as in API",
+			frequency: "FIFTEEN_MINUTES",
+			expected:  guardduty.FindingPublishingFrequencyFifteenMinutes},
+		{description: "lower case frequency with spaces",
+			frequency: " six_hours ",
+			expected:  guardduty.FindingPublishingFrequencySixHours},
+		{description: "unknown frequency",
+			frequency: "ONE_DAY",
+			error:     `unknown GuardDuty finding publishing frequency "ONE_DAY", should be one of FIFTEEN_MINUTES, ONE_HOUR, SIX_HOURS`},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			frequency, err := ParseGuardDutyPublishFrequency(x.frequency)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.expected, frequency, "Test case %d frequency check failed", i)
+		})
+	}
+}
+
+func TestGuardDutyInviter_MemberStatus(t *testing.T) {
+	var (
+		detectorID  = "mock_detector"
+		memberAccID = "112233445566"
+		goodDReq    = gdDetectorReq{output: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&detectorID}}}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		dReq        gdDetectorReq
+		gmReq       gdGetMembersReq
+		status      string
+	}{
+		{description: "problem listing detectors",
+			dReq:  gdDetectorReq{err: fmt.Errorf("mock err")},
+			error: "can't get detectorID of master account: error listing detectors: mock err"},
+		{description: "problem getting members",
+			dReq:  goodDReq,
+			gmReq: gdGetMembersReq{err: fmt.Errorf("mock err")},
+			error: "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "not a member",
+			dReq:   goodDReq,
+			gmReq:  gdGetMembersReq{output: &guardduty.GetMembersOutput{}},
+			status: MemberStatusNotMember},
+		{description: "member without status",
+			dReq:   goodDReq,
+			gmReq:  gdGetMembersReq{output: &guardduty.GetMembersOutput{Members: []*guardduty.Member{{}}}},
+			status: MemberStatusNotMember},
+		{description: "invited member",
+			dReq: goodDReq,
+			gmReq: gdGetMembersReq{output: &guardduty.GetMembersOutput{
+				Members: []*guardduty.Member{{RelationshipStatus: aws.String("Invited")}}}},
+			status: "Invited"},
+		{description: "enabled member",
+			dReq: goodDReq,
+			gmReq: gdGetMembersReq{output: &guardduty.GetMembersOutput{
+				Members: []*guardduty.Member{{RelationshipStatus: aws.String("Enabled")}}}},
+			status: "Enabled"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			// read-only clients panic on any mutating call
+			g := GuardDutyInviter{
+				masterSvc: readOnlyGDMasterClient{
+					mockGDDetectorClient: mockGDDetectorClient{t: t, dReq: x.dReq},
+					memberAccID:          &memberAccID,
+					detectorID:           &detectorID,
+					gmReq:                x.gmReq,
+				},
+				memberSvc: readOnlyGDMemberClient{mockGDDetectorClient: mockGDDetectorClient{t: t}},
+			}
+			status, err := g.MemberStatus(memberAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.status, status, "Test case %d status check failed", i)
+		})
+	}
+}
+
+// readOnlyGDMasterClient implements only read-only calls, any other call panics on nil embedded interface
+type readOnlyGDMasterClient struct {
+	GuardDutyMasterClient
+	mockGDDetectorClient
+	memberAccID *string
+	detectorID  *string
+	gmReq       gdGetMembersReq
+}
+
+func (s readOnlyGDMasterClient) ListDetectorsPages(input *guardduty.ListDetectorsInput, fn func(*guardduty.ListDetectorsOutput, bool) bool) error {
+	return s.mockGDDetectorClient.ListDetectorsPages(input, fn)
+}
+
+func (s readOnlyGDMasterClient) GetMembers(input *guardduty.GetMembersInput) (*guardduty.GetMembersOutput, error) {
+	assert.Equal(s.t, &guardduty.GetMembersInput{AccountIds: []*string{s.memberAccID}, DetectorId: s.detectorID}, input)
+	return s.gmReq.output, s.gmReq.err
+}
+
+// readOnlyGDMemberClient implements only read-only calls, any other call panics on nil embedded interface
+type readOnlyGDMemberClient struct {
+	GuardDutyMemberClient
+	mockGDDetectorClient
+	liReq gdListInvitationsReq
+}
+
+func (s readOnlyGDMemberClient) ListDetectorsPages(input *guardduty.ListDetectorsInput, fn func(*guardduty.ListDetectorsOutput, bool) bool) error {
+	return s.mockGDDetectorClient.ListDetectorsPages(input, fn)
+}
+
+func (s readOnlyGDMemberClient) ListInvitations(input *guardduty.ListInvitationsInput) (*guardduty.ListInvitationsOutput, error) {
+	assert.Nil(s.t, input)
+	return s.liReq.output, s.liReq.err
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	log "github.com/sirupsen/logrus"
+)
+
+// Inviter connects member account to master account of a single AWS security service in one region.
+type Inviter interface {
+	// AddMember connects member account to master and reports what was done.
+	AddMember(accountID, accountEmail, masterAccountID string) (Result, error)
+	// Name returns service identifier, used in logs and report.
+	Name() string
+	// Preflight verifies permissions needed for AddMember without changing anything.
+	Preflight(accountID string) error
+	// MemberStatus returns current status of member account in master without changing anything.
+	MemberStatus(accountID string) (string, error)
+}
+
+// memberStatusLister is implemented by inviters able to list statuses of all members at once, keyed by account ID,
+// which is cheaper than getting them one by one when many accounts are checked.
+type memberStatusLister interface {
+	MemberStatuses() (map[string]string, error)
+}
+
+// InvitersConfig describes which AWS security services should be connected and how.
+// Connected statuses are member statuses meaning that member is connected to master per service,
+// "Enabled" for GuardDuty, "Associated" for Security Hub and "ENABLED" for Detective are used if they're empty.
+// CreateMemberDetector makes GuardDuty detector created in member account in case it has none.
+type InvitersConfig struct {
+	GuardDuty              bool
+	GuardDutyFeatures      []string
+	GuardDutyInviteMessage string
+	GuardDutyInviteEmails  bool
+	GuardDutyFrequency     string
+	GuardDutyConnected     []string
+	CreateMemberDetector   bool
+	SecurityHub            bool
+	SuppressInviteEmails   bool
+	SecurityHubStandards   []string
+	SecurityHubConnected   []string
+	Detective              bool
+	DetectivePackages      []string
+	DetectiveConnected     []string
+	// DisabledControls are IDs of Security Hub controls to disable on member, by standard they belong to
+	DisabledControls map[string][]string
+	// WaitForEnabled makes inviters wait up to WaitForEnabledTimeout for member to become enabled
+	// after invitation is accepted
+	WaitForEnabled        bool
+	WaitForEnabledTimeout time.Duration
+	// InvitationAttempts is the number of times to look for just sent invitation in member account,
+	// with InvitationRetryDelay before the first retry which is doubled after every attempt
+	InvitationAttempts   int
+	InvitationRetryDelay time.Duration
+	// ClientFactory is used for creating AWS clients of all inviters, default one is used if not set
+	ClientFactory *ClientFactory
+	// Logger is used by inviters for all messages, so that context fields like account ID and region
+	// are attached to them; standard logger is used if not set
+	Logger *log.Entry
+	// Context interrupts waiting for member to be enabled or Security Hub standard to be ready, and retrying
+	// invitation lookup once it's done, background context is used if not set
+	Context context.Context
+}
+
+// NewInviters returns inviters for all services enabled in provided config.
+func NewInviters(masterSess, memberSess client.ConfigProvider, cfg InvitersConfig) []Inviter {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = log.NewEntry(log.StandardLogger())
+	}
+	factory := cfg.ClientFactory
+	if factory == nil {
+		factory = NewClientFactory()
+	}
+	ctx := cfg.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var waiter *memberWaiter
+	if cfg.WaitForEnabled {
+		waiter = newMemberWaiter(ctx, cfg.WaitForEnabledTimeout)
+	}
+	var retryer *invitationRetryer
+	if cfg.InvitationAttempts > 1 {
+		retryer = newInvitationRetryer(ctx, cfg.InvitationAttempts, cfg.InvitationRetryDelay)
+	}
+	var inviters []Inviter
+	if cfg.GuardDuty {
+		g := NewGuardDutyInviterWithFactory(factory, masterSess, memberSess, cfg.GuardDutyFeatures, cfg.GuardDutyInviteMessage, cfg.GuardDutyInviteEmails)
+		g.publishFrequency = cfg.GuardDutyFrequency
+		g.connectedStatuses = cfg.GuardDutyConnected
+		g.createDetector = cfg.CreateMemberDetector
+		g.waiter = waiter
+		g.retryer = retryer
+		g.log = logger
+		inviters = append(inviters, g)
+	}
+	if cfg.SecurityHub {
+		s := NewSecurityHubInviterWithFactory(factory, masterSess, memberSess, cfg.SuppressInviteEmails, cfg.SecurityHubStandards)
+		s.disabledControls = cfg.DisabledControls
+		s.standardWaiter = newStandardWaiter(ctx)
+		s.connectedStatuses = cfg.SecurityHubConnected
+		s.waiter = waiter
+		s.retryer = retryer
+		s.log = logger
+		inviters = append(inviters, s)
+	}
+	if cfg.Detective {
+		d := NewDetectiveInviterWithFactory(factory, masterSess, memberSess, cfg.DetectivePackages)
+		d.connectedStatuses = cfg.DetectiveConnected
+		d.waiter = waiter
+		d.retryer = retryer
+		d.log = logger
+		inviters = append(inviters, d)
+	}
+	return inviters
+}
+
+// Enabled returns true if at least one service is enabled in the config.
+func (c InvitersConfig) Enabled() bool {
+	return c.GuardDuty || c.SecurityHub || c.Detective
+}
+
+// EnableServices enables services from provided comma-separated list in addition to already enabled ones,
+// returning error in case of unknown service. Both "securityhub" and "security_hub" name Security Hub.
+func (c *InvitersConfig) EnableServices(s string) error {
+	for _, service := range strings.Split(s, ",") {
+		service = strings.TrimSpace(service)
+		if service == "" {
+			continue
+		}
+		name, err := serviceName(service)
+		if err != nil {
+			return err
+		}
+		switch name {
+		case "guardduty":
+			c.GuardDuty = true
+		case "security_hub":
+			c.SecurityHub = true
+		case "detective":
+			c.Detective = true
+		}
+	}
+	return nil
+}
+
+// serviceName returns name of inviter of provided service as returned by its Name method,
+// or error in case of unknown service. Both "securityhub" and "security_hub" name Security Hub.
+func serviceName(service string) (string, error) {
+	switch service {
+	case "guardduty", "security_hub", "detective":
+		return service, nil
+	case "securityhub":
+		return "security_hub", nil
+	}
+	return "", fmt.Errorf("unknown service %q", service)
+}
+
+// ServiceRegionExceptions holds regions in which particular services should be skipped,
+// keyed by name of the service inviter.
+type ServiceRegionExceptions map[string]map[string]bool
+
+// ParseServiceRegionExceptions parses comma-separated list of service:region pairs like
+// "detective:ap-east-1,guardduty:us-west-1", returning error in case of unknown service or malformed pair.
+func ParseServiceRegionExceptions(s string) (ServiceRegionExceptions, error) {
+	exceptions := ServiceRegionExceptions{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.Split(pair, ":")
+		if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("%q is not in service:region format", pair)
+		}
+		name, err := serviceName(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, err
+		}
+		if exceptions[name] == nil {
+			exceptions[name] = map[string]bool{}
+		}
+		exceptions[name][strings.TrimSpace(parts[1])] = true
+	}
+	return exceptions, nil
+}
+
+// Skip returns true in case provided service should be skipped in provided region.
+func (e ServiceRegionExceptions) Skip(service, region string) bool {
+	return e[service][region]
+}
+
+// memberStatusOrNotMember returns provided member status, or MemberStatusNotMember in case it's empty
+func memberStatusOrNotMember(status string) string {
+	if status == "" {
+		return MemberStatusNotMember
+	}
+	return status
+}
+
+// logResult logs the outcome of AddMember call
+func logResult(l *log.Entry, res Result, err error) {
+	if err != nil {
+		l.WithError(err).Warn("Problem connecting member account")
+		return
+	}
+	l.WithField("status", res.Status).Info("Member account connected")
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/detective"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInviters(t *testing.T) {
+	testData := []struct {
+		description string
+		cfg         InvitersConfig
+		names       []string
+	}{
+		{description: "nothing enabled",
+			cfg: InvitersConfig{}},
+		{description: "GuardDuty only",
+			cfg:   InvitersConfig{GuardDuty: true},
+			names: []string{"guardduty"}},
+		{description: "Security Hub and Detective",
+			cfg:   InvitersConfig{SecurityHub: true, Detective: true},
+			names: []string{"security_hub", "detective"}},
+		{description: "all services",
+			cfg:   InvitersConfig{GuardDuty: true, SecurityHub: true, Detective: true},
+			names: []string{"guardduty", "security_hub", "detective"}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			inviters := NewInviters(unit.Session, unit.Session, x.cfg)
+			var names []string
+			for _, inviter := range inviters {
+				names = append(names, inviter.Name())
+			}
+			assert.Equal(t, x.names, names, "Test case %d", i)
+			assert.Equal(t, len(x.names) > 0, x.cfg.Enabled(), "Test case %d", i)
+		})
+	}
+}
+
+func TestInvitersConfig_EnableServices(t *testing.T) {
+	testData := []struct {
+		description string
+		cfg         InvitersConfig
+		services    string
+		expected    InvitersConfig
+		error       string
+	}{
+		{description: "empty list",
+			services: ""},
+		{description: "all services",
+			services: "guardduty,securityhub,detective",
+			expected: InvitersConfig{GuardDuty: true, SecurityHub: true, Detective: true}},
+		{description: "spaces, duplicates and service name",
+			services: " security_hub, ,securityhub ",
+			expected: InvitersConfig{SecurityHub: true}},
+		{description: "union with separate flags",
+			cfg:      InvitersConfig{GuardDuty: true},
+			services: "detective",
+			expected: InvitersConfig{GuardDuty: true, Detective: true}},
+		{description: "separate flags are not disabled",
+			cfg:      InvitersConfig{SecurityHub: true, SuppressInviteEmails: true},
+			services: "securityhub",
+			expected: InvitersConfig{SecurityHub: true, SuppressInviteEmails: true}},
+		{description: "unknown service",
+			services: "guardduty,macie",
+			error:    `unknown service "macie"`},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			cfg := x.cfg
+			err := cfg.EnableServices(x.services)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.expected, cfg, "Test case %d config check failed", i)
+		})
+	}
+}
+
+func TestParseServiceRegionExceptions(t *testing.T) {
+	testData := []struct {
+		description string
+		exceptions  string
+		expected    ServiceRegionExceptions
+		error       string
+	}{
+		{description: "empty list",
+			expected: ServiceRegionExceptions{}},
+		{description: "several services and regions",
+			exceptions: "detective:ap-east-1, guardduty:us-west-1,detective:me-south-1,securityhub:eu-south-1",
+			expected: ServiceRegionExceptions{
+				"detective":    {"ap-east-1": true, "me-south-1": true},
+				"guardduty":    {"us-west-1": true},
+				"security_hub": {"eu-south-1": true},
+			}},
+		{description: "unknown service",
+			exceptions: "macie:us-west-1",
+			error:      `unknown service "macie"`},
+		{description: "no region",
+			exceptions: "guardduty:",
+			error:      `"guardduty:" is not in service:region format`},
+		{description: "no separator",
+			exceptions: "guardduty",
+			error:      `"guardduty" is not in service:region format`},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			exceptions, err := ParseServiceRegionExceptions(x.exceptions)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.expected, exceptions, "Test case %d exceptions check failed", i)
+		})
+	}
+}
+
+func TestServiceRegionExceptions_Skip(t *testing.T) {
+	exceptions, err := ParseServiceRegionExceptions("detective:ap-east-1,securityhub:us-west-1")
+	assert.NoError(t, err)
+
+	assert.True(t, exceptions.Skip("detective", "ap-east-1"))
+	assert.True(t, exceptions.Skip("security_hub", "us-west-1"))
+	assert.False(t, exceptions.Skip("detective", "us-west-1"), "other region of the service is not skipped")
+	assert.False(t, exceptions.Skip("guardduty", "ap-east-1"), "other service in the region is not skipped")
+
+	var empty ServiceRegionExceptions
+	assert.False(t, empty.Skip("guardduty", "us-west-1"))
+}
+
+func TestNewInviters_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.JSONFormatter{})
+	entry := logger.WithFields(log.Fields{"account_id": "112233445566", "region": "eu-west-1"})
+
+	inviters := NewInviters(unit.Session, unit.Session, InvitersConfig{Detective: true, Logger: entry})
+	require.Len(t, inviters, 1)
+	d, ok := inviters[0].(*DetectiveInviter)
+	require.True(t, ok)
+	graphARN := "mock_graph"
+	memberAccID := "112233445566"
+	d.masterSvc = mockDMasterClient{
+		t:           t,
+		memberAccID: &memberAccID,
+		graphArn:    &graphARN,
+		dReq:        dGraphReq{output: &detective.ListGraphsOutput{GraphList: []*detective.Graph{{Arn: &graphARN}}}},
+		gmReq: dGetMembersReq{output: &detective.GetMembersOutput{
+			MemberDetails: []*detective.MemberDetail{{Status: aws.String(detective.MemberStatusEnabled)}}}},
+	}
+
+	_, err := d.AddMember(memberAccID, "email@example.com", "665544332211")
+	require.NoError(t, err)
+
+	var entryFields map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entryFields))
+	assert.Equal(t, "112233445566", entryFields["account_id"])
+	assert.Equal(t, "eu-west-1", entryFields["region"])
+	assert.Equal(t, "detective", entryFields["service"])
+	assert.Equal(t, string(StatusAlreadyConnected), entryFields["status"])
+	assert.Equal(t, "Member account connected", entryFields["msg"])
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/detective"
+)
+
+// memberPollInterval is the interval between member status checks while waiting for it to be enabled
+const memberPollInterval = 10 * time.Second
+
+// membersPage returns relationship statuses of members from a single page of members list, keyed by account ID,
+// and token of the next page, which is empty for the last page
+type membersPage func(nextToken *string) (map[string]string, *string, error)
+
+// membersByStatus pages through members list and returns relationship statuses of all members, keyed by account ID
+func membersByStatus(listPage membersPage) (map[string]string, error) {
+	statuses := map[string]string{}
+	var nextToken *string
+	for {
+		page, next, err := listPage(nextToken)
+		if err != nil {
+			return nil, fmt.Errorf("error listing members: %w", err)
+		}
+		for accountID, status := range page {
+			statuses[accountID] = status
+		}
+		if aws.StringValue(next) == "" {
+			return statuses, nil
+		}
+		nextToken = next
+	}
+}
+
+// memberWaiter polls status of member account in master until it becomes enabled, or its context is done.
+type memberWaiter struct {
+	ctx          context.Context
+	pollInterval time.Duration
+	timeout      time.Duration
+	sleep        func(ctx context.Context, d time.Duration) error
+}
+
+// newMemberWaiter creates memberWaiter which waits up to provided timeout for member to become enabled,
+// waiting stops early once provided context is done.
+func newMemberWaiter(ctx context.Context, timeout time.Duration) *memberWaiter {
+	return &memberWaiter{
+		ctx:          ctx,
+		pollInterval: memberPollInterval,
+		timeout:      timeout,
+		sleep:        sleepContext,
+	}
+}
+
+// pendingMemberStatuses returns GuardDuty and Security Hub member statuses meaning that member could still
+// become enabled without any further action, which is the case after invitation is accepted.
+func pendingMemberStatuses() []string {
+	return []string{"Created", "Invited", "EmailVerificationInProgress"}
+}
+
+// detectivePendingMemberStatuses is the same as pendingMemberStatuses for Detective, which uses upper case statuses.
+func detectivePendingMemberStatuses() []string {
+	return []string{detective.MemberStatusInvited, detective.MemberStatusVerificationInProgress}
+}
+
+// Member statuses meaning that member is connected to master, used unless connected statuses are configured
+const (
+	guardDutyConnectedStatus   = "Enabled"
+	securityHubConnectedStatus = "Associated"
+	detectiveConnectedStatus   = detective.MemberStatusEnabled
+)
+
+// connectedStatuses returns provided member statuses meaning that member is connected to master,
+// or only the default one in case none is provided
+func connectedStatuses(statuses []string, defaultStatus string) []string {
+	if len(statuses) == 0 {
+		return []string{defaultStatus}
+	}
+	return statuses
+}
+
+// waitForEnabled calls getStatus until it returns one of enabledStatuses, error is returned in case the status
+// is neither enabled nor one of pendingStatuses, or it's not enabled after the timeout.
+func (w *memberWaiter) waitForEnabled(getStatus func() (string, error), enabledStatuses, pendingStatuses []string) error {
+	enabled := strings.Join(enabledStatuses, " or ")
+	for waited := time.Duration(0); ; waited += w.pollInterval {
+		status, err := getStatus()
+		if err != nil {
+			return err
+		}
+		if contains(enabledStatuses, status) {
+			return nil
+		}
+		if !contains(pendingStatuses, status) {
+			return fmt.Errorf("unexpected member status %q while waiting for it to be %s", status, enabled)
+		}
+		if waited >= w.timeout {
+			return fmt.Errorf("member status is %s instead of %s after %s", status, enabled, w.timeout)
+		}
+		if err := w.sleep(w.ctx, w.pollInterval); err != nil {
+			return fmt.Errorf("waiting for member status to be %s is interrupted: %w", enabled, err)
+		}
+	}
+}
+
+// memberInvitation is invitation to member account from master account, id is used to accept it:
+// invitation ID for GuardDuty and Security Hub, and graph ARN for Detective
+type memberInvitation struct {
+	accountID string
+	id        string
+}
+
+// acceptMemberInvitation looks for invitation with provided lookup function among listed ones and accepts it,
+// ErrInvitationMissing is returned if there is no such invitation
+func acceptMemberInvitation(
+	list func() ([]memberInvitation, error),
+	lookup func(memberInvitation) bool,
+	accept func(memberInvitation) error,
+) error {
+	invitations, err := list()
+	if err != nil {
+		return fmt.Errorf("error retrieving list of invitations: %w", err)
+	}
+	for _, inv := range invitations {
+		if lookup(inv) {
+			return accept(inv)
+		}
+	}
+	return ErrInvitationMissing
+}
+
+// fromAccount returns lookup function for acceptMemberInvitation matching invitation from provided account
+func fromAccount(accountID string) func(memberInvitation) bool {
+	return func(inv memberInvitation) bool {
+		return inv.accountID == accountID
+	}
+}
+
+// invitationRetryer retries looking for invitation from master account, as invitation which was just sent
+// might not be visible in member account yet.
+type invitationRetryer struct {
+	ctx      context.Context
+	attempts int
+	delay    time.Duration
+	sleep    func(ctx context.Context, d time.Duration) error
+}
+
+// newInvitationRetryer creates invitationRetryer which makes up to provided number of attempts,
+// starting with provided delay between them and doubling it after every attempt.
+// Retrying stops early once provided context is done.
+func newInvitationRetryer(ctx context.Context, attempts int, delay time.Duration) *invitationRetryer {
+	return &invitationRetryer{
+		ctx:      ctx,
+		attempts: attempts,
+		delay:    delay,
+		sleep:    sleepContext,
+	}
+}
+
+// accept calls provided function again while it returns ErrInvitationMissing and attempts are not exhausted.
+// Nil retryer calls it only once.
+func (r *invitationRetryer) accept(accept func() error) error {
+	err := accept()
+	if r == nil {
+		return err
+	}
+	delay := r.delay
+	for attempt := 1; attempt < r.attempts && errors.Is(err, ErrInvitationMissing); attempt++ {
+		if serr := r.sleep(r.ctx, delay); serr != nil {
+			return fmt.Errorf("looking for invitation is interrupted: %w", serr)
+		}
+		delay *= 2
+		err = accept()
+	}
+	return err
+}
@@ -0,0 +1,345 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/detective"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMembersByStatus(t *testing.T) {
+	pages := map[string]map[string]string{
+		"":       {"112233445566": "Enabled", "223344556677": "Invited"},
+		"token1": {"334455667788": "Enabled"},
+		"token2": {},
+		"token3": {"445566778899": "Removed"},
+	}
+	nextTokens := map[string]string{"": "token1", "token1": "token2", "token2": "token3"}
+	var requested []string
+	statuses, err := membersByStatus(func(nextToken *string) (map[string]string, *string, error) {
+		token := aws.StringValue(nextToken)
+		requested = append(requested, token)
+		next := nextTokens[token]
+		if next == "" {
+			return pages[token], nil, nil
+		}
+		return pages[token], &next, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "token1", "token2", "token3"}, requested)
+	assert.Equal(t, map[string]string{
+		"112233445566": "Enabled",
+		"223344556677": "Invited",
+		"334455667788": "Enabled",
+		"445566778899": "Removed",
+	}, statuses)
+
+	// error on the second page
+	_, err = membersByStatus(func(nextToken *string) (map[string]string, *string, error) {
+		if nextToken != nil {
+			return nil, nil, fmt.Errorf("mock err")
+		}
+		return map[string]string{"112233445566": "Enabled"}, aws.String("token1"), nil
+	})
+	assert.EqualError(t, err, "error listing members: mock err")
+}
+
+func TestMemberStatuses(t *testing.T) {
+	detectorID := "detector_id"
+	graphARN := "mock_graph"
+	expected := map[string]string{"112233445566": "Enabled", "223344556677": "Invited"}
+
+	gdMaster := &mockGDMasterClient{detectorID: &detectorID, lmPages: map[string]*guardduty.ListMembersOutput{
+		"": {Members: []*guardduty.Member{{AccountId: aws.String("112233445566"), RelationshipStatus: aws.String("Enabled")}},
+			NextToken: aws.String("token1")},
+		"token1": {Members: []*guardduty.Member{{AccountId: aws.String("223344556677"), RelationshipStatus: aws.String("Invited")}}},
+	}}
+	gdMaster.t = t // promoted field
+	gdMaster.dReq = gdDetectorReq{output: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&detectorID}}}
+	g := NewGuardDutyInviter(unit.Session, unit.Session, nil, "", false)
+	g.masterSvc = gdMaster
+	statuses, err := g.MemberStatuses()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, statuses)
+
+	shMaster := &mockSHMasterClient{t: t, lmPages: map[string]*securityhub.ListMembersOutput{
+		"": {Members: []*securityhub.Member{{AccountId: aws.String("112233445566"), MemberStatus: aws.String("Enabled")}},
+			NextToken: aws.String("token1")},
+		"token1": {Members: []*securityhub.Member{{AccountId: aws.String("223344556677"), MemberStatus: aws.String("Invited")}}},
+	}}
+	s := NewSecurityHubInviter(unit.Session, unit.Session, true, nil)
+	s.masterSvc = shMaster
+	statuses, err = s.MemberStatuses()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, statuses)
+
+	shMaster.lmErr = fmt.Errorf("mock err")
+	_, err = s.MemberStatuses()
+	assert.EqualError(t, err, "error listing members: mock err")
+
+	dMaster := &mockDMasterClient{t: t, graphArn: &graphARN,
+		dReq: dGraphReq{output: &detective.ListGraphsOutput{GraphList: []*detective.Graph{{Arn: &graphARN}}}},
+		lmPages: map[string]*detective.ListMembersOutput{
+			"": {MemberDetails: []*detective.MemberDetail{{AccountId: aws.String("112233445566"), Status: aws.String("Enabled")}},
+				NextToken: aws.String("token1")},
+			"token1": {MemberDetails: []*detective.MemberDetail{{AccountId: aws.String("223344556677"), Status: aws.String("Invited")}}},
+		}}
+	d := NewDetectiveInviter(unit.Session, unit.Session, nil)
+	d.masterSvc = dMaster
+	statuses, err = d.MemberStatuses()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, statuses)
+
+	// all inviters list members in status mode
+	for _, inviter := range []Inviter{g, s, d} {
+		assert.Implements(t, (*memberStatusLister)(nil), inviter, "Inviter %s check failed", inviter.Name())
+	}
+}
+
+func TestMemberWaiter_WaitForEnabled(t *testing.T) {
+	testData := []struct {
+		description string
+		statuses    []string
+		enabled     []string
+		statusErr   error
+		sleeps      int
+		error       string
+		// detective makes Detective pending statuses used instead of GuardDuty and Security Hub ones
+		detective bool
+	}{
+		{description: "problem getting member status",
+			statusErr: fmt.Errorf("mock err"),
+			error:     "mock err"},
+		{description: "member enabled right away",
+			statuses: []string{"Enabled"}},
+		{description: "member enabled after waiting",
+			statuses: []string{"Invited", "EmailVerificationInProgress", "Enabled"},
+			sleeps:   2},
+		{description: "unexpected status while waiting",
+			statuses: []string{"Invited", "Resigned"},
+			sleeps:   1,
+			error:    `unexpected member status "Resigned" while waiting for it to be Enabled`},
+		{description: "timeout waiting for member",
+			statuses: []string{"Invited", "Invited", "Invited", "Invited", "Invited"},
+			sleeps:   3,
+			error:    "member status is Invited instead of Enabled after 3m0s"},
+		{description: "member enabled with alternate status",
+			statuses: []string{"Invited", "Monitored"},
+			enabled:  []string{"Enabled", "Monitored"},
+			sleeps:   1},
+		{description: "unexpected status while waiting for alternate ones",
+			statuses: []string{"Resigned"},
+			enabled:  []string{"Enabled", "Monitored"},
+			error:    `unexpected member status "Resigned" while waiting for it to be Enabled or Monitored`},
+		{description: "Detective member enabled after waiting",
+			statuses:  []string{"INVITED", "VERIFICATION_IN_PROGRESS", "ENABLED"},
+			enabled:   []string{"ENABLED"},
+			detective: true,
+			sleeps:    2},
+		{description: "Detective member with GuardDuty pending status",
+			statuses:  []string{"Invited"},
+			enabled:   []string{"ENABLED"},
+			detective: true,
+			error:     `unexpected member status "Invited" while waiting for it to be ENABLED`},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			var sleeps, calls int
+			w := &memberWaiter{
+				pollInterval: time.Minute,
+				timeout:      3 * time.Minute,
+				sleep: func(_ context.Context, d time.Duration) error {
+					assert.Equal(t, time.Minute, d)
+					sleeps++
+					return nil
+				},
+			}
+			pending := pendingMemberStatuses()
+			if x.detective {
+				pending = detectivePendingMemberStatuses()
+			}
+			err := w.waitForEnabled(func() (string, error) {
+				if x.statusErr != nil {
+					return "", x.statusErr
+				}
+				calls++
+				return x.statuses[calls-1], nil
+			}, connectedStatuses(x.enabled, "Enabled"), pending)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.sleeps, sleeps, "Test case %d sleeps check failed", i)
+		})
+	}
+
+	// waiting is interrupted once context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := newMemberWaiter(ctx, time.Hour)
+	err := w.waitForEnabled(func() (string, error) { return "Invited", nil }, []string{"Enabled"}, pendingMemberStatuses())
+	assert.EqualError(t, err, "waiting for member status to be Enabled is interrupted: context canceled")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestInvitationRetryer_Accept(t *testing.T) {
+	var testDataset = []struct {
+		description string
+		attempts    int
+		results     []error
+		error       string
+		calls       int
+		slept       []time.Duration
+	}{
+		{description: "found on first attempt", attempts: 3, results: []error{nil}, calls: 1},
+		{description: "found on third attempt",
+			attempts: 3,
+			results:  []error{ErrInvitationMissing, ErrInvitationMissing, nil},
+			calls:    3,
+			slept:    []time.Duration{time.Second, 2 * time.Second}},
+		{description: "not found after all attempts",
+			attempts: 2,
+			results:  []error{ErrInvitationMissing, ErrInvitationMissing},
+			error:    "can't find invitation from master account",
+			calls:    2,
+			slept:    []time.Duration{time.Second}},
+		{description: "other errors are not retried",
+			attempts: 3,
+			results:  []error{fmt.Errorf("mock err")},
+			error:    "mock err",
+			calls:    1},
+	}
+
+	for i, x := range testDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			var slept []time.Duration
+			r := &invitationRetryer{attempts: x.attempts, delay: time.Second, sleep: func(_ context.Context, d time.Duration) error {
+				slept = append(slept, d)
+				return nil
+			}}
+			calls := 0
+			err := r.accept(func() error {
+				calls++
+				return x.results[calls-1]
+			})
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.calls, calls, "Test case %d calls check failed", i)
+			assert.Equal(t, x.slept, slept, "Test case %d sleep check failed", i)
+		})
+	}
+
+	// nil retryer looks for invitation once
+	var r *invitationRetryer
+	calls := 0
+	err := r.accept(func() error {
+		calls++
+		return ErrInvitationMissing
+	})
+	assert.ErrorIs(t, err, ErrInvitationMissing)
+	assert.Equal(t, 1, calls)
+
+	// retrying is interrupted once context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = newInvitationRetryer(ctx, 3, time.Hour)
+	calls = 0
+	err = r.accept(func() error {
+		calls++
+		return ErrInvitationMissing
+	})
+	assert.EqualError(t, err, "looking for invitation is interrupted: context canceled")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+}
+
+func TestAcceptMemberInvitation(t *testing.T) {
+	invitations := []memberInvitation{
+		{accountID: "111111111111", id: "inv-1"},
+		{accountID: "123456789012", id: "inv-2"},
+		{accountID: "123456789012", id: "inv-3"},
+	}
+	var testDataset = []struct {
+		description string
+		listErr     error
+		acceptErr   error
+		lookup      func(memberInvitation) bool
+		error       string
+		accepted    []string
+	}{
+		{description: "first matching invitation is accepted",
+			lookup:   fromAccount("123456789012"),
+			accepted: []string{"inv-2"}},
+		{description: "custom lookup",
+			lookup:   func(inv memberInvitation) bool { return inv.id == "inv-3" },
+			accepted: []string{"inv-3"}},
+		{description: "invitation is missing",
+			lookup: fromAccount("999999999999"),
+			error:  "can't find invitation from master account"},
+		{description: "list error",
+			listErr: fmt.Errorf("mock err"),
+			lookup:  fromAccount("123456789012"),
+			error:   "error retrieving list of invitations: mock err"},
+		{description: "accept error is returned as is",
+			acceptErr: fmt.Errorf("mock accept err"),
+			lookup:    fromAccount("111111111111"),
+			error:     "mock accept err",
+			accepted:  []string{"inv-1"}},
+	}
+
+	for i, x := range testDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			var accepted []string
+			err := acceptMemberInvitation(
+				func() ([]memberInvitation, error) {
+					if x.listErr != nil {
+						return nil, x.listErr
+					}
+					return invitations, nil
+				},
+				x.lookup,
+				func(inv memberInvitation) error {
+					accepted = append(accepted, inv.id)
+					return x.acceptErr
+				})
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.accepted, accepted, "Test case %d accepted check failed", i)
+		})
+	}
+}
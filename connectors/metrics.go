@@ -0,0 +1,114 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// metricsNamespace is a prefix of all exposed metrics names
+const metricsNamespace = "aws_security_connectors"
+
+// Metrics holds Prometheus metrics of the onboarding run.
+type Metrics struct {
+	registry          *prometheus.Registry
+	accountsProcessed prometheus.Counter
+	serviceResults    *prometheus.CounterVec
+	regionDuration    prometheus.Histogram
+	log               *log.Entry
+}
+
+// NewMetrics creates Metrics registered in their own registry.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		log:      log.NewEntry(log.StandardLogger()),
+		accountsProcessed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "accounts_processed_total",
+			Help:      "Number of processed member accounts.",
+		}),
+		serviceResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "service_results_total",
+			Help:      "Number of member account connection attempts per service and resulting status.",
+		}, []string{"service", "status"}),
+		regionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "region_duration_seconds",
+			Help:      "Time spent connecting services in a single region.",
+			Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+		}),
+	}
+	m.registry.MustRegister(m.accountsProcessed, m.serviceResults, m.regionDuration)
+	return m
+}
+
+// SetLogger makes Metrics use provided logger for all messages instead of the standard one.
+func (m *Metrics) SetLogger(logger *log.Entry) {
+	m.log = logger
+}
+
+// AccountProcessed increments the number of processed accounts, it's called once account is done in all regions.
+func (m *Metrics) AccountProcessed() {
+	m.accountsProcessed.Inc()
+}
+
+// ObserveResult records the outcome of connecting a member account to a service.
+// In case of not nil error, the status is always counted as StatusFailed.
+func (m *Metrics) ObserveResult(service string, res Result, err error) {
+	status := res.Status
+	if err != nil {
+		status = StatusFailed
+	}
+	m.serviceResults.WithLabelValues(service, string(status)).Inc()
+}
+
+// ObserveRegionDuration records the time spent in a single region.
+func (m *Metrics) ObserveRegionDuration(d time.Duration) {
+	m.regionDuration.Observe(d.Seconds())
+}
+
+// Handler returns HTTP handler exposing the metrics in Prometheus format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Serve starts HTTP server exposing the metrics on /metrics path of provided address,
+// the server should be shut down by the caller once the run is completed.
+func (m *Metrics) Serve(addr string) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	// address is taken from the listener, as port might be chosen by the system
+	srv := &http.Server{Addr: listener.Addr().String(), Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.log.Errorf("Metrics server stopped: %s", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Serve(t *testing.T) {
+	m := NewMetrics()
+	m.AccountProcessed()
+	m.AccountProcessed()
+	m.ObserveResult("guardduty", Result{Status: StatusInvited}, nil)
+	m.ObserveResult("guardduty", Result{Status: StatusFailed}, fmt.Errorf("mock err"))
+	m.ObserveResult("detective", Result{Status: StatusAlreadyConnected}, nil)
+	m.ObserveRegionDuration(3 * time.Second)
+
+	srv, err := m.Serve("127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, srv.Shutdown(context.Background())) }()
+	_, err = m.Serve(srv.Addr)
+	assert.Error(t, err, "second server shouldn't be able to listen on the same address")
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/metrics", srv.Addr))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	for _, line := range []string{
+		"aws_security_connectors_accounts_processed_total 2",
+		`aws_security_connectors_service_results_total{service="guardduty",status="invited"} 1`,
+		`aws_security_connectors_service_results_total{service="guardduty",status="failed"} 1`,
+		`aws_security_connectors_service_results_total{service="detective",status="already_connected"} 1`,
+		"aws_security_connectors_region_duration_seconds_count 1",
+		"aws_security_connectors_region_duration_seconds_sum 3",
+	} {
+		assert.Contains(t, string(body), line)
+	}
+}
@@ -0,0 +1,545 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/hashicorp/go-multierror"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config describes how Onboard connects member accounts to AWS security services.
+type Config struct {
+	// AccountID and Email are of the member account to connect, ignored in case AllOrgAccounts is set
+	AccountID string
+	Email     string
+	// MasterAccountID is retrieved using STS in case it's not set
+	MasterAccountID string
+	// AllOrgAccounts makes all active organization accounts connected, they're listed using OrgManagementProfile
+	AllOrgAccounts       bool
+	OrgManagementProfile string
+	// AccountDelay is a pause between processing of organization accounts in every region, randomized
+	// between its half and full value, there is no pause if zero
+	AccountDelay time.Duration
+	// AccountTagFilter leaves only organization accounts having the tag in case AllOrgAccounts is set
+	AccountTagFilter *TagFilter
+	// AccountFilter includes or excludes organization accounts by ID in case AllOrgAccounts is set
+	AccountFilter AccountFilter
+	// Regions to process in sorted order, OnlyEnabledRegions leaves only the ones enabled for master account
+	Regions            []string
+	OnlyEnabledRegions bool
+	// Session is a base of all sessions, its Region, Profile and MemberAccountID are set per call
+	Session SessionConfig
+	// Inviters describes services to connect, ServiceRegionExceptions are skipped
+	Inviters                InvitersConfig
+	ServiceRegionExceptions ServiceRegionExceptions
+	// FailFast stops the run once a member account fails to connect to any service, instead of trying the rest
+	FailFast bool
+	// Preflight and Status make only permissions or member statuses checked, nothing is changed in both modes
+	Preflight bool
+	Status    bool
+	// OrgMode makes master account delegated administrator of the organization for enabled services, OrgDefaultStandards makes
+	// default Security Hub standards enabled for new organization accounts
+	OrgMode             bool
+	OrgDefaultStandards bool
+	// GuardDutyFindingsBucket is ARN of S3 bucket to export master account GuardDuty findings to,
+	// encrypted with GuardDutyFindingsKMSKey, no export is configured if empty
+	GuardDutyFindingsBucket string
+	GuardDutyFindingsKMSKey string
+	// ConfigAggregatorName is a name of AWS Config organization aggregator of processed regions to configure
+	// in ConfigAggregatorRegion using ConfigAggregatorRole, no aggregator is configured if empty
+	ConfigAggregatorName   string
+	ConfigAggregatorRegion string
+	ConfigAggregatorRole   string
+	// EnableOptInRegions makes opt-in regions enabled for member account, waiting up to OptInTimeout for every one
+	EnableOptInRegions bool
+	OptInTimeout       time.Duration
+	// VerifyMemberAccount makes member role checked before connecting any service,
+	// accounts with broken role are skipped
+	VerifyMemberAccount bool
+	// AggregationRegion is a region to aggregate Security Hub findings of all processed regions in
+	AggregationRegion string
+	// Metrics are updated with the results in case they're set
+	Metrics *Metrics
+	// Logger is used for all messages, standard logger is used if not set
+	Logger *log.Entry
+	// NewInviters creates inviters for every account in every region, NewInviters of the package is used if not set
+	NewInviters func(masterSess, memberSess client.ConfigProvider, cfg InvitersConfig) []Inviter
+}
+
+// OnboardReport is the outcome of Onboard.
+type OnboardReport struct {
+	// Accounts are reports of all processed accounts in case AllOrgAccounts is set,
+	// or the only report of AccountID otherwise
+	Accounts []*Report
+	// Attempted is the number of operations which could fail, the failed ones are returned as error by Onboard
+	Attempted int
+}
+
+// Onboard connects member accounts to AWS security services in all provided regions, as configured.
+// Failures of particular services, regions or accounts don't stop the run, they're all returned
+// as *multierror.Error. Once provided context is cancelled, the run stops after the current operation.
+func Onboard(ctx context.Context, cfg Config) (OnboardReport, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = log.NewEntry(log.StandardLogger())
+	}
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = NewMetrics()
+	}
+	newInviters := cfg.NewInviters
+	if newInviters == nil {
+		newInviters = NewInviters
+	}
+	// nothing is changed in preflight and status modes
+	readOnly := cfg.Preflight || cfg.Status
+	// in fail fast mode, the run is stopped by cancelling its context once a member fails to connect
+	ctx, stopRun := context.WithCancel(ctx)
+	defer stopRun()
+	var stoppedOnFailure bool
+
+	var result *multierror.Error
+	var onboardReport OnboardReport
+	report := NewReport(cfg.AccountID)
+	if !cfg.AllOrgAccounts {
+		onboardReport.Accounts = []*Report{report}
+	}
+	// in case of all organization accounts processing, every account gets its own report
+	reportFor := func(accountID string) *Report {
+		if !cfg.AllOrgAccounts {
+			return report
+		}
+		for _, r := range onboardReport.Accounts {
+			if r.AccountID == accountID {
+				return r
+			}
+		}
+		r := NewReport(accountID)
+		onboardReport.Accounts = append(onboardReport.Accounts, r)
+		return r
+	}
+
+	if !cfg.Inviters.Enabled() && cfg.ConfigAggregatorName == "" {
+		return onboardReport, nil
+	}
+
+	sessCfg := func(region, profile string) SessionConfig {
+		c := cfg.Session
+		c.Region = region
+		c.Profile = profile
+		return c
+	}
+	// MFA token can't be used twice, so member credentials obtained with it are reused in all regions
+	memberCreds := map[string]*credentials.Credentials{}
+	memberSessFor := func(masterSess *session.Session, region, accountID string) *session.Session {
+		c := sessCfg(region, cfg.Session.Profile)
+		c.MemberAccountID = accountID
+		c.MemberCredentials = memberCreds[accountID]
+		memberSess := NewMemberSess(masterSess, c)
+		if cfg.Session.MFASerial != "" {
+			memberCreds[accountID] = memberSess.Config.Credentials
+		}
+		return memberSess
+	}
+
+	// regions are processed in sorted order, so that logs of different runs are comparable
+	regions := sortedCopy(cfg.Regions)
+	globalRegion := defaultRegion(cfg.Session.Partition)
+	globalSess := NewMasterSess(sessCfg(globalRegion, cfg.Session.Profile))
+	if cfg.OnlyEnabledRegions {
+		regions = onlyEnabledRegions(regions, globalSess, logger)
+	}
+	regionEnabler := NewRegionEnabler(globalSess, cfg.OptInTimeout)
+	regionEnabler.SetLogger(logger)
+
+	// master account ID is retrieved once and reused in all regions
+	onboardReport.Attempted++
+	masterAccountID, err := resolveMasterAccountID(cfg.MasterAccountID, func() (string, error) {
+		return GetAccountID(globalSess)
+	})
+	if err != nil {
+		result = multierror.Append(result, fmt.Errorf("%w, aborting AWS services adding", err))
+		regions = nil
+	}
+
+	accounts := []Account{{ID: cfg.AccountID, Email: cfg.Email}}
+	if cfg.AllOrgAccounts {
+		onboardReport.Attempted++
+		accounts, err = ListActiveAccounts(NewMasterSess(sessCfg(globalRegion, cfg.OrgManagementProfile)),
+			cfg.AccountFilter, cfg.AccountTagFilter)
+		if err != nil {
+			result = multierror.Append(result,
+				fmt.Errorf("problem listing organization accounts: %w", err))
+		}
+		// master account can't be a member of itself, so it's dropped in case it belongs to the organization
+		for i, account := range accounts {
+			if account.ID == masterAccountID {
+				accounts = append(accounts[:i], accounts[i+1:]...)
+				logger.Infof("Master account %s is skipped as organization member", masterAccountID)
+				break
+			}
+		}
+		if cfg.AccountTagFilter != nil {
+			logger.Infof("Found %d active organization accounts with tag %s=%s",
+				len(accounts), cfg.AccountTagFilter.Key, cfg.AccountTagFilter.Value)
+		} else {
+			logger.Infof("Found %d active organization accounts", len(accounts))
+		}
+	}
+	if !cfg.Inviters.Enabled() {
+		accounts = nil
+	}
+
+	// member role is checked once before connecting any service in any region, so that accounts with
+	// broken role or role ARN pointing to other account are skipped instead of being connected
+	if cfg.VerifyMemberAccount {
+		onboardReport.Attempted += len(accounts)
+		var errs []error
+		accounts, errs = verifyMemberAccounts(accounts, func(accountID string) error {
+			return VerifyMemberAccount(memberSessFor(globalSess, globalRegion, accountID),
+				accountID, cfg.Session.MemberRole)
+		})
+		for _, err := range errs {
+			result = multierror.Append(result, err)
+		}
+	}
+
+regions:
+	for r, region := range regions {
+		if ctx.Err() != nil {
+			break
+		}
+		regionStart := time.Now()
+		masterSess := NewMasterSess(sessCfg(region, cfg.Session.Profile))
+
+		if cfg.OrgMode && !readOnly {
+			managementSess := NewMasterSess(sessCfg(region, cfg.OrgManagementProfile))
+			if cfg.Inviters.GuardDuty && !cfg.ServiceRegionExceptions.Skip("guardduty", region) {
+				o := NewOrganizationConfigurer(managementSess, masterSess)
+				onboardReport.Attempted++
+				res, err := o.ConfigureGuardDuty(masterAccountID)
+				reportFor(masterAccountID).Add(o.Name(), region, res, err)
+				metrics.ObserveResult(o.Name(), res, err)
+				if err != nil {
+					result = multierror.Append(result,
+						fmt.Errorf("problem configuring GuardDuty organization in %s: %w", region, err))
+				}
+			}
+			if cfg.Inviters.SecurityHub && !cfg.ServiceRegionExceptions.Skip("security_hub", region) {
+				s := NewSecurityHubInviter(managementSess, managementSess, true, nil)
+				onboardReport.Attempted++
+				if err := s.EnableOrgAdmin(masterAccountID); err != nil {
+					result = multierror.Append(result,
+						fmt.Errorf("problem registering Security Hub delegated administrator in %s: %w", region, err))
+				} else {
+					o := NewSecurityHubOrganizationConfigurer(masterSess, cfg.OrgDefaultStandards)
+					onboardReport.Attempted++
+					res, err := o.EnableOrgAutoEnroll()
+					reportFor(masterAccountID).Add(o.Name(), region, res, err)
+					metrics.ObserveResult(o.Name(), res, err)
+					if err != nil {
+						result = multierror.Append(result,
+							fmt.Errorf("problem configuring Security Hub organization in %s: %w", region, err))
+					}
+				}
+			}
+			if cfg.Inviters.Detective && !cfg.ServiceRegionExceptions.Skip("detective", region) {
+				d := NewDetectiveInviter(managementSess, managementSess, nil)
+				onboardReport.Attempted++
+				if err := d.EnableOrgAdmin(masterAccountID); err != nil {
+					result = multierror.Append(result,
+						fmt.Errorf("problem registering Detective delegated administrator in %s: %w", region, err))
+				}
+			}
+		}
+
+		if cfg.Inviters.GuardDuty && cfg.GuardDutyFindingsBucket != "" && !readOnly &&
+			!cfg.ServiceRegionExceptions.Skip("guardduty", region) {
+			p := NewGuardDutyPublishingConfigurer(masterSess, cfg.GuardDutyFindingsBucket, cfg.GuardDutyFindingsKMSKey)
+			onboardReport.Attempted++
+			res, err := p.ConfigureDestination()
+			reportFor(masterAccountID).Add(p.Name(), region, res, err)
+			metrics.ObserveResult(p.Name(), res, err)
+			if err != nil {
+				result = multierror.Append(result,
+					fmt.Errorf("problem configuring GuardDuty findings export in %s: %w", region, err))
+			}
+		}
+
+		// with several accounts checked, members are listed once per service in the region instead of one by one
+		var regionStatuses map[string]map[string]string
+		if cfg.Status && len(accounts) > 1 {
+			regionStatuses = map[string]map[string]string{}
+		}
+		// account is processed once it's done in the last region, so that progress is seen during long runs
+		lastRegion := r == len(regions)-1
+		for i, account := range accounts {
+			if i > 0 && cfg.AccountDelay > 0 {
+				if err := jitteredSleep(ctx, cfg.AccountDelay); err != nil {
+					break regions
+				}
+			}
+			if cfg.EnableOptInRegions && !readOnly {
+				onboardReport.Attempted++
+				if err := regionEnabler.EnableRegion(ctx, account.ID, region); err != nil {
+					result = multierror.Append(result,
+						fmt.Errorf("problem enabling region %s for account %s, skipping it: %w", region, account.ID, err))
+					if lastRegion {
+						metrics.AccountProcessed()
+					}
+					continue
+				}
+			}
+
+			memberSess := memberSessFor(masterSess, region, account.ID)
+			accountCfg := cfg.Inviters
+			accountCfg.Logger = logger.WithFields(log.Fields{"account_id": account.ID, "region": region})
+			accountCfg.Context = ctx
+			// services not available in the region are skipped with a warning instead of failing the run
+			skipUnavailable := func(inviter Inviter, err error) bool {
+				if !isServiceUnavailableInRegion(err) {
+					return false
+				}
+				accountCfg.Logger.WithField("service", inviter.Name()).
+					Warnf("Service is not available in the region, skipping it: %s", err)
+				reportFor(account.ID).Add(inviter.Name(), region, Result{Status: StatusSkipped}, nil)
+				return true
+			}
+			err := runInviters(ctx, newInviters(masterSess, memberSess, accountCfg), func(inviter Inviter) {
+				if cfg.ServiceRegionExceptions.Skip(inviter.Name(), region) {
+					accountCfg.Logger.WithField("service", inviter.Name()).Info("Service is skipped in the region")
+					reportFor(account.ID).Add(inviter.Name(), region, Result{Status: StatusSkipped}, nil)
+					return
+				}
+				onboardReport.Attempted++
+				if cfg.Preflight {
+					err := inviter.Preflight(account.ID)
+					if skipUnavailable(inviter, err) {
+						return
+					}
+					reportFor(account.ID).Add(inviter.Name(), region, Result{Status: StatusChecked}, err)
+					if err != nil {
+						result = multierror.Append(result,
+							fmt.Errorf("preflight check of %s failed for member account %s in %s: %w", inviter.Name(), account.ID, region, err))
+					}
+					return
+				}
+				if cfg.Status {
+					status, err := memberStatus(inviter, account.ID, regionStatuses)
+					if skipUnavailable(inviter, err) {
+						return
+					}
+					reportFor(account.ID).AddMemberStatus(inviter.Name(), region, status, err)
+					if err != nil {
+						result = multierror.Append(result,
+							fmt.Errorf("problem getting status of member account %s in %s in %s: %w", account.ID, inviter.Name(), region, err))
+						return
+					}
+					accountCfg.Logger.WithFields(log.Fields{"service": inviter.Name(), "member_status": status}).
+						Info("Member account status")
+					return
+				}
+				res, err := inviter.AddMember(account.ID, account.Email, masterAccountID)
+				if skipUnavailable(inviter, err) {
+					return
+				}
+				reportFor(account.ID).Add(inviter.Name(), region, res, err)
+				metrics.ObserveResult(inviter.Name(), res, err)
+				if err != nil {
+					result = multierror.Append(result,
+						fmt.Errorf("problem adding member account %s to %s in %s: %w", account.ID, inviter.Name(), region, err))
+					if cfg.FailFast {
+						stoppedOnFailure = true
+						stopRun()
+					}
+				}
+			})
+			if err != nil {
+				break regions
+			}
+			if lastRegion {
+				metrics.AccountProcessed()
+			}
+		}
+		metrics.ObserveRegionDuration(time.Since(regionStart))
+	}
+	// findings are aggregated once members are connected in all regions
+	if cfg.AggregationRegion != "" && !readOnly && len(regions) > 0 && ctx.Err() == nil {
+		homeSess := NewMasterSess(sessCfg(cfg.AggregationRegion, cfg.Session.Profile))
+		onboardReport.Attempted++
+		s := NewSecurityHubInviter(homeSess, homeSess, true, nil)
+		if err := s.ConfigureFindingAggregation(cfg.AggregationRegion, regions); err != nil {
+			result = multierror.Append(result,
+				fmt.Errorf("problem configuring Security Hub finding aggregation in %s: %w", cfg.AggregationRegion, err))
+		}
+	}
+	if cfg.ConfigAggregatorName != "" && !readOnly && len(regions) > 0 && ctx.Err() == nil {
+		aggregatorSess := NewMasterSess(sessCfg(cfg.ConfigAggregatorRegion, cfg.Session.Profile))
+		c := NewConfigAggregatorConfigurer(aggregatorSess, cfg.ConfigAggregatorName, cfg.ConfigAggregatorRole)
+		onboardReport.Attempted++
+		res, err := c.ConfigureAggregator(regions)
+		reportFor(masterAccountID).Add(c.Name(), cfg.ConfigAggregatorRegion, res, err)
+		metrics.ObserveResult(c.Name(), res, err)
+		if err != nil {
+			result = multierror.Append(result,
+				fmt.Errorf("problem configuring AWS Config aggregator in %s: %w", cfg.ConfigAggregatorRegion, err))
+		}
+	}
+	if err := ctx.Err(); err != nil && stoppedOnFailure {
+		onboardReport.Attempted++
+		result = multierror.Append(result,
+			errors.New("run is stopped after the first failure, not all regions and services are processed"))
+	} else if err != nil {
+		onboardReport.Attempted++
+		result = multierror.Append(result,
+			fmt.Errorf("run is interrupted, not all regions and services are processed: %w", err))
+	}
+	return onboardReport, result.ErrorOrNil()
+}
+
+// jitteredSleep pauses for random duration between half of provided one and the full one,
+// returning early with error in case context is done
+func jitteredSleep(ctx context.Context, d time.Duration) error {
+	half := d / 2
+	return sleepContext(ctx, half+time.Duration(rand.Int63n(int64(d-half)+1))) // nolint:gosec
+}
+
+// memberStatus returns status of member account, taken from all members statuses listed once per service
+// and kept in provided cache in case inviter is able to list them and cache is not nil
+func memberStatus(inviter Inviter, accountID string, cache map[string]map[string]string) (string, error) {
+	lister, ok := inviter.(memberStatusLister)
+	if !ok || cache == nil {
+		return inviter.MemberStatus(accountID)
+	}
+	statuses, ok := cache[inviter.Name()]
+	if !ok {
+		var err error
+		if statuses, err = lister.MemberStatuses(); err != nil {
+			return "", err
+		}
+		cache[inviter.Name()] = statuses
+	}
+	return memberStatusOrNotMember(statuses[accountID]), nil
+}
+
+// runInviters calls run for every inviter until provided context is cancelled,
+// context error is returned in that case and the rest of inviters is not run
+func runInviters(ctx context.Context, inviters []Inviter, run func(Inviter)) error {
+	for _, inviter := range inviters {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		run(inviter)
+	}
+	return nil
+}
+
+// isServiceUnavailableInRegion returns true in case provided error shows that AWS service is not available
+// in the region: its endpoint is unknown or doesn't resolve, or the service rejects the call as unsupported in the region
+func isServiceUnavailableInRegion(err error) bool {
+	var awsErr awserr.Error
+	if !errors.As(err, &awsErr) {
+		return false
+	}
+	switch awsErr.Code() {
+	case "UnknownEndpointError":
+		return true
+	case request.ErrCodeRequestError:
+		var dnsErr *net.DNSError
+		return errors.As(awsErr.OrigErr(), &dnsErr) && dnsErr.IsNotFound
+	case "InvalidInputException", "BadRequestException", "ValidationException":
+		message := strings.ToLower(awsErr.Message())
+		return strings.Contains(message, "region") &&
+			(strings.Contains(message, "not supported") || strings.Contains(message, "not available"))
+	}
+	return false
+}
+
+// resolveMasterAccountID returns provided master account ID override in case it's set,
+// otherwise the account ID is retrieved using provided lookup function
+func resolveMasterAccountID(override string, lookup func() (string, error)) (string, error) {
+	id := override
+	if id == "" {
+		var err error
+		if id, err = lookup(); err != nil {
+			return "", fmt.Errorf("problem retrieving master account ID: %w", err)
+		}
+	}
+	if !IsValidAccountID(id) {
+		return "", fmt.Errorf("invalid master account ID %q", id)
+	}
+	return id, nil
+}
+
+// verifyMemberAccounts returns accounts which passed provided verification, and errors of ones which didn't
+func verifyMemberAccounts(accounts []Account, verify func(accountID string) error) ([]Account, []error) {
+	var verified []Account
+	var errs []error
+	for _, account := range accounts {
+		if err := verify(account.ID); err != nil {
+			errs = append(errs, fmt.Errorf("skipping member account %s: %w", account.ID, err))
+			continue
+		}
+		verified = append(verified, account)
+	}
+	return verified, errs
+}
+
+// defaultRegion returns region to use for global calls in provided partition
+func defaultRegion(partitionID string) string {
+	switch partitionID {
+	case endpoints.AwsUsGovPartitionID:
+		return "us-gov-west-1"
+	case endpoints.AwsCnPartitionID:
+		return "cn-north-1"
+	default:
+		return "us-east-1"
+	}
+}
+
+// onlyEnabledRegions returns provided regions which are enabled for account of provided session,
+// or all provided regions in case enabled regions can't be retrieved
+func onlyEnabledRegions(regions []string, sess client.ConfigProvider, logger *log.Entry) []string {
+	enabled, err := EnabledRegions(sess)
+	if err != nil {
+		logger.Warnf("Problem retrieving enabled regions, processing all of them: %s", err)
+		return regions
+	}
+	enabledSet := map[string]bool{}
+	for _, region := range enabled {
+		enabledSet[region] = true
+	}
+	var result []string
+	for _, region := range regions {
+		if enabledSet[region] {
+			result = append(result, region)
+		}
+	}
+	return result
+}
@@ -0,0 +1,671 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockInviter returns the same error from all calls in the region it's created for
+type mockInviter struct {
+	name string
+	err  error
+}
+
+func (m mockInviter) Name() string { return m.name }
+
+func (m mockInviter) AddMember(string, string, string) (Result, error) {
+	return Result{Status: StatusInvited}, m.err
+}
+
+func (m mockInviter) Preflight(string) error { return m.err }
+
+func (m mockInviter) MemberStatus(string) (string, error) { return "Enabled", m.err }
+
+func TestOnboard(t *testing.T) {
+	const (
+		memberAccID = "112233445566"
+		masterAccID = "665544332211"
+	)
+
+	var testData = []struct {
+		description string
+		preflight   bool
+		status      bool
+		exceptions  ServiceRegionExceptions
+		errs        map[string]error
+		services    map[string]map[string]ReportRegionEntry
+		attempted   int
+		error       string
+	}{
+		{description: "member connected in all regions",
+			services: map[string]map[string]ReportRegionEntry{"guardduty": {
+				"eu-west-1": {Status: StatusInvited},
+				"us-east-1": {Status: StatusInvited},
+			}},
+			attempted: 3},
+		{description: "problem connecting member in one region",
+			errs: map[string]error{"us-east-1": fmt.Errorf("mock err")},
+			services: map[string]map[string]ReportRegionEntry{"guardduty": {
+				"eu-west-1": {Status: StatusInvited},
+				"us-east-1": {Status: StatusFailed, Error: "mock err"},
+			}},
+			attempted: 3,
+			error:     "1 error occurred:\n\t* problem adding member account 112233445566 to guardduty in us-east-1: mock err\n\n"},
+		{description: "service skipped in one region",
+			exceptions: ServiceRegionExceptions{"guardduty": {"us-east-1": true}},
+			services: map[string]map[string]ReportRegionEntry{"guardduty": {
+				"eu-west-1": {Status: StatusInvited},
+				"us-east-1": {Status: StatusSkipped},
+			}},
+			attempted: 2},
+		{description: "service unavailable in one region",
+			errs: map[string]error{"us-east-1": endpoints.NewUnknownEndpointError("aws", "guardduty", "us-east-1", nil)},
+			services: map[string]map[string]ReportRegionEntry{"guardduty": {
+				"eu-west-1": {Status: StatusInvited},
+				"us-east-1": {Status: StatusSkipped},
+			}},
+			attempted: 3},
+		{description: "preflight",
+			preflight: true,
+			errs:      map[string]error{"us-east-1": fmt.Errorf("mock err")},
+			services: map[string]map[string]ReportRegionEntry{"guardduty": {
+				"eu-west-1": {Status: StatusChecked},
+				"us-east-1": {Status: StatusFailed, Error: "mock err"},
+			}},
+			attempted: 3,
+			error:     "1 error occurred:\n\t* preflight check of guardduty failed for member account 112233445566 in us-east-1: mock err\n\n"},
+		{description: "member status",
+			status: true,
+			services: map[string]map[string]ReportRegionEntry{"guardduty": {
+				"eu-west-1": {Status: StatusChecked, MemberStatus: "Enabled"},
+				"us-east-1": {Status: StatusChecked, MemberStatus: "Enabled"},
+			}},
+			attempted: 3},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			var regions []string
+			report, err := Onboard(context.Background(), Config{
+				AccountID:               memberAccID,
+				Email:                   "email@example.com",
+				MasterAccountID:         masterAccID,
+				Regions:                 []string{"eu-west-1", "us-east-1"},
+				Session:                 SessionConfig{Partition: "aws", MemberRole: "test_role"},
+				Inviters:                InvitersConfig{GuardDuty: true},
+				ServiceRegionExceptions: x.exceptions,
+				Preflight:               x.preflight,
+				Status:                  x.status,
+				NewInviters: func(masterSess, memberSess client.ConfigProvider, cfg InvitersConfig) []Inviter {
+					region := *masterSess.(*session.Session).Config.Region
+					regions = append(regions, region)
+					assert.Equal(t, memberAccID, cfg.Logger.Data["account_id"], "Test case %d logger check failed", i)
+					return []Inviter{mockInviter{name: "guardduty", err: x.errs[region]}}
+				},
+			})
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, []string{"eu-west-1", "us-east-1"}, regions, "Test case %d regions check failed", i)
+			require.Len(t, report.Accounts, 1, "Test case %d reports check failed", i)
+			assert.Equal(t, memberAccID, report.Accounts[0].AccountID, "Test case %d report account check failed", i)
+			assert.Equal(t, x.services, report.Accounts[0].Services, "Test case %d report check failed", i)
+			assert.Equal(t, x.attempted, report.Attempted, "Test case %d attempted check failed", i)
+		})
+	}
+}
+
+func TestOnboard_FailFast(t *testing.T) {
+	var calls []string
+	report, err := Onboard(context.Background(), Config{
+		AccountID:       "112233445566",
+		MasterAccountID: "665544332211",
+		Regions:         []string{"ap-south-1", "eu-west-1", "us-east-1"},
+		Session:         SessionConfig{Partition: "aws", MemberRole: "test_role"},
+		Inviters:        InvitersConfig{GuardDuty: true, SecurityHub: true},
+		FailFast:        true,
+		NewInviters: func(masterSess, memberSess client.ConfigProvider, cfg InvitersConfig) []Inviter {
+			region := *masterSess.(*session.Session).Config.Region
+			calls = append(calls, region)
+			var err error
+			if region == "eu-west-1" {
+				err = fmt.Errorf("mock err")
+			}
+			return []Inviter{mockInviter{name: "guardduty", err: err}, mockInviter{name: "security_hub"}}
+		},
+	})
+	assert.EqualError(t, err, "2 errors occurred:\n"+
+		"\t* problem adding member account 112233445566 to guardduty in eu-west-1: mock err\n"+
+		"\t* run is stopped after the first failure, not all regions and services are processed\n\n")
+	// the run stops right after the failure, before the rest of services and regions
+	assert.Equal(t, []string{"ap-south-1", "eu-west-1"}, calls)
+	require.Len(t, report.Accounts, 1)
+	assert.Equal(t, map[string]map[string]ReportRegionEntry{
+		"guardduty": {
+			"ap-south-1": {Status: StatusInvited},
+			"eu-west-1":  {Status: StatusFailed, Error: "mock err"},
+		},
+		"security_hub": {
+			"ap-south-1": {Status: StatusInvited},
+		},
+	}, report.Accounts[0].Services)
+	assert.Equal(t, 5, report.Attempted)
+}
+
+func TestOnboard_ConfigAggregatorOnly(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "master_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "master_secret")
+	t.Setenv("AWS_PROFILE", "")
+
+	var calls []string
+	var putInput configservice.PutConfigurationAggregatorInput
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := r.Header.Get("X-Amz-Target")
+		calls = append(calls, target)
+		switch target {
+		case "StarlingDoveService.DescribeConfigurationAggregators":
+			_, _ = w.Write([]byte(`{"ConfigurationAggregators":[]}`))
+		case "StarlingDoveService.PutConfigurationAggregator":
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&putInput))
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	// aggregator is configured even though no service is connected
+	report, err := Onboard(context.Background(), Config{
+		MasterAccountID:        "665544332211",
+		Regions:                []string{"us-east-1", "eu-west-1"},
+		Session:                SessionConfig{Partition: "aws", Endpoint: ts.URL},
+		ConfigAggregatorName:   "organization",
+		ConfigAggregatorRegion: "eu-west-1",
+		ConfigAggregatorRole:   "arn:aws:iam::665544332211:role/config-aggregator",
+		NewInviters: func(client.ConfigProvider, client.ConfigProvider, InvitersConfig) []Inviter {
+			t.Error("no inviters are expected to be created")
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"StarlingDoveService.DescribeConfigurationAggregators",
+		"StarlingDoveService.PutConfigurationAggregator",
+	}, calls)
+	assert.Equal(t, "organization", aws.StringValue(putInput.ConfigurationAggregatorName))
+	if assert.NotNil(t, putInput.OrganizationAggregationSource) {
+		assert.Equal(t, []*string{aws.String("eu-west-1"), aws.String("us-east-1")},
+			putInput.OrganizationAggregationSource.AwsRegions)
+	}
+	require.Len(t, report.Accounts, 1)
+	assert.Equal(t, map[string]map[string]ReportRegionEntry{
+		"config_aggregator": {"eu-west-1": {Status: StatusUpdated}},
+	}, report.Accounts[0].Services)
+	assert.Equal(t, 2, report.Attempted)
+}
+
+// newOrgAccountsServer returns server of Organizations API listing provided active accounts,
+// management account of the organization isn't among them
+func newOrgAccountsServer(accountIDs ...string) *httptest.Server {
+	var accounts []string
+	for _, id := range accountIDs {
+		accounts = append(accounts, fmt.Sprintf(`{"Id":%q,"Email":"%s@example.com","Status":"ACTIVE"}`, id, id))
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("X-Amz-Target") {
+		case "AWSOrganizationsV20161128.DescribeOrganization":
+			_, _ = w.Write([]byte(`{"Organization":{"MasterAccountId":"998877665544"}}`))
+		case "AWSOrganizationsV20161128.ListAccounts":
+			_, _ = w.Write([]byte(`{"Accounts":[` + strings.Join(accounts, ",") + `]}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestOnboard_AllOrgAccountsWithMaster(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "master_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "master_secret")
+	t.Setenv("AWS_PROFILE", "")
+
+	// master account belongs to the organization, as it's usually a separate security account
+	ts := newOrgAccountsServer("112233445566", "665544332211")
+	defer ts.Close()
+
+	inviters := 0
+	report, err := Onboard(context.Background(), Config{
+		MasterAccountID: "665544332211",
+		AllOrgAccounts:  true,
+		Regions:         []string{"eu-west-1"},
+		Session:         SessionConfig{Partition: "aws", Endpoint: ts.URL, MemberRole: "test_role"},
+		Inviters:        InvitersConfig{GuardDuty: true},
+		NewInviters: func(client.ConfigProvider, client.ConfigProvider, InvitersConfig) []Inviter {
+			inviters++
+			return []Inviter{mockInviter{name: "guardduty"}}
+		},
+	})
+	require.NoError(t, err)
+	// only member account is connected
+	assert.Equal(t, 1, inviters)
+	require.Len(t, report.Accounts, 1)
+	assert.Equal(t, "112233445566", report.Accounts[0].AccountID)
+}
+
+func TestOnboard_AccountsProcessedMetric(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "master_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "master_secret")
+	t.Setenv("AWS_PROFILE", "")
+	ts := newOrgAccountsServer("112233445566", "223344556677")
+	defer ts.Close()
+
+	metrics := NewMetrics()
+	srv, err := metrics.Serve("127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, srv.Shutdown(context.Background())) }()
+	processed := func() string {
+		resp, err := http.Get(fmt.Sprintf("http://%s/metrics", srv.Addr))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		for _, line := range strings.Split(string(body), "\n") {
+			if strings.HasPrefix(line, "aws_security_connectors_accounts_processed_total ") {
+				return strings.TrimPrefix(line, "aws_security_connectors_accounts_processed_total ")
+			}
+		}
+		return ""
+	}
+
+	// processed accounts are read while inviters are created for the next account and region
+	var seen []string
+	_, err = Onboard(context.Background(), Config{
+		MasterAccountID: "665544332211",
+		AllOrgAccounts:  true,
+		Regions:         []string{"eu-west-1", "us-east-1"},
+		Session:         SessionConfig{Partition: "aws", Endpoint: ts.URL, MemberRole: "test_role"},
+		Inviters:        InvitersConfig{GuardDuty: true},
+		Metrics:         metrics,
+		NewInviters: func(client.ConfigProvider, client.ConfigProvider, InvitersConfig) []Inviter {
+			seen = append(seen, processed())
+			return []Inviter{mockInviter{name: "guardduty"}}
+		},
+	})
+	require.NoError(t, err)
+	// the first account is counted once it's done in the last region, before the second one is processed there
+	assert.Equal(t, []string{"0", "0", "0", "1"}, seen)
+	assert.Equal(t, "2", processed())
+}
+
+func TestOnboard_OrgModeWithoutGuardDuty(t *testing.T) {
+	report, err := Onboard(context.Background(), Config{
+		AccountID:       "112233445566",
+		MasterAccountID: "665544332211",
+		Regions:         []string{"eu-west-1"},
+		Session:         SessionConfig{Partition: "aws", MemberRole: "test_role"},
+		Inviters:        InvitersConfig{SecurityHub: true},
+		// Security Hub organization is skipped by the exception, GuardDuty one is not configured as the service is disabled
+		ServiceRegionExceptions: ServiceRegionExceptions{"security_hub": {"eu-west-1": true}},
+		OrgMode:                 true,
+		NewInviters: func(client.ConfigProvider, client.ConfigProvider, InvitersConfig) []Inviter {
+			return nil
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Attempted)
+}
+
+func TestOnboard_Logger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	_, err := Onboard(context.Background(), Config{
+		AccountID:               "112233445566",
+		MasterAccountID:         "665544332211",
+		Regions:                 []string{"eu-west-1", "us-east-1"},
+		Session:                 SessionConfig{Partition: "aws", MemberRole: "test_role"},
+		Inviters:                InvitersConfig{GuardDuty: true, SecurityHub: true},
+		ServiceRegionExceptions: ServiceRegionExceptions{"guardduty": {"us-east-1": true}},
+		Status:                  true,
+		Logger:                  logger.WithField("run_id", "mock-run-id"),
+		NewInviters: func(masterSess, memberSess client.ConfigProvider, cfg InvitersConfig) []Inviter {
+			return []Inviter{mockInviter{name: "guardduty"}, mockInviter{name: "security_hub"}}
+		},
+	})
+	require.NoError(t, err)
+	// member statuses in both regions and the skipped service
+	require.Len(t, hook.AllEntries(), 4)
+	for i, entry := range hook.AllEntries() {
+		assert.Equal(t, "mock-run-id", entry.Data["run_id"], "Entry %d (%q) run ID check failed", i, entry.Message)
+	}
+}
+
+func TestOnboard_RegionOrder(t *testing.T) {
+	cfgRegions := []string{"us-east-1", "eu-west-1", "ap-south-1"}
+	run := func() []string {
+		var regions []string
+		_, err := Onboard(context.Background(), Config{
+			AccountID:       "112233445566",
+			MasterAccountID: "665544332211",
+			Regions:         cfgRegions,
+			Session:         SessionConfig{Partition: "aws", MemberRole: "test_role"},
+			Inviters:        InvitersConfig{GuardDuty: true},
+			NewInviters: func(masterSess, memberSess client.ConfigProvider, cfg InvitersConfig) []Inviter {
+				regions = append(regions, *masterSess.(*session.Session).Config.Region)
+				return []Inviter{mockInviter{name: "guardduty"}}
+			},
+		})
+		require.NoError(t, err)
+		return regions
+	}
+
+	first := run()
+	assert.Equal(t, []string{"ap-south-1", "eu-west-1", "us-east-1"}, first)
+	assert.Equal(t, first, run())
+	// provided regions are not reordered in place
+	assert.Equal(t, []string{"us-east-1", "eu-west-1", "ap-south-1"}, cfgRegions)
+}
+
+func TestOnboard_NotRun(t *testing.T) {
+	newInviters := func(client.ConfigProvider, client.ConfigProvider, InvitersConfig) []Inviter {
+		t.Error("no inviters are expected to be created")
+		return nil
+	}
+	cfg := Config{
+		AccountID:       "112233445566",
+		MasterAccountID: "665544332211",
+		Regions:         []string{"eu-west-1"},
+		Session:         SessionConfig{Partition: "aws"},
+		NewInviters:     newInviters,
+	}
+
+	// no services enabled
+	report, err := Onboard(context.Background(), cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, OnboardReport{Accounts: []*Report{NewReport("112233445566")}}, report)
+
+	// invalid master account ID
+	cfg.Inviters = InvitersConfig{GuardDuty: true}
+	cfg.MasterAccountID = "1122-3344-5566"
+	report, err = Onboard(context.Background(), cfg)
+	assert.EqualError(t, err, "1 error occurred:\n\t* invalid master account ID \"1122-3344-5566\", aborting AWS services adding\n\n")
+	assert.Equal(t, 1, report.Attempted)
+
+	// interrupted run
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg.MasterAccountID = "665544332211"
+	report, err = Onboard(ctx, cfg)
+	assert.EqualError(t, err, "1 error occurred:\n\t* run is interrupted, not all regions and services are processed: context canceled\n\n")
+	assert.Equal(t, 2, report.Attempted)
+}
+
+// fakeInviter is an inviter which does nothing and reports member account as already connected
+type fakeInviter struct {
+	name string
+}
+
+func (f fakeInviter) Name() string { return f.name }
+
+func (f fakeInviter) AddMember(string, string, string) (Result, error) {
+	return Result{Status: StatusAlreadyConnected}, nil
+}
+
+func (f fakeInviter) Preflight(string) error { return nil }
+
+func (f fakeInviter) MemberStatus(string) (string, error) { return "Enabled", nil }
+
+// listingInviter is an inviter which is able to list statuses of all members, counting the calls
+type listingInviter struct {
+	fakeInviter
+	statuses map[string]string
+	err      error
+	calls    *int
+}
+
+func (l listingInviter) MemberStatuses() (map[string]string, error) {
+	*l.calls++
+	return l.statuses, l.err
+}
+
+func TestMemberStatus(t *testing.T) {
+	calls := 0
+	inviter := listingInviter{fakeInviter: fakeInviter{"guardduty"},
+		statuses: map[string]string{"112233445566": "Enabled", "223344556677": "Invited"}, calls: &calls}
+
+	// members are listed once and reused for next accounts
+	cache := map[string]map[string]string{}
+	for accountID, expected := range map[string]string{
+		"112233445566": "Enabled",
+		"223344556677": "Invited",
+		"334455667788": MemberStatusNotMember,
+	} {
+		status, err := memberStatus(inviter, accountID, cache)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, status, "Account %s status check failed", accountID)
+	}
+	assert.Equal(t, 1, calls)
+
+	// member status is got directly without cache, and for inviters unable to list members
+	status, err := memberStatus(inviter, "223344556677", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "Enabled", status)
+	status, err = memberStatus(fakeInviter{"security_hub"}, "223344556677", cache)
+	assert.NoError(t, err)
+	assert.Equal(t, "Enabled", status)
+	assert.Equal(t, 1, calls)
+
+	// listing error isn't cached, so that members are listed again for the next account
+	calls = 0
+	inviter.err = fmt.Errorf("mock err")
+	cache = map[string]map[string]string{}
+	_, err = memberStatus(inviter, "112233445566", cache)
+	assert.EqualError(t, err, "mock err")
+	_, err = memberStatus(inviter, "223344556677", cache)
+	assert.EqualError(t, err, "mock err")
+	assert.Equal(t, 2, calls)
+}
+
+func TestRunInviters(t *testing.T) {
+	inviters := []Inviter{fakeInviter{"guardduty"}, fakeInviter{"security_hub"}, fakeInviter{"detective"}}
+
+	var ran []string
+	err := runInviters(context.Background(), inviters, func(inviter Inviter) {
+		_, err := inviter.AddMember("112233445566", "", "665544332211")
+		assert.NoError(t, err)
+		ran = append(ran, inviter.Name())
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"guardduty", "security_hub", "detective"}, ran)
+
+	// context is cancelled while the second inviter runs, so that it's finished and the third one isn't started
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran = nil
+	err = runInviters(ctx, inviters, func(inviter Inviter) {
+		if inviter.Name() == "security_hub" {
+			cancel()
+		}
+		_, err := inviter.AddMember("112233445566", "", "665544332211")
+		assert.NoError(t, err)
+		ran = append(ran, inviter.Name())
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"guardduty", "security_hub"}, ran)
+
+	// nothing is run with already cancelled context
+	ran = nil
+	err = runInviters(ctx, inviters, func(inviter Inviter) { ran = append(ran, inviter.Name()) })
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Empty(t, ran)
+}
+
+func TestIsServiceUnavailableInRegion(t *testing.T) {
+	requestError := func(err error) error {
+		return awserr.New(request.ErrCodeRequestError, "send request failed",
+			&url.Error{Op: "Post", URL: "https://detective.ap-east-1.amazonaws.com/graphs/list", Err: err})
+	}
+	testData := []struct {
+		description string
+		err         error
+		unavailable bool
+	}{
+		{description: "no error"},
+		{description: "non-AWS error",
+			err: fmt.Errorf("mock err")},
+		{description: "unknown endpoint",
+			err:         fmt.Errorf("error listing graphs: %w", endpoints.NewUnknownEndpointError("aws", "detective", "ap-east-1", nil)),
+			unavailable: true},
+		{description: "endpoint host not found",
+			err: &ServiceError{Service: "detective", Err: fmt.Errorf("error listing graphs: %w",
+				requestError(&net.OpError{Op: "dial", Net: "tcp",
+					Err: &net.DNSError{Err: "no such host", Name: "detective.ap-east-1.amazonaws.com", IsNotFound: true}}))},
+			unavailable: true},
+		{description: "temporary DNS failure",
+			err: requestError(&net.OpError{Op: "dial", Net: "tcp",
+				Err: &net.DNSError{Err: "server misbehaving", Name: "detective.ap-east-1.amazonaws.com", IsTemporary: true}})},
+		{description: "connection timeout",
+			err: requestError(&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("i/o timeout")})},
+		{description: "Security Hub not supported in region",
+			err:         awserr.New("InvalidInputException", "Security Hub is not supported in region ap-east-1", nil),
+			unavailable: true},
+		{description: "GuardDuty feature not available in region",
+			err:         awserr.New("BadRequestException", "The request is rejected because the feature is not available in this region.", nil),
+			unavailable: true},
+		{description: "other invalid input",
+			err: awserr.New("InvalidInputException", "Invalid member account ID", nil)},
+		{description: "access denied",
+			err: awserr.New("AccessDeniedException", "Operation is not available in region for this user", nil)},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			assert.Equal(t, x.unavailable, isServiceUnavailableInRegion(x.err), "Test case %d check failed", i)
+		})
+	}
+}
+
+func TestResolveMasterAccountID(t *testing.T) {
+	testData := []struct {
+		description string
+		override    string
+		lookupID    string
+		lookupErr   error
+		lookups     int
+		id          string
+		error       string
+	}{
+		{description: "override is used without lookup",
+			override: "112233445566",
+			id:       "112233445566"},
+		{description: "invalid override",
+			override: "1122-3344-5566",
+			error:    `invalid master account ID "1122-3344-5566"`},
+		{description: "lookup",
+			lookupID: "665544332211",
+			lookups:  1,
+			id:       "665544332211"},
+		{description: "lookup error",
+			lookupErr: fmt.Errorf("mock err"),
+			lookups:   1,
+			error:     "problem retrieving master account ID: mock err"},
+		{description: "invalid looked up ID",
+			lookupID: "12345",
+			lookups:  1,
+			error:    `invalid master account ID "12345"`},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			var lookups int
+			id, err := resolveMasterAccountID(x.override, func() (string, error) {
+				lookups++
+				return x.lookupID, x.lookupErr
+			})
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.id, id, "Test case %d ID check failed", i)
+			assert.Equal(t, x.lookups, lookups, "Test case %d lookups check failed", i)
+		})
+	}
+}
+
+func TestVerifyMemberAccounts(t *testing.T) {
+	accounts := []Account{
+		{ID: "112233445566", Email: "a@example.com"},
+		{ID: "223344556677", Email: "b@example.com"},
+		{ID: "334455667788", Email: "c@example.com"},
+	}
+	var checked []string
+	verified, errs := verifyMemberAccounts(accounts, func(accountID string) error {
+		checked = append(checked, accountID)
+		if accountID == "223344556677" {
+			return fmt.Errorf("role test_role is assumed in account 665544332211 instead of 223344556677")
+		}
+		return nil
+	})
+	assert.Equal(t, []string{"112233445566", "223344556677", "334455667788"}, checked)
+	assert.Equal(t, []Account{accounts[0], accounts[2]}, verified)
+	if assert.Len(t, errs, 1) {
+		assert.EqualError(t, errs[0], "skipping member account 223344556677: "+
+			"role test_role is assumed in account 665544332211 instead of 223344556677")
+	}
+
+	verified, errs = verifyMemberAccounts(nil, func(string) error { return nil })
+	assert.Empty(t, verified)
+	assert.Empty(t, errs)
+}
+
+func TestJitteredSleep(t *testing.T) {
+	start := time.Now()
+	require.NoError(t, jitteredSleep(context.Background(), 20*time.Millisecond))
+	assert.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start = time.Now()
+	assert.ErrorIs(t, jitteredSleep(ctx, time.Hour), context.Canceled)
+	assert.Less(t, time.Since(start), time.Second, "cancellation should interrupt the delay promptly")
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+)
+
+// OrganizationConfigurer is a per-region structure which contains all information for making master account
+// GuardDuty delegated administrator of AWS Organization, so that new organization accounts are onboarded
+// to GuardDuty automatically without invitations.
+type OrganizationConfigurer struct {
+	managementSvc GuardDutyManagementClient
+	adminSvc      GuardDutyOrganizationAdminClient
+}
+
+// GuardDutyManagementClient is a subset of aws-sdk-go/service/guardduty which is used for registering
+// delegated administrator from the organization management account.
+type GuardDutyManagementClient interface {
+	ListOrganizationAdminAccounts(*guardduty.ListOrganizationAdminAccountsInput) (*guardduty.ListOrganizationAdminAccountsOutput, error)
+	EnableOrganizationAdminAccount(*guardduty.EnableOrganizationAdminAccountInput) (*guardduty.EnableOrganizationAdminAccountOutput, error)
+}
+
+// GuardDutyOrganizationAdminClient is a subset of aws-sdk-go/service/guardduty which is used for configuring
+// organization on the delegated administrator account.
+type GuardDutyOrganizationAdminClient interface {
+	GuardDutyListDetectors
+	DescribeOrganizationConfiguration(*guardduty.DescribeOrganizationConfigurationInput) (*guardduty.DescribeOrganizationConfigurationOutput, error)
+	UpdateOrganizationConfiguration(*guardduty.UpdateOrganizationConfigurationInput) (*guardduty.UpdateOrganizationConfigurationOutput, error)
+}
+
+// NewOrganizationConfigurer creates new instance of OrganizationConfigurer, managementSess should belong
+// to the organization management account and adminSess to the account which becomes delegated administrator.
+func NewOrganizationConfigurer(managementSess, adminSess client.ConfigProvider) *OrganizationConfigurer {
+	return &OrganizationConfigurer{
+		managementSvc: guardduty.New(managementSess),
+		adminSvc:      guardduty.New(adminSess),
+	}
+}
+
+// Name returns "guardduty_organization", identifier of the service.
+func (o OrganizationConfigurer) Name() string {
+	return "guardduty_organization"
+}
+
+// ConfigureGuardDuty registers provided account as GuardDuty delegated administrator of the organization
+// and enables automatic onboarding of new organization accounts to GuardDuty.
+// In case both are in place already, nothing is done.
+// https://docs.aws.amazon.com/guardduty/latest/ug/guardduty_organizations.html
+func (o OrganizationConfigurer) ConfigureGuardDuty(adminAccountID string) (Result, error) {
+	registered, err := registerGuardDutyAdmin(o.managementSvc, adminAccountID)
+	if err != nil {
+		return Result{Status: StatusFailed}, newServiceError(o.Name(), fmt.Errorf("error registering delegated administrator: %w", err))
+	}
+
+	autoEnabled, err := enableGuardDutyAutoEnable(o.adminSvc)
+	if err != nil {
+		return Result{Status: StatusFailed}, newServiceError(o.Name(), fmt.Errorf("error enabling automatic onboarding: %w", err))
+	}
+
+	if registered || autoEnabled {
+		return Result{Status: StatusUpdated}, nil
+	}
+	return Result{Status: StatusAlreadyConnected}, nil
+}
+
+// registerGuardDutyAdmin makes provided account GuardDuty delegated administrator in case it's not one already,
+// and returns if it was registered
+func registerGuardDutyAdmin(g GuardDutyManagementClient, adminAccountID string) (bool, error) {
+	input := &guardduty.ListOrganizationAdminAccountsInput{}
+	for {
+		admins, err := g.ListOrganizationAdminAccounts(input)
+		if err != nil {
+			return false, fmt.Errorf("error listing delegated administrators: %w", err)
+		}
+		for _, admin := range admins.AdminAccounts {
+			if aws.StringValue(admin.AdminStatus) != guardduty.AdminStatusEnabled {
+				continue
+			}
+			if aws.StringValue(admin.AdminAccountId) == adminAccountID {
+				return false, nil
+			}
+			return false, fmt.Errorf("account %s is delegated administrator already", aws.StringValue(admin.AdminAccountId))
+		}
+		if aws.StringValue(admins.NextToken) == "" {
+			break
+		}
+		input.NextToken = admins.NextToken
+	}
+
+	_, err := g.EnableOrganizationAdminAccount(&guardduty.EnableOrganizationAdminAccountInput{
+		AdminAccountId: aws.String(adminAccountID),
+	})
+	if err != nil {
+		return false, fmt.Errorf("error enabling delegated administrator: %w", err)
+	}
+	return true, nil
+}
+
+// SecurityHubOrganizationConfigurer is a per-region structure which contains all information for making
+// Security Hub enabled automatically for new organization accounts, so that they are onboarded without invitations.
+type SecurityHubOrganizationConfigurer struct {
+	adminSvc SecurityHubOrganizationAdminClient
+	// autoEnableStandards is either "DEFAULT" or "NONE", and defines if default standards are enabled for new accounts
+	autoEnableStandards string
+}
+
+// SecurityHubOrganizationAdminClient is a subset of aws-sdk-go/service/securityhub which is used for configuring
+// organization on the delegated administrator account.
+type SecurityHubOrganizationAdminClient interface {
+	DescribeOrganizationConfiguration(*securityhub.DescribeOrganizationConfigurationInput) (*securityhub.DescribeOrganizationConfigurationOutput, error)
+	UpdateOrganizationConfiguration(*securityhub.UpdateOrganizationConfigurationInput) (*securityhub.UpdateOrganizationConfigurationOutput, error)
+}
+
+// NewSecurityHubOrganizationConfigurer creates new instance of SecurityHubOrganizationConfigurer, adminSess should
+// belong to Security Hub delegated administrator of the organization. In case defaultStandards is set,
+// default Security Hub standards are enabled for new accounts as well.
+func NewSecurityHubOrganizationConfigurer(adminSess client.ConfigProvider, defaultStandards bool) *SecurityHubOrganizationConfigurer {
+	autoEnableStandards := securityhub.AutoEnableStandardsNone
+	if defaultStandards {
+		autoEnableStandards = securityhub.AutoEnableStandardsDefault
+	}
+	return &SecurityHubOrganizationConfigurer{
+		adminSvc:            securityhub.New(adminSess),
+		autoEnableStandards: autoEnableStandards,
+	}
+}
+
+// Name returns "security_hub_organization", identifier of the service.
+func (o SecurityHubOrganizationConfigurer) Name() string {
+	return "security_hub_organization"
+}
+
+// EnableOrgAutoEnroll enables Security Hub automatically for new organization accounts.
+// In case it's configured already, nothing is done.
+// https://docs.aws.amazon.com/securityhub/latest/userguide/accounts-orgs-auto-enable.html
+func (o SecurityHubOrganizationConfigurer) EnableOrgAutoEnroll() (Result, error) {
+	conf, err := o.adminSvc.DescribeOrganizationConfiguration(&securityhub.DescribeOrganizationConfigurationInput{})
+	if err != nil {
+		return Result{Status: StatusFailed}, newServiceError(o.Name(), fmt.Errorf("error describing organization configuration: %w", err))
+	}
+	if aws.BoolValue(conf.AutoEnable) && aws.StringValue(conf.AutoEnableStandards) == o.autoEnableStandards {
+		return Result{Status: StatusAlreadyConnected}, nil
+	}
+
+	_, err = o.adminSvc.UpdateOrganizationConfiguration(&securityhub.UpdateOrganizationConfigurationInput{
+		AutoEnable:          aws.Bool(true),
+		AutoEnableStandards: aws.String(o.autoEnableStandards),
+	})
+	if err != nil {
+		return Result{Status: StatusFailed}, newServiceError(o.Name(), fmt.Errorf("error updating organization configuration: %w", err))
+	}
+	return Result{Status: StatusUpdated}, nil
+}
+
+// enableGuardDutyAutoEnable turns on automatic enabling of GuardDuty for new organization accounts
+// in case it's not enabled yet, and returns if the update was made
+func enableGuardDutyAutoEnable(g GuardDutyOrganizationAdminClient) (bool, error) {
+	detectorID, err := getDetectorID(g)
+	if err != nil {
+		return false, fmt.Errorf("can't get detectorID of delegated administrator account: %w", err)
+	}
+
+	conf, err := g.DescribeOrganizationConfiguration(&guardduty.DescribeOrganizationConfigurationInput{
+		DetectorId: detectorID,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error describing organization configuration: %w", err)
+	}
+	if aws.BoolValue(conf.AutoEnable) {
+		return false, nil
+	}
+
+	_, err = g.UpdateOrganizationConfiguration(&guardduty.UpdateOrganizationConfigurationInput{
+		DetectorId: detectorID,
+		AutoEnable: aws.Bool(true),
+	})
+	if err != nil {
+		return false, fmt.Errorf("error updating organization configuration: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrganizationConfigurer_ConfigureGuardDuty(t *testing.T) {
+	var (
+		adminAccID = "123456789012"
+		detectorID = "detector1"
+		badLOAReq  = gdListOrgAdminsReq{err: fmt.Errorf("mock err")}
+		noLOAReq   = gdListOrgAdminsReq{output: &guardduty.ListOrganizationAdminAccountsOutput{}}
+		goodLOAReq = gdListOrgAdminsReq{output: &guardduty.ListOrganizationAdminAccountsOutput{
+			AdminAccounts: []*guardduty.AdminAccount{
+				{AdminAccountId: aws.String(adminAccID), AdminStatus: aws.String(guardduty.AdminStatusEnabled)}}}}
+		disablingLOAReq = gdListOrgAdminsReq{output: &guardduty.ListOrganizationAdminAccountsOutput{
+			AdminAccounts: []*guardduty.AdminAccount{
+				{AdminAccountId: aws.String("210987654321"), AdminStatus: aws.String(guardduty.AdminStatusDisableInProgress)}}}}
+		otherLOAReq = gdListOrgAdminsReq{output: &guardduty.ListOrganizationAdminAccountsOutput{
+			AdminAccounts: []*guardduty.AdminAccount{
+				{AdminAccountId: aws.String("210987654321"), AdminStatus: aws.String(guardduty.AdminStatusEnabled)}}}}
+		badEOAReq = gdEnableOrgAdminReq{err: fmt.Errorf("mock err")}
+		badDReq   = gdDetectorReq{err: fmt.Errorf("mock err")}
+		goodDReq  = gdDetectorReq{output: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&detectorID}}}
+		badDOCReq = gdDescribeOrgConfReq{err: fmt.Errorf("mock err")}
+		offDOCReq = gdDescribeOrgConfReq{output: &guardduty.DescribeOrganizationConfigurationOutput{AutoEnable: aws.Bool(false)}}
+		onDOCReq  = gdDescribeOrgConfReq{output: &guardduty.DescribeOrganizationConfigurationOutput{AutoEnable: aws.Bool(true)}}
+		badUOCReq = gdUpdateOrgConfReq{err: fmt.Errorf("mock err")}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		status      Status
+		loaReq      gdListOrgAdminsReq
+		eoaReq      gdEnableOrgAdminReq
+		dReq        gdDetectorReq
+		docReq      gdDescribeOrgConfReq
+		uocReq      gdUpdateOrgConfReq
+		enabled     bool
+		updated     bool
+	}{
+		{description: "problem listing administrators",
+			loaReq: badLOAReq,
+			error:  "error registering delegated administrator: error listing delegated administrators: mock err"},
+		{description: "other account is administrator",
+			loaReq: otherLOAReq,
+			error:  "error registering delegated administrator: account 210987654321 is delegated administrator already"},
+		{description: "problem enabling administrator",
+			loaReq:  noLOAReq,
+			eoaReq:  badEOAReq,
+			enabled: true,
+			error:   "error registering delegated administrator: error enabling delegated administrator: mock err"},
+		{description: "problem getting detector",
+			loaReq: goodLOAReq,
+			dReq:   badDReq,
+			error:  "error enabling automatic onboarding: can't get detectorID of delegated administrator account: error listing detectors: mock err"},
+		{description: "problem describing organization configuration",
+			loaReq: goodLOAReq,
+			dReq:   goodDReq,
+			docReq: badDOCReq,
+			error:  "error enabling automatic onboarding: error describing organization configuration: mock err"},
+		{description: "problem updating organization configuration",
+			loaReq:  goodLOAReq,
+			dReq:    goodDReq,
+			docReq:  offDOCReq,
+			uocReq:  badUOCReq,
+			updated: true,
+			error:   "error enabling automatic onboarding: error updating organization configuration: mock err"},
+		{description: "everything is configured already",
+			loaReq: goodLOAReq,
+			dReq:   goodDReq,
+			docReq: onDOCReq,
+			status: StatusAlreadyConnected},
+		{description: "administrator registered and auto-enable turned on",
+			loaReq:  disablingLOAReq,
+			dReq:    goodDReq,
+			docReq:  offDOCReq,
+			enabled: true,
+			updated: true,
+			status:  StatusUpdated},
+		{description: "only auto-enable turned on",
+			loaReq:  goodLOAReq,
+			dReq:    goodDReq,
+			docReq:  offDOCReq,
+			updated: true,
+			status:  StatusUpdated},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			management := &mockGDManagementClient{t: t, adminAccID: adminAccID, loaReq: x.loaReq, eoaReq: x.eoaReq}
+			admin := &mockGDOrgAdminClient{detectorID: detectorID, docReq: x.docReq, uocReq: x.uocReq}
+			admin.t = t         // promoted field
+			admin.dReq = x.dReq // promoted field
+			o := OrganizationConfigurer{managementSvc: management, adminSvc: admin}
+			res, err := o.ConfigureGuardDuty(adminAccID)
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				assert.Equal(t, StatusFailed, res.Status, "Test case %d status check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+				assert.Equal(t, x.status, res.Status, "Test case %d status check failed", i)
+			}
+			assert.Equal(t, x.enabled, management.enabled, "Test case %d administrator enabling check failed", i)
+			assert.Equal(t, x.updated, admin.updated, "Test case %d configuration update check failed", i)
+		})
+	}
+}
+
+func TestSecurityHubOrganizationConfigurer_EnableOrgAutoEnroll(t *testing.T) {
+	var (
+		badDOCReq = shDescribeOrgConfReq{err: fmt.Errorf("mock err")}
+		offDOCReq = shDescribeOrgConfReq{output: &securityhub.DescribeOrganizationConfigurationOutput{
+			AutoEnable: aws.Bool(false), AutoEnableStandards: aws.String(securityhub.AutoEnableStandardsDefault)}}
+		onDOCReq = shDescribeOrgConfReq{output: &securityhub.DescribeOrganizationConfigurationOutput{
+			AutoEnable: aws.Bool(true), AutoEnableStandards: aws.String(securityhub.AutoEnableStandardsNone)}}
+		onDefaultDOCReq = shDescribeOrgConfReq{output: &securityhub.DescribeOrganizationConfigurationOutput{
+			AutoEnable: aws.Bool(true), AutoEnableStandards: aws.String(securityhub.AutoEnableStandardsDefault)}}
+		badUOCReq = shUpdateOrgConfReq{err: fmt.Errorf("mock err")}
+	)
+
+	var testData = []struct {
+		description      string
+		error            string
+		status           Status
+		defaultStandards bool
+		docReq           shDescribeOrgConfReq
+		uocReq           shUpdateOrgConfReq
+		updated          bool
+	}{
+		{description: "problem describing organization configuration",
+			docReq: badDOCReq,
+			error:  "error describing organization configuration: mock err"},
+		{description: "problem updating organization configuration",
+			docReq:  offDOCReq,
+			uocReq:  badUOCReq,
+			updated: true,
+			error:   "error updating organization configuration: mock err"},
+		{description: "auto-enable configured already",
+			docReq: onDOCReq,
+			status: StatusAlreadyConnected},
+		{description: "auto-enable with default standards configured already",
+			defaultStandards: true,
+			docReq:           onDefaultDOCReq,
+			status:           StatusAlreadyConnected},
+		{description: "auto-enable turned on",
+			docReq:  offDOCReq,
+			updated: true,
+			status:  StatusUpdated},
+		{description: "default standards turned on",
+			defaultStandards: true,
+			docReq:           onDOCReq,
+			updated:          true,
+			status:           StatusUpdated},
+		{description: "default standards turned off",
+			docReq:  onDefaultDOCReq,
+			updated: true,
+			status:  StatusUpdated},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			o := NewSecurityHubOrganizationConfigurer(unit.Session, x.defaultStandards)
+			admin := &mockSHOrgAdminClient{t: t, autoEnableStandards: o.autoEnableStandards,
+				docReq: x.docReq, uocReq: x.uocReq}
+			o.adminSvc = admin
+			res, err := o.EnableOrgAutoEnroll()
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				assert.Equal(t, StatusFailed, res.Status, "Test case %d status check failed", i)
+				var serviceErr *ServiceError
+				if assert.ErrorAs(t, err, &serviceErr, "Test case %d error type check failed", i) {
+					assert.Equal(t, "security_hub_organization", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+				assert.Equal(t, x.status, res.Status, "Test case %d status check failed", i)
+			}
+			assert.Equal(t, x.updated, admin.updated, "Test case %d configuration update check failed", i)
+		})
+	}
+}
+
+type mockSHOrgAdminClient struct {
+	t                   *testing.T
+	autoEnableStandards string
+	docReq              shDescribeOrgConfReq
+	uocReq              shUpdateOrgConfReq
+	updated             bool
+}
+
+type shDescribeOrgConfReq struct {
+	output *securityhub.DescribeOrganizationConfigurationOutput
+	err    error
+}
+type shUpdateOrgConfReq struct {
+	err error
+}
+
+func (s *mockSHOrgAdminClient) DescribeOrganizationConfiguration(input *securityhub.DescribeOrganizationConfigurationInput) (*securityhub.DescribeOrganizationConfigurationOutput, error) {
+	assert.Equal(s.t, &securityhub.DescribeOrganizationConfigurationInput{}, input)
+	return s.docReq.output, s.docReq.err
+}
+
+func (s *mockSHOrgAdminClient) UpdateOrganizationConfiguration(input *securityhub.UpdateOrganizationConfigurationInput) (*securityhub.UpdateOrganizationConfigurationOutput, error) {
+	assert.Equal(s.t, &securityhub.UpdateOrganizationConfigurationInput{
+		AutoEnable:          aws.Bool(true),
+		AutoEnableStandards: aws.String(s.autoEnableStandards),
+	}, input)
+	s.updated = true
+	return &securityhub.UpdateOrganizationConfigurationOutput{}, s.uocReq.err
+}
+
+type mockGDManagementClient struct {
+	t          *testing.T
+	adminAccID string
+	loaReq     gdListOrgAdminsReq
+	eoaReq     gdEnableOrgAdminReq
+	enabled    bool
+}
+
+type gdListOrgAdminsReq struct {
+	output *guardduty.ListOrganizationAdminAccountsOutput
+	err    error
+}
+type gdEnableOrgAdminReq struct {
+	err error
+}
+
+func (s *mockGDManagementClient) ListOrganizationAdminAccounts(input *guardduty.ListOrganizationAdminAccountsInput) (*guardduty.ListOrganizationAdminAccountsOutput, error) {
+	assert.Equal(s.t, &guardduty.ListOrganizationAdminAccountsInput{}, input)
+	return s.loaReq.output, s.loaReq.err
+}
+
+func (s *mockGDManagementClient) EnableOrganizationAdminAccount(input *guardduty.EnableOrganizationAdminAccountInput) (*guardduty.EnableOrganizationAdminAccountOutput, error) {
+	assert.Equal(s.t, &guardduty.EnableOrganizationAdminAccountInput{AdminAccountId: &s.adminAccID}, input)
+	s.enabled = true
+	return &guardduty.EnableOrganizationAdminAccountOutput{}, s.eoaReq.err
+}
+
+type mockGDOrgAdminClient struct {
+	mockGDDetectorClient
+	detectorID string
+	docReq     gdDescribeOrgConfReq
+	uocReq     gdUpdateOrgConfReq
+	updated    bool
+}
+
+type gdDescribeOrgConfReq struct {
+	output *guardduty.DescribeOrganizationConfigurationOutput
+	err    error
+}
+type gdUpdateOrgConfReq struct {
+	err error
+}
+
+func (s *mockGDOrgAdminClient) DescribeOrganizationConfiguration(input *guardduty.DescribeOrganizationConfigurationInput) (*guardduty.DescribeOrganizationConfigurationOutput, error) {
+	assert.Equal(s.t, &guardduty.DescribeOrganizationConfigurationInput{DetectorId: &s.detectorID}, input)
+	return s.docReq.output, s.docReq.err
+}
+
+func (s *mockGDOrgAdminClient) UpdateOrganizationConfiguration(input *guardduty.UpdateOrganizationConfigurationInput) (*guardduty.UpdateOrganizationConfigurationOutput, error) {
+	assert.Equal(s.t, &guardduty.UpdateOrganizationConfigurationInput{
+		DetectorId: &s.detectorID,
+		AutoEnable: aws.Bool(true),
+	}, input)
+	s.updated = true
+	return &guardduty.UpdateOrganizationConfigurationOutput{}, s.uocReq.err
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+// Account is an AWS account to connect to security services.
+type Account struct {
+	ID    string
+	Email string
+}
+
+// OrganizationsClient is a subset of aws-sdk-go/service/organizations which is used for listing
+// organization accounts.
+type OrganizationsClient interface {
+	DescribeOrganization(*organizations.DescribeOrganizationInput) (*organizations.DescribeOrganizationOutput, error)
+	ListAccounts(*organizations.ListAccountsInput) (*organizations.ListAccountsOutput, error)
+	ListTagsForResource(*organizations.ListTagsForResourceInput) (*organizations.ListTagsForResourceOutput, error)
+}
+
+// TagFilter selects organization accounts which have the tag with provided key and value.
+type TagFilter struct {
+	Key   string
+	Value string
+}
+
+// ParseTagFilter parses tag filter in key=value format, returning nil filter for empty string.
+func ParseTagFilter(s string) (*TagFilter, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return nil, fmt.Errorf("%q is not in key=value format", s)
+	}
+	return &TagFilter{Key: strings.TrimSpace(parts[0]), Value: strings.TrimSpace(parts[1])}, nil
+}
+
+// matches returns true in case provided tags contain the tag of the filter
+func (f TagFilter) matches(tags []*organizations.Tag) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == f.Key && aws.StringValue(tag.Value) == f.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// AccountFilter selects organization accounts by their IDs.
+type AccountFilter struct {
+	// Include leaves only listed accounts in case it's not empty
+	Include []string
+	// Exclude drops listed accounts, it takes precedence over Include
+	Exclude []string
+}
+
+// allows returns true in case account with provided ID passes the filter. Management account is excluded
+// unless it's explicitly included, while explicitly excluded account is never allowed.
+func (f AccountFilter) allows(accountID, managementAccountID string) bool {
+	if contains(f.Exclude, accountID) {
+		return false
+	}
+	if contains(f.Include, accountID) {
+		return true
+	}
+	return len(f.Include) == 0 && accountID != managementAccountID
+}
+
+// contains returns true in case list has provided string
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ListActiveAccounts returns active member accounts of the organization allowed by account filter,
+// which excludes the management account by default.
+// In case tag filter is set, only accounts having the tag are returned.
+// Provided session should belong to the organization management account or delegated administrator.
+func ListActiveAccounts(sess client.ConfigProvider, accountFilter AccountFilter, tagFilter *TagFilter) ([]Account, error) {
+	return listActiveAccounts(organizations.New(sess), accountFilter, tagFilter)
+}
+
+func listActiveAccounts(o OrganizationsClient, accountFilter AccountFilter, tagFilter *TagFilter) ([]Account, error) {
+	org, err := o.DescribeOrganization(&organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return nil, fmt.Errorf("error describing organization: %w", err)
+	}
+	managementAccountID := aws.StringValue(org.Organization.MasterAccountId)
+
+	var accounts []Account
+	input := &organizations.ListAccountsInput{}
+	for {
+		out, err := o.ListAccounts(input)
+		if err != nil {
+			return nil, fmt.Errorf("error listing accounts: %w", err)
+		}
+		for _, acc := range out.Accounts {
+			if aws.StringValue(acc.Status) != organizations.AccountStatusActive ||
+				!accountFilter.allows(aws.StringValue(acc.Id), managementAccountID) {
+				continue
+			}
+			accounts = append(accounts, Account{ID: aws.StringValue(acc.Id), Email: aws.StringValue(acc.Email)})
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+	if tagFilter == nil {
+		return accounts, nil
+	}
+
+	var matching []Account
+	for _, acc := range accounts {
+		tags, err := listAccountTags(o, acc.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error listing tags of account %s: %w", acc.ID, err)
+		}
+		if tagFilter.matches(tags) {
+			matching = append(matching, acc)
+		}
+	}
+	return matching, nil
+}
+
+// listAccountTags returns all tags of provided organization account
+func listAccountTags(o OrganizationsClient, accountID string) ([]*organizations.Tag, error) {
+	var tags []*organizations.Tag
+	input := &organizations.ListTagsForResourceInput{ResourceId: aws.String(accountID)}
+	for {
+		out, err := o.ListTagsForResource(input)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, out.Tags...)
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+	return tags, nil
+}
@@ -0,0 +1,310 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListActiveAccounts(t *testing.T) {
+	var (
+		goodDOReq = orgDescribeOrganizationReq{output: &organizations.DescribeOrganizationOutput{
+			Organization: &organizations.Organization{MasterAccountId: aws.String("111111111111")}}}
+		firstPage = &organizations.ListAccountsOutput{
+			Accounts: []*organizations.Account{
+				{Id: aws.String("111111111111"), Email: aws.String("management@example.org"), Status: aws.String("ACTIVE")},
+				{Id: aws.String("222222222222"), Email: aws.String("two@example.org"), Status: aws.String("ACTIVE")},
+				{Id: aws.String("333333333333"), Email: aws.String("three@example.org"), Status: aws.String("SUSPENDED")},
+			},
+			NextToken: aws.String("page2"),
+		}
+		secondPage = &organizations.ListAccountsOutput{
+			Accounts: []*organizations.Account{
+				{Id: aws.String("444444444444"), Email: aws.String("four@example.org"), Status: aws.String("ACTIVE")},
+				{Id: aws.String("555555555555"), Email: aws.String("five@example.org"), Status: aws.String("PENDING_CLOSURE")},
+			},
+		}
+	)
+
+	var testData = []struct {
+		description string
+		doReq       orgDescribeOrganizationReq
+		pages       []*organizations.ListAccountsOutput
+		laErr       error
+		filter      AccountFilter
+		tagFilter   *TagFilter
+		tags        map[string][]*organizations.ListTagsForResourceOutput
+		ltErr       error
+		accounts    []Account
+		error       string
+	}{
+		{description: "problem describing organization",
+			doReq: orgDescribeOrganizationReq{err: fmt.Errorf("mock err")},
+			error: "error describing organization: mock err"},
+		{description: "problem listing accounts",
+			doReq: goodDOReq,
+			laErr: fmt.Errorf("mock err"),
+			error: "error listing accounts: mock err"},
+		{description: "no accounts",
+			doReq: goodDOReq,
+			pages: []*organizations.ListAccountsOutput{{}}},
+		{description: "active member accounts from all pages",
+			doReq: goodDOReq,
+			pages: []*organizations.ListAccountsOutput{firstPage, secondPage},
+			accounts: []Account{
+				{ID: "222222222222", Email: "two@example.org"},
+				{ID: "444444444444", Email: "four@example.org"},
+			}},
+		{description: "excluded account is dropped",
+			doReq:    goodDOReq,
+			pages:    []*organizations.ListAccountsOutput{firstPage, secondPage},
+			filter:   AccountFilter{Exclude: []string{"222222222222"}},
+			accounts: []Account{{ID: "444444444444", Email: "four@example.org"}}},
+		{description: "only included accounts, management account is connected when included",
+			doReq:  goodDOReq,
+			pages:  []*organizations.ListAccountsOutput{firstPage, secondPage},
+			filter: AccountFilter{Include: []string{"111111111111", "444444444444", "555555555555"}},
+			accounts: []Account{
+				{ID: "111111111111", Email: "management@example.org"},
+				{ID: "444444444444", Email: "four@example.org"},
+			}},
+		{description: "exclude takes precedence over include",
+			doReq:    goodDOReq,
+			pages:    []*organizations.ListAccountsOutput{firstPage, secondPage},
+			filter:   AccountFilter{Include: []string{"222222222222", "444444444444"}, Exclude: []string{"444444444444"}},
+			accounts: []Account{{ID: "222222222222", Email: "two@example.org"}}},
+		{description: "tags are not listed for filtered out accounts",
+			doReq:     goodDOReq,
+			pages:     []*organizations.ListAccountsOutput{firstPage, secondPage},
+			filter:    AccountFilter{Exclude: []string{"222222222222", "444444444444"}},
+			tagFilter: &TagFilter{Key: "security-managed", Value: "true"},
+			ltErr:     fmt.Errorf("mock err")},
+		{description: "only accounts with the tag, tags from all pages",
+			doReq:     goodDOReq,
+			pages:     []*organizations.ListAccountsOutput{firstPage, secondPage},
+			tagFilter: &TagFilter{Key: "security-managed", Value: "true"},
+			tags: map[string][]*organizations.ListTagsForResourceOutput{
+				"222222222222": {
+					{Tags: []*organizations.Tag{{Key: aws.String("team"), Value: aws.String("security")}}, NextToken: aws.String("tags2")},
+					{Tags: []*organizations.Tag{{Key: aws.String("security-managed"), Value: aws.String("true")}}},
+				},
+				"444444444444": {
+					{Tags: []*organizations.Tag{{Key: aws.String("security-managed"), Value: aws.String("false")}}},
+				},
+			},
+			accounts: []Account{{ID: "222222222222", Email: "two@example.org"}}},
+		{description: "problem listing tags",
+			doReq:     goodDOReq,
+			pages:     []*organizations.ListAccountsOutput{firstPage, secondPage},
+			tagFilter: &TagFilter{Key: "security-managed", Value: "true"},
+			ltErr:     fmt.Errorf("mock err"),
+			error:     "error listing tags of account 222222222222: mock err"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			o := &mockOrganizationsClient{t: t, doReq: x.doReq, pages: x.pages, laErr: x.laErr,
+				tags: x.tags, ltErr: x.ltErr, tagCalls: map[string]int{}}
+			accounts, err := listActiveAccounts(o, x.filter, x.tagFilter)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.accounts, accounts, "Test case %d accounts check failed", i)
+		})
+	}
+}
+
+func TestAccountFilter_Allows(t *testing.T) {
+	const managementAccountID = "111111111111"
+	testData := []struct {
+		description string
+		filter      AccountFilter
+		accountID   string
+		expected    bool
+	}{
+		{description: "empty filter allows member account", accountID: "222222222222", expected: true},
+		{description: "empty filter excludes management account", accountID: managementAccountID},
+		{description: "excluded account",
+			filter:    AccountFilter{Exclude: []string{"222222222222"}},
+			accountID: "222222222222"},
+		{description: "account not in exclude list",
+			filter:    AccountFilter{Exclude: []string{"333333333333"}},
+			accountID: "222222222222",
+			expected:  true},
+		{description: "included account",
+			filter:    AccountFilter{Include: []string{"222222222222"}},
+			accountID: "222222222222",
+			expected:  true},
+		{description: "account not in include list",
+			filter:    AccountFilter{Include: []string{"333333333333"}},
+			accountID: "222222222222"},
+		{description: "included management account",
+			filter:    AccountFilter{Include: []string{managementAccountID}},
+			accountID: managementAccountID,
+			expected:  true},
+		{description: "management account not in include list",
+			filter:    AccountFilter{Include: []string{"222222222222"}},
+			accountID: managementAccountID},
+		{description: "both included and excluded account",
+			filter:    AccountFilter{Include: []string{"222222222222"}, Exclude: []string{"222222222222"}},
+			accountID: "222222222222"},
+		{description: "both included and excluded management account",
+			filter:    AccountFilter{Include: []string{managementAccountID}, Exclude: []string{managementAccountID}},
+			accountID: managementAccountID},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			assert.Equal(t, x.expected, x.filter.allows(x.accountID, managementAccountID), "Test case %d check failed", i)
+		})
+	}
+}
+
+type mockOrganizationsClient struct {
+	t     *testing.T
+	doReq orgDescribeOrganizationReq
+	pages []*organizations.ListAccountsOutput
+	laErr error
+	calls int
+	// tags are pages of tags keyed by account ID
+	tags     map[string][]*organizations.ListTagsForResourceOutput
+	ltErr    error
+	tagCalls map[string]int
+}
+
+type orgDescribeOrganizationReq struct {
+	output *organizations.DescribeOrganizationOutput
+	err    error
+}
+
+func (o *mockOrganizationsClient) DescribeOrganization(input *organizations.DescribeOrganizationInput) (*organizations.DescribeOrganizationOutput, error) {
+	assert.Equal(o.t, &organizations.DescribeOrganizationInput{}, input)
+	return o.doReq.output, o.doReq.err
+}
+
+func (o *mockOrganizationsClient) ListAccounts(input *organizations.ListAccountsInput) (*organizations.ListAccountsOutput, error) {
+	if o.laErr != nil {
+		return nil, o.laErr
+	}
+	// every page after the first one is requested with the token of the previous page
+	expected := &organizations.ListAccountsInput{}
+	if o.calls > 0 {
+		expected.NextToken = o.pages[o.calls-1].NextToken
+	}
+	assert.Equal(o.t, expected, input)
+	page := o.pages[o.calls]
+	o.calls++
+	return page, nil
+}
+
+func (o *mockOrganizationsClient) ListTagsForResource(input *organizations.ListTagsForResourceInput) (*organizations.ListTagsForResourceOutput, error) {
+	if o.ltErr != nil {
+		return nil, o.ltErr
+	}
+	accountID := aws.StringValue(input.ResourceId)
+	pages := o.tags[accountID]
+	if len(pages) == 0 {
+		return &organizations.ListTagsForResourceOutput{}, nil
+	}
+	// every page after the first one is requested with the token of the previous page
+	calls := o.tagCalls[accountID]
+	expected := &organizations.ListTagsForResourceInput{ResourceId: aws.String(accountID)}
+	if calls > 0 {
+		expected.NextToken = pages[calls-1].NextToken
+	}
+	assert.Equal(o.t, expected, input)
+	o.tagCalls[accountID]++
+	return pages[calls], nil
+}
+
+func TestParseTagFilter(t *testing.T) {
+	testData := []struct {
+		description string
+		filter      string
+		expected    *TagFilter
+		error       string
+	}{
+		{description: "empty filter"},
+		{description: "key and value",
+			filter:   " security-managed = true ",
+			expected: &TagFilter{Key: "security-managed", Value: "true"}},
+		{description: "empty value",
+			filter:   "security-managed=",
+			expected: &TagFilter{Key: "security-managed"}},
+		{description: "value with equals sign",
+			filter:   "owner=team=security",
+			expected: &TagFilter{Key: "owner", Value: "team=security"}},
+		{description: "no separator",
+			filter: "security-managed",
+			error:  `"security-managed" is not in key=value format`},
+		{description: "no key",
+			filter: "=true",
+			error:  `"=true" is not in key=value format`},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			filter, err := ParseTagFilter(x.filter)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.expected, filter, "Test case %d check failed", i)
+		})
+	}
+}
+
+func TestTagFilter_Matches(t *testing.T) {
+	filter := TagFilter{Key: "security-managed", Value: "true"}
+	testData := []struct {
+		description string
+		tags        []*organizations.Tag
+		matches     bool
+	}{
+		{description: "no tags"},
+		{description: "matching tag among others",
+			tags: []*organizations.Tag{
+				{Key: aws.String("team"), Value: aws.String("security")},
+				{Key: aws.String("security-managed"), Value: aws.String("true")},
+			},
+			matches: true},
+		{description: "tag with other value",
+			tags: []*organizations.Tag{{Key: aws.String("security-managed"), Value: aws.String("True")}}},
+		{description: "value in other tag",
+			tags: []*organizations.Tag{{Key: aws.String("managed"), Value: aws.String("true")}}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			assert.Equal(t, x.matches, filter.matches(x.tags), "Test case %d check failed", i)
+		})
+	}
+}
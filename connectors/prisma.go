@@ -16,121 +16,747 @@ package connectors
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/paskal/go-prisma"
+	"github.com/hashicorp/go-multierror"
 	log "github.com/sirupsen/logrus"
 )
 
 // Prisma contain credentials for API access
 type Prisma struct {
 	api apiCaller
+	log *log.Entry
+	// awsAccountBody builds AWS account creation request body in the schema of used API version
+	awsAccountBody func(acc awsAccountInfo) ([]byte, error)
+	// updatePolicy defines if existing AWS accounts are updated
+	updatePolicy PrismaUpdatePolicy
 }
 
+// PrismaUpdatePolicy defines if existing AWS account is updated when it's added to Prisma again.
+type PrismaUpdatePolicy int
+
+const (
+	// PrismaUpdateChanged updates existing account only in case it differs from desired one
+	PrismaUpdateChanged PrismaUpdatePolicy = iota
+	// PrismaUpdateNever leaves existing account as is
+	PrismaUpdateNever
+	// PrismaUpdateAlways updates existing account even in case it's equal to desired one
+	PrismaUpdateAlways
+)
+
 type apiCaller interface {
 	Call(method, url string, body io.Reader) ([]byte, error)
 }
 
 type prismaCloudAccount struct {
 	AccountID string `json:"accountId"`
+	Name      string `json:"name"`
+	CloudType string `json:"cloudType"`
 }
 
 type awsAccountInfo struct {
-	Name       string `json:"name"`
-	Enabled    bool   `json:"enabled"`
-	ExternalID string `json:"externalId"`
-	RoleArn    string `json:"roleArn"`
-	AccountID  string `json:"accountId"`
-}
-
-// NewPrisma returns new Prisma client
-func NewPrisma(username, password, apiURL string) *Prisma {
-	log.Infof("Creating Prisma connection using API key %s", username)
-	p := Prisma{}
-	p.api = prisma.NewClient(username, password, apiURL)
+	Name       string   `json:"name"`
+	Enabled    bool     `json:"enabled"`
+	ExternalID string   `json:"externalId"`
+	RoleArn    string   `json:"roleArn"`
+	AccountID  string   `json:"accountId"`
+	GroupIDs   []string `json:"groupIds"`
+	// ProtectionMode is either MONITOR or MONITOR_AND_PROTECT, Prisma default is used on creation if empty
+	ProtectionMode string `json:"protectionMode,omitempty"`
+}
+
+// Prisma API versions which differ in AWS account creation request schema
+const (
+	// PrismaAPIVersionLegacy expects flat account details
+	PrismaAPIVersionLegacy = "legacy"
+	// PrismaAPIVersionV2 expects cloud type and account details nested in cloudAccount
+	PrismaAPIVersionV2 = "v2"
+)
+
+// awsCloudAccountRequest is AWS account creation request body in PrismaAPIVersionV2 schema
+type awsCloudAccountRequest struct {
+	CloudAccount awsCloudAccount `json:"cloudAccount"`
+	CloudType    string          `json:"cloudType"`
+	ExternalID   string          `json:"externalId"`
+	RoleArn      string          `json:"roleArn"`
+}
+
+type awsCloudAccount struct {
+	AccountID      string   `json:"accountId"`
+	AccountType    string   `json:"accountType"`
+	Enabled        bool     `json:"enabled"`
+	GroupIDs       []string `json:"groupIds"`
+	Name           string   `json:"name"`
+	ProtectionMode string   `json:"protectionMode,omitempty"`
+}
+
+// awsAccountBodyBuilders returns AWS account creation request body builders by Prisma API version
+func awsAccountBodyBuilders() map[string]func(acc awsAccountInfo) ([]byte, error) {
+	return map[string]func(acc awsAccountInfo) ([]byte, error){
+		PrismaAPIVersionLegacy: legacyAWSAccountBody,
+		PrismaAPIVersionV2:     v2AWSAccountBody,
+	}
+}
+
+// legacyAWSAccountBody returns AWS account creation request body in PrismaAPIVersionLegacy schema
+func legacyAWSAccountBody(acc awsAccountInfo) ([]byte, error) {
+	return json.Marshal(acc)
+}
+
+// v2AWSAccountBody returns AWS account creation request body in PrismaAPIVersionV2 schema
+func v2AWSAccountBody(acc awsAccountInfo) ([]byte, error) {
+	return json.Marshal(awsCloudAccountRequest{
+		CloudAccount: awsCloudAccount{
+			AccountID:      acc.AccountID,
+			AccountType:    "account",
+			Enabled:        acc.Enabled,
+			GroupIDs:       acc.GroupIDs,
+			Name:           acc.Name,
+			ProtectionMode: acc.ProtectionMode,
+		},
+		CloudType:  "aws",
+		ExternalID: acc.ExternalID,
+		RoleArn:    acc.RoleArn,
+	})
+}
+
+// PrismaDefaultAPIURL is used in case neither API URL nor region is provided
+const PrismaDefaultAPIURL = "https://api.eu.prismacloud.io"
+
+// prismaAPIURLs returns mapping of Prisma regions, as seen in app URL (app.<region>.prismacloud.io), to API URLs
+func prismaAPIURLs() map[string]string {
+	return map[string]string{
+		"us":   "https://api.prismacloud.io",
+		"app2": "https://api2.prismacloud.io",
+		"app3": "https://api3.prismacloud.io",
+		"app4": "https://api4.prismacloud.io",
+		"eu":   "https://api.eu.prismacloud.io",
+		"eu2":  "https://api2.eu.prismacloud.io",
+		"anz":  "https://api.anz.prismacloud.io",
+		"ca":   "https://api.ca.prismacloud.io",
+		"fr":   "https://api.fr.prismacloud.io",
+		"gov":  "https://api.gov.prismacloud.io",
+		"ind":  "https://api.ind.prismacloud.io",
+		"jp":   "https://api.jp.prismacloud.io",
+		"sg":   "https://api.sg.prismacloud.io",
+		"uk":   "https://api.uk.prismacloud.io",
+	}
+}
+
+// PrismaAPIURL returns API URL of provided Prisma region, returning error in case region is unknown
+func PrismaAPIURL(region string) (string, error) {
+	apiURLs := prismaAPIURLs()
+	apiURL, ok := apiURLs[region]
+	if !ok {
+		regions := make([]string, 0, len(apiURLs))
+		for r := range apiURLs {
+			regions = append(regions, r)
+		}
+		sort.Strings(regions)
+		return "", fmt.Errorf("unknown Prisma region %q, should be one of: %s", region, strings.Join(regions, ", "))
+	}
+	return apiURL, nil
+}
+
+// Statuses of AWS account in Prisma, returned by GetAWSAccountStatus
+const (
+	PrismaAccountStatusOK      = "ok"
+	PrismaAccountStatusWarning = "warning"
+	PrismaAccountStatusError   = "error"
+)
+
+// prismaAccountStatus is a status of single component of AWS account, like its role or flow logs ingestion
+type prismaAccountStatus struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// AWSAccountConfig contains toggles of data ingestion features of AWS account in Prisma
+type AWSAccountConfig struct {
+	FlowLogs bool `json:"flowLogsEnabled"`
+	S3Config bool `json:"s3ConfigEnabled"`
+}
+
+type azureAccountInfo struct {
+	CloudAccount       cloudAccountInfo `json:"cloudAccount"`
+	ClientID           string           `json:"clientId"`
+	Key                string           `json:"key"`
+	MonitorFlowLogs    bool             `json:"monitorFlowLogs"`
+	TenantID           string           `json:"tenantId"`
+	ServicePrincipalID string           `json:"servicePrincipalId"`
+}
+
+type gcpAccountInfo struct {
+	CloudAccount           cloudAccountInfo `json:"cloudAccount"`
+	CompressionEnabled     bool             `json:"compressionEnabled"`
+	DataflowEnabledProject string           `json:"dataflowEnabledProject,omitempty"`
+	FlowLogStorageBucket   string           `json:"flowLogStorageBucket,omitempty"`
+	Credentials            json.RawMessage  `json:"credentials,omitempty"`
+}
+
+type cloudAccountInfo struct {
+	AccountID   string `json:"accountId"`
+	Enabled     bool   `json:"enabled"`
+	Name        string `json:"name"`
+	AccountType string `json:"accountType"`
+}
+
+// NewPrisma returns new Prisma client, which retries requests throttled by API up to maxRetries times
+// and fails requests taking longer than timeout. Requests are sent through provided proxy,
+// or through the one set in environment in case it's nil. Non-empty userAgent is sent in User-Agent header.
+func NewPrisma(username, password, apiURL, userAgent string, maxRetries int, timeout time.Duration, proxy *url.URL) *Prisma {
+	return NewPrismaWithContext(context.Background(), username, password, apiURL, userAgent, maxRetries, timeout, proxy)
+}
+
+// NewPrismaWithContext returns new Prisma client same as NewPrisma does, all requests of which,
+// including login ones, are cancelled once provided context is done.
+func NewPrismaWithContext(ctx context.Context, username, password, apiURL, userAgent string, maxRetries int,
+	timeout time.Duration, proxy *url.URL) *Prisma {
+	p := Prisma{log: log.NewEntry(log.StandardLogger()), awsAccountBody: legacyAWSAccountBody}
+	p.api = newPrismaRetryingCaller(ctx, newPrismaClient(ctx, username, password, apiURL, userAgent, timeout, proxy), maxRetries)
 	return &p
 }
 
-// AddAWSAccount adds an AWS account to Prisma, or updates existing one
-// with provided AWS credentials in case it's necessary
-func (p Prisma) AddAWSAccount(accountID, name, externalID, roleName string) error {
-	exists, err := p.ifAWSAccountExists(accountID)
+// SetLogger makes Prisma client use provided logger for all messages instead of the standard one.
+func (p *Prisma) SetLogger(logger *log.Entry) {
+	p.log = logger
+	if l, ok := p.api.(loggerSetter); ok {
+		l.setLogger(logger)
+	}
+}
+
+// SetAPIVersion makes Prisma client create AWS accounts using request schema of provided API version,
+// PrismaAPIVersionLegacy is used by default.
+func (p *Prisma) SetAPIVersion(version string) error {
+	builder, ok := awsAccountBodyBuilders()[version]
+	if !ok {
+		return fmt.Errorf("unknown Prisma API version %q", version)
+	}
+	p.awsAccountBody = builder
+	return nil
+}
+
+// SetUpdatePolicy defines if AWS accounts which exist in Prisma already are updated by AddAWSAccount
+// and AddAWSAccounts, PrismaUpdateChanged is used by default.
+func (p *Prisma) SetUpdatePolicy(policy PrismaUpdatePolicy) {
+	p.updatePolicy = policy
+}
+
+// AddAWSAccount adds an AWS account from provided partition to Prisma, or updates existing one
+// with provided AWS credentials, account groups and protection mode in case it's necessary.
+// Existing account is left as is or updated regardless of the difference depending on update policy.
+// Existing account protection mode is kept in case provided one is empty.
+func (p Prisma) AddAWSAccount(accountID, partition, name, externalID, roleName string, groupIDs []string,
+	protectionMode string) error {
+	exists, err := p.ifCloudAccountExists(accountID)
 	if err != nil {
 		return fmt.Errorf("error checking for existing account: %w", err)
 	}
 
-	newAcc := awsAccountInfo{
-		Name:       name,
-		Enabled:    true,
-		ExternalID: externalID,
-		RoleArn:    buildRoleARN(accountID, roleName),
-		AccountID:  accountID,
+	return p.addAWSAccount(AWSAccountSpec{
+		AccountID:      accountID,
+		Partition:      partition,
+		Name:           name,
+		ExternalID:     externalID,
+		RoleName:       roleName,
+		GroupIDs:       groupIDs,
+		ProtectionMode: protectionMode,
+	}, exists)
+}
+
+// AWSAccountSpec describes an AWS account to add to Prisma, with the same meaning of fields
+// as AddAWSAccount arguments.
+type AWSAccountSpec struct {
+	AccountID      string
+	Partition      string
+	Name           string
+	ExternalID     string
+	RoleName       string
+	GroupIDs       []string
+	ProtectionMode string
+}
+
+// AddAWSAccounts adds provided AWS accounts to Prisma or updates existing ones, same as AddAWSAccount does,
+// listing Prisma accounts only once. Problem with one account doesn't stop others from being added,
+// all errors are returned as *multierror.Error.
+func (p Prisma) AddAWSAccounts(accounts []AWSAccountSpec) error {
+	existing, err := p.listCloudAccounts()
+	if err != nil {
+		return fmt.Errorf("error checking for existing accounts: %w", err)
+	}
+
+	var result *multierror.Error
+	for _, acc := range accounts {
+		accPrisma := p
+		accPrisma.log = p.log.WithField("account_id", acc.AccountID)
+		if err := accPrisma.addAWSAccount(acc, existing[acc.AccountID]); err != nil {
+			result = multierror.Append(result, fmt.Errorf("problem adding account %s: %w", acc.AccountID, err))
+		}
+	}
+	return result.ErrorOrNil()
+}
+
+// accountInfo returns desired Prisma account details for the spec
+func (s AWSAccountSpec) accountInfo() awsAccountInfo {
+	return awsAccountInfo{
+		Name:           s.Name,
+		Enabled:        true,
+		ExternalID:     s.ExternalID,
+		RoleArn:        buildRoleARN(s.Partition, s.AccountID, s.RoleName),
+		AccountID:      s.AccountID,
+		GroupIDs:       s.GroupIDs,
+		ProtectionMode: s.ProtectionMode,
+	}
+}
+
+// CheckAWSAccount compares existing AWS account in Prisma with the settings AddAWSAccount would set
+// for provided spec, and returns JSON names of the fields which differ, without changing anything.
+// ErrPrismaAccountMissing is returned in case the account doesn't exist in Prisma.
+func (p Prisma) CheckAWSAccount(spec AWSAccountSpec) ([]string, error) {
+	exists, err := p.ifCloudAccountExists(spec.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("error checking for existing account: %w", err)
+	}
+	if !exists {
+		return nil, ErrPrismaAccountMissing
+	}
+
+	_, oldAcc, err := p.getAWSAccount(spec.AccountID)
+	if err != nil {
+		return nil, err
 	}
+	acc, oldAcc := normalizeAWSAccounts(spec.accountInfo(), oldAcc)
+	return diffAWSAccount(oldAcc, acc), nil
+}
+
+// addAWSAccount updates provided account in case it exists in Prisma, or creates it otherwise
+func (p Prisma) addAWSAccount(spec AWSAccountSpec, exists bool) error {
+	newAcc := spec.accountInfo()
 
 	if exists {
-		log.Print("Account already exists in Prisma")
+		if p.updatePolicy == PrismaUpdateNever {
+			p.log.Info("Account already exists in Prisma, leaving it as is")
+			return nil
+		}
+		p.log.Info("Account already exists in Prisma")
 		if err := p.updateExistingAWSAccount(newAcc); err != nil {
 			return fmt.Errorf("error updating existing account: %w", err)
 		}
 		return nil
 	}
 
-	err = p.createNewAWSAccount(newAcc)
-	if err != nil {
+	if err := p.createNewAWSAccount(newAcc); err != nil {
 		return fmt.Errorf("error creating new account: %w", err)
 	}
 
 	return nil
 }
 
-// ifAWSAccountExists returns if AWS account is already exist in Prisma,
+// UpdateAWSAccountConfig sets data ingestion features of existing AWS account in Prisma
+func (p Prisma) UpdateAWSAccountConfig(accountID string, cfg AWSAccountConfig) error {
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("error marshaling account config: %w", err)
+	}
+
+	_, err = p.api.Call("PUT", "/cloud/aws/"+accountID+"/config", bytes.NewBuffer(b))
+	if err != nil {
+		return fmt.Errorf("error sending API request: %w", err)
+	}
+
+	p.log.Info("Prisma account config updated")
+	return nil
+}
+
+// GetAWSAccountStatus returns overall status of existing AWS account in Prisma, which is the worst status
+// of its components: "ok" in case account is connected and data is ingested, "warning" or "error" otherwise
+func (p Prisma) GetAWSAccountStatus(accountID string) (string, error) {
+	rawStatus, err := p.api.Call("GET", "/account/"+accountID+"/config/status", nil)
+	if err != nil {
+		return "", fmt.Errorf("error retrieving account status: %w", err)
+	}
+
+	var components []prismaAccountStatus
+	if err := json.Unmarshal(rawStatus, &components); err != nil {
+		return "", fmt.Errorf("error unmarshalling account status: %w", err)
+	}
+	if len(components) == 0 {
+		return "", fmt.Errorf("no status returned for account %s", accountID)
+	}
+
+	status := PrismaAccountStatusOK
+	for _, c := range components {
+		if c.Status == PrismaAccountStatusOK {
+			continue
+		}
+		p.log.Debugf("Prisma account component %s status is %s: %s", c.Name, c.Status, c.Message)
+		if status != PrismaAccountStatusError {
+			status = c.Status
+		}
+	}
+	return status, nil
+}
+
+// DeleteAWSAccount removes an AWS account from Prisma, doing nothing
+// in case it's not present there
+func (p Prisma) DeleteAWSAccount(accountID string) error {
+	exists, err := p.ifCloudAccountExists(accountID)
+	if err != nil {
+		return fmt.Errorf("error checking for existing account: %w", err)
+	}
+
+	if !exists {
+		p.log.Info("Account doesn't exist in Prisma, doing nothing")
+		return nil
+	}
+
+	return p.deleteAWSAccount(accountID)
+}
+
+// DeleteAWSAccountByName removes an AWS account with provided name from Prisma, doing nothing
+// in case it's not present there. *PrismaAmbiguousAccountNameError is returned in case several
+// AWS accounts have this name, none of them is deleted then.
+func (p Prisma) DeleteAWSAccountByName(name string) error {
+	accountID, err := p.findAWSAccountByName(name)
+	if err != nil {
+		return fmt.Errorf("error looking for account by name: %w", err)
+	}
+
+	if accountID == "" {
+		p.log.Infof("Account with name %q doesn't exist in Prisma, doing nothing", name)
+		return nil
+	}
+
+	return p.deleteAWSAccount(accountID)
+}
+
+func (p Prisma) deleteAWSAccount(accountID string) error {
+	// https://api.docs.prismacloud.io/reference#delete-cloud-account
+	_, err := p.api.Call("DELETE", "/cloud/aws/"+accountID, nil)
+	if err != nil {
+		return fmt.Errorf("error sending API request: %w", err)
+	}
+
+	p.log.Infof("Prisma account %s deleted", accountID)
+	return nil
+}
+
+// findAWSAccountByName returns ID of the only AWS account with provided name present in Prisma,
+// or empty string in case there is none
+func (p Prisma) findAWSAccountByName(name string) (string, error) {
+	accounts, err := p.getCloudAccounts()
+	if err != nil {
+		return "", err
+	}
+
+	var ids []string
+	for _, acc := range accounts {
+		if acc.CloudType == "aws" && acc.Name == name {
+			ids = append(ids, acc.AccountID)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", nil
+	case 1:
+		return ids[0], nil
+	default:
+		sort.Strings(ids)
+		return "", &PrismaAmbiguousAccountNameError{Name: name, AccountIDs: ids}
+	}
+}
+
+// Preflight verifies Prisma API credentials and permissions by listing cloud accounts, nothing is changed.
+func (p Prisma) Preflight() error {
+	// https://api.docs.prismacloud.io/reference#get-cloud-accounts
+	if _, err := p.api.Call("GET", "/cloud", nil); err != nil {
+		return fmt.Errorf("error retrieving list of accounts: %w", err)
+	}
+	return nil
+}
+
+// ifCloudAccountExists returns if cloud account (of any cloud type) is already exist in Prisma,
 // false in other case
-func (p Prisma) ifAWSAccountExists(accountID string) (bool, error) {
+func (p Prisma) ifCloudAccountExists(accountID string) (bool, error) {
+	existing, err := p.listCloudAccounts()
+	if err != nil {
+		return false, err
+	}
+	return existing[accountID], nil
+}
+
+// listCloudAccounts returns IDs of all cloud accounts (of any cloud type) present in Prisma
+func (p Prisma) listCloudAccounts() (map[string]bool, error) {
+	accounts, err := p.getCloudAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make(map[string]bool, len(accounts))
+	for _, acc := range accounts {
+		existing[acc.AccountID] = true
+	}
+	return existing, nil
+}
+
+// getCloudAccounts returns all cloud accounts (of any cloud type) present in Prisma
+func (p Prisma) getCloudAccounts() ([]prismaCloudAccount, error) {
 	// https://api.docs.prismacloud.io/reference#get-cloud-accounts
 	rawAccounts, err := p.api.Call("GET", "/cloud", nil)
 	if err != nil {
-		return false, fmt.Errorf("error retrieving list of accounts: %w", err)
+		return nil, fmt.Errorf("error retrieving list of accounts: %w", err)
 	}
 
 	var accounts []prismaCloudAccount
 	if err := json.Unmarshal(rawAccounts, &accounts); err != nil {
-		return false, fmt.Errorf("error unmarshalling accounts information: %w", err)
+		return nil, fmt.Errorf("error unmarshalling accounts information: %w", err)
 	}
+	return accounts, nil
+}
 
-	for _, acc := range accounts {
-		if acc.AccountID == accountID {
-			return true, nil
+// updateExistingAWSAccount checks provided account against given one and updates it if necessary,
+// or always in case of PrismaUpdateAlways policy. Empty name is ignored.
+func (p Prisma) updateExistingAWSAccount(acc awsAccountInfo) error {
+	rawAccountInfo, oldAcc, err := p.getAWSAccount(acc.AccountID)
+	if err != nil {
+		return err
+	}
+
+	acc, oldAcc = normalizeAWSAccounts(acc, oldAcc)
+	if diff := diffAWSAccount(oldAcc, acc); len(diff) > 0 || p.updatePolicy == PrismaUpdateAlways {
+		p.log.Debugf("Existing Prisma account details: %+v", oldAcc)
+		p.log.Debugf("Desired Prisma account details: %+v", acc)
+		if len(diff) > 0 {
+			p.log.Infof("Prisma account fields differ from desired: %s", strings.Join(diff, ", "))
+		} else {
+			p.log.Info("Prisma account is up to date, updating it anyway as forced")
+		}
+
+		// fields which are not modeled by awsAccountInfo are sent back as is, so that they are not reset
+		b, err := overlayJSON(rawAccountInfo, acc)
+		if err != nil {
+			return fmt.Errorf("error marshaling account info: %w", err)
+		}
+
+		// https://api.docs.prismacloud.io/reference#update-cloud-account
+		_, err = p.api.Call("PUT", "/cloud/aws/"+acc.AccountID, bytes.NewBuffer(b))
+		if err != nil {
+			return fmt.Errorf("error sending API request: %w", err)
 		}
+
+		p.log.Info("Prisma account information updated")
+		return nil
+	}
+
+	p.log.Info("Prisma account already up to date, doing nothing")
+	return nil
+}
+
+// getAWSAccount returns raw and parsed details of existing AWS account in Prisma
+func (p Prisma) getAWSAccount(accountID string) ([]byte, awsAccountInfo, error) {
+	// https://api.docs.prismacloud.io/reference#get-cloud-account
+	rawAccountInfo, err := p.api.Call("GET", "/cloud/aws/"+accountID, nil)
+	if err != nil {
+		return nil, awsAccountInfo{}, fmt.Errorf("error retrieving existing account details: %w", err)
+	}
+
+	var acc awsAccountInfo
+	if err := json.Unmarshal(rawAccountInfo, &acc); err != nil {
+		return nil, awsAccountInfo{}, fmt.Errorf("error unmarshalling account details: %w", err)
+	}
+	return rawAccountInfo, acc, nil
+}
+
+// normalizeAWSAccounts fills fields of desired account which are not provided from the existing one,
+// and brings values of both accounts to the form in which they are compared
+func normalizeAWSAccounts(acc, oldAcc awsAccountInfo) (awsAccountInfo, awsAccountInfo) {
+	// Names are unique and should not be empty.
+	// In case we don't have new account name provided by user, take old one instead of updating it.
+	if acc.Name == "" {
+		acc.Name = oldAcc.Name
+	}
+
+	// In case we don't have account groups provided by user, keep old ones.
+	// Order of groups doesn't matter, so they are sorted before the comparison.
+	if len(acc.GroupIDs) == 0 {
+		acc.GroupIDs = oldAcc.GroupIDs
+	}
+	acc.GroupIDs = sortedCopy(acc.GroupIDs)
+	oldAcc.GroupIDs = sortedCopy(oldAcc.GroupIDs)
+
+	if acc.ProtectionMode == "" {
+		acc.ProtectionMode = oldAcc.ProtectionMode
+	}
+
+	// Values are compared case-sensitively, but surrounding whitespace doesn't matter.
+	// Field names of the response are matched case-insensitively by json.Unmarshal already.
+	acc.RoleArn, oldAcc.RoleArn = strings.TrimSpace(acc.RoleArn), strings.TrimSpace(oldAcc.RoleArn)
+	acc.ExternalID, oldAcc.ExternalID = strings.TrimSpace(acc.ExternalID), strings.TrimSpace(oldAcc.ExternalID)
+
+	return acc, oldAcc
+}
+
+// diffAWSAccount returns JSON names of the fields which differ between existing and desired account,
+// in the order of awsAccountInfo fields, or nil in case accounts are equal
+func diffAWSAccount(oldAcc, acc awsAccountInfo) []string {
+	var diff []string
+	if oldAcc.Name != acc.Name {
+		diff = append(diff, "name")
+	}
+	if oldAcc.Enabled != acc.Enabled {
+		diff = append(diff, "enabled")
+	}
+	if oldAcc.ExternalID != acc.ExternalID {
+		diff = append(diff, "externalId")
+	}
+	if oldAcc.RoleArn != acc.RoleArn {
+		diff = append(diff, "roleArn")
+	}
+	if oldAcc.AccountID != acc.AccountID {
+		diff = append(diff, "accountId")
+	}
+	if !reflect.DeepEqual(oldAcc.GroupIDs, acc.GroupIDs) {
+		diff = append(diff, "groupIds")
+	}
+	if oldAcc.ProtectionMode != acc.ProtectionMode {
+		diff = append(diff, "protectionMode")
+	}
+	return diff
+}
+
+// overlayJSON returns raw JSON object with fields of provided value set on top of it.
+// Field names are matched case-insensitively, the same way json.Unmarshal does.
+func overlayJSON(raw []byte, v interface{}) ([]byte, error) {
+	var merged map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		return nil, err
+	}
+	if merged == nil {
+		merged = map[string]json.RawMessage{}
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
 	}
 
-	return false, nil
+	for name, value := range fields {
+		for existing := range merged {
+			if strings.EqualFold(existing, name) {
+				delete(merged, existing)
+			}
+		}
+		merged[name] = value
+	}
+	return json.Marshal(merged)
+}
+
+// createNewAWSAccount creates new cloud account in Prisma.
+// Empty name replaced with accountID.
+func (p Prisma) createNewAWSAccount(acc awsAccountInfo) error {
+	p.log.Debugf("New Prisma account details %+v", acc)
+
+	if acc.Name == "" {
+		acc.Name = acc.AccountID
+	}
+
+	b, err := p.awsAccountBody(acc)
+	if err != nil {
+		return fmt.Errorf("error marshaling account info: %w", err)
+	}
+
+	// https://api.docs.prismacloud.io/reference#add-cloud-account
+	_, err = p.api.Call("POST", "/cloud/aws/", bytes.NewBuffer(b))
+	if isPrismaNameConflict(err) {
+		return &PrismaAccountNameConflictError{Name: acc.Name, AccountID: acc.AccountID, Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("error sending API request: %w", err)
+	}
+
+	p.log.Info("Prisma account created")
+	return nil
 }
 
-// updateExistingAWSAccount checks provided account against given one and updates it if necessary.
+// AddAzureAccount adds an Azure subscription to Prisma, or updates existing one
+// with provided Azure credentials in case it's necessary
+func (p Prisma) AddAzureAccount(subscriptionID, name, tenantID, clientID, key, servicePrincipalID string, monitorFlowLogs bool) error {
+	exists, err := p.ifCloudAccountExists(subscriptionID)
+	if err != nil {
+		return fmt.Errorf("error checking for existing account: %w", err)
+	}
+
+	newAcc := azureAccountInfo{
+		CloudAccount: cloudAccountInfo{
+			AccountID:   subscriptionID,
+			Enabled:     true,
+			Name:        name,
+			AccountType: "account",
+		},
+		ClientID:           clientID,
+		Key:                key,
+		MonitorFlowLogs:    monitorFlowLogs,
+		TenantID:           tenantID,
+		ServicePrincipalID: servicePrincipalID,
+	}
+
+	if exists {
+		p.log.Info("Azure account already exists in Prisma")
+		if err := p.updateExistingAzureAccount(newAcc); err != nil {
+			return fmt.Errorf("error updating existing account: %w", err)
+		}
+		return nil
+	}
+
+	err = p.createNewAzureAccount(newAcc)
+	if err != nil {
+		return fmt.Errorf("error creating new account: %w", err)
+	}
+
+	return nil
+}
+
+// updateExistingAzureAccount checks provided account against given one and updates it if necessary.
 // Empty name is ignored.
-func (p Prisma) updateExistingAWSAccount(acc awsAccountInfo) error {
+func (p Prisma) updateExistingAzureAccount(acc azureAccountInfo) error {
 	// https://api.docs.prismacloud.io/reference#get-cloud-account
-	rawAccountInfo, err := p.api.Call("GET", "/cloud/aws/"+acc.AccountID, nil)
+	rawAccountInfo, err := p.api.Call("GET", "/cloud/azure/"+acc.CloudAccount.AccountID, nil)
 	if err != nil {
 		return fmt.Errorf("error retrieving existing account details: %w", err)
 	}
 
-	var oldAcc awsAccountInfo
+	var oldAcc azureAccountInfo
 	if err := json.Unmarshal(rawAccountInfo, &oldAcc); err != nil {
 		return fmt.Errorf("error unmarshalling account details: %w", err)
 	}
 
 	// Names are unique and should not be empty.
 	// In case we don't have new account name provided by user, take old one instead of updating it.
-	if acc.Name == "" {
-		acc.Name = oldAcc.Name
+	if acc.CloudAccount.Name == "" {
+		acc.CloudAccount.Name = oldAcc.CloudAccount.Name
 	}
 
-	if oldAcc != acc {
-		log.Debugf("Existing Prisma account details: %+v", oldAcc)
-		log.Debugf("Desired Prisma account details: %+v", acc)
+	// Application key is a secret which is not returned by API, so it's neither compared nor logged.
+	desiredAcc := acc
+	desiredAcc.Key = ""
+	oldAcc.Key = ""
+
+	if oldAcc != desiredAcc {
+		p.log.Debugf("Existing Prisma Azure account details: %+v", oldAcc)
+		p.log.Debugf("Desired Prisma Azure account details: %+v", desiredAcc)
 
 		b, err := json.Marshal(acc)
 		if err != nil {
@@ -138,26 +764,131 @@ func (p Prisma) updateExistingAWSAccount(acc awsAccountInfo) error {
 		}
 
 		// https://api.docs.prismacloud.io/reference#update-cloud-account
-		_, err = p.api.Call("PUT", "/cloud/aws/"+acc.AccountID, bytes.NewBuffer(b))
+		_, err = p.api.Call("PUT", "/cloud/azure/"+acc.CloudAccount.AccountID, bytes.NewBuffer(b))
 		if err != nil {
 			return fmt.Errorf("error sending API request: %w", err)
 		}
 
-		log.Info("Prisma account information updated")
+		p.log.Info("Prisma Azure account information updated")
 		return nil
 	}
 
-	log.Info("Prisma account already up to date, doing nothing")
+	p.log.Info("Prisma Azure account already up to date, doing nothing")
 	return nil
 }
 
-// createNewAWSAccount creates new cloud account in Prisma.
-// Empty name replaced with accountID.
-func (p Prisma) createNewAWSAccount(acc awsAccountInfo) error {
-	log.Debugf("New Prisma account details %+v", acc)
+// createNewAzureAccount creates new Azure cloud account in Prisma.
+// Empty name replaced with subscription ID.
+func (p Prisma) createNewAzureAccount(acc azureAccountInfo) error {
+	if acc.CloudAccount.Name == "" {
+		acc.CloudAccount.Name = acc.CloudAccount.AccountID
+	}
 
-	if acc.Name == "" {
-		acc.Name = acc.AccountID
+	b, err := json.Marshal(acc)
+	if err != nil {
+		return fmt.Errorf("error marshaling account info: %w", err)
+	}
+
+	// https://api.docs.prismacloud.io/reference#add-cloud-account
+	_, err = p.api.Call("POST", "/cloud/azure", bytes.NewBuffer(b))
+	if err != nil {
+		return fmt.Errorf("error sending API request: %w", err)
+	}
+
+	p.log.Info("Prisma Azure account created")
+	return nil
+}
+
+// AddGCPAccount adds a GCP project to Prisma, or updates existing one
+// with provided GCP service account credentials in case it's necessary
+func (p Prisma) AddGCPAccount(projectID, name string, credentials []byte, compressionEnabled bool, dataflowProject, flowLogBucket string) error {
+	exists, err := p.ifCloudAccountExists(projectID)
+	if err != nil {
+		return fmt.Errorf("error checking for existing account: %w", err)
+	}
+
+	newAcc := gcpAccountInfo{
+		CloudAccount: cloudAccountInfo{
+			AccountID:   projectID,
+			Enabled:     true,
+			Name:        name,
+			AccountType: "account",
+		},
+		CompressionEnabled:     compressionEnabled,
+		DataflowEnabledProject: dataflowProject,
+		FlowLogStorageBucket:   flowLogBucket,
+		Credentials:            credentials,
+	}
+
+	if exists {
+		p.log.Info("GCP account already exists in Prisma")
+		if err := p.updateExistingGCPAccount(newAcc); err != nil {
+			return fmt.Errorf("error updating existing account: %w", err)
+		}
+		return nil
+	}
+
+	err = p.createNewGCPAccount(newAcc)
+	if err != nil {
+		return fmt.Errorf("error creating new account: %w", err)
+	}
+
+	return nil
+}
+
+// updateExistingGCPAccount checks provided account against given one and updates it if necessary.
+// Empty name is ignored.
+func (p Prisma) updateExistingGCPAccount(acc gcpAccountInfo) error {
+	// https://api.docs.prismacloud.io/reference#get-cloud-account
+	rawAccountInfo, err := p.api.Call("GET", "/cloud/gcp/"+acc.CloudAccount.AccountID, nil)
+	if err != nil {
+		return fmt.Errorf("error retrieving existing account details: %w", err)
+	}
+
+	var oldAcc gcpAccountInfo
+	if err := json.Unmarshal(rawAccountInfo, &oldAcc); err != nil {
+		return fmt.Errorf("error unmarshalling account details: %w", err)
+	}
+
+	// Names are unique and should not be empty.
+	// In case we don't have new account name provided by user, take old one instead of updating it.
+	if acc.CloudAccount.Name == "" {
+		acc.CloudAccount.Name = oldAcc.CloudAccount.Name
+	}
+
+	// Service account credentials are secret and not returned by API, so they are neither compared nor logged.
+	desiredAcc := acc
+	desiredAcc.Credentials = nil
+	oldAcc.Credentials = nil
+
+	if !reflect.DeepEqual(oldAcc, desiredAcc) {
+		p.log.Debugf("Existing Prisma GCP account details: %+v", oldAcc)
+		p.log.Debugf("Desired Prisma GCP account details: %+v", desiredAcc)
+
+		b, err := json.Marshal(acc)
+		if err != nil {
+			return fmt.Errorf("error marshaling account info: %w", err)
+		}
+
+		// https://api.docs.prismacloud.io/reference#update-cloud-account
+		_, err = p.api.Call("PUT", "/cloud/gcp/"+acc.CloudAccount.AccountID, bytes.NewBuffer(b))
+		if err != nil {
+			return fmt.Errorf("error sending API request: %w", err)
+		}
+
+		p.log.Info("Prisma GCP account information updated")
+		return nil
+	}
+
+	p.log.Info("Prisma GCP account already up to date, doing nothing")
+	return nil
+}
+
+// createNewGCPAccount creates new GCP cloud account in Prisma.
+// Empty name replaced with project ID.
+func (p Prisma) createNewGCPAccount(acc gcpAccountInfo) error {
+	if acc.CloudAccount.Name == "" {
+		acc.CloudAccount.Name = acc.CloudAccount.AccountID
 	}
 
 	b, err := json.Marshal(acc)
@@ -166,11 +897,11 @@ func (p Prisma) createNewAWSAccount(acc awsAccountInfo) error {
 	}
 
 	// https://api.docs.prismacloud.io/reference#add-cloud-account
-	_, err = p.api.Call("POST", "/cloud/aws/", bytes.NewBuffer(b))
+	_, err = p.api.Call("POST", "/cloud/gcp", bytes.NewBuffer(b))
 	if err != nil {
 		return fmt.Errorf("error sending API request: %w", err)
 	}
 
-	log.Info("Prisma account created")
+	p.log.Info("Prisma GCP account created")
 	return nil
 }
@@ -0,0 +1,274 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Prisma token is valid for 10 minutes after it's issued
+	prismaTokenLifetime = 10 * time.Minute
+	// token is renewed that long before it expires
+	prismaTokenRenewMargin = time.Minute
+	// wait time before retrying throttled request in case API didn't specify it
+	prismaDefaultRetryAfter = time.Second
+)
+
+// prismaClient implements apiCaller for Prisma API. It authenticates once and reuses
+// the token until it nears expiry, and re-authenticates in case the token is rejected by API.
+type prismaClient struct {
+	// ctx cancels all requests, including login ones
+	ctx        context.Context
+	username   string
+	password   string
+	apiURL     string
+	userAgent  string
+	httpClient *http.Client
+	log        *log.Entry
+
+	tokenLock sync.Mutex
+	token     string
+	tokenTime time.Time
+}
+
+// prismaAPIError is returned in case Prisma API responded with non-OK status
+type prismaAPIError struct {
+	statusCode int
+	status     string
+	body       []byte
+	// retryAfter is set from Retry-After header of throttled (429) responses
+	retryAfter time.Duration
+	// redlockStatus is x-redlock-status header, which Prisma uses to describe request errors
+	redlockStatus string
+}
+
+func (e *prismaAPIError) Error() string {
+	return fmt.Sprintf("%s, response body: %q", e.status, e.body)
+}
+
+func newPrismaClient(ctx context.Context, username, password, apiURL, userAgent string, timeout time.Duration,
+	proxy *url.URL) *prismaClient {
+	httpClient := newHTTPClient(proxy)
+	httpClient.Timeout = timeout
+	return &prismaClient{
+		ctx:        ctx,
+		username:   username,
+		password:   password,
+		apiURL:     apiURL,
+		userAgent:  userAgent,
+		httpClient: httpClient,
+		log:        log.NewEntry(log.StandardLogger()),
+	}
+}
+
+// loggerSetter is implemented by API callers which log messages, so that the logger of Prisma is passed to them
+type loggerSetter interface {
+	setLogger(*log.Entry)
+}
+
+func (c *prismaClient) setLogger(logger *log.Entry) {
+	c.log = logger
+}
+
+// Call sends request to Prisma API with provided method and body to the url relative to API URL
+// and returns the response body
+func (c *prismaClient) Call(method, url string, body io.Reader) ([]byte, error) {
+	// body is read in advance as it might be sent the second time after re-authentication
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+
+	token, err := c.getToken("")
+	if err != nil {
+		return nil, fmt.Errorf("error getting auth token: %w", err)
+	}
+
+	data, err := c.do(method, url, token, payload)
+	var apiErr *prismaAPIError
+	if errors.As(err, &apiErr) && apiErr.statusCode == http.StatusUnauthorized {
+		c.log.Debug("Prisma token is rejected, re-authenticating")
+		if token, err = c.getToken(token); err != nil {
+			return nil, fmt.Errorf("error getting auth token: %w", err)
+		}
+		return c.do(method, url, token, payload)
+	}
+	return data, err
+}
+
+// getToken returns cached token in case it's not expiring soon, or obtains new one otherwise.
+// Non-empty staleToken forces re-authentication in case the cached token is equal to it.
+func (c *prismaClient) getToken(staleToken string) (string, error) {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+
+	if c.token != "" && c.token != staleToken && time.Since(c.tokenTime) < prismaTokenLifetime-prismaTokenRenewMargin {
+		return c.token, nil
+	}
+
+	// https://api.docs.prismacloud.io/reference#login
+	loginData, err := json.Marshal(map[string]string{"username": c.username, "password": c.password})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling login data: %w", err)
+	}
+	data, err := c.do("POST", "/login", "", loginData)
+	if err != nil {
+		return "", fmt.Errorf("error logging in with user %q: %w", c.username, err)
+	}
+	var res struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", fmt.Errorf("error obtaining token from login response: %w", err)
+	}
+
+	c.token = res.Token
+	c.tokenTime = time.Now()
+	return c.token, nil
+}
+
+// do sends single request to Prisma API with provided token
+func (c *prismaClient) do(method, url, token string, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(c.ctx, method, c.apiURL+url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-redlock-auth", token)
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close() // nolint:errcheck
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &prismaAPIError{statusCode: resp.StatusCode, status: resp.Status, body: data,
+			redlockStatus: resp.Header.Get("x-redlock-status")}
+		if resp.StatusCode == http.StatusTooManyRequests {
+			apiErr.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+		return nil, apiErr
+	}
+	return data, nil
+}
+
+// isPrismaNameConflict checks if provided error is Prisma API error about account name being already in use
+func isPrismaNameConflict(err error) bool {
+	var apiErr *prismaAPIError
+	if !errors.As(err, &apiErr) || apiErr.statusCode != http.StatusBadRequest && apiErr.statusCode != http.StatusConflict {
+		return false
+	}
+	details := strings.ToLower(apiErr.redlockStatus + string(apiErr.body))
+	// i18nKey values Prisma reports for account names which are already in use
+	for _, key := range []string{"duplicate_cloud_account_name", "account_name_already_exists"} {
+		if strings.Contains(details, key) {
+			return true
+		}
+	}
+	return strings.Contains(details, "name already in use")
+}
+
+// parseRetryAfter returns duration from Retry-After header value, which could be either
+// number of seconds or HTTP date. Zero is returned in case the value can't be parsed.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// prismaRetryingCaller wraps apiCaller and retries requests throttled by Prisma API,
+// waiting for duration requested by API before each retry unless context is done
+type prismaRetryingCaller struct {
+	ctx        context.Context
+	api        apiCaller
+	maxRetries int
+	sleep      func(ctx context.Context, d time.Duration) error
+	log        *log.Entry
+}
+
+func newPrismaRetryingCaller(ctx context.Context, api apiCaller, maxRetries int) *prismaRetryingCaller {
+	return &prismaRetryingCaller{ctx: ctx, api: api, maxRetries: maxRetries, sleep: sleepContext, log: log.NewEntry(log.StandardLogger())}
+}
+
+// setLogger sets logger of the caller and of the wrapped one
+func (c *prismaRetryingCaller) setLogger(logger *log.Entry) {
+	c.log = logger
+	if l, ok := c.api.(loggerSetter); ok {
+		l.setLogger(logger)
+	}
+}
+
+// Call sends request using wrapped apiCaller and retries it up to maxRetries times in case it's throttled
+func (c *prismaRetryingCaller) Call(method, url string, body io.Reader) ([]byte, error) {
+	// body is read in advance as it might be sent more than once
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = io.ReadAll(body); err != nil {
+			return nil, fmt.Errorf("error reading request body: %w", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if body != nil {
+			reqBody = bytes.NewReader(payload)
+		}
+		data, err := c.api.Call(method, url, reqBody)
+		var apiErr *prismaAPIError
+		if attempt >= c.maxRetries || !errors.As(err, &apiErr) || apiErr.statusCode != http.StatusTooManyRequests {
+			return data, err
+		}
+		wait := apiErr.retryAfter
+		if wait == 0 {
+			wait = prismaDefaultRetryAfter
+		}
+		c.log.Debugf("Prisma API request is throttled, retrying in %s", wait)
+		if serr := c.sleep(c.ctx, wait); serr != nil {
+			return data, fmt.Errorf("retrying throttled request is interrupted: %w", serr)
+		}
+	}
+}
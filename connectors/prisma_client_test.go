@@ -0,0 +1,214 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrismaClient_Call(t *testing.T) {
+	var logins, calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "test_agent/1.0", r.Header.Get("User-Agent"))
+		if r.URL.Path == "/login" {
+			logins++
+			b, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"username":"test_user","password":"test_password"}`, string(b))
+			_, _ = fmt.Fprintf(w, `{"token":"token_%d"}`, logins)
+			return
+		}
+		calls++
+		b, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "test_body", string(b))
+		// token is rejected on the second call, as if it expired mid-run
+		if calls == 2 {
+			assert.Equal(t, "token_1", r.Header.Get("x-redlock-auth"))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = fmt.Fprintf(w, "answer with %s", r.Header.Get("x-redlock-auth"))
+	}))
+	defer ts.Close()
+
+	c := newPrismaClient(context.Background(), "test_user", "test_password", ts.URL, "test_agent/1.0", time.Second, nil)
+
+	res, err := c.Call("POST", "/cloud", bytes.NewBufferString("test_body"))
+	require.NoError(t, err)
+	assert.Equal(t, "answer with token_1", string(res))
+	assert.Equal(t, 1, logins)
+
+	// 401 causes single re-authentication, and the request is repeated with the new token
+	res, err = c.Call("POST", "/cloud", bytes.NewBufferString("test_body"))
+	require.NoError(t, err)
+	assert.Equal(t, "answer with token_2", string(res))
+	assert.Equal(t, 2, logins)
+	assert.Equal(t, 3, calls)
+
+	// valid token is reused
+	res, err = c.Call("POST", "/cloud", bytes.NewBufferString("test_body"))
+	require.NoError(t, err)
+	assert.Equal(t, "answer with token_2", string(res))
+	assert.Equal(t, 2, logins)
+
+	// token nearing expiry is renewed
+	c.tokenTime = time.Now().Add(-prismaTokenLifetime)
+	res, err = c.Call("POST", "/cloud", bytes.NewBufferString("test_body"))
+	require.NoError(t, err)
+	assert.Equal(t, "answer with token_3", string(res))
+	assert.Equal(t, 3, logins)
+}
+
+func TestPrismaClient_CallErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			_, _ = fmt.Fprint(w, `{"token":"test_token"}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "bad request")
+	}))
+	defer ts.Close()
+
+	c := newPrismaClient(context.Background(), "test_user", "test_password", ts.URL, "", time.Second, nil)
+	_, err := c.Call("GET", "/cloud", nil)
+	assert.EqualError(t, err, `400 Bad Request, response body: "bad request"`)
+
+	badLoginServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer badLoginServer.Close()
+
+	c = newPrismaClient(context.Background(), "test_user", "test_password", badLoginServer.URL, "", time.Second, nil)
+	_, err = c.Call("GET", "/cloud", nil)
+	assert.EqualError(t, err, `error getting auth token: error logging in with user "test_user": `+
+		`401 Unauthorized, response body: ""`)
+}
+
+func TestPrismaClient_CallThrottled(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			_, _ = fmt.Fprint(w, `{"token":"test_token"}`)
+			return
+		}
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer ts.Close()
+
+	c := newPrismaClient(context.Background(), "test_user", "test_password", ts.URL, "", time.Second, nil)
+	_, err := c.Call("GET", "/cloud", nil)
+	var apiErr *prismaAPIError
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTooManyRequests, apiErr.statusCode)
+	assert.Equal(t, 3*time.Second, apiErr.retryAfter)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("not_a_number"))
+	assert.InDelta(t, time.Minute, parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)),
+		float64(2*time.Second))
+}
+
+func TestPrismaRetryingCaller_Call(t *testing.T) {
+	throttledWithDelay := &prismaAPIError{statusCode: http.StatusTooManyRequests, status: "429 Too Many Requests",
+		retryAfter: 2 * time.Second}
+	throttled := &prismaAPIError{statusCode: http.StatusTooManyRequests, status: "429 Too Many Requests"}
+
+	// throttled once, then succeeded
+	m := &mockClient{t: t, requests: []mockRequest{
+		{url: "/cloud", method: "GET", err: throttledWithDelay},
+		{url: "/cloud", method: "GET", answer: "test_answer"},
+	}}
+	var slept []time.Duration
+	c := newPrismaRetryingCaller(context.Background(), m, 2)
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	res, err := c.Call("GET", "/cloud", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "test_answer", string(res))
+	assert.Equal(t, []time.Duration{2 * time.Second}, slept)
+	assert.True(t, m.requestsDepleted())
+
+	// throttled more times than allowed
+	m = &mockClient{t: t, requests: []mockRequest{
+		{url: "/cloud", method: "GET", err: throttled},
+		{url: "/cloud", method: "GET", err: throttled},
+		{url: "/cloud", method: "GET", err: throttled},
+	}}
+	slept = nil
+	c = newPrismaRetryingCaller(context.Background(), m, 2)
+	c.sleep = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	_, err = c.Call("GET", "/cloud", nil)
+	assert.EqualError(t, err, `429 Too Many Requests, response body: ""`)
+	assert.Equal(t, []time.Duration{prismaDefaultRetryAfter, prismaDefaultRetryAfter}, slept)
+	assert.True(t, m.requestsDepleted())
+
+	// waiting for retry is interrupted once context is done
+	m = &mockClient{t: t, requests: []mockRequest{{url: "/cloud", method: "GET", err: throttled}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c = newPrismaRetryingCaller(ctx, m, 2)
+	_, err = c.Call("GET", "/cloud", nil)
+	assert.EqualError(t, err, "retrying throttled request is interrupted: context canceled")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, m.requestsDepleted())
+
+	// other errors are not retried
+	m = &mockClient{t: t, requests: []mockRequest{{url: "/cloud", method: "GET", err: fmt.Errorf("mock error")}}}
+	c = newPrismaRetryingCaller(context.Background(), m, 2)
+	_, err = c.Call("GET", "/cloud", nil)
+	assert.EqualError(t, err, "mock error")
+	assert.True(t, m.requestsDepleted())
+}
+
+func TestIsPrismaNameConflict(t *testing.T) {
+	var testDataset = []struct {
+		err      error
+		conflict bool
+	}{
+		{err: nil},
+		{err: fmt.Errorf("mock error")},
+		{err: &prismaAPIError{statusCode: http.StatusBadRequest, status: "400 Bad Request",
+			redlockStatus: `[{"i18nKey":"duplicate_cloud_account_name","severity":"error"}]`},
+			conflict: true},
+		{err: fmt.Errorf("wrapped: %w", &prismaAPIError{statusCode: http.StatusConflict, status: "409 Conflict",
+			body: []byte(`{"message":"Account name already in use"}`)}),
+			conflict: true},
+		{err: &prismaAPIError{statusCode: http.StatusBadRequest, status: "400 Bad Request",
+			redlockStatus: `[{"i18nKey":"invalid_role_arn","severity":"error"}]`}},
+		{err: &prismaAPIError{statusCode: http.StatusInternalServerError, status: "500 Internal Server Error",
+			body: []byte("duplicate_cloud_account_name")}},
+	}
+
+	for i, x := range testDataset {
+		assert.Equal(t, x.conflict, isPrismaNameConflict(x.err), "Test case %d check failed", i)
+	}
+}
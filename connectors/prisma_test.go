@@ -15,10 +15,18 @@
 package connectors
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -26,6 +34,8 @@ import (
 type mockRequest struct {
 	method string
 	url    string
+	// body is compared with request body as JSON in case it's set
+	body   string
 	answer string
 	err    error
 }
@@ -44,16 +54,54 @@ func TestPrisma_AddAWSAccount(t *testing.T) {
 		getAccInfoGoodEqual = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
 			answer: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
 "RoleArn":"arn:aws:iam::011223344556:role/test_role_name"}`}
+		getAccInfoSpacedEqual = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"ACCOUNTID":"011223344556","Enabled":true,"ExternalID":" test_external_id\n",
+"ROLEARN":"  arn:aws:iam::011223344556:role/test_role_name "}`}
+		getAccInfoCaseDiff = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"RoleArn":"arn:aws:iam::011223344556:role/Test_Role_Name"}`}
+		getAccInfoGovEqual = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"RoleArn":"arn:aws-us-gov:iam::011223344556:role/test_role_name"}`}
+		getAccInfoGroupsEqual = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"RoleArn":"arn:aws:iam::011223344556:role/test_role_name","groupIds":["group_b","group_a"]}`}
+		getAccInfoExtraFields = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"RoleArn":"arn:aws:iam::011223344556:role/old_role_name","name":"test_name","groupIds":["group_a"],
+"protectionMode":"MONITOR","cloudType":"aws"}`}
+		getAccUpdateExtraFields = mockRequest{url: "/cloud/aws/011223344556", method: "PUT",
+			body: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"test_name","groupIds":["group_a"],
+"protectionMode":"MONITOR","cloudType":"aws"}`}
+		getAccInfoMonitorEqual = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","protectionMode":"MONITOR"}`}
+		getAccUpdateProtect = mockRequest{url: "/cloud/aws/011223344556", method: "PUT",
+			body: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"","groupIds":null,
+"protectionMode":"MONITOR_AND_PROTECT"}`}
+		getAccCreateMonitor = mockRequest{url: "/cloud/aws/", method: "POST",
+			body: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"011223344556","groupIds":null,
+"protectionMode":"MONITOR"}`}
 		getAccUpdateErr  = mockRequest{url: "/cloud/aws/011223344556", method: "PUT", err: fmt.Errorf("mock error")}
 		getAccUpdateGood = mockRequest{url: "/cloud/aws/011223344556", method: "PUT"}
 		getAccCreateErr  = mockRequest{url: "/cloud/aws/", method: "POST", err: fmt.Errorf("mock error")}
 		getAccCreateGood = mockRequest{url: "/cloud/aws/", method: "POST"}
+		getAccCreateName = mockRequest{url: "/cloud/aws/", method: "POST",
+			err: &prismaAPIError{statusCode: http.StatusBadRequest, status: "400 Bad Request",
+				redlockStatus: `[{"i18nKey":"duplicate_cloud_account_name","severity":"error","subject":"011223344556"}]`}}
 	)
 
 	var testAPIRequestsDataset = []struct {
-		description string
-		error       string
-		requests    []mockRequest
+		description    string
+		error          string
+		partition      string
+		groupIDs       []string
+		protectionMode string
+		nameConflict   bool
+		requests       []mockRequest
 	}{
 		{description: "problem checking existing account existence",
 			requests: []mockRequest{getAccListErr},
@@ -71,16 +119,51 @@ func TestPrisma_AddAWSAccount(t *testing.T) {
 				"invalid character 'o' in literal null (expecting 'u')"},
 		{description: "existing account equal to desired",
 			requests: []mockRequest{getAccListGood, getAccInfoGoodEqual}},
+		{description: "existing account field names and values whitespace don't matter",
+			requests: []mockRequest{getAccListGood, getAccInfoSpacedEqual}},
+		{description: "existing account role name case matters",
+			requests: []mockRequest{getAccListGood, getAccInfoCaseDiff, getAccUpdateGood}},
+		{description: "existing GovCloud account equal to desired",
+			partition: "aws-us-gov",
+			requests:  []mockRequest{getAccListGood, getAccInfoGovEqual}},
+		{description: "existing account in different partition",
+			requests: []mockRequest{getAccListGood, getAccInfoGovEqual, getAccUpdateGood}},
+		{description: "existing account with the same set of groups",
+			groupIDs: []string{"group_a", "group_b"},
+			requests: []mockRequest{getAccListGood, getAccInfoGroupsEqual}},
+		{description: "existing account groups kept when groups are not provided",
+			requests: []mockRequest{getAccListGood, getAccInfoGroupsEqual}},
+		{description: "existing account groups changed",
+			groupIDs: []string{"group_a", "group_c"},
+			requests: []mockRequest{getAccListGood, getAccInfoGroupsEqual, getAccUpdateGood}},
 		{description: "problem updating existing account",
 			requests: []mockRequest{getAccListGood, getAccInfoGoodDiff, getAccUpdateErr},
 			error:    "error updating existing account: error sending API request: mock error"},
 		{description: "existing account updated",
 			requests: []mockRequest{getAccListGood, getAccInfoGoodDiff, getAccUpdateGood}},
+		{description: "fields not managed by the program kept on update",
+			requests: []mockRequest{getAccListGood, getAccInfoExtraFields, getAccUpdateExtraFields}},
+		{description: "existing account protection mode equal to desired",
+			protectionMode: "MONITOR",
+			requests:       []mockRequest{getAccListGood, getAccInfoMonitorEqual}},
+		{description: "existing account protection mode kept when mode is not provided",
+			requests: []mockRequest{getAccListGood, getAccInfoMonitorEqual}},
+		{description: "existing account protection mode changed",
+			protectionMode: "MONITOR_AND_PROTECT",
+			requests:       []mockRequest{getAccListGood, getAccInfoMonitorEqual, getAccUpdateProtect}},
+		{description: "new account created with protection mode",
+			protectionMode: "MONITOR",
+			requests:       []mockRequest{getAccListEmpty, getAccCreateMonitor}},
 		{description: "problem creating new account",
 			requests: []mockRequest{getAccListEmpty, getAccCreateErr},
 			error:    "error creating new account: error sending API request: mock error"},
 		{description: "problem creating new account",
 			requests: []mockRequest{getAccListEmpty, getAccCreateGood}},
+		{description: "new account name is already in use",
+			requests:     []mockRequest{getAccListEmpty, getAccCreateName},
+			nameConflict: true,
+			error: "error creating new account: Prisma account name \"011223344556\" is already in use " +
+				"by another cloud account, set a unique name for account 011223344556"},
 	}
 
 	for i, x := range testAPIRequestsDataset {
@@ -88,15 +171,687 @@ func TestPrisma_AddAWSAccount(t *testing.T) {
 		x := x
 		t.Run(x.description, func(t *testing.T) {
 			m := &mockClient{t: t, requests: x.requests}
-			p := NewPrisma("", "", "")
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
 			p.api = m
-			err := p.AddAWSAccount("011223344556", "", "test_external_id", "test_role_name")
+			partition := "aws"
+			if x.partition != "" {
+				partition = x.partition
+			}
+			err := p.AddAWSAccount("011223344556", partition, "", "test_external_id", "test_role_name", x.groupIDs,
+				x.protectionMode)
 
 			if x.error != "" {
 				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
 			} else {
 				assert.NoError(t, err, "Test case %d error check failed", i)
 			}
+			if x.nameConflict {
+				var conflictErr *PrismaAccountNameConflictError
+				if assert.True(t, errors.As(err, &conflictErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "011223344556", conflictErr.Name, "Test case %d name check failed", i)
+				}
+			}
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
+
+func TestPrisma_AddAWSAccountAPIVersion(t *testing.T) {
+	var testData = []struct {
+		description string
+		version     string
+		body        string
+		error       string
+	}{
+		{description: "legacy schema",
+			version: PrismaAPIVersionLegacy,
+			body: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"test_name","groupIds":["group_a"],
+"protectionMode":"MONITOR"}`},
+		{description: "v2 schema",
+			version: PrismaAPIVersionV2,
+			body: `{"cloudAccount":{"accountId":"011223344556","accountType":"account","enabled":true,
+"groupIds":["group_a"],"name":"test_name","protectionMode":"MONITOR"},"cloudType":"aws",
+"externalId":"test_external_id","roleArn":"arn:aws:iam::011223344556:role/test_role_name"}`},
+		{description: "unknown version",
+			version: "v3",
+			error:   `unknown Prisma API version "v3"`},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			err := p.SetAPIVersion(x.version)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			require.NoError(t, err, "Test case %d error check failed", i)
+
+			m := &mockClient{t: t, requests: []mockRequest{
+				{url: "/cloud", method: "GET", answer: `[]`},
+				{url: "/cloud/aws/", method: "POST", body: x.body},
+			}}
+			p.api = m
+			err = p.AddAWSAccount("011223344556", "aws", "test_name", "test_external_id", "test_role_name",
+				[]string{"group_a"}, "MONITOR")
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
+
+func TestPrisma_AddAWSAccountUpdatePolicy(t *testing.T) {
+	var (
+		getAccListEmpty = mockRequest{url: "/cloud", method: "GET", answer: `[]`}
+		getAccListGood  = mockRequest{url: "/cloud", method: "GET", answer: `[{"accountId":"011223344556"}]`}
+		getAccInfoEqual = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"test_name"}`}
+		getAccInfoDiff = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":"old_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"test_name"}`}
+		getAccUpdate = mockRequest{url: "/cloud/aws/011223344556", method: "PUT",
+			body: `{"accountId":"011223344556","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"test_name","groupIds":null}`}
+		getAccCreate = mockRequest{url: "/cloud/aws/", method: "POST"}
+	)
+
+	var testData = []struct {
+		description string
+		policy      PrismaUpdatePolicy
+		requests    []mockRequest
+	}{
+		{description: "equal account is not updated",
+			policy:   PrismaUpdateChanged,
+			requests: []mockRequest{getAccListGood, getAccInfoEqual}},
+		{description: "different account is updated",
+			policy:   PrismaUpdateChanged,
+			requests: []mockRequest{getAccListGood, getAccInfoDiff, getAccUpdate}},
+		{description: "no update leaves different account as is",
+			policy:   PrismaUpdateNever,
+			requests: []mockRequest{getAccListGood}},
+		{description: "no update still creates missing account",
+			policy:   PrismaUpdateNever,
+			requests: []mockRequest{getAccListEmpty, getAccCreate}},
+		{description: "force update updates equal account",
+			policy:   PrismaUpdateAlways,
+			requests: []mockRequest{getAccListGood, getAccInfoEqual, getAccUpdate}},
+		{description: "force update updates different account",
+			policy:   PrismaUpdateAlways,
+			requests: []mockRequest{getAccListGood, getAccInfoDiff, getAccUpdate}},
+		{description: "force update creates missing account",
+			policy:   PrismaUpdateAlways,
+			requests: []mockRequest{getAccListEmpty, getAccCreate}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			p.SetUpdatePolicy(x.policy)
+			err := p.AddAWSAccount("011223344556", "aws", "test_name", "test_external_id", "test_role_name", nil, "")
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.True(t, m.requestsDepleted(), "Test case %d requests check failed", i)
+		})
+	}
+}
+
+func TestPrisma_AddAWSAccounts(t *testing.T) {
+	var (
+		getAccListErr  = mockRequest{url: "/cloud", method: "GET", err: fmt.Errorf("mock error")}
+		getAccListGood = mockRequest{url: "/cloud", method: "GET", answer: `[{"accountId":"011223344556"}]`}
+		getAccInfoDiff = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556"}`}
+		getAccInfoErr   = mockRequest{url: "/cloud/aws/011223344556", method: "GET", err: fmt.Errorf("mock error")}
+		getAccUpdate    = mockRequest{url: "/cloud/aws/011223344556", method: "PUT"}
+		getAccCreate    = mockRequest{url: "/cloud/aws/", method: "POST"}
+		getAccCreateErr = mockRequest{url: "/cloud/aws/", method: "POST", err: fmt.Errorf("mock error")}
+		getAccCreateNew = mockRequest{url: "/cloud/aws/", method: "POST",
+			body: `{"accountId":"112233445566","enabled":true,"externalId":"test_external_id",
+"roleArn":"arn:aws:iam::112233445566:role/test_role_name","name":"payments","groupIds":["group_a"]}`}
+		accounts = []AWSAccountSpec{
+			{AccountID: "011223344556", Partition: "aws", ExternalID: "test_external_id", RoleName: "test_role_name"},
+			{AccountID: "112233445566", Partition: "aws", Name: "payments", ExternalID: "test_external_id",
+				RoleName: "test_role_name", GroupIDs: []string{"group_a"}},
+			{AccountID: "223344556677", Partition: "aws", ExternalID: "test_external_id", RoleName: "test_role_name"},
+		}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		requests    []mockRequest
+	}{
+		{description: "problem listing accounts",
+			requests: []mockRequest{getAccListErr},
+			error:    "error checking for existing accounts: error retrieving list of accounts: mock error"},
+		// account list is fetched only once, before processing the first account
+		{description: "existing account updated and new ones created",
+			requests: []mockRequest{getAccListGood, getAccInfoDiff, getAccUpdate, getAccCreateNew, getAccCreate}},
+		{description: "errors of all accounts aggregated",
+			requests: []mockRequest{getAccListGood, getAccInfoErr, getAccCreateNew, getAccCreateErr},
+			error: "2 errors occurred:\n" +
+				"\t* problem adding account 011223344556: error updating existing account: " +
+				"error retrieving existing account details: mock error\n" +
+				"\t* problem adding account 223344556677: error creating new account: error sending API request: mock error\n\n"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			err := p.AddAWSAccounts(accounts)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.True(t, m.requestsDepleted(), "Test case %d requests check failed", i)
+		})
+	}
+}
+
+func TestDiffAWSAccount(t *testing.T) {
+	base := awsAccountInfo{
+		Name:           "test_name",
+		Enabled:        true,
+		ExternalID:     "test_external_id",
+		RoleArn:        "arn:aws:iam::011223344556:role/test_role_name",
+		AccountID:      "011223344556",
+		GroupIDs:       []string{"group_a", "group_b"},
+		ProtectionMode: "MONITOR",
+	}
+	with := func(modify func(acc *awsAccountInfo)) awsAccountInfo {
+		acc := base
+		modify(&acc)
+		return acc
+	}
+
+	testData := []struct {
+		description string
+		oldAcc      awsAccountInfo
+		acc         awsAccountInfo
+		diff        []string
+	}{
+		{description: "equal accounts",
+			oldAcc: base,
+			acc:    base},
+		{description: "role ARN differs",
+			oldAcc: base,
+			acc:    with(func(acc *awsAccountInfo) { acc.RoleArn = "arn:aws:iam::011223344556:role/other_role" }),
+			diff:   []string{"roleArn"}},
+		{description: "external ID and enabled differ",
+			oldAcc: with(func(acc *awsAccountInfo) { acc.Enabled = false }),
+			acc:    with(func(acc *awsAccountInfo) { acc.ExternalID = "other_external_id" }),
+			diff:   []string{"enabled", "externalId"}},
+		{description: "groups differ",
+			oldAcc: base,
+			acc:    with(func(acc *awsAccountInfo) { acc.GroupIDs = []string{"group_a"} }),
+			diff:   []string{"groupIds"}},
+		{description: "all fields differ",
+			oldAcc: awsAccountInfo{},
+			acc:    base,
+			diff:   []string{"name", "enabled", "externalId", "roleArn", "accountId", "groupIds", "protectionMode"}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			assert.Equal(t, x.diff, diffAWSAccount(x.oldAcc, x.acc), "Test case %d check failed", i)
+		})
+	}
+}
+
+func TestPrisma_CheckAWSAccount(t *testing.T) {
+	var (
+		getAccListErr   = mockRequest{url: "/cloud", method: "GET", err: fmt.Errorf("mock error")}
+		getAccListEmpty = mockRequest{url: "/cloud", method: "GET", answer: `[]`}
+		getAccListGood  = mockRequest{url: "/cloud", method: "GET", answer: `[{"accountId":"011223344556"}]`}
+		getAccInfoErr   = mockRequest{url: "/cloud/aws/011223344556", method: "GET", err: fmt.Errorf("mock error")}
+		getAccInfoEqual = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":true,"externalId":" test_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"test_name","groupIds":["group_b","group_a"]}`}
+		getAccInfoDrift = mockRequest{url: "/cloud/aws/011223344556", method: "GET",
+			answer: `{"accountId":"011223344556","enabled":false,"externalId":"old_external_id",
+"roleArn":"arn:aws:iam::011223344556:role/test_role_name","name":"test_name","groupIds":["group_c"]}`}
+	)
+
+	var testData = []struct {
+		description string
+		requests    []mockRequest
+		diff        []string
+		error       string
+		errIs       error
+	}{
+		{description: "problem checking existing account existence",
+			requests: []mockRequest{getAccListErr},
+			error:    "error checking for existing account: error retrieving list of accounts: mock error"},
+		{description: "account doesn't exist",
+			requests: []mockRequest{getAccListEmpty},
+			error:    "account doesn't exist in Prisma",
+			errIs:    ErrPrismaAccountMissing},
+		{description: "problem retrieving account details",
+			requests: []mockRequest{getAccListGood, getAccInfoErr},
+			error:    "error retrieving existing account details: mock error"},
+		{description: "account matches desired settings",
+			requests: []mockRequest{getAccListGood, getAccInfoEqual}},
+		// nothing is updated, as only the existing account details are requested
+		{description: "account settings drifted",
+			requests: []mockRequest{getAccListGood, getAccInfoDrift},
+			diff:     []string{"enabled", "externalId", "groupIds"}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			diff, err := p.CheckAWSAccount(AWSAccountSpec{
+				AccountID:  "011223344556",
+				Partition:  "aws",
+				ExternalID: "test_external_id",
+				RoleName:   "test_role_name",
+				GroupIDs:   []string{"group_a", "group_b"},
+			})
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				if x.errIs != nil {
+					assert.ErrorIs(t, err, x.errIs, "Test case %d errors.Is check failed", i)
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.diff, diff, "Test case %d diff check failed", i)
+			assert.True(t, m.requestsDepleted(), "Test case %d requests check failed", i)
+		})
+	}
+}
+
+func TestPrisma_SetLogger(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	logger.SetLevel(log.DebugLevel)
+	entry := logger.WithField("component", "prisma")
+
+	p := NewPrisma("", "", "", "", 0, time.Second, nil)
+	p.SetLogger(entry)
+	caller, ok := p.api.(*prismaRetryingCaller)
+	require.True(t, ok)
+	assert.Equal(t, entry, caller.log, "logger of retrying caller check failed")
+	client, ok := caller.api.(*prismaClient)
+	require.True(t, ok)
+	assert.Equal(t, entry, client.log, "logger of API client check failed")
+
+	m := &mockClient{t: t, requests: []mockRequest{
+		{url: "/cloud", method: "GET", answer: `[{"accountId":"011223344556"}]`},
+		{url: "/cloud/aws/011223344556", method: "GET", answer: `{"accountId":"011223344556","enabled":true,
+"externalId":"test_external_id","roleArn":"arn:aws:iam::011223344556:role/test_role_name"}`},
+	}}
+	p.api = m
+	require.NoError(t, p.AddAWSAccount("011223344556", "aws", "", "test_external_id", "test_role_name", nil, ""))
+	assert.True(t, m.requestsDepleted())
+
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, "Prisma account already up to date, doing nothing", hook.LastEntry().Message)
+	assert.Equal(t, "prisma", hook.LastEntry().Data["component"])
+}
+
+func TestPrisma_AddAzureAccount(t *testing.T) {
+	// mock requests
+	var (
+		getAccListErr      = mockRequest{url: "/cloud", method: "GET", err: fmt.Errorf("mock error")}
+		getAccListEmpty    = mockRequest{url: "/cloud", method: "GET", answer: `[]`}
+		getAccListGood     = mockRequest{url: "/cloud", method: "GET", answer: `[{"accountId":"test_subscription"}]`}
+		getAccInfoErr      = mockRequest{url: "/cloud/azure/test_subscription", method: "GET", err: fmt.Errorf("mock error")}
+		getAccInfoGoodDiff = mockRequest{url: "/cloud/azure/test_subscription", method: "GET",
+			answer: `{"cloudAccount":{"accountId":"test_subscription"}}`}
+		getAccInfoGoodEqual = mockRequest{url: "/cloud/azure/test_subscription", method: "GET",
+			answer: `{"cloudAccount":{"accountId":"test_subscription","enabled":true,"name":"test_name","accountType":"account"},
+"clientId":"test_client","tenantId":"test_tenant","servicePrincipalId":"test_principal","monitorFlowLogs":true}`}
+		getAccUpdateErr  = mockRequest{url: "/cloud/azure/test_subscription", method: "PUT", err: fmt.Errorf("mock error")}
+		getAccUpdateGood = mockRequest{url: "/cloud/azure/test_subscription", method: "PUT"}
+		getAccCreateErr  = mockRequest{url: "/cloud/azure", method: "POST", err: fmt.Errorf("mock error")}
+		getAccCreateGood = mockRequest{url: "/cloud/azure", method: "POST"}
+	)
+
+	var testAPIRequestsDataset = []struct {
+		description string
+		error       string
+		requests    []mockRequest
+	}{
+		{description: "problem checking existing account existence",
+			requests: []mockRequest{getAccListErr},
+			error:    "error checking for existing account: error retrieving list of accounts: mock error"},
+		{description: "problem checking existing account details",
+			requests: []mockRequest{getAccListGood, getAccInfoErr},
+			error:    "error updating existing account: error retrieving existing account details: mock error"},
+		{description: "existing account equal to desired",
+			requests: []mockRequest{getAccListGood, getAccInfoGoodEqual}},
+		{description: "problem updating existing account",
+			requests: []mockRequest{getAccListGood, getAccInfoGoodDiff, getAccUpdateErr},
+			error:    "error updating existing account: error sending API request: mock error"},
+		{description: "existing account updated",
+			requests: []mockRequest{getAccListGood, getAccInfoGoodDiff, getAccUpdateGood}},
+		{description: "problem creating new account",
+			requests: []mockRequest{getAccListEmpty, getAccCreateErr},
+			error:    "error creating new account: error sending API request: mock error"},
+		{description: "new account created",
+			requests: []mockRequest{getAccListEmpty, getAccCreateGood}},
+	}
+
+	for i, x := range testAPIRequestsDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			err := p.AddAzureAccount("test_subscription", "test_name", "test_tenant", "test_client",
+				"test_key", "test_principal", true)
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
+
+func TestPrisma_AddGCPAccount(t *testing.T) {
+	// mock requests
+	var (
+		getAccListEmpty    = mockRequest{url: "/cloud", method: "GET", answer: `[]`}
+		getAccListGood     = mockRequest{url: "/cloud", method: "GET", answer: `[{"accountId":"test-project"}]`}
+		getAccInfoBadJSON  = mockRequest{url: "/cloud/gcp/test-project", method: "GET", answer: "not_json"}
+		getAccInfoGoodDiff = mockRequest{url: "/cloud/gcp/test-project", method: "GET",
+			answer: `{"cloudAccount":{"accountId":"test-project","enabled":true,"name":"test_name","accountType":"account"}}`}
+		getAccInfoGoodEqual = mockRequest{url: "/cloud/gcp/test-project", method: "GET",
+			answer: `{"cloudAccount":{"accountId":"test-project","enabled":true,"name":"test_name","accountType":"account"},
+"compressionEnabled":true,"dataflowEnabledProject":"test-dataflow-project"}`}
+		getAccUpdateErr  = mockRequest{url: "/cloud/gcp/test-project", method: "PUT", err: fmt.Errorf("mock error")}
+		getAccUpdateGood = mockRequest{url: "/cloud/gcp/test-project", method: "PUT"}
+		getAccCreateErr  = mockRequest{url: "/cloud/gcp", method: "POST", err: fmt.Errorf("mock error")}
+		getAccCreateGood = mockRequest{url: "/cloud/gcp", method: "POST"}
+	)
+
+	var testAPIRequestsDataset = []struct {
+		description string
+		error       string
+		requests    []mockRequest
+	}{
+		{description: "json problem checking existing account details",
+			requests: []mockRequest{getAccListGood, getAccInfoBadJSON},
+			error: "error updating existing account: error unmarshalling account details: " +
+				"invalid character 'o' in literal null (expecting 'u')"},
+		{description: "existing account equal to desired",
+			requests: []mockRequest{getAccListGood, getAccInfoGoodEqual}},
+		{description: "problem updating existing account",
+			requests: []mockRequest{getAccListGood, getAccInfoGoodDiff, getAccUpdateErr},
+			error:    "error updating existing account: error sending API request: mock error"},
+		{description: "existing account updated",
+			requests: []mockRequest{getAccListGood, getAccInfoGoodDiff, getAccUpdateGood}},
+		{description: "problem creating new account",
+			requests: []mockRequest{getAccListEmpty, getAccCreateErr},
+			error:    "error creating new account: error sending API request: mock error"},
+		{description: "new account created",
+			requests: []mockRequest{getAccListEmpty, getAccCreateGood}},
+	}
+
+	for i, x := range testAPIRequestsDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			err := p.AddGCPAccount("test-project", "test_name", []byte(`{"type":"service_account"}`),
+				true, "test-dataflow-project", "")
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
+
+func TestPrisma_UpdateAWSAccountConfig(t *testing.T) {
+	var testAPIRequestsDataset = []struct {
+		description string
+		error       string
+		cfg         AWSAccountConfig
+		requests    []mockRequest
+	}{
+		{description: "problem updating config",
+			cfg: AWSAccountConfig{FlowLogs: true},
+			requests: []mockRequest{{url: "/cloud/aws/011223344556/config", method: "PUT",
+				err: fmt.Errorf("mock error")}},
+			error: "error sending API request: mock error"},
+		{description: "all features enabled",
+			cfg: AWSAccountConfig{FlowLogs: true, S3Config: true},
+			requests: []mockRequest{{url: "/cloud/aws/011223344556/config", method: "PUT",
+				body: `{"flowLogsEnabled":true,"s3ConfigEnabled":true}`}}},
+		{description: "all features disabled",
+			requests: []mockRequest{{url: "/cloud/aws/011223344556/config", method: "PUT",
+				body: `{"flowLogsEnabled":false,"s3ConfigEnabled":false}`}}},
+	}
+
+	for i, x := range testAPIRequestsDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			err := p.UpdateAWSAccountConfig("011223344556", x.cfg)
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
+
+func TestPrisma_GetAWSAccountStatus(t *testing.T) {
+	var testAPIRequestsDataset = []struct {
+		description string
+		answer      string
+		err         error
+		status      string
+		error       string
+	}{
+		{description: "healthy account",
+			answer: `[{"name":"Config","status":"ok"},{"name":"Flow Logs","status":"ok"}]`,
+			status: PrismaAccountStatusOK},
+		{description: "degraded account",
+			answer: `[{"name":"Config","status":"ok"},{"name":"Flow Logs","status":"warning","message":"no data"}]`,
+			status: PrismaAccountStatusWarning},
+		{description: "error is preferred over warning",
+			answer: `[{"name":"Config","status":"error","message":"access denied"},{"name":"Flow Logs","status":"warning"}]`,
+			status: PrismaAccountStatusError},
+		{description: "problem retrieving status",
+			err:   fmt.Errorf("mock error"),
+			error: "error retrieving account status: mock error"},
+		{description: "json problem",
+			answer: "not_json",
+			error:  "error unmarshalling account status: invalid character 'o' in literal null (expecting 'u')"},
+		{description: "empty status",
+			answer: `[]`,
+			error:  "no status returned for account 011223344556"},
+	}
+
+	for i, x := range testAPIRequestsDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: []mockRequest{{url: "/account/011223344556/config/status", method: "GET",
+				answer: x.answer, err: x.err}}}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			status, err := p.GetAWSAccountStatus("011223344556")
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.status, status, "Test case %d status check failed", i)
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
+
+func TestPrismaAPIURL(t *testing.T) {
+	testData := []struct {
+		region   string
+		expected string
+		error    string
+	}{
+		{region: "eu", expected: "https://api.eu.prismacloud.io"},
+		{region: "us", expected: "https://api.prismacloud.io"},
+		{region: "app2", expected: "https://api2.prismacloud.io"},
+		{region: "anz", expected: "https://api.anz.prismacloud.io"},
+		{region: "mars", error: `unknown Prisma region "mars", should be one of: ` +
+			"anz, app2, app3, app4, ca, eu, eu2, fr, gov, ind, jp, sg, uk, us"},
+		{region: "", error: `unknown Prisma region "", should be one of: ` +
+			"anz, app2, app3, app4, ca, eu, eu2, fr, gov, ind, jp, sg, uk, us"},
+	}
+
+	for i, x := range testData {
+		apiURL, err := PrismaAPIURL(x.region)
+		if x.error != "" {
+			assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+		} else {
+			assert.NoError(t, err, "Test case %d error check failed", i)
+		}
+		assert.Equal(t, x.expected, apiURL, "Test case %d URL check failed", i)
+	}
+}
+
+func TestPrisma_DeleteAWSAccount(t *testing.T) {
+	// mock requests
+	var (
+		getAccListErr   = mockRequest{url: "/cloud", method: "GET", err: fmt.Errorf("mock error")}
+		getAccListEmpty = mockRequest{url: "/cloud", method: "GET", answer: `[]`}
+		getAccListGood  = mockRequest{url: "/cloud", method: "GET", answer: `[{"accountId":"011223344556"}]`}
+		getAccDeleteErr = mockRequest{url: "/cloud/aws/011223344556", method: "DELETE", err: fmt.Errorf("mock error")}
+		getAccDelete    = mockRequest{url: "/cloud/aws/011223344556", method: "DELETE"}
+	)
+
+	var testAPIRequestsDataset = []struct {
+		description string
+		error       string
+		requests    []mockRequest
+	}{
+		{description: "problem checking existing account existence",
+			requests: []mockRequest{getAccListErr},
+			error:    "error checking for existing account: error retrieving list of accounts: mock error"},
+		{description: "account not found",
+			requests: []mockRequest{getAccListEmpty}},
+		{description: "problem deleting account",
+			requests: []mockRequest{getAccListGood, getAccDeleteErr},
+			error:    "error sending API request: mock error"},
+		{description: "account deleted",
+			requests: []mockRequest{getAccListGood, getAccDelete}},
+	}
+
+	for i, x := range testAPIRequestsDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			err := p.DeleteAWSAccount("011223344556")
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
+
+func TestPrisma_DeleteAWSAccountByName(t *testing.T) {
+	// mock requests
+	var (
+		getAccListErr    = mockRequest{url: "/cloud", method: "GET", err: fmt.Errorf("mock error")}
+		getAccListNoName = mockRequest{url: "/cloud", method: "GET", answer: `[
+			{"accountId":"011223344556","name":"other","cloudType":"aws"},
+			{"accountId":"test-project","name":"test","cloudType":"gcp"}]`}
+		getAccListUnique = mockRequest{url: "/cloud", method: "GET", answer: `[
+			{"accountId":"011223344556","name":"test","cloudType":"aws"},
+			{"accountId":"665544332211","name":"other","cloudType":"aws"},
+			{"accountId":"test-project","name":"test","cloudType":"gcp"}]`}
+		getAccListAmbiguous = mockRequest{url: "/cloud", method: "GET", answer: `[
+			{"accountId":"665544332211","name":"test","cloudType":"aws"},
+			{"accountId":"011223344556","name":"test","cloudType":"aws"}]`}
+		getAccDeleteErr = mockRequest{url: "/cloud/aws/011223344556", method: "DELETE", err: fmt.Errorf("mock error")}
+		getAccDelete    = mockRequest{url: "/cloud/aws/011223344556", method: "DELETE"}
+	)
+
+	var testAPIRequestsDataset = []struct {
+		description string
+		error       string
+		ambiguous   []string
+		requests    []mockRequest
+	}{
+		{description: "problem listing accounts",
+			requests: []mockRequest{getAccListErr},
+			error:    "error looking for account by name: error retrieving list of accounts: mock error"},
+		{description: "no account with the name",
+			requests: []mockRequest{getAccListNoName}},
+		{description: "problem deleting account",
+			requests: []mockRequest{getAccListUnique, getAccDeleteErr},
+			error:    "error sending API request: mock error"},
+		{description: "unique account with the name deleted",
+			requests: []mockRequest{getAccListUnique, getAccDelete}},
+		{description: "several accounts with the name",
+			requests:  []mockRequest{getAccListAmbiguous},
+			ambiguous: []string{"011223344556", "665544332211"},
+			error: "error looking for account by name: Prisma account name \"test\" is used by 2 AWS accounts: " +
+				"011223344556, 665544332211, use account ID instead"},
+	}
+
+	for i, x := range testAPIRequestsDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			err := p.DeleteAWSAccountByName("test")
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			var ambiguousErr *PrismaAmbiguousAccountNameError
+			if x.ambiguous != nil && assert.ErrorAs(t, err, &ambiguousErr, "Test case %d error type check failed", i) {
+				assert.Equal(t, "test", ambiguousErr.Name, "Test case %d error name check failed", i)
+				assert.Equal(t, x.ambiguous, ambiguousErr.AccountIDs, "Test case %d error accounts check failed", i)
+			}
 			assert.True(t, m.requestsDepleted())
 		})
 	}
@@ -108,15 +863,108 @@ type mockClient struct {
 	requests   []mockRequest
 }
 
-func (m *mockClient) Call(method, url string, _ io.Reader) ([]byte, error) {
+func (m *mockClient) Call(method, url string, body io.Reader) ([]byte, error) {
 	require.False(m.t, m.currentReq >= len(m.requests), "we're out of mocked requests")
 	i := m.currentReq
 	m.currentReq++
 	assert.Equal(m.t, m.requests[i].url, url)
 	assert.Equal(m.t, m.requests[i].method, method)
+	if m.requests[i].body != "" {
+		require.NotNil(m.t, body)
+		b, err := io.ReadAll(body)
+		require.NoError(m.t, err)
+		assert.JSONEq(m.t, m.requests[i].body, string(b))
+	}
 	return []byte(m.requests[i].answer), m.requests[i].err
 }
 
 func (m *mockClient) requestsDepleted() bool {
 	return m.currentReq == len(m.requests)
 }
+
+func TestPrisma_AddAWSAccountTimeout(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			_, _ = fmt.Fprint(w, `{"token":"test_token"}`)
+			return
+		}
+		// API stalls until the test is over
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	p := NewPrisma("test_user", "test_password", ts.URL, "", 0, 100*time.Millisecond, nil)
+	err := p.AddAWSAccount("011223344556", "aws", "test_name", "test_external_id", "test_role", nil, "")
+	require.Error(t, err)
+	var netErr net.Error
+	require.True(t, errors.As(err, &netErr), "unexpected error %v", err)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestPrisma_LoginHangs(t *testing.T) {
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// login stalls until the test is over
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	t.Run("timeout", func(t *testing.T) {
+		p := NewPrismaWithContext(context.Background(), "test_user", "test_password", ts.URL, "", 0,
+			100*time.Millisecond, nil)
+		start := time.Now()
+		err := p.Preflight()
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		var netErr net.Error
+		require.True(t, errors.As(err, &netErr), "unexpected error %v", err)
+		assert.True(t, netErr.Timeout())
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		p := NewPrismaWithContext(ctx, "test_user", "test_password", ts.URL, "", 0, time.Minute, nil)
+		start := time.Now()
+		err := p.Preflight()
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Contains(t, err.Error(), "error logging in")
+	})
+}
+
+func TestPrisma_Preflight(t *testing.T) {
+	var testAPIRequestsDataset = []struct {
+		description string
+		error       string
+		requests    []mockRequest
+	}{
+		{description: "problem listing accounts",
+			requests: []mockRequest{{url: "/cloud", method: "GET", err: fmt.Errorf("mock error")}},
+			error:    "error retrieving list of accounts: mock error"},
+		{description: "accounts listed",
+			requests: []mockRequest{{url: "/cloud", method: "GET", answer: `[]`}}},
+	}
+
+	for i, x := range testAPIRequestsDataset {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockClient{t: t, requests: x.requests}
+			p := NewPrisma("", "", "", "", 0, time.Second, nil)
+			p.api = m
+			err := p.Preflight()
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.True(t, m.requestsDepleted())
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+)
+
+// GuardDutyPublishingConfigurer is a per-region structure which contains all information for exporting
+// findings of master account GuardDuty detector, including ones of its members, to S3 bucket.
+type GuardDutyPublishingConfigurer struct {
+	masterSvc GuardDutyPublishingClient
+	bucketARN string
+	kmsKeyARN string
+}
+
+// GuardDutyPublishingClient is a subset of aws-sdk-go/service/guardduty which is used for configuring
+// findings publishing destination of master detector.
+type GuardDutyPublishingClient interface {
+	GuardDutyListDetectors
+	ListPublishingDestinations(*guardduty.ListPublishingDestinationsInput) (*guardduty.ListPublishingDestinationsOutput, error)
+	DescribePublishingDestination(*guardduty.DescribePublishingDestinationInput) (*guardduty.DescribePublishingDestinationOutput, error)
+	CreatePublishingDestination(*guardduty.CreatePublishingDestinationInput) (*guardduty.CreatePublishingDestinationOutput, error)
+	UpdatePublishingDestination(*guardduty.UpdatePublishingDestinationInput) (*guardduty.UpdatePublishingDestinationOutput, error)
+}
+
+// NewGuardDutyPublishingConfigurer creates new instance of GuardDutyPublishingConfigurer which exports findings
+// of masterSess account to S3 bucket with provided ARN, encrypting them with KMS key with provided ARN.
+func NewGuardDutyPublishingConfigurer(masterSess client.ConfigProvider, bucketARN, kmsKeyARN string) *GuardDutyPublishingConfigurer {
+	return &GuardDutyPublishingConfigurer{
+		masterSvc: guardduty.New(masterSess),
+		bucketARN: bucketARN,
+		kmsKeyARN: kmsKeyARN,
+	}
+}
+
+// Name returns "guardduty_publishing", identifier of the service.
+func (p GuardDutyPublishingConfigurer) Name() string {
+	return "guardduty_publishing"
+}
+
+// ConfigureDestination makes master detector publish findings to the bucket, creating S3 publishing destination
+// or updating existing one in case its bucket or KMS key differ. In case it's in place already, nothing is done.
+// https://docs.aws.amazon.com/guardduty/latest/ug/guardduty_exportfindings.html
+func (p GuardDutyPublishingConfigurer) ConfigureDestination() (Result, error) {
+	res, err := p.configureDestination()
+	return res, newServiceError(p.Name(), err)
+}
+
+func (p GuardDutyPublishingConfigurer) configureDestination() (Result, error) {
+	detectorID, err := getDetectorID(p.masterSvc)
+	if err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("can't get detectorID of master account: %w", err)
+	}
+
+	destinationID, err := getS3PublishingDestinationID(p.masterSvc, detectorID)
+	if err != nil {
+		return Result{Status: StatusFailed}, err
+	}
+	properties := &guardduty.DestinationProperties{
+		DestinationArn: aws.String(p.bucketARN),
+		KmsKeyArn:      aws.String(p.kmsKeyARN),
+	}
+
+	if destinationID == nil {
+		_, err := p.masterSvc.CreatePublishingDestination(&guardduty.CreatePublishingDestinationInput{
+			DetectorId:            detectorID,
+			DestinationType:       aws.String(guardduty.DestinationTypeS3),
+			DestinationProperties: properties,
+		})
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error creating publishing destination: %w", err)
+		}
+		return Result{Status: StatusUpdated}, nil
+	}
+
+	destination, err := p.masterSvc.DescribePublishingDestination(&guardduty.DescribePublishingDestinationInput{
+		DetectorId:    detectorID,
+		DestinationId: destinationID,
+	})
+	if err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error describing publishing destination: %w", err)
+	}
+	existing := destination.DestinationProperties
+	if existing != nil && aws.StringValue(existing.DestinationArn) == p.bucketARN &&
+		aws.StringValue(existing.KmsKeyArn) == p.kmsKeyARN {
+		return Result{Status: StatusAlreadyConnected}, nil
+	}
+
+	_, err = p.masterSvc.UpdatePublishingDestination(&guardduty.UpdatePublishingDestinationInput{
+		DetectorId:            detectorID,
+		DestinationId:         destinationID,
+		DestinationProperties: properties,
+	})
+	if err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error updating publishing destination: %w", err)
+	}
+	return Result{Status: StatusUpdated}, nil
+}
+
+// getS3PublishingDestinationID returns ID of S3 publishing destination of the detector, or nil in case there is none.
+// Detector can have only one destination of every type.
+func getS3PublishingDestinationID(g GuardDutyPublishingClient, detectorID *string) (*string, error) {
+	input := &guardduty.ListPublishingDestinationsInput{DetectorId: detectorID}
+	for {
+		out, err := g.ListPublishingDestinations(input)
+		if err != nil {
+			return nil, fmt.Errorf("error listing publishing destinations: %w", err)
+		}
+		for _, d := range out.Destinations {
+			if aws.StringValue(d.DestinationType) == guardduty.DestinationTypeS3 {
+				return d.DestinationId, nil
+			}
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			return nil, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGuardDutyPublishingConfigurer_ConfigureDestination(t *testing.T) {
+	var (
+		detectorID    = "detector1"
+		destinationID = "destination1"
+		bucketARN     = "arn:aws:s3:::findings-bucket"
+		kmsKeyARN     = "arn:aws:kms:eu-west-1:123456789012:key/key1"
+		badDReq       = gdDetectorReq{err: fmt.Errorf("mock err")}
+		goodDReq      = gdDetectorReq{output: &guardduty.ListDetectorsOutput{DetectorIds: []*string{&detectorID}}}
+		badLPDReq     = gdListPublishingDestinationsReq{err: fmt.Errorf("mock err")}
+		noLPDReq      = gdListPublishingDestinationsReq{pages: []*guardduty.ListPublishingDestinationsOutput{{}}}
+		goodLPDReq    = gdListPublishingDestinationsReq{pages: []*guardduty.ListPublishingDestinationsOutput{
+			{NextToken: aws.String("page2")},
+			{Destinations: []*guardduty.Destination{{
+				DestinationId: aws.String(destinationID), DestinationType: aws.String(guardduty.DestinationTypeS3)}}},
+		}}
+		badDPDReq   = gdDescribePublishingDestinationReq{err: fmt.Errorf("mock err")}
+		equalDPDReq = gdDescribePublishingDestinationReq{output: &guardduty.DescribePublishingDestinationOutput{
+			DestinationProperties: &guardduty.DestinationProperties{
+				DestinationArn: aws.String(bucketARN), KmsKeyArn: aws.String(kmsKeyARN)}}}
+		otherBucketDPDReq = gdDescribePublishingDestinationReq{output: &guardduty.DescribePublishingDestinationOutput{
+			DestinationProperties: &guardduty.DestinationProperties{
+				DestinationArn: aws.String("arn:aws:s3:::old-bucket"), KmsKeyArn: aws.String(kmsKeyARN)}}}
+		otherKeyDPDReq = gdDescribePublishingDestinationReq{output: &guardduty.DescribePublishingDestinationOutput{
+			DestinationProperties: &guardduty.DestinationProperties{
+				DestinationArn: aws.String(bucketARN), KmsKeyArn: aws.String("arn:aws:kms:eu-west-1:123456789012:key/old")}}}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		status      Status
+		dReq        gdDetectorReq
+		lpdReq      gdListPublishingDestinationsReq
+		dpdReq      gdDescribePublishingDestinationReq
+		cpdErr      error
+		updErr      error
+		created     bool
+		updated     bool
+	}{
+		{description: "problem getting detector",
+			dReq:  badDReq,
+			error: "can't get detectorID of master account: error listing detectors: mock err"},
+		{description: "problem listing destinations",
+			dReq:   goodDReq,
+			lpdReq: badLPDReq,
+			error:  "error listing publishing destinations: mock err"},
+		{description: "problem creating destination",
+			dReq:    goodDReq,
+			lpdReq:  noLPDReq,
+			cpdErr:  fmt.Errorf("mock err"),
+			created: true,
+			error:   "error creating publishing destination: mock err"},
+		{description: "destination created",
+			dReq:    goodDReq,
+			lpdReq:  noLPDReq,
+			created: true,
+			status:  StatusUpdated},
+		{description: "problem describing destination",
+			dReq:   goodDReq,
+			lpdReq: goodLPDReq,
+			dpdReq: badDPDReq,
+			error:  "error describing publishing destination: mock err"},
+		{description: "destination configured already",
+			dReq:   goodDReq,
+			lpdReq: goodLPDReq,
+			dpdReq: equalDPDReq,
+			status: StatusAlreadyConnected},
+		{description: "problem updating destination",
+			dReq:    goodDReq,
+			lpdReq:  goodLPDReq,
+			dpdReq:  otherBucketDPDReq,
+			updErr:  fmt.Errorf("mock err"),
+			updated: true,
+			error:   "error updating publishing destination: mock err"},
+		{description: "destination bucket updated",
+			dReq:    goodDReq,
+			lpdReq:  goodLPDReq,
+			dpdReq:  otherBucketDPDReq,
+			updated: true,
+			status:  StatusUpdated},
+		{description: "destination KMS key updated",
+			dReq:    goodDReq,
+			lpdReq:  goodLPDReq,
+			dpdReq:  otherKeyDPDReq,
+			updated: true,
+			status:  StatusUpdated},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			m := &mockGDPublishingClient{mockGDDetectorClient: mockGDDetectorClient{t: t, dReq: x.dReq},
+				detectorID: detectorID, destinationID: destinationID, bucketARN: bucketARN, kmsKeyARN: kmsKeyARN,
+				lpdReq: x.lpdReq, dpdReq: x.dpdReq, cpdErr: x.cpdErr, updErr: x.updErr}
+			p := GuardDutyPublishingConfigurer{masterSvc: m, bucketARN: bucketARN, kmsKeyARN: kmsKeyARN}
+			res, err := p.ConfigureDestination()
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				assert.Equal(t, StatusFailed, res.Status, "Test case %d status check failed", i)
+				var serviceErr *ServiceError
+				if assert.ErrorAs(t, err, &serviceErr, "Test case %d error type check failed", i) {
+					assert.Equal(t, "guardduty_publishing", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+				assert.Equal(t, x.status, res.Status, "Test case %d status check failed", i)
+			}
+			assert.Equal(t, x.created, m.created, "Test case %d destination creation check failed", i)
+			assert.Equal(t, x.updated, m.updated, "Test case %d destination update check failed", i)
+		})
+	}
+}
+
+type mockGDPublishingClient struct {
+	mockGDDetectorClient
+	detectorID    string
+	destinationID string
+	bucketARN     string
+	kmsKeyARN     string
+	lpdReq        gdListPublishingDestinationsReq
+	lpdCalls      int
+	dpdReq        gdDescribePublishingDestinationReq
+	cpdErr        error
+	updErr        error
+	created       bool
+	updated       bool
+}
+
+type gdListPublishingDestinationsReq struct {
+	pages []*guardduty.ListPublishingDestinationsOutput
+	err   error
+}
+type gdDescribePublishingDestinationReq struct {
+	output *guardduty.DescribePublishingDestinationOutput
+	err    error
+}
+
+func (s *mockGDPublishingClient) ListPublishingDestinations(input *guardduty.ListPublishingDestinationsInput) (*guardduty.ListPublishingDestinationsOutput, error) {
+	if s.lpdReq.err != nil {
+		return nil, s.lpdReq.err
+	}
+	// every page after the first one is requested with the token of the previous page
+	expected := &guardduty.ListPublishingDestinationsInput{DetectorId: &s.detectorID}
+	if s.lpdCalls > 0 {
+		expected.NextToken = s.lpdReq.pages[s.lpdCalls-1].NextToken
+	}
+	assert.Equal(s.t, expected, input)
+	page := s.lpdReq.pages[s.lpdCalls]
+	s.lpdCalls++
+	return page, nil
+}
+
+func (s *mockGDPublishingClient) DescribePublishingDestination(input *guardduty.DescribePublishingDestinationInput) (*guardduty.DescribePublishingDestinationOutput, error) {
+	assert.Equal(s.t, &guardduty.DescribePublishingDestinationInput{
+		DetectorId:    &s.detectorID,
+		DestinationId: &s.destinationID,
+	}, input)
+	return s.dpdReq.output, s.dpdReq.err
+}
+
+func (s *mockGDPublishingClient) CreatePublishingDestination(input *guardduty.CreatePublishingDestinationInput) (*guardduty.CreatePublishingDestinationOutput, error) {
+	assert.Equal(s.t, &guardduty.CreatePublishingDestinationInput{
+		DetectorId:      &s.detectorID,
+		DestinationType: aws.String(guardduty.DestinationTypeS3),
+		DestinationProperties: &guardduty.DestinationProperties{
+			DestinationArn: &s.bucketARN,
+			KmsKeyArn:      &s.kmsKeyARN,
+		},
+	}, input)
+	s.created = true
+	return &guardduty.CreatePublishingDestinationOutput{DestinationId: &s.destinationID}, s.cpdErr
+}
+
+func (s *mockGDPublishingClient) UpdatePublishingDestination(input *guardduty.UpdatePublishingDestinationInput) (*guardduty.UpdatePublishingDestinationOutput, error) {
+	assert.Equal(s.t, &guardduty.UpdatePublishingDestinationInput{
+		DetectorId:    &s.detectorID,
+		DestinationId: &s.destinationID,
+		DestinationProperties: &guardduty.DestinationProperties{
+			DestinationArn: &s.bucketARN,
+			KmsKeyArn:      &s.kmsKeyARN,
+		},
+	}, input)
+	s.updated = true
+	return &guardduty.UpdatePublishingDestinationOutput{}, s.updErr
+}
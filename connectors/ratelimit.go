@@ -0,0 +1,90 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// RateLimiter is a token bucket limiting the rate of AWS API requests made through all sessions it's added to.
+// Bucket holds a single token, so requests are evenly spaced. It's safe for concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	// next is the time when the next request is allowed
+	next  time.Time
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRateLimiter returns limiter allowing provided number of requests per second.
+func NewRateLimiter(rate float64) *RateLimiter {
+	return &RateLimiter{
+		interval: time.Duration(float64(time.Second) / rate),
+		now:      time.Now,
+		sleep:    sleepContext,
+	}
+}
+
+// Wait blocks until the next request is allowed, or until provided context is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	return l.sleep(ctx, wait)
+}
+
+// sleepContext pauses for provided duration, returning early with error in case context is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addRateLimiter makes every request attempt of the session clients wait for provided limiter,
+// session is returned as is in case limiter is nil. Limiter is added to the session instead of ClientFactory,
+// so that inviter clients created by the factory inherit it along with all other clients of the session.
+func addRateLimiter(sess *session.Session, limiter *RateLimiter) *session.Session {
+	if limiter != nil {
+		sess.Handlers.Sign.PushFrontNamed(request.NamedHandler{
+			Name: "connectors.RateLimiter",
+			Fn: func(r *request.Request) {
+				if err := limiter.Wait(r.Context()); err != nil {
+					r.Error = err
+				}
+			},
+		})
+	}
+	return sess
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/detective"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	var sleeps []time.Duration
+	l := NewRateLimiter(4)
+	l.now = func() time.Time { return now }
+	l.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return ctx.Err()
+	}
+
+	// requests made at once are spaced by the interval
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	assert.Equal(t, []time.Duration{250 * time.Millisecond, 500 * time.Millisecond}, sleeps)
+
+	// after a pause, tokens are not accumulated beyond a single one
+	now = start.Add(10 * time.Second)
+	sleeps = nil
+	for i := 0; i < 2; i++ {
+		require.NoError(t, l.Wait(context.Background()))
+	}
+	assert.Equal(t, []time.Duration{250 * time.Millisecond}, sleeps)
+
+	// waiting is interrupted once context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, l.Wait(ctx), context.Canceled)
+}
+
+func TestRateLimiter_SharedBySessions(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "master_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "master_secret")
+	t.Setenv("AWS_PROFILE", "")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<GetCallerIdentityResponse><GetCallerIdentityResult>
+<Account>665544332211</Account></GetCallerIdentityResult></GetCallerIdentityResponse>`))
+	}))
+	defer ts.Close()
+
+	// sessions of different regions are limited together, with calls made in parallel,
+	// so the last of them waits for three intervals at least
+	limiter := NewRateLimiter(20)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, region := range []string{"eu-west-1", "us-east-1", "us-west-2", "ap-east-1"} {
+		sess := NewMasterSess(SessionConfig{Region: region, Endpoint: ts.URL, RateLimiter: limiter})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := sts.New(sess).GetCallerIdentity(nil)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestRateLimiter_InviterClients(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "master_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "master_secret")
+	t.Setenv("AWS_PROFILE", "")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	// clients of all inviters are limited by the limiter of the session they're created for
+	now := time.Now()
+	var waits []time.Duration
+	limiter := NewRateLimiter(1)
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	sess := NewMasterSess(SessionConfig{Region: "eu-west-1", Endpoint: ts.URL, RateLimiter: limiter})
+	inviters := NewInviters(sess, sess, InvitersConfig{GuardDuty: true, SecurityHub: true, Detective: true})
+	require.Len(t, inviters, 3)
+
+	_, err := inviters[0].(*GuardDutyInviter).masterSvc.ListMembers(&guardduty.ListMembersInput{DetectorId: aws.String("detector")})
+	assert.NoError(t, err)
+	_, err = inviters[1].(*SecurityHubInviter).masterSvc.ListMembers(&securityhub.ListMembersInput{})
+	assert.NoError(t, err)
+	_, err = inviters[2].(*DetectiveInviter).masterSvc.ListGraphs(&detective.ListGraphsInput{})
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, waits)
+}
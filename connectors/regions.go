@@ -0,0 +1,148 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/account"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+)
+
+// regionOptInPollInterval is the interval between region status checks while waiting for it to be enabled
+const regionOptInPollInterval = 30 * time.Second
+
+// RegionsDescriber is a subset of aws-sdk-go/service/ec2 which is used for listing regions enabled for account.
+type RegionsDescriber interface {
+	DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error)
+}
+
+// EnabledRegions returns sorted list of regions enabled for the account of provided session,
+// which are regions not requiring opt-in and opted-in ones.
+func EnabledRegions(sess client.ConfigProvider) ([]string, error) {
+	return enabledRegions(ec2.New(sess))
+}
+
+func enabledRegions(e RegionsDescriber) ([]string, error) {
+	out, err := e.DescribeRegions(&ec2.DescribeRegionsInput{AllRegions: aws.Bool(false)})
+	if err != nil {
+		return nil, fmt.Errorf("error describing regions: %w", err)
+	}
+	regions := make([]string, 0, len(out.Regions))
+	for _, r := range out.Regions {
+		// with AllRegions=false only enabled regions are returned, but the status is checked just in case
+		if aws.StringValue(r.OptInStatus) == "not-opted-in" {
+			continue
+		}
+		regions = append(regions, aws.StringValue(r.RegionName))
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// RegionOptInClient is a subset of aws-sdk-go/service/account which is used for enabling opt-in regions.
+type RegionOptInClient interface {
+	GetRegionOptStatus(*account.GetRegionOptStatusInput) (*account.GetRegionOptStatusOutput, error)
+	EnableRegion(*account.EnableRegionInput) (*account.EnableRegionOutput, error)
+}
+
+// RegionEnabler enables opt-in regions for accounts and waits for them to become enabled.
+type RegionEnabler struct {
+	svc          RegionOptInClient
+	pollInterval time.Duration
+	timeout      time.Duration
+	sleep        func(ctx context.Context, d time.Duration) error
+	log          *log.Entry
+}
+
+// NewRegionEnabler creates new instance of RegionEnabler which waits up to provided timeout
+// for the region to become enabled.
+// Provided session should belong to the organization management account or delegated administrator
+// in order to enable regions for other accounts.
+func NewRegionEnabler(sess client.ConfigProvider, timeout time.Duration) *RegionEnabler {
+	return &RegionEnabler{
+		svc:          account.New(sess),
+		pollInterval: regionOptInPollInterval,
+		timeout:      timeout,
+		sleep:        sleepContext,
+		log:          log.NewEntry(log.StandardLogger()),
+	}
+}
+
+// SetLogger makes RegionEnabler use provided logger for all messages instead of the standard one.
+func (e *RegionEnabler) SetLogger(logger *log.Entry) {
+	e.log = logger
+}
+
+// EnableRegion enables provided region for the account in case it's not enabled yet
+// and waits until the region status becomes enabled, or provided context is done.
+func (e *RegionEnabler) EnableRegion(ctx context.Context, accountID, region string) error {
+	status, err := e.getRegionOptStatus(accountID, region)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case account.RegionOptStatusEnabled, account.RegionOptStatusEnabledByDefault:
+		return nil
+	case account.RegionOptStatusDisabled:
+		e.log.Infof("Enabling region %s for account %s", region, accountID)
+		if _, err = e.svc.EnableRegion(&account.EnableRegionInput{
+			AccountId:  aws.String(accountID),
+			RegionName: aws.String(region),
+		}); err != nil {
+			return fmt.Errorf("error enabling region: %w", err)
+		}
+	case account.RegionOptStatusEnabling:
+	default:
+		return fmt.Errorf("region can't be enabled while its status is %s", status)
+	}
+
+	for waited := time.Duration(0); ; waited += e.pollInterval {
+		status, err = e.getRegionOptStatus(accountID, region)
+		if err != nil {
+			return err
+		}
+		switch status {
+		case account.RegionOptStatusEnabled, account.RegionOptStatusEnabledByDefault:
+			return nil
+		case account.RegionOptStatusEnabling:
+		default:
+			return fmt.Errorf("unexpected region status %s while waiting for it to be enabled", status)
+		}
+		if waited >= e.timeout {
+			return fmt.Errorf("region wasn't enabled in %s", e.timeout)
+		}
+		if err := e.sleep(ctx, e.pollInterval); err != nil {
+			return fmt.Errorf("waiting for region to be enabled is interrupted: %w", err)
+		}
+	}
+}
+
+func (e *RegionEnabler) getRegionOptStatus(accountID, region string) (string, error) {
+	out, err := e.svc.GetRegionOptStatus(&account.GetRegionOptStatusInput{
+		AccountId:  aws.String(accountID),
+		RegionName: aws.String(region),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error getting region status: %w", err)
+	}
+	return aws.StringValue(out.RegionOptStatus), nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/account"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnabledRegions(t *testing.T) {
+	testData := []struct {
+		description string
+		output      *ec2.DescribeRegionsOutput
+		err         error
+		regions     []string
+		error       string
+	}{
+		{description: "problem describing regions",
+			err:   fmt.Errorf("mock err"),
+			error: "error describing regions: mock err"},
+		{description: "no regions",
+			output:  &ec2.DescribeRegionsOutput{},
+			regions: []string{}},
+		{description: "enabled regions are returned sorted",
+			output: &ec2.DescribeRegionsOutput{Regions: []*ec2.Region{
+				{RegionName: aws.String("us-east-1"), OptInStatus: aws.String("opt-in-not-required")},
+				{RegionName: aws.String("me-south-1"), OptInStatus: aws.String("opted-in")},
+				{RegionName: aws.String("ap-east-1"), OptInStatus: aws.String("not-opted-in")},
+				{RegionName: aws.String("eu-west-1"), OptInStatus: aws.String("opt-in-not-required")},
+			}},
+			regions: []string{"eu-west-1", "me-south-1", "us-east-1"}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			regions, err := enabledRegions(mockRegionsDescriber{t: t, output: x.output, err: x.err})
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.regions, regions, "Test case %d regions check failed", i)
+		})
+	}
+}
+
+type mockRegionsDescriber struct {
+	t      *testing.T
+	output *ec2.DescribeRegionsOutput
+	err    error
+}
+
+func (m mockRegionsDescriber) DescribeRegions(input *ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	assert.Equal(m.t, &ec2.DescribeRegionsInput{AllRegions: aws.Bool(false)}, input)
+	return m.output, m.err
+}
+
+func TestRegionEnabler_EnableRegion(t *testing.T) {
+	testData := []struct {
+		description string
+		statuses    []string
+		statusErr   error
+		enable      bool
+		enableErr   error
+		sleeps      int
+		error       string
+	}{
+		{description: "problem getting region status",
+			statusErr: fmt.Errorf("mock err"),
+			error:     "error getting region status: mock err"},
+		{description: "region enabled by default",
+			statuses: []string{account.RegionOptStatusEnabledByDefault}},
+		{description: "region already enabled",
+			statuses: []string{account.RegionOptStatusEnabled}},
+		{description: "region is being disabled",
+			statuses: []string{account.RegionOptStatusDisabling},
+			error:    "region can't be enabled while its status is DISABLING"},
+		{description: "problem enabling region",
+			statuses:  []string{account.RegionOptStatusDisabled},
+			enable:    true,
+			enableErr: fmt.Errorf("mock err"),
+			error:     "error enabling region: mock err"},
+		{description: "region enabled right away",
+			statuses: []string{account.RegionOptStatusDisabled, account.RegionOptStatusEnabled},
+			enable:   true},
+		{description: "region enabled after waiting",
+			statuses: []string{account.RegionOptStatusDisabled, account.RegionOptStatusEnabling,
+				account.RegionOptStatusEnabling, account.RegionOptStatusEnabled},
+			enable: true,
+			sleeps: 2},
+		{description: "region being enabled already",
+			statuses: []string{account.RegionOptStatusEnabling, account.RegionOptStatusEnabled}},
+		{description: "unexpected status while waiting",
+			statuses: []string{account.RegionOptStatusDisabled, account.RegionOptStatusEnabling,
+				account.RegionOptStatusDisabled},
+			enable: true,
+			sleeps: 1,
+			error:  "unexpected region status DISABLED while waiting for it to be enabled"},
+		{description: "timeout waiting for region",
+			statuses: []string{account.RegionOptStatusDisabled, account.RegionOptStatusEnabling,
+				account.RegionOptStatusEnabling, account.RegionOptStatusEnabling, account.RegionOptStatusEnabling},
+			enable: true,
+			sleeps: 3,
+			error:  "region wasn't enabled in 3m0s"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			client := &mockRegionOptInClient{t: t, statuses: x.statuses, statusErr: x.statusErr, enableErr: x.enableErr}
+			var sleeps int
+			e := &RegionEnabler{
+				svc:          client,
+				log:          log.NewEntry(log.StandardLogger()),
+				pollInterval: time.Minute,
+				timeout:      3 * time.Minute,
+				sleep: func(_ context.Context, d time.Duration) error {
+					assert.Equal(t, time.Minute, d)
+					sleeps++
+					return nil
+				},
+			}
+			err := e.EnableRegion(context.Background(), "123456789012", "me-south-1")
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.enable, client.enabled, "Test case %d enable check failed", i)
+			assert.Equal(t, x.sleeps, sleeps, "Test case %d sleeps check failed", i)
+		})
+	}
+
+	// waiting is interrupted once context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	client := &mockRegionOptInClient{t: t, statuses: []string{account.RegionOptStatusEnabling, account.RegionOptStatusEnabling}}
+	e := &RegionEnabler{svc: client, log: log.NewEntry(log.StandardLogger()), pollInterval: time.Hour, timeout: time.Hour,
+		sleep: sleepContext}
+	err := e.EnableRegion(ctx, "123456789012", "me-south-1")
+	assert.EqualError(t, err, "waiting for region to be enabled is interrupted: context canceled")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+type mockRegionOptInClient struct {
+	t         *testing.T
+	statuses  []string
+	statusErr error
+	enabled   bool
+	enableErr error
+}
+
+func (m *mockRegionOptInClient) GetRegionOptStatus(input *account.GetRegionOptStatusInput) (*account.GetRegionOptStatusOutput, error) {
+	assert.Equal(m.t, &account.GetRegionOptStatusInput{
+		AccountId:  aws.String("123456789012"),
+		RegionName: aws.String("me-south-1"),
+	}, input)
+	if m.statusErr != nil {
+		return nil, m.statusErr
+	}
+	if !assert.NotEmpty(m.t, m.statuses, "unexpected region status request") {
+		return nil, fmt.Errorf("no more statuses")
+	}
+	status := m.statuses[0]
+	m.statuses = m.statuses[1:]
+	return &account.GetRegionOptStatusOutput{RegionOptStatus: aws.String(status)}, nil
+}
+
+func (m *mockRegionOptInClient) EnableRegion(input *account.EnableRegionInput) (*account.EnableRegionOutput, error) {
+	assert.Equal(m.t, &account.EnableRegionInput{
+		AccountId:  aws.String("123456789012"),
+		RegionName: aws.String("me-south-1"),
+	}, input)
+	m.enabled = true
+	return &account.EnableRegionOutput{}, m.enableErr
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Report summarizes results of connecting member account to AWS services, per service and per region.
+type Report struct {
+	AccountID string                                  `json:"account_id"`
+	Services  map[string]map[string]ReportRegionEntry `json:"services"`
+	// PrismaStatus is a status of the account in Prisma after adding it there, empty in case it wasn't checked
+	PrismaStatus string `json:"prisma_status,omitempty"`
+	// PrismaDrift lists Prisma account fields which differ from desired ones, set in Prisma check-only mode only
+	PrismaDrift []string `json:"prisma_drift,omitempty"`
+}
+
+// ReportRegionEntry is a result of connecting member account to a single service in a single region.
+type ReportRegionEntry struct {
+	Status Status `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// MemberStatus is a status of member account in master as returned by the service, set in status mode only
+	MemberStatus string `json:"member_status,omitempty"`
+}
+
+// ServiceRegions partitions regions of a single service by whether the member account was newly connected there
+// (created, invited or accepted the invitation) or was already connected before.
+type ServiceRegions struct {
+	NewRegions      []string `json:"new_regions"`
+	ExistingRegions []string `json:"existing_regions"`
+}
+
+// NewReport creates empty Report for provided member account ID
+func NewReport(accountID string) *Report {
+	return &Report{
+		AccountID: accountID,
+		Services:  map[string]map[string]ReportRegionEntry{},
+	}
+}
+
+// Add records the result of AddMember call for given service and region.
+// In case of not nil error, the status is always set to StatusFailed.
+func (r *Report) Add(service, region string, res Result, err error) {
+	entry := ReportRegionEntry{Status: res.Status}
+	if err != nil {
+		entry.Status = StatusFailed
+		entry.Error = err.Error()
+	}
+	if _, ok := r.Services[service]; !ok {
+		r.Services[service] = map[string]ReportRegionEntry{}
+	}
+	r.Services[service][region] = entry
+}
+
+// RegionsByChange returns regions of every service partitioned into ones the member account was newly connected in
+// and ones it was already connected in, both sorted. Regions with failed, skipped or only checked services
+// are in neither list, and services having no such regions at all are omitted.
+func (r *Report) RegionsByChange() map[string]ServiceRegions {
+	regions := map[string]ServiceRegions{}
+	for service, entries := range r.Services {
+		sr := ServiceRegions{NewRegions: []string{}, ExistingRegions: []string{}}
+		for region, entry := range entries {
+			switch entry.Status {
+			case StatusInvited, StatusAccepted:
+				sr.NewRegions = append(sr.NewRegions, region)
+			case StatusAlreadyConnected, StatusUpdated:
+				sr.ExistingRegions = append(sr.ExistingRegions, region)
+			}
+		}
+		if len(sr.NewRegions) == 0 && len(sr.ExistingRegions) == 0 {
+			continue
+		}
+		sort.Strings(sr.NewRegions)
+		sort.Strings(sr.ExistingRegions)
+		regions[service] = sr
+	}
+	return regions
+}
+
+// MarshalJSON adds regions partitioned by RegionsByChange to the report as "regions" field.
+func (r Report) MarshalJSON() ([]byte, error) {
+	type report Report
+	return json.Marshal(struct {
+		report
+		Regions map[string]ServiceRegions `json:"regions,omitempty"`
+	}{report: report(r), Regions: r.RegionsByChange()})
+}
+
+// SummarizeReports returns a line with numbers of regions and services of provided reports, and numbers
+// of their results which are new connections, already connected members and failures, as in RegionsByChange
+func SummarizeReports(reports []*Report) string {
+	regions := map[string]bool{}
+	services := map[string]bool{}
+	var newCount, existingCount, failedCount int
+	for _, r := range reports {
+		for service, entries := range r.Services {
+			services[service] = true
+			for region, entry := range entries {
+				regions[region] = true
+				switch entry.Status {
+				case StatusInvited, StatusAccepted:
+					newCount++
+				case StatusAlreadyConnected, StatusUpdated:
+					existingCount++
+				case StatusFailed:
+					failedCount++
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("Processed %d regions across %d services: %d new, %d already connected, %d failed",
+		len(regions), len(services), newCount, existingCount, failedCount)
+}
+
+// AddMemberStatus records the result of MemberStatus call for given service and region.
+// In case of not nil error, the status is set to StatusFailed, and to StatusChecked otherwise.
+func (r *Report) AddMemberStatus(service, region, memberStatus string, err error) {
+	r.Add(service, region, Result{Status: StatusChecked}, err)
+	if err == nil {
+		entry := r.Services[service][region]
+		entry.MemberStatus = memberStatus
+		r.Services[service][region] = entry
+	}
+}
+
+// WriteFile writes the report in JSON format to the file with provided name
+func (r *Report) WriteFile(fileName string) error {
+	return writeJSONFile(fileName, r)
+}
+
+// WriteReportsFile writes provided reports of multiple accounts as JSON array to the file with provided name
+func WriteReportsFile(fileName string, reports []*Report) error {
+	return writeJSONFile(fileName, reports)
+}
+
+func writeJSONFile(fileName string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling report: %w", err)
+	}
+	if err := os.WriteFile(fileName, b, 0o600); err != nil {
+		return fmt.Errorf("error writing report to %s: %w", fileName, err)
+	}
+	return nil
+}
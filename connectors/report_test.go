@@ -0,0 +1,213 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport_WriteFile(t *testing.T) {
+	r := NewReport("112233445566")
+	r.Add("guardduty", "eu-west-1", Result{Status: StatusAlreadyConnected}, nil)
+	r.Add("guardduty", "us-east-1", Result{Status: StatusInvited}, nil)
+	r.Add("security_hub", "eu-west-1", Result{Status: StatusUpdated}, nil)
+	r.Add("security_hub", "us-east-1", Result{Status: StatusFailed}, fmt.Errorf("mock err"))
+	r.PrismaStatus = PrismaAccountStatusWarning
+
+	fileName := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, r.WriteFile(fileName))
+
+	b, err := os.ReadFile(fileName) // nolint:gosec
+	require.NoError(t, err)
+
+	var got Report
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, "112233445566", got.AccountID)
+	assert.Equal(t, map[string]map[string]ReportRegionEntry{
+		"guardduty": {
+			"eu-west-1": {Status: StatusAlreadyConnected},
+			"us-east-1": {Status: StatusInvited},
+		},
+		"security_hub": {
+			"eu-west-1": {Status: StatusUpdated},
+			"us-east-1": {Status: StatusFailed, Error: "mock err"},
+		},
+	}, got.Services)
+	assert.Equal(t, PrismaAccountStatusWarning, got.PrismaStatus)
+
+	var raw struct {
+		Regions map[string]ServiceRegions `json:"regions"`
+	}
+	require.NoError(t, json.Unmarshal(b, &raw))
+	assert.Equal(t, map[string]ServiceRegions{
+		"guardduty":    {NewRegions: []string{"us-east-1"}, ExistingRegions: []string{"eu-west-1"}},
+		"security_hub": {NewRegions: []string{}, ExistingRegions: []string{"eu-west-1"}},
+	}, raw.Regions)
+
+	assert.Error(t, r.WriteFile(filepath.Join(t.TempDir(), "no_such_dir", "report.json")))
+}
+
+func TestReport_RegionsByChange(t *testing.T) {
+	type add struct {
+		service string
+		region  string
+		status  Status
+		err     error
+	}
+	testData := []struct {
+		description string
+		adds        []add
+		expected    map[string]ServiceRegions
+	}{
+		{description: "empty report", expected: map[string]ServiceRegions{}},
+		{description: "mixed results",
+			adds: []add{
+				{"guardduty", "us-east-1", StatusInvited, nil},
+				{"guardduty", "eu-west-1", StatusAlreadyConnected, nil},
+				{"guardduty", "ap-south-1", StatusAccepted, nil},
+				{"guardduty", "us-west-2", StatusFailed, fmt.Errorf("mock err")},
+				{"detective", "eu-west-1", StatusUpdated, nil},
+				{"detective", "us-east-1", StatusSkipped, nil},
+			},
+			expected: map[string]ServiceRegions{
+				"guardduty": {NewRegions: []string{"ap-south-1", "us-east-1"}, ExistingRegions: []string{"eu-west-1"}},
+				"detective": {NewRegions: []string{}, ExistingRegions: []string{"eu-west-1"}},
+			}},
+		{description: "result with error is neither new nor existing",
+			adds:     []add{{"guardduty", "eu-west-1", StatusInvited, fmt.Errorf("mock err")}},
+			expected: map[string]ServiceRegions{}},
+		{description: "service with only skipped and checked regions is omitted",
+			adds: []add{
+				{"security_hub", "eu-west-1", StatusSkipped, nil},
+				{"security_hub", "us-east-1", StatusChecked, nil},
+			},
+			expected: map[string]ServiceRegions{}},
+		{description: "later result of the same region replaces earlier one",
+			adds: []add{
+				{"guardduty", "eu-west-1", StatusAlreadyConnected, nil},
+				{"guardduty", "eu-west-1", StatusInvited, nil},
+			},
+			expected: map[string]ServiceRegions{
+				"guardduty": {NewRegions: []string{"eu-west-1"}, ExistingRegions: []string{}},
+			}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			r := NewReport("112233445566")
+			for _, a := range x.adds {
+				r.Add(a.service, a.region, Result{Status: a.status}, a.err)
+			}
+			assert.Equal(t, x.expected, r.RegionsByChange(), "Test case %d check failed", i)
+		})
+	}
+}
+
+func TestSummarizeReports(t *testing.T) {
+	type add struct {
+		accountID string
+		service   string
+		region    string
+		status    Status
+		err       error
+	}
+	testData := []struct {
+		description string
+		adds        []add
+		expected    string
+	}{
+		{description: "no reports", expected: "Processed 0 regions across 0 services: 0 new, 0 already connected, 0 failed"},
+		{description: "single account",
+			adds: []add{
+				{"112233445566", "guardduty", "us-east-1", StatusInvited, nil},
+				{"112233445566", "guardduty", "eu-west-1", StatusAlreadyConnected, nil},
+				{"112233445566", "guardduty", "us-west-2", StatusFailed, fmt.Errorf("mock err")},
+				{"112233445566", "security_hub", "us-east-1", StatusAccepted, nil},
+				{"112233445566", "security_hub", "eu-west-1", StatusUpdated, nil},
+				{"112233445566", "detective", "us-east-1", StatusSkipped, nil},
+				{"112233445566", "detective", "eu-west-1", StatusInvited, fmt.Errorf("mock err")},
+			},
+			expected: "Processed 3 regions across 3 services: 2 new, 2 already connected, 2 failed"},
+		{description: "several accounts",
+			adds: []add{
+				{"112233445566", "guardduty", "us-east-1", StatusInvited, nil},
+				{"665544332211", "guardduty", "us-east-1", StatusAlreadyConnected, nil},
+				{"665544332211", "detective", "eu-west-1", StatusChecked, nil},
+			},
+			expected: "Processed 2 regions across 2 services: 1 new, 1 already connected, 0 failed"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			reports := map[string]*Report{}
+			var list []*Report
+			for _, a := range x.adds {
+				r, ok := reports[a.accountID]
+				if !ok {
+					r = NewReport(a.accountID)
+					reports[a.accountID] = r
+					list = append(list, r)
+				}
+				r.Add(a.service, a.region, Result{Status: a.status}, a.err)
+			}
+			assert.Equal(t, x.expected, SummarizeReports(list), "Test case %d check failed", i)
+		})
+	}
+}
+
+func TestReport_AddMemberStatus(t *testing.T) {
+	r := NewReport("112233445566")
+	r.AddMemberStatus("guardduty", "eu-west-1", "Enabled", nil)
+	r.AddMemberStatus("guardduty", "us-east-1", MemberStatusNotMember, nil)
+	r.AddMemberStatus("detective", "eu-west-1", "", fmt.Errorf("mock err"))
+
+	assert.Equal(t, map[string]map[string]ReportRegionEntry{
+		"guardduty": {
+			"eu-west-1": {Status: StatusChecked, MemberStatus: "Enabled"},
+			"us-east-1": {Status: StatusChecked, MemberStatus: MemberStatusNotMember},
+		},
+		"detective": {
+			"eu-west-1": {Status: StatusFailed, Error: "mock err"},
+		},
+	}, r.Services)
+}
+
+func TestWriteReportsFile(t *testing.T) {
+	r1 := NewReport("112233445566")
+	r1.Add("guardduty", "eu-west-1", Result{Status: StatusInvited}, nil)
+	r2 := NewReport("665544332211")
+	r2.Add("guardduty", "eu-west-1", Result{Status: StatusFailed}, fmt.Errorf("mock err"))
+
+	fileName := filepath.Join(t.TempDir(), "report.json")
+	require.NoError(t, WriteReportsFile(fileName, []*Report{r1, r2}))
+
+	b, err := os.ReadFile(fileName) // nolint:gosec
+	require.NoError(t, err)
+
+	var got []*Report
+	require.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, []*Report{r1, r2}, got)
+}
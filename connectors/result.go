@@ -0,0 +1,59 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+// Status describes what happened to the member account during AddMember call.
+type Status string
+
+// Possible AddMember outcomes.
+const (
+	// StatusAlreadyConnected means member was already connected to master, nothing was done.
+	StatusAlreadyConnected Status = "already_connected"
+	// StatusInvited means member was created in master, invited and invitation was accepted.
+	StatusInvited Status = "invited"
+	// StatusUpdated means member was already present in master but not connected,
+	// and invitation was (re)sent and accepted, or its configuration was updated.
+	StatusUpdated Status = "updated"
+	// StatusAccepted means member was already invited, and only the pending invitation was accepted.
+	StatusAccepted Status = "accepted"
+	// StatusFailed means there was an error while connecting the member.
+	StatusFailed Status = "failed"
+	// StatusChecked means only read-only checks were made and they passed, nothing was changed.
+	StatusChecked Status = "checked"
+	// StatusSkipped means the service is configured to be skipped in the region, nothing was done.
+	StatusSkipped Status = "skipped"
+)
+
+// MemberStatusNotMember is returned by MemberStatus in case account is not a member of master account.
+const MemberStatusNotMember = "NotMember"
+
+// Result is returned by AddMember and describes the outcome of connecting a member account.
+type Result struct {
+	Status Status `json:"status"`
+}
+
+// addMemberResult returns the outcome of successful AddMember call for the member which had memberStatus
+// in master before the call, invitedStatus is the service status of member with pending invitation,
+// and resent is set in case pending invitation wasn't found and was sent again.
+func addMemberResult(memberStatus, invitedStatus string, resent bool) Result {
+	switch {
+	case memberStatus == "":
+		return Result{Status: StatusInvited}
+	case memberStatus == invitedStatus && !resent:
+		return Result{Status: StatusAccepted}
+	default:
+		return Result{Status: StatusUpdated}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+// PrismaSecret is Prisma API credentials stored in AWS Secrets Manager secret as JSON,
+// e.g. {"api_key": "...", "api_password": "..."}
+type PrismaSecret struct {
+	APIKey      string `json:"api_key"`
+	APIPassword string `json:"api_password"`
+}
+
+// SecretsManagerClient is a subset of aws-sdk-go/service/secretsmanager which is used for reading secrets.
+type SecretsManagerClient interface {
+	GetSecretValue(*secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// LoadPrismaSecret reads Prisma API credentials from AWS Secrets Manager secret with provided ARN.
+func LoadPrismaSecret(sess client.ConfigProvider, arn string) (PrismaSecret, error) {
+	return loadPrismaSecret(secretsmanager.New(sess), arn)
+}
+
+func loadPrismaSecret(s SecretsManagerClient, arn string) (PrismaSecret, error) {
+	value, err := loadSecretString(s, arn)
+	if err != nil {
+		return PrismaSecret{}, err
+	}
+
+	var secret PrismaSecret
+	if err := json.Unmarshal([]byte(value), &secret); err != nil {
+		return PrismaSecret{}, fmt.Errorf("error unmarshalling secret: %w", err)
+	}
+	if secret.APIKey == "" || secret.APIPassword == "" {
+		return PrismaSecret{}, errors.New("secret should have both api_key and api_password set")
+	}
+	return secret, nil
+}
+
+// LoadSecretString returns string value of AWS Secrets Manager secret with provided ARN.
+func LoadSecretString(sess client.ConfigProvider, arn string) (string, error) {
+	return loadSecretString(secretsmanager.New(sess), arn)
+}
+
+func loadSecretString(s SecretsManagerClient, arn string) (string, error) {
+	out, err := s.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: &arn})
+	if err != nil {
+		return "", fmt.Errorf("error getting secret value: %w", err)
+	}
+	if out.SecretString == nil {
+		return "", errors.New("secret has no string value")
+	}
+	return *out.SecretString, nil
+}
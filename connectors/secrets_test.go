@@ -0,0 +1,84 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPrismaSecret(t *testing.T) {
+	const secretARN = "arn:aws:secretsmanager:eu-west-1:111111111111:secret:prisma-AbCdEf"
+
+	var testData = []struct {
+		description string
+		gsvReq      smGetSecretValueReq
+		error       string
+		secret      PrismaSecret
+	}{
+		{description: "problem getting secret value",
+			gsvReq: smGetSecretValueReq{err: fmt.Errorf("mock err")},
+			error:  "error getting secret value: mock err"},
+		{description: "binary secret",
+			gsvReq: smGetSecretValueReq{output: &secretsmanager.GetSecretValueOutput{SecretBinary: []byte("mock")}},
+			error:  "secret has no string value"},
+		{description: "malformed secret JSON",
+			gsvReq: smGetSecretValueReq{output: &secretsmanager.GetSecretValueOutput{SecretString: aws.String("not_json")}},
+			error:  "error unmarshalling secret: invalid character 'o' in literal null (expecting 'u')"},
+		{description: "secret without password",
+			gsvReq: smGetSecretValueReq{output: &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(`{"api_key":"test_key"}`)}},
+			error: "secret should have both api_key and api_password set"},
+		{description: "secret loaded",
+			gsvReq: smGetSecretValueReq{output: &secretsmanager.GetSecretValueOutput{
+				SecretString: aws.String(`{"api_key":"test_key","api_password":"test_password"}`)}},
+			secret: PrismaSecret{APIKey: "test_key", APIPassword: "test_password"}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			s := mockSecretsManagerClient{t: t, arn: secretARN, gsvReq: x.gsvReq}
+			secret, err := loadPrismaSecret(s, secretARN)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.secret, secret, "Test case %d secret check failed", i)
+		})
+	}
+}
+
+type mockSecretsManagerClient struct {
+	t      *testing.T
+	arn    string
+	gsvReq smGetSecretValueReq
+}
+
+type smGetSecretValueReq struct {
+	output *secretsmanager.GetSecretValueOutput
+	err    error
+}
+
+func (s mockSecretsManagerClient) GetSecretValue(input *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	assert.Equal(s.t, &secretsmanager.GetSecretValueInput{SecretId: &s.arn}, input)
+	return s.gsvReq.output, s.gsvReq.err
+}
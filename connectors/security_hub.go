@@ -15,10 +15,17 @@
 package connectors
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/service/securityhub"
+	log "github.com/sirupsen/logrus"
 )
 
 // SecurityHubInviter is a per-region structure which contains all information
@@ -26,14 +33,37 @@ import (
 type SecurityHubInviter struct {
 	masterSvc SecurityHubMasterClient
 	memberSvc SecurityHubMemberClient
+	// suppressInviteEmails makes member to be created without email, so that invitation email is not sent
+	suppressInviteEmails bool
+	// standards to enable on member account
+	standards []string
+	// controls to disable on member account, by standard they belong to
+	disabledControls map[string][]string
+	// standardWaiter polls just enabled standard until it's ready, so that its controls could be disabled
+	standardWaiter *standardWaiter
+	// connectedStatuses are member statuses meaning that member is connected to master, "Associated" if empty
+	connectedStatuses []string
+	// waiter polls member status after invitation is accepted until it's enabled, no waiting is done if nil
+	waiter *memberWaiter
+	// retryer retries looking for just sent invitation in member account, it's looked for once if nil
+	retryer *invitationRetryer
+	// log is an entry with context fields, like account ID and region, used for all messages
+	log *log.Entry
 }
 
 // SecurityHubMasterClient is a subset of aws-sdk-go/service/securityhub which is used for sending
 // invitations from Security Hub master.
 type SecurityHubMasterClient interface {
 	GetMembers(*securityhub.GetMembersInput) (*securityhub.GetMembersOutput, error)
+	ListMembers(*securityhub.ListMembersInput) (*securityhub.ListMembersOutput, error)
 	CreateMembers(*securityhub.CreateMembersInput) (*securityhub.CreateMembersOutput, error)
 	InviteMembers(*securityhub.InviteMembersInput) (*securityhub.InviteMembersOutput, error)
+	ListOrganizationAdminAccounts(*securityhub.ListOrganizationAdminAccountsInput) (*securityhub.ListOrganizationAdminAccountsOutput, error)
+	EnableOrganizationAdminAccount(*securityhub.EnableOrganizationAdminAccountInput) (*securityhub.EnableOrganizationAdminAccountOutput, error)
+	ListFindingAggregators(*securityhub.ListFindingAggregatorsInput) (*securityhub.ListFindingAggregatorsOutput, error)
+	GetFindingAggregator(*securityhub.GetFindingAggregatorInput) (*securityhub.GetFindingAggregatorOutput, error)
+	CreateFindingAggregator(*securityhub.CreateFindingAggregatorInput) (*securityhub.CreateFindingAggregatorOutput, error)
+	UpdateFindingAggregator(*securityhub.UpdateFindingAggregatorInput) (*securityhub.UpdateFindingAggregatorOutput, error)
 }
 
 // SecurityHubMemberClient is a subset of aws-sdk-go/service/securityhub which is used for accepting
@@ -41,67 +71,403 @@ type SecurityHubMasterClient interface {
 type SecurityHubMemberClient interface {
 	ListInvitations(*securityhub.ListInvitationsInput) (*securityhub.ListInvitationsOutput, error)
 	AcceptInvitation(*securityhub.AcceptInvitationInput) (*securityhub.AcceptInvitationOutput, error)
+	DescribeStandards(*securityhub.DescribeStandardsInput) (*securityhub.DescribeStandardsOutput, error)
+	GetEnabledStandards(*securityhub.GetEnabledStandardsInput) (*securityhub.GetEnabledStandardsOutput, error)
+	BatchEnableStandards(*securityhub.BatchEnableStandardsInput) (*securityhub.BatchEnableStandardsOutput, error)
+	DescribeStandardsControls(*securityhub.DescribeStandardsControlsInput) (*securityhub.DescribeStandardsControlsOutput, error)
+	UpdateStandardsControl(*securityhub.UpdateStandardsControlInput) (*securityhub.UpdateStandardsControlOutput, error)
 }
 
 // NewSecurityHubInviter creates new instance of SecurityHubInviter which is capable of inviting
-// specified member account to master account SecurityHub
-func NewSecurityHubInviter(masterSess, memberSess client.ConfigProvider) *SecurityHubInviter {
+// specified member account to master account SecurityHub.
+// Security Hub API has no option to disable invitation emails like GuardDuty does, so in case
+// suppressInviteEmails is set the member is created without email address.
+// Standards are identified by the end of their ARN like "aws-foundational-security-best-practices/v/1.0.0",
+// as full ARN depends on the region.
+func NewSecurityHubInviter(masterSess, memberSess client.ConfigProvider, suppressInviteEmails bool, standards []string) *SecurityHubInviter {
+	return NewSecurityHubInviterWithFactory(NewClientFactory(), masterSess, memberSess, suppressInviteEmails, standards)
+}
+
+// NewSecurityHubInviterWithFactory is the same as NewSecurityHubInviter, but creates clients with provided factory.
+func NewSecurityHubInviterWithFactory(f *ClientFactory, masterSess, memberSess client.ConfigProvider, suppressInviteEmails bool, standards []string) *SecurityHubInviter {
 	return &SecurityHubInviter{
-		masterSvc: securityhub.New(masterSess),
-		memberSvc: securityhub.New(memberSess),
+		masterSvc:            f.SecurityHub(masterSess),
+		memberSvc:            f.SecurityHub(memberSess),
+		suppressInviteEmails: suppressInviteEmails,
+		standards:            standards,
+		standardWaiter:       newStandardWaiter(context.Background()),
+		log:                  log.NewEntry(log.StandardLogger()),
 	}
 }
 
+// Name returns "security_hub", identifier of the service.
+func (s SecurityHubInviter) Name() string {
+	return "security_hub"
+}
+
 // AddMember adds new member account to master, sends invite to it,
 // and then accepts invite from the member account.
 // In case the member is already in place and connected (enabled), nothing is done.
+// Returned error is *ServiceError, which can be inspected with errors.Is and errors.As.
 // https://docs.aws.amazon.com/securityhub/latest/userguide/securityhub-accounts.html
-func (s SecurityHubInviter) AddMember(accountID, accountEmail, masterAccountID string) error {
-	connected, err := ifSecurityHubMemberAlreadyAssociated(s.masterSvc, &accountID)
+func (s SecurityHubInviter) AddMember(accountID, accountEmail, masterAccountID string) (Result, error) {
+	res, err := s.addMember(accountID, accountEmail, masterAccountID)
+	logResult(s.log.WithField("service", s.Name()), res, err)
+	return res, newServiceError(s.Name(), err)
+}
+
+func (s SecurityHubInviter) addMember(accountID, accountEmail, masterAccountID string) (Result, error) {
+	status, err := getSecurityHubMemberStatus(s.masterSvc, &accountID)
+	if err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return Result{Status: StatusFailed}, fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	connected := connectedStatuses(s.connectedStatuses, securityHubConnectedStatus)
+	if contains(connected, status) {
+		updated, err := enableSecurityHubStandards(s.memberSvc, s.standards)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error enabling standards in member account: %w", err)
+		}
+		disabled, err := disableSecurityHubStandardsControls(s.memberSvc, s.standardWaiter, s.disabledControls)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error disabling controls in member account: %w", err)
+		}
+		if updated || disabled {
+			return Result{Status: StatusUpdated}, nil
+		}
+		return Result{Status: StatusAlreadyConnected}, nil
+	}
+
+	email := &accountEmail
+	if s.suppressInviteEmails {
+		email = nil
+	}
+	// invited member already has the invitation sent, so only accepting it is left
+	if status != "Invited" {
+		err = setUpSecurityHubMaster(s.masterSvc, &accountID, email)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error setting up master account: %w", err)
+		}
+	}
+
+	accept := func() error { return acceptSecurityHubMemberInvitation(s.memberSvc, &masterAccountID) }
+	// invitation which was just sent might not be visible in member account yet, so looking for it is retried
+	var resent bool
+	if status == "Invited" {
+		err = accept()
+	} else {
+		err = s.retryer.accept(accept)
+	}
+	if errors.Is(err, ErrInvitationMissing) && status == "Invited" {
+		// invitation might have expired, so it's sent again
+		s.log.WithField("service", s.Name()).Info("Invitation not found, re-sending it")
+		err = setUpSecurityHubMaster(s.masterSvc, &accountID, email)
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error re-sending invitation: %w", err)
+		}
+		resent = true
+		err = s.retryer.accept(accept)
+	}
 	if err != nil {
-		return fmt.Errorf("error retrieving information about existing member account: %w", err)
+		return Result{Status: StatusFailed}, fmt.Errorf("error accepting invitation in member account: %w", err)
 	}
-	if connected {
-		return nil
+
+	if s.waiter != nil {
+		err = s.waiter.waitForEnabled(func() (string, error) {
+			return getSecurityHubMemberStatus(s.masterSvc, &accountID)
+		}, connected, pendingMemberStatuses())
+		if err != nil {
+			return Result{Status: StatusFailed}, fmt.Errorf("error waiting for member account to be enabled: %w", err)
+		}
+	}
+
+	if _, err = enableSecurityHubStandards(s.memberSvc, s.standards); err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error enabling standards in member account: %w", err)
+	}
+	if _, err = disableSecurityHubStandardsControls(s.memberSvc, s.standardWaiter, s.disabledControls); err != nil {
+		return Result{Status: StatusFailed}, fmt.Errorf("error disabling controls in member account: %w", err)
+	}
+
+	return addMemberResult(status, "Invited", resent), nil
+}
+
+// ParseSecurityHubDisabledControls parses Security Hub controls in standard:control_id format, like
+// "aws-foundational-security-best-practices/v/1.0.0:IAM.6", into control IDs by standard.
+// Standard could be full ARN as well, so the last colon separates control ID.
+func ParseSecurityHubDisabledControls(specs []string) (map[string][]string, error) {
+	var controls map[string][]string
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		i := strings.LastIndex(spec, ":")
+		if i <= 0 || i == len(spec)-1 {
+			return nil, fmt.Errorf("invalid Security Hub control %q, it should be in standard:control_id format", spec)
+		}
+		if controls == nil {
+			controls = map[string][]string{}
+		}
+		standard := spec[:i]
+		controls[standard] = append(controls[standard], spec[i+1:])
+	}
+	return controls, nil
+}
+
+// DisableControls disables controls with provided IDs like "IAM.6" of enabled standard in member account,
+// controls which are disabled already are left as is. Standard is identified by its ARN or the end of it
+// like "aws-foundational-security-best-practices/v/1.0.0", same as for standards enabling.
+// Just enabled standard is waited to become ready first, as its controls can't be described before that.
+// https://docs.aws.amazon.com/securityhub/latest/userguide/disable-controls-standard.html
+func (s SecurityHubInviter) DisableControls(standardsArn string, controlIds []string) error {
+	_, err := disableSecurityHubControls(s.memberSvc, s.standardWaiter, standardsArn, controlIds)
+	return newServiceError(s.Name(), err)
+}
+
+// EnableOrgAdmin registers provided account as Security Hub delegated administrator of the organization
+// in case it's not registered already. Inviter should be created with the organization management account
+// session as master session for this call.
+// https://docs.aws.amazon.com/securityhub/latest/userguide/designate-orgs-admin-account.html
+func (s SecurityHubInviter) EnableOrgAdmin(adminAccountID string) error {
+	input := &securityhub.ListOrganizationAdminAccountsInput{}
+	for {
+		admins, err := s.masterSvc.ListOrganizationAdminAccounts(input)
+		if err != nil {
+			return newServiceError(s.Name(), fmt.Errorf("error listing delegated administrators: %w", err))
+		}
+		for _, admin := range admins.AdminAccounts {
+			if aws.StringValue(admin.Status) != securityhub.AdminStatusEnabled {
+				continue
+			}
+			if aws.StringValue(admin.AccountId) == adminAccountID {
+				return nil
+			}
+			return newServiceError(s.Name(),
+				fmt.Errorf("account %s is delegated administrator already", aws.StringValue(admin.AccountId)))
+		}
+		if aws.StringValue(admins.NextToken) == "" {
+			break
+		}
+		input.NextToken = admins.NextToken
 	}
 
-	err = setUpSecurityHubMaster(s.masterSvc, &accountID, &accountEmail)
+	_, err := s.masterSvc.EnableOrganizationAdminAccount(&securityhub.EnableOrganizationAdminAccountInput{
+		AdminAccountId: aws.String(adminAccountID),
+	})
 	if err != nil {
-		return fmt.Errorf("error setting up master account: %w", err)
+		return newServiceError(s.Name(), fmt.Errorf("error enabling delegated administrator: %w", err))
 	}
+	return nil
+}
+
+// securityHubDisabledReason is set on Security Hub controls disabled by DisableControls
+const securityHubDisabledReason = "Disabled by aws-security-connectors"
+
+// Interval between checks of just enabled Security Hub standard, and the time it's waited to become ready
+const (
+	standardPollInterval = 10 * time.Second
+	standardReadyTimeout = 5 * time.Minute
+)
+
+// standardWaiter polls status of enabled Security Hub standard until it's not pending anymore, or its context is done.
+type standardWaiter struct {
+	ctx          context.Context
+	pollInterval time.Duration
+	timeout      time.Duration
+	sleep        func(ctx context.Context, d time.Duration) error
+}
+
+// newStandardWaiter creates standardWaiter which waits up to standardReadyTimeout for standard to become ready,
+// waiting stops early once provided context is done.
+func newStandardWaiter(ctx context.Context) *standardWaiter {
+	return &standardWaiter{
+		ctx:          ctx,
+		pollInterval: standardPollInterval,
+		timeout:      standardReadyTimeout,
+		sleep:        sleepContext,
+	}
+}
+
+// waitForReady calls getSubscription until pending subscription of the standard is ready, error is returned in case
+// the subscription fails, disappears or is still pending after the timeout. Nil waiter doesn't wait and returns error.
+func (w *standardWaiter) waitForReady(standard string,
+	getSubscription func() (*securityhub.StandardsSubscription, error)) (*securityhub.StandardsSubscription, error) {
+	if w == nil {
+		return nil, fmt.Errorf("standard %q is not ready yet", standard)
+	}
+	for waited := w.pollInterval; ; waited += w.pollInterval {
+		if err := w.sleep(w.ctx, w.pollInterval); err != nil {
+			return nil, fmt.Errorf("waiting for standard %q to be ready is interrupted: %w", standard, err)
+		}
+		sub, err := getSubscription()
+		if err != nil {
+			return nil, err
+		}
+		if sub == nil {
+			return nil, fmt.Errorf("standard %q is not enabled", standard)
+		}
+		switch status := aws.StringValue(sub.StandardsStatus); status {
+		case securityhub.StandardsStatusPending:
+			if waited >= w.timeout {
+				return nil, fmt.Errorf("standard %q is still %s after %s", standard, status, w.timeout)
+			}
+		case securityhub.StandardsStatusFailed, securityhub.StandardsStatusDeleting:
+			return nil, fmt.Errorf("standard %q is %s instead of %s", standard, status, securityhub.StandardsStatusReady)
+		default:
+			return sub, nil
+		}
+	}
+}
+
+// Security Hub modes of linking regions to finding aggregation region
+const (
+	securityHubLinkAllRegions       = "ALL_REGIONS"
+	securityHubLinkSpecifiedRegions = "SPECIFIED_REGIONS"
+)
 
-	err = acceptSecurityHubMemberInvitation(s.memberSvc, &masterAccountID)
+// ConfigureFindingAggregation makes homeRegion the finding aggregation region of master account with provided
+// regions linked to it, or all regions in case linkedRegions is empty. Existing aggregator is updated in case
+// it links other regions, and nothing is done in case it's configured already. Inviter should be created with
+// the master account session in homeRegion for this call.
+// https://docs.aws.amazon.com/securityhub/latest/userguide/finding-aggregation.html
+func (s SecurityHubInviter) ConfigureFindingAggregation(homeRegion string, linkedRegions []string) error {
+	return newServiceError(s.Name(), configureSecurityHubFindingAggregation(s.masterSvc, homeRegion, linkedRegions))
+}
+
+func configureSecurityHubFindingAggregation(s SecurityHubMasterClient, homeRegion string, linkedRegions []string) error {
+	mode := securityHubLinkAllRegions
+	var regions []string
+	if len(linkedRegions) > 0 {
+		mode = securityHubLinkSpecifiedRegions
+		// home region aggregates its own findings and can't be linked to itself
+		for _, region := range linkedRegions {
+			if region != homeRegion {
+				regions = append(regions, region)
+			}
+		}
+		if len(regions) == 0 {
+			return fmt.Errorf("no regions to link to aggregation region %s", homeRegion)
+		}
+		sort.Strings(regions)
+	}
+
+	aggregators, err := s.ListFindingAggregators(&securityhub.ListFindingAggregatorsInput{})
+	if err != nil {
+		return fmt.Errorf("error listing finding aggregators: %w", err)
+	}
+	// there could be only one aggregator per account
+	if len(aggregators.FindingAggregators) == 0 {
+		input := &securityhub.CreateFindingAggregatorInput{RegionLinkingMode: aws.String(mode)}
+		if len(regions) > 0 {
+			input.Regions = aws.StringSlice(regions)
+		}
+		if _, err = s.CreateFindingAggregator(input); err != nil {
+			return fmt.Errorf("error creating finding aggregator: %w", err)
+		}
+		return nil
+	}
+
+	arn := aggregators.FindingAggregators[0].FindingAggregatorArn
+	aggregator, err := s.GetFindingAggregator(&securityhub.GetFindingAggregatorInput{FindingAggregatorArn: arn})
 	if err != nil {
-		return fmt.Errorf("error accepting invitation in member account: %w", err)
+		return fmt.Errorf("error getting finding aggregator: %w", err)
+	}
+	if region := aws.StringValue(aggregator.FindingAggregationRegion); region != homeRegion {
+		return fmt.Errorf("finding aggregation region is %s already", region)
+	}
+	current := aws.StringValueSlice(aggregator.Regions)
+	sort.Strings(current)
+	if aws.StringValue(aggregator.RegionLinkingMode) == mode && strings.Join(current, ",") == strings.Join(regions, ",") {
+		return nil
 	}
 
+	input := &securityhub.UpdateFindingAggregatorInput{FindingAggregatorArn: arn, RegionLinkingMode: aws.String(mode)}
+	if len(regions) > 0 {
+		input.Regions = aws.StringSlice(regions)
+	}
+	if _, err = s.UpdateFindingAggregator(input); err != nil {
+		return fmt.Errorf("error updating finding aggregator: %w", err)
+	}
 	return nil
 }
 
-// ifSecurityHubMemberAlreadyAssociated checks if member account is already present
-// in master and is in Associated state.
-func ifSecurityHubMemberAlreadyAssociated(s SecurityHubMasterClient, memberAccountID *string) (bool, error) {
+// MemberStatuses returns relationship statuses of all members of master account, keyed by member account ID.
+func (s SecurityHubInviter) MemberStatuses() (map[string]string, error) {
+	statuses, err := listSecurityHubMemberStatuses(s.masterSvc)
+	return statuses, newServiceError(s.Name(), err)
+}
+
+// MemberStatus returns status of member account in master, or MemberStatusNotMember
+// in case member account is not present there. Only read-only calls are made.
+func (s SecurityHubInviter) MemberStatus(accountID string) (string, error) {
+	status, err := getSecurityHubMemberStatus(s.masterSvc, &accountID)
+	if err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return "", newServiceError(s.Name(),
+			fmt.Errorf("error retrieving information about existing member account: %w", err))
+	}
+	return memberStatusOrNotMember(status), nil
+}
+
+// Preflight verifies permissions of master and member accounts by performing only read-only calls
+// which AddMember makes, nothing is changed.
+func (s SecurityHubInviter) Preflight(accountID string) error {
+	return newServiceError(s.Name(), s.preflight(accountID))
+}
+
+func (s SecurityHubInviter) preflight(accountID string) error {
+	if _, err := getSecurityHubMemberStatus(s.masterSvc, &accountID); err != nil && !errors.Is(err, ErrMemberNotFound) {
+		return fmt.Errorf("error retrieving information about existing member account: %w", err)
+	}
+	if _, err := s.memberSvc.ListInvitations(nil); err != nil {
+		return fmt.Errorf("error retrieving list of invitations: %w", err)
+	}
+	return nil
+}
+
+// listSecurityHubMemberStatuses returns relationship statuses of all members, including not associated ones
+func listSecurityHubMemberStatuses(s SecurityHubMasterClient) (map[string]string, error) {
+	return membersByStatus(func(nextToken *string) (map[string]string, *string, error) {
+		members, err := s.ListMembers(&securityhub.ListMembersInput{
+			NextToken:      nextToken,
+			OnlyAssociated: aws.Bool(false),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		statuses := map[string]string{}
+		for _, m := range members.Members {
+			statuses[aws.StringValue(m.AccountId)] = aws.StringValue(m.MemberStatus)
+		}
+		return statuses, members.NextToken, nil
+	})
+}
+
+// getSecurityHubMemberStatus returns status of member account in master,
+// ErrMemberNotFound is returned in case member account is not present there.
+func getSecurityHubMemberStatus(s SecurityHubMasterClient, memberAccountID *string) (string, error) {
 	members, err := s.GetMembers(&securityhub.GetMembersInput{
 		AccountIds: []*string{memberAccountID},
 	})
 	if err != nil {
-		return false, fmt.Errorf("error getting existing members: %w", err)
+		return "", fmt.Errorf("error getting existing members: %w", err)
 	}
 
 	// Search conditions looking for particular account and we expect to get either zero results
-	// (account is not yet connected) or one result (account is connected with either Invited or Associated status).
-	// Situation with more than single member in the results is impossible but yet be handled correctly by this code.
-	if len(members.Members) == 1 {
-		if *members.Members[0].MemberStatus == "Associated" {
-			return true, nil
-		}
+	// (account is not yet connected, ErrMemberNotFound is returned) or one result
+	// (account is connected with either Invited or Associated status).
+	// More than single member in the results means the service misbehaves, which is reported as an error.
+	switch len(members.Members) {
+	case 0:
+		return "", ErrMemberNotFound
+	case 1:
+		// member without status is treated as not connected
+		return aws.StringValue(members.Members[0].MemberStatus), nil
+	default:
+		return "", &UnexpectedMembersError{AccountID: aws.StringValue(memberAccountID), Count: len(members.Members)}
 	}
-
-	// The check didn't fail but didn't found that member account is in Associated state, returning no error.
-	return false, nil
 }
 
 // setUpSecurityHubMaster creates new member account and sends invite to it.
+// Member is created without email in case it's nil.
 func setUpSecurityHubMaster(s SecurityHubMasterClient, memberAccountID, email *string) error {
 	_, err := s.CreateMembers(&securityhub.CreateMembersInput{
 		AccountDetails: []*securityhub.AccountDetails{{
@@ -126,28 +492,205 @@ func setUpSecurityHubMaster(s SecurityHubMasterClient, memberAccountID, email *s
 
 // acceptSecurityHubMemberInvitation looks for invitation from specified master account and accepts it
 func acceptSecurityHubMemberInvitation(s SecurityHubMemberClient, masterAccountID *string) error {
-	invitations, err := s.ListInvitations(nil)
-	if err != nil {
-		return fmt.Errorf("error retrieving list of invitations: %w", err)
+	list := func() ([]memberInvitation, error) {
+		res, err := s.ListInvitations(nil)
+		if err != nil {
+			return nil, err
+		}
+		invitations := make([]memberInvitation, 0, len(res.Invitations))
+		for _, inv := range res.Invitations {
+			invitations = append(invitations, memberInvitation{
+				accountID: aws.StringValue(inv.AccountId),
+				id:        aws.StringValue(inv.InvitationId),
+			})
+		}
+		return invitations, nil
+	}
+	accept := func(inv memberInvitation) error {
+		_, err := s.AcceptInvitation(&securityhub.AcceptInvitationInput{
+			InvitationId: aws.String(inv.id),
+			MasterId:     masterAccountID,
+		})
+		if err != nil {
+			return fmt.Errorf("error accepting invitation: %w", err)
+		}
+		return nil
+	}
+
+	return acceptMemberInvitation(list, fromAccount(*masterAccountID), accept)
+}
+
+// enableSecurityHubStandards enables provided standards in member account in case they are not enabled yet,
+// and returns if any standard was enabled
+func enableSecurityHubStandards(s SecurityHubMemberClient, standards []string) (bool, error) {
+	if len(standards) == 0 {
+		return false, nil
+	}
+
+	var available []*securityhub.Standard
+	input := &securityhub.DescribeStandardsInput{}
+	for {
+		res, err := s.DescribeStandards(input)
+		if err != nil {
+			return false, fmt.Errorf("error describing standards: %w", err)
+		}
+		available = append(available, res.Standards...)
+		if res.NextToken == nil {
+			break
+		}
+		input.NextToken = res.NextToken
 	}
-	var invitationID *string
-	for _, inv := range invitations.Invitations {
-		if *inv.AccountId == *masterAccountID {
-			invitationID = inv.InvitationId
+
+	enabled := map[string]bool{}
+	enabledInput := &securityhub.GetEnabledStandardsInput{}
+	for {
+		res, err := s.GetEnabledStandards(enabledInput)
+		if err != nil {
+			return false, fmt.Errorf("error getting enabled standards: %w", err)
+		}
+		for _, sub := range res.StandardsSubscriptions {
+			enabled[aws.StringValue(sub.StandardsArn)] = true
+		}
+		if res.NextToken == nil {
 			break
 		}
+		enabledInput.NextToken = res.NextToken
+	}
+
+	var requests []*securityhub.StandardsSubscriptionRequest
+	for _, std := range standards {
+		var arn *string
+		for _, a := range available {
+			if aws.StringValue(a.StandardsArn) == std || strings.HasSuffix(aws.StringValue(a.StandardsArn), "/"+std) {
+				arn = a.StandardsArn
+				break
+			}
+		}
+		if arn == nil {
+			return false, fmt.Errorf("unknown standard %q", std)
+		}
+		if !enabled[*arn] {
+			requests = append(requests, &securityhub.StandardsSubscriptionRequest{StandardsArn: arn})
+		}
 	}
-	if invitationID == nil {
-		return fmt.Errorf("can't find invitation from master account")
+	if len(requests) == 0 {
+		return false, nil
 	}
 
-	_, err = s.AcceptInvitation(&securityhub.AcceptInvitationInput{
-		InvitationId: invitationID,
-		MasterId:     masterAccountID,
+	_, err := s.BatchEnableStandards(&securityhub.BatchEnableStandardsInput{
+		StandardsSubscriptionRequests: requests,
 	})
 	if err != nil {
-		return fmt.Errorf("error accepting invitation: %w", err)
+		return false, fmt.Errorf("error enabling standards: %w", err)
 	}
 
-	return nil
+	return true, nil
+}
+
+// disableSecurityHubStandardsControls disables provided controls of every standard in member account,
+// and returns if any control was disabled
+func disableSecurityHubStandardsControls(s SecurityHubMemberClient, w *standardWaiter, controls map[string][]string) (bool, error) {
+	standards := make([]string, 0, len(controls))
+	for std := range controls {
+		standards = append(standards, std)
+	}
+	sort.Strings(standards)
+
+	var updated bool
+	for _, std := range standards {
+		disabled, err := disableSecurityHubControls(s, w, std, controls[std])
+		if err != nil {
+			return false, err
+		}
+		updated = updated || disabled
+	}
+	return updated, nil
+}
+
+// disableSecurityHubControls disables provided controls of enabled standard in member account in case
+// they are not disabled yet, and returns if any of them was disabled. Pending standard is waited to be ready first.
+func disableSecurityHubControls(s SecurityHubMemberClient, w *standardWaiter, standard string, controlIDs []string) (bool, error) {
+	if len(controlIDs) == 0 {
+		return false, nil
+	}
+
+	getSubscription := func() (*securityhub.StandardsSubscription, error) {
+		return getSecurityHubStandardSubscription(s, standard)
+	}
+	sub, err := getSubscription()
+	if err != nil {
+		return false, err
+	}
+	if sub == nil {
+		return false, fmt.Errorf("standard %q is not enabled", standard)
+	}
+	// controls of just enabled standard aren't listed until it's ready
+	if aws.StringValue(sub.StandardsStatus) == securityhub.StandardsStatusPending {
+		if sub, err = w.waitForReady(standard, getSubscription); err != nil {
+			return false, err
+		}
+	}
+
+	controls := map[string]*securityhub.StandardsControl{}
+	input := &securityhub.DescribeStandardsControlsInput{StandardsSubscriptionArn: sub.StandardsSubscriptionArn}
+	for {
+		res, err := s.DescribeStandardsControls(input)
+		if err != nil {
+			return false, fmt.Errorf("error describing controls of standard %q: %w", standard, err)
+		}
+		for _, c := range res.Controls {
+			controls[aws.StringValue(c.ControlId)] = c
+		}
+		if res.NextToken == nil {
+			break
+		}
+		input.NextToken = res.NextToken
+	}
+
+	// all controls are checked before any of them is disabled
+	for _, id := range controlIDs {
+		if _, ok := controls[id]; !ok {
+			return false, fmt.Errorf("unknown control %q of standard %q", id, standard)
+		}
+	}
+
+	var updated bool
+	for _, id := range controlIDs {
+		c := controls[id]
+		if aws.StringValue(c.ControlStatus) == securityhub.ControlStatusDisabled {
+			continue
+		}
+		_, err := s.UpdateStandardsControl(&securityhub.UpdateStandardsControlInput{
+			StandardsControlArn: c.StandardsControlArn,
+			ControlStatus:       aws.String(securityhub.ControlStatusDisabled),
+			DisabledReason:      aws.String(securityHubDisabledReason),
+		})
+		if err != nil {
+			return false, fmt.Errorf("error disabling control %q of standard %q: %w", id, standard, err)
+		}
+		updated = true
+	}
+	return updated, nil
+}
+
+// getSecurityHubStandardSubscription returns subscription of member account to the standard identified by its ARN
+// or the end of it, nil is returned in case the standard is not enabled
+func getSecurityHubStandardSubscription(s SecurityHubMemberClient, standard string) (*securityhub.StandardsSubscription, error) {
+	input := &securityhub.GetEnabledStandardsInput{}
+	for {
+		res, err := s.GetEnabledStandards(input)
+		if err != nil {
+			return nil, fmt.Errorf("error getting enabled standards: %w", err)
+		}
+		for _, sub := range res.StandardsSubscriptions {
+			arn := aws.StringValue(sub.StandardsArn)
+			if arn == standard || strings.HasSuffix(arn, "/"+standard) {
+				return sub, nil
+			}
+		}
+		if res.NextToken == nil {
+			return nil, nil
+		}
+		input.NextToken = res.NextToken
+	}
 }
@@ -15,12 +15,22 @@
 package connectors
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/securityhub"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	fsbpSubscriptionARN = "arn:aws:securityhub:us-west-2:112233445566:subscription/aws-foundational-security-best-practices/v/1.0.0"
+	iam6ControlARN      = "arn:aws:securityhub:us-west-2:112233445566:control/aws-foundational-security-best-practices/v/1.0.0/IAM.6"
+	s3ControlARN        = "arn:aws:securityhub:us-west-2:112233445566:control/aws-foundational-security-best-practices/v/1.0.0/S3.1"
 )
 
 func TestSecurityHubInviter_AddMember(t *testing.T) {
@@ -34,30 +44,156 @@ func TestSecurityHubInviter_AddMember(t *testing.T) {
 		emptyGMReq      = shGetMembersReq{output: &securityhub.GetMembersOutput{}}
 		associatedGMReq = shGetMembersReq{output: &securityhub.GetMembersOutput{
 			Members: []*securityhub.Member{{MemberStatus: aws.String("Associated")}}}}
+		severalGMReq = shGetMembersReq{output: &securityhub.GetMembersOutput{
+			Members: []*securityhub.Member{{MemberStatus: aws.String("Associated")}, {MemberStatus: aws.String("Associated")}}}}
 		invitedGMReq = shGetMembersReq{output: &securityhub.GetMembersOutput{
 			Members: []*securityhub.Member{{MemberStatus: aws.String("Invited")}}}}
+		removedGMReq = shGetMembersReq{output: &securityhub.GetMembersOutput{
+			Members: []*securityhub.Member{{MemberStatus: aws.String("Removed")}}}}
 		badCMReq   = shCreateMembersReq{err: fmt.Errorf("mock err")}
 		badIMReq   = shInviteMembersReq{err: fmt.Errorf("mock err")}
 		badLIReq   = shListInvitationsReq{err: fmt.Errorf("mock err")}
 		emptyLIReq = shListInvitationsReq{output: &securityhub.ListInvitationsOutput{}}
 		goodLIReq  = shListInvitationsReq{output: &securityhub.ListInvitationsOutput{
 			Invitations: []*securityhub.Invitation{{AccountId: &masterAccID, InvitationId: &invitationID}}}}
-		badAIReq = shAcceptInvitationReq{err: fmt.Errorf("mock err")}
+		badAIReq  = shAcceptInvitationReq{err: fmt.Errorf("mock err")}
+		standards = []string{"aws-foundational-security-best-practices/v/1.0.0", "cis-aws-foundations-benchmark/v/1.2.0"}
+		badDSReq  = shDescribeStandardsReq{err: fmt.Errorf("mock err")}
+		goodDSReq = shDescribeStandardsReq{output: &securityhub.DescribeStandardsOutput{Standards: []*securityhub.Standard{
+			{StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/aws-foundational-security-best-practices/v/1.0.0")},
+			{StandardsArn: aws.String("arn:aws:securityhub:::ruleset/cis-aws-foundations-benchmark/v/1.2.0")},
+			{StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/pci-dss/v/3.2.1")},
+		}}}
+		badGESReq = shGetEnabledStandardsReq{err: fmt.Errorf("mock err")}
+		cisGESReq = shGetEnabledStandardsReq{output: &securityhub.GetEnabledStandardsOutput{
+			StandardsSubscriptions: []*securityhub.StandardsSubscription{
+				{StandardsArn: aws.String("arn:aws:securityhub:::ruleset/cis-aws-foundations-benchmark/v/1.2.0")}}}}
+		allGESReq = shGetEnabledStandardsReq{output: &securityhub.GetEnabledStandardsOutput{
+			StandardsSubscriptions: []*securityhub.StandardsSubscription{
+				{StandardsArn: aws.String("arn:aws:securityhub:::ruleset/cis-aws-foundations-benchmark/v/1.2.0")},
+				{StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/aws-foundational-security-best-practices/v/1.0.0"),
+					StandardsSubscriptionArn: aws.String(fsbpSubscriptionARN)}}}}
+		badBESReq      = shBatchEnableStandardsReq{err: fmt.Errorf("mock err")}
+		fsbpControls   = map[string][]string{"aws-foundational-security-best-practices/v/1.0.0": {"IAM.6"}}
+		enabledDSCReqs = []shDescribeStandardsControlsReq{{output: &securityhub.DescribeStandardsControlsOutput{
+			Controls: []*securityhub.StandardsControl{{ControlId: aws.String("IAM.6"),
+				ControlStatus: aws.String(securityhub.ControlStatusEnabled), StandardsControlArn: aws.String(iam6ControlARN)}}}}}
+		disabledDSCReqs = []shDescribeStandardsControlsReq{{output: &securityhub.DescribeStandardsControlsOutput{
+			Controls: []*securityhub.StandardsControl{{ControlId: aws.String("IAM.6"),
+				ControlStatus: aws.String(securityhub.ControlStatusDisabled), StandardsControlArn: aws.String(iam6ControlARN)}}}}}
+		fsbpGESReq = func(status string) shGetEnabledStandardsReq {
+			return shGetEnabledStandardsReq{output: &securityhub.GetEnabledStandardsOutput{
+				StandardsSubscriptions: []*securityhub.StandardsSubscription{
+					{StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/aws-foundational-security-best-practices/v/1.0.0"),
+						StandardsSubscriptionArn: aws.String(fsbpSubscriptionARN), StandardsStatus: aws.String(status)}}}}
+		}
 	)
 
 	var testAPIRequestsDataset = []struct {
 		description string
 		error       string
+		errIs       error
+		errMembers  int
+		status      Status
+		sendEmails  bool
 		gmReq       shGetMembersReq
+		gmWaitReqs  []shGetMembersReq
 		cmReq       shCreateMembersReq
 		imReq       shInviteMembersReq
 		liReq       shListInvitationsReq
+		liResentReq shListInvitationsReq
 		aiReq       shAcceptInvitationReq
+		standards   []string
+		dsReq       shDescribeStandardsReq
+		gesReq      shGetEnabledStandardsReq
+		gesWaitReqs []shGetEnabledStandardsReq
+		besReq      shBatchEnableStandardsReq
+		controls    map[string][]string
+		dscReqs     []shDescribeStandardsControlsReq
+		uscErr      error
+		disabled    []string
+		attempts    int
+		connected   []string
 	}{
 		{description: "problem checking existing members",
 			gmReq: badGMReq,
 			error: "error retrieving information about existing member account: error getting existing members: mock err"},
-		{description: "member already associated", gmReq: associatedGMReq},
+		{description: "several members returned for single account",
+			gmReq:      severalGMReq,
+			error:      "error retrieving information about existing member account: 2 members returned instead of one for account 112233445566",
+			errMembers: 2},
+		{description: "member already associated", gmReq: associatedGMReq, status: StatusAlreadyConnected},
+		{description: "member already connected with alternate status",
+			gmReq: shGetMembersReq{output: &securityhub.GetMembersOutput{
+				Members: []*securityhub.Member{{MemberStatus: aws.String("Enabled")}}}},
+			connected: []string{"Associated", "Enabled"},
+			status:    StatusAlreadyConnected},
+		{description: "member already associated with standards enabled",
+			gmReq:     associatedGMReq,
+			standards: standards,
+			dsReq:     goodDSReq,
+			gesReq:    allGESReq,
+			status:    StatusAlreadyConnected},
+		{description: "member already associated, standards enabled",
+			gmReq:     associatedGMReq,
+			standards: standards,
+			dsReq:     goodDSReq,
+			gesReq:    cisGESReq,
+			status:    StatusUpdated},
+		{description: "member already associated with controls disabled",
+			gmReq:    associatedGMReq,
+			gesReq:   allGESReq,
+			controls: fsbpControls,
+			dscReqs:  disabledDSCReqs,
+			status:   StatusAlreadyConnected},
+		{description: "member already associated, controls disabled",
+			gmReq:    associatedGMReq,
+			gesReq:   allGESReq,
+			controls: fsbpControls,
+			dscReqs:  enabledDSCReqs,
+			disabled: []string{iam6ControlARN},
+			status:   StatusUpdated},
+		{description: "member already associated, controls disabled once just enabled standard is ready",
+			gmReq:       associatedGMReq,
+			gesReq:      fsbpGESReq(securityhub.StandardsStatusPending),
+			gesWaitReqs: []shGetEnabledStandardsReq{fsbpGESReq(securityhub.StandardsStatusPending), fsbpGESReq(securityhub.StandardsStatusReady)},
+			controls:    fsbpControls,
+			dscReqs:     enabledDSCReqs,
+			disabled:    []string{iam6ControlARN},
+			status:      StatusUpdated},
+		{description: "member already associated, problem disabling controls",
+			gmReq:    associatedGMReq,
+			gesReq:   allGESReq,
+			controls: fsbpControls,
+			dscReqs:  enabledDSCReqs,
+			uscErr:   fmt.Errorf("mock err"),
+			disabled: []string{iam6ControlARN},
+			error: "error disabling controls in member account: error disabling control \"IAM.6\" " +
+				"of standard \"aws-foundational-security-best-practices/v/1.0.0\": mock err"},
+		{description: "problem describing standards",
+			gmReq:     associatedGMReq,
+			standards: standards,
+			dsReq:     badDSReq,
+			error:     "error enabling standards in member account: error describing standards: mock err"},
+		{description: "problem getting enabled standards",
+			gmReq:     associatedGMReq,
+			standards: standards,
+			dsReq:     goodDSReq,
+			gesReq:    badGESReq,
+			error:     "error enabling standards in member account: error getting enabled standards: mock err"},
+		{description: "unknown standard",
+			gmReq:     associatedGMReq,
+			standards: []string{"nist-800-53/v/5.0.0"},
+			dsReq:     goodDSReq,
+			gesReq:    cisGESReq,
+			error:     `error enabling standards in member account: unknown standard "nist-800-53/v/5.0.0"`},
+		{description: "problem enabling standards",
+			gmReq:     associatedGMReq,
+			standards: standards,
+			dsReq:     goodDSReq,
+			gesReq:    cisGESReq,
+			besReq:    badBESReq,
+			error:     "error enabling standards in member account: error enabling standards: mock err"},
 		{description: "problem creating member account",
 			gmReq: emptyGMReq,
 			cmReq: badCMReq,
@@ -73,27 +209,89 @@ func TestSecurityHubInviter_AddMember(t *testing.T) {
 		{description: "invitation not found",
 			gmReq: invitedGMReq,
 			liReq: emptyLIReq,
-			error: "error accepting invitation in member account: can't find invitation from master account"},
+			error: "error accepting invitation in member account: can't find invitation from master account",
+			errIs: ErrInvitationMissing},
+		{description: "invitation not found, re-sent and accepted",
+			gmReq:       invitedGMReq,
+			liReq:       emptyLIReq,
+			liResentReq: goodLIReq,
+			status:      StatusUpdated},
+		{description: "invitation not found, problem re-sending it",
+			gmReq: invitedGMReq,
+			liReq: emptyLIReq,
+			imReq: badIMReq,
+			error: "error re-sending invitation: error sending invitation: mock err"},
 		{description: "problem accepting invitation",
 			gmReq: invitedGMReq,
 			liReq: goodLIReq,
 			aiReq: badAIReq,
 			error: "error accepting invitation in member account: error accepting invitation: mock err"},
 		{description: "correctly send and accept invitation",
-			gmReq: invitedGMReq,
-			liReq: goodLIReq},
+			gmReq:  invitedGMReq,
+			liReq:  goodLIReq,
+			status: StatusAccepted},
+		{description: "invitation found on second attempt",
+			gmReq:       emptyGMReq,
+			liReq:       emptyLIReq,
+			liResentReq: goodLIReq,
+			attempts:    3,
+			status:      StatusInvited},
+		{description: "invitation not found after all attempts",
+			gmReq:    emptyGMReq,
+			liReq:    emptyLIReq,
+			attempts: 2,
+			error:    "error accepting invitation in member account: can't find invitation from master account",
+			errIs:    ErrInvitationMissing},
+		{description: "correctly create member, send and accept invitation",
+			gmReq:  emptyGMReq,
+			liReq:  goodLIReq,
+			status: StatusInvited},
+		{description: "correctly create member, send and accept invitation and enable standards",
+			gmReq:     emptyGMReq,
+			liReq:     goodLIReq,
+			standards: standards,
+			dsReq:     goodDSReq,
+			gesReq:    cisGESReq,
+			status:    StatusInvited},
+		{description: "correctly create member with email, send and accept invitation",
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			status:     StatusInvited,
+			sendEmails: true},
+		{description: "member associated after waiting",
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []shGetMembersReq{invitedGMReq, associatedGMReq},
+			status:     StatusInvited},
+		{description: "member deleted while waiting",
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []shGetMembersReq{invitedGMReq, emptyGMReq},
+			error:      "error waiting for member account to be enabled: member account is not found in master account",
+			errIs:      ErrMemberNotFound},
+		{description: "member removed while waiting",
+			gmReq:      emptyGMReq,
+			liReq:      goodLIReq,
+			gmWaitReqs: []shGetMembersReq{invitedGMReq, removedGMReq},
+			error:      `error waiting for member account to be enabled: unexpected member status "Removed" while waiting for it to be Associated`},
 	}
 
-	masterSess, memberSess := NewMasterMemberSess("us-west-2", "", "")
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws"})
 	for i, x := range testAPIRequestsDataset {
 		i := i
 		x := x
 		t.Run(x.description, func(t *testing.T) {
+			var email *string
+			if x.sendEmails {
+				email = &testEmail
+			}
 			master := &mockSHMasterClient{
 				t:           t,
-				email:       &testEmail,
+				email:       email,
 				memberAccID: &memberAccID,
 				gmReq:       x.gmReq,
+				gmWaitReqs:  x.gmWaitReqs,
+				gmCalls:     new(int),
 				cmReq:       x.cmReq,
 				imReq:       x.imReq,
 			}
@@ -102,22 +300,219 @@ func TestSecurityHubInviter_AddMember(t *testing.T) {
 				masterAccountID: &masterAccID,
 				invitationID:    &invitationID,
 				liReq:           x.liReq,
+				liResentReq:     x.liResentReq,
 				aiReq:           x.aiReq,
+				dsReq:           x.dsReq,
+				gesReq:          x.gesReq,
+				gesWaitReqs:     x.gesWaitReqs,
+				besReq:          x.besReq,
+				dscReqs:         x.dscReqs,
+				uscErr:          x.uscErr,
+				disabled:        &[]string{},
 			}
-			s := NewSecurityHubInviter(masterSess, memberSess)
+			s := NewSecurityHubInviter(masterSess, memberSess, !x.sendEmails, x.standards)
 			s.masterSvc = master
 			s.memberSvc = member
-			err := s.AddMember(memberAccID, testEmail, masterAccID)
+			s.disabledControls = x.controls
+			s.connectedStatuses = x.connected
+			s.standardWaiter.sleep = func(context.Context, time.Duration) error { return nil }
+			if len(x.gmWaitReqs) > 0 {
+				s.waiter = &memberWaiter{pollInterval: time.Minute, timeout: 3 * time.Minute, sleep: func(context.Context, time.Duration) error { return nil }}
+			}
+			if x.attempts > 0 {
+				s.retryer = &invitationRetryer{attempts: x.attempts, delay: time.Second, sleep: func(context.Context, time.Duration) error { return nil }}
+			}
+			res, err := s.AddMember(memberAccID, testEmail, masterAccID)
+
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				assert.Equal(t, StatusFailed, res.Status, "Test case %d status check failed", i)
+				var serviceErr *ServiceError
+				if assert.True(t, errors.As(err, &serviceErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "security_hub", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+				if x.errIs != nil {
+					assert.ErrorIs(t, err, x.errIs, "Test case %d errors.Is check failed", i)
+				}
+				if x.errMembers != 0 {
+					var membersErr *UnexpectedMembersError
+					if assert.True(t, errors.As(err, &membersErr), "Test case %d errors.As check failed", i) {
+						assert.Equal(t, x.errMembers, membersErr.Count, "Test case %d members count check failed", i)
+					}
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+				assert.Equal(t, x.status, res.Status, "Test case %d status check failed", i)
+			}
+			assert.Equal(t, append([]string{}, x.disabled...), *member.disabled, "Test case %d disabled controls check failed", i)
+		})
+	}
+}
+
+func TestSecurityHubInviter_DisableControls(t *testing.T) {
+	var (
+		fsbp      = "aws-foundational-security-best-practices/v/1.0.0"
+		badGESReq = shGetEnabledStandardsReq{err: fmt.Errorf("mock err")}
+		cisGESReq = shGetEnabledStandardsReq{output: &securityhub.GetEnabledStandardsOutput{
+			StandardsSubscriptions: []*securityhub.StandardsSubscription{
+				{StandardsArn: aws.String("arn:aws:securityhub:::ruleset/cis-aws-foundations-benchmark/v/1.2.0")}}}}
+		fsbpGESReq = shGetEnabledStandardsReq{output: &securityhub.GetEnabledStandardsOutput{
+			StandardsSubscriptions: []*securityhub.StandardsSubscription{
+				{StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/" + fsbp),
+					StandardsSubscriptionArn: aws.String(fsbpSubscriptionARN)}}}}
+		pendingGESReq = shGetEnabledStandardsReq{output: &securityhub.GetEnabledStandardsOutput{
+			StandardsSubscriptions: []*securityhub.StandardsSubscription{
+				{StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/" + fsbp),
+					StandardsSubscriptionArn: aws.String(fsbpSubscriptionARN), StandardsStatus: aws.String(securityhub.StandardsStatusPending)}}}}
+		failedGESReq = shGetEnabledStandardsReq{output: &securityhub.GetEnabledStandardsOutput{
+			StandardsSubscriptions: []*securityhub.StandardsSubscription{
+				{StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/" + fsbp),
+					StandardsSubscriptionArn: aws.String(fsbpSubscriptionARN), StandardsStatus: aws.String(securityhub.StandardsStatusFailed)}}}}
+		badDSCReqs  = []shDescribeStandardsControlsReq{{err: fmt.Errorf("mock err")}}
+		goodDSCReqs = []shDescribeStandardsControlsReq{
+			{output: &securityhub.DescribeStandardsControlsOutput{NextToken: aws.String("page2"),
+				Controls: []*securityhub.StandardsControl{{ControlId: aws.String("IAM.6"),
+					ControlStatus: aws.String(securityhub.ControlStatusEnabled), StandardsControlArn: aws.String(iam6ControlARN)}}}},
+			{output: &securityhub.DescribeStandardsControlsOutput{
+				Controls: []*securityhub.StandardsControl{{ControlId: aws.String("S3.1"),
+					ControlStatus: aws.String(securityhub.ControlStatusDisabled), StandardsControlArn: aws.String(s3ControlARN)}}}},
+		}
+	)
+
+	var testData = []struct {
+		description string
+		error       string
+		standard    string
+		controls    []string
+		gesReq      shGetEnabledStandardsReq
+		gesWaitReqs []shGetEnabledStandardsReq
+		sleepErr    error
+		dscReqs     []shDescribeStandardsControlsReq
+		uscErr      error
+		disabled    []string
+	}{
+		{description: "no controls", standard: fsbp},
+		{description: "controls disabled once pending standard is ready",
+			standard:    fsbp,
+			controls:    []string{"IAM.6"},
+			gesReq:      pendingGESReq,
+			gesWaitReqs: []shGetEnabledStandardsReq{pendingGESReq, fsbpGESReq},
+			dscReqs:     goodDSCReqs,
+			disabled:    []string{iam6ControlARN}},
+		{description: "standard is still pending after timeout",
+			standard:    fsbp,
+			controls:    []string{"IAM.6"},
+			gesReq:      pendingGESReq,
+			gesWaitReqs: []shGetEnabledStandardsReq{pendingGESReq, pendingGESReq},
+			error:       `standard "aws-foundational-security-best-practices/v/1.0.0" is still PENDING after 2m0s`},
+		{description: "pending standard fails",
+			standard:    fsbp,
+			controls:    []string{"IAM.6"},
+			gesReq:      pendingGESReq,
+			gesWaitReqs: []shGetEnabledStandardsReq{failedGESReq},
+			error:       `standard "aws-foundational-security-best-practices/v/1.0.0" is FAILED instead of READY`},
+		{description: "problem getting pending standard",
+			standard:    fsbp,
+			controls:    []string{"IAM.6"},
+			gesReq:      pendingGESReq,
+			gesWaitReqs: []shGetEnabledStandardsReq{badGESReq},
+			error:       "error getting enabled standards: mock err"},
+		{description: "waiting for pending standard is interrupted",
+			standard: fsbp,
+			controls: []string{"IAM.6"},
+			gesReq:   pendingGESReq,
+			sleepErr: context.Canceled,
+			error:    `waiting for standard "aws-foundational-security-best-practices/v/1.0.0" to be ready is interrupted: context canceled`},
+		{description: "problem getting enabled standards",
+			standard: fsbp,
+			controls: []string{"IAM.6"},
+			gesReq:   badGESReq,
+			error:    "error getting enabled standards: mock err"},
+		{description: "standard not enabled",
+			standard: fsbp,
+			controls: []string{"IAM.6"},
+			gesReq:   cisGESReq,
+			error:    `standard "aws-foundational-security-best-practices/v/1.0.0" is not enabled`},
+		{description: "problem describing controls",
+			standard: fsbp,
+			controls: []string{"IAM.6"},
+			gesReq:   fsbpGESReq,
+			dscReqs:  badDSCReqs,
+			error:    `error describing controls of standard "aws-foundational-security-best-practices/v/1.0.0": mock err`},
+		{description: "unknown control",
+			standard: fsbp,
+			controls: []string{"IAM.6", "EC2.100"},
+			gesReq:   fsbpGESReq,
+			dscReqs:  goodDSCReqs,
+			error:    `unknown control "EC2.100" of standard "aws-foundational-security-best-practices/v/1.0.0"`},
+		{description: "problem disabling control",
+			standard: fsbp,
+			controls: []string{"IAM.6"},
+			gesReq:   fsbpGESReq,
+			dscReqs:  goodDSCReqs,
+			uscErr:   fmt.Errorf("mock err"),
+			disabled: []string{iam6ControlARN},
+			error:    `error disabling control "IAM.6" of standard "aws-foundational-security-best-practices/v/1.0.0": mock err`},
+		{description: "controls disabled, already disabled one is skipped",
+			standard: fsbp,
+			controls: []string{"IAM.6", "S3.1"},
+			gesReq:   fsbpGESReq,
+			dscReqs:  goodDSCReqs,
+			disabled: []string{iam6ControlARN}},
+		{description: "standard identified by full ARN",
+			standard: "arn:aws:securityhub:us-west-2::standards/" + fsbp,
+			controls: []string{"IAM.6"},
+			gesReq:   fsbpGESReq,
+			dscReqs:  goodDSCReqs,
+			disabled: []string{iam6ControlARN}},
+	}
 
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			member := &mockSHMemberClient{t: t, gesReq: x.gesReq, gesWaitReqs: x.gesWaitReqs, dscReqs: x.dscReqs,
+				uscErr: x.uscErr, disabled: &[]string{}}
+			s := SecurityHubInviter{memberSvc: member, standardWaiter: &standardWaiter{pollInterval: time.Minute,
+				timeout: 2 * time.Minute, sleep: func(context.Context, time.Duration) error { return x.sleepErr }}}
+			err := s.DisableControls(x.standard, x.controls)
 			if x.error != "" {
 				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				var serviceErr *ServiceError
+				if assert.True(t, errors.As(err, &serviceErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "security_hub", serviceErr.Service, "Test case %d error service check failed", i)
+				}
 			} else {
 				assert.NoError(t, err, "Test case %d error check failed", i)
 			}
+			assert.Equal(t, append([]string{}, x.disabled...), *member.disabled, "Test case %d disabled controls check failed", i)
 		})
 	}
 }
 
+func TestParseSecurityHubDisabledControls(t *testing.T) {
+	controls, err := ParseSecurityHubDisabledControls(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, controls)
+
+	controls, err = ParseSecurityHubDisabledControls([]string{
+		" aws-foundational-security-best-practices/v/1.0.0:IAM.6",
+		"",
+		"arn:aws:securityhub:::ruleset/cis-aws-foundations-benchmark/v/1.2.0:1.1",
+		"aws-foundational-security-best-practices/v/1.0.0:S3.1",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"aws-foundational-security-best-practices/v/1.0.0":                    {"IAM.6", "S3.1"},
+		"arn:aws:securityhub:::ruleset/cis-aws-foundations-benchmark/v/1.2.0": {"1.1"},
+	}, controls)
+
+	for _, spec := range []string{"IAM.6", ":IAM.6", "aws-foundational-security-best-practices/v/1.0.0:"} {
+		_, err = ParseSecurityHubDisabledControls([]string{spec})
+		assert.EqualError(t, err, fmt.Sprintf("invalid Security Hub control %q, it should be in standard:control_id format", spec))
+	}
+}
+
 type mockSHMasterClient struct {
 	t           *testing.T
 	email       *string
@@ -125,6 +520,18 @@ type mockSHMasterClient struct {
 	gmReq       shGetMembersReq
 	cmReq       shCreateMembersReq
 	imReq       shInviteMembersReq
+	loaReq      shListOrgAdminsReq
+	eoaReq      shEnableOrgAdminReq
+	lfaReq      shListAggregatorsReq
+	gfaReq      shGetAggregatorReq
+	cfaReq      shCreateAggregatorReq
+	ufaReq      shUpdateAggregatorReq
+	// lmPages are pages of members list keyed by page token, empty for the first page
+	lmPages map[string]*securityhub.ListMembersOutput
+	lmErr   error
+	// gmWaitReqs are responses to GetMembers calls following the first one, made while waiting for member to be enabled
+	gmWaitReqs []shGetMembersReq
+	gmCalls    *int
 }
 
 type shGetMembersReq struct {
@@ -140,9 +547,20 @@ type shInviteMembersReq struct {
 
 func (s mockSHMasterClient) GetMembers(input *securityhub.GetMembersInput) (*securityhub.GetMembersOutput, error) {
 	assert.Equal(s.t, &securityhub.GetMembersInput{AccountIds: []*string{s.memberAccID}}, input)
+	if len(s.gmWaitReqs) > 0 {
+		*s.gmCalls++
+		if *s.gmCalls > 1 {
+			return s.gmWaitReqs[*s.gmCalls-2].output, s.gmWaitReqs[*s.gmCalls-2].err
+		}
+	}
 	return s.gmReq.output, s.gmReq.err
 }
 
+func (s mockSHMasterClient) ListMembers(input *securityhub.ListMembersInput) (*securityhub.ListMembersOutput, error) {
+	assert.False(s.t, aws.BoolValue(input.OnlyAssociated))
+	return s.lmPages[aws.StringValue(input.NextToken)], s.lmErr
+}
+
 func (s mockSHMasterClient) CreateMembers(input *securityhub.CreateMembersInput) (*securityhub.CreateMembersOutput, error) {
 	assert.Equal(s.t, &securityhub.CreateMembersInput{
 		AccountDetails: []*securityhub.AccountDetails{{
@@ -163,7 +581,20 @@ type mockSHMemberClient struct {
 	masterAccountID *string
 	invitationID    *string
 	liReq           shListInvitationsReq
+	liResentReq     shListInvitationsReq
+	liCalls         int
 	aiReq           shAcceptInvitationReq
+	dsReq           shDescribeStandardsReq
+	gesReq          shGetEnabledStandardsReq
+	// gesWaitReqs are responses to GetEnabledStandards calls following the first one, made while waiting for standard
+	gesWaitReqs []shGetEnabledStandardsReq
+	gesCalls    int
+	besReq      shBatchEnableStandardsReq
+	dscReqs     []shDescribeStandardsControlsReq
+	dscCalls    int
+	uscErr      error
+	// disabled records ARNs of controls disabled with UpdateStandardsControl
+	disabled *[]string
 }
 
 type shListInvitationsReq struct {
@@ -173,9 +604,25 @@ type shListInvitationsReq struct {
 type shAcceptInvitationReq struct {
 	err error
 }
+type shDescribeStandardsReq struct {
+	output *securityhub.DescribeStandardsOutput
+	err    error
+}
+type shGetEnabledStandardsReq struct {
+	output *securityhub.GetEnabledStandardsOutput
+	err    error
+}
+type shBatchEnableStandardsReq struct {
+	err error
+}
 
-func (s mockSHMemberClient) ListInvitations(input *securityhub.ListInvitationsInput) (*securityhub.ListInvitationsOutput, error) {
+func (s *mockSHMemberClient) ListInvitations(input *securityhub.ListInvitationsInput) (*securityhub.ListInvitationsOutput, error) {
 	assert.Nil(s.t, input)
+	s.liCalls++
+	// invitations listed after the invitation is re-sent or looked for again
+	if s.liCalls > 1 && s.liResentReq.output != nil {
+		return s.liResentReq.output, s.liResentReq.err
+	}
 	return s.liReq.output, s.liReq.err
 }
 
@@ -183,3 +630,368 @@ func (s mockSHMemberClient) AcceptInvitation(input *securityhub.AcceptInvitation
 	assert.Equal(s.t, &securityhub.AcceptInvitationInput{InvitationId: s.invitationID, MasterId: s.masterAccountID}, input)
 	return nil, s.aiReq.err
 }
+
+func (s mockSHMemberClient) DescribeStandards(input *securityhub.DescribeStandardsInput) (*securityhub.DescribeStandardsOutput, error) {
+	assert.Equal(s.t, &securityhub.DescribeStandardsInput{}, input)
+	return s.dsReq.output, s.dsReq.err
+}
+
+func (s *mockSHMemberClient) GetEnabledStandards(input *securityhub.GetEnabledStandardsInput) (*securityhub.GetEnabledStandardsOutput, error) {
+	assert.Equal(s.t, &securityhub.GetEnabledStandardsInput{}, input)
+	s.gesCalls++
+	if s.gesCalls > 1 && len(s.gesWaitReqs) > 0 {
+		require.LessOrEqual(s.t, s.gesCalls-1, len(s.gesWaitReqs), "unexpected enabled standards getting")
+		return s.gesWaitReqs[s.gesCalls-2].output, s.gesWaitReqs[s.gesCalls-2].err
+	}
+	return s.gesReq.output, s.gesReq.err
+}
+
+func (s mockSHMemberClient) BatchEnableStandards(input *securityhub.BatchEnableStandardsInput) (*securityhub.BatchEnableStandardsOutput, error) {
+	// only standard which is not enabled yet is expected to be requested
+	assert.Equal(s.t, &securityhub.BatchEnableStandardsInput{
+		StandardsSubscriptionRequests: []*securityhub.StandardsSubscriptionRequest{{
+			StandardsArn: aws.String("arn:aws:securityhub:us-west-2::standards/aws-foundational-security-best-practices/v/1.0.0"),
+		}},
+	}, input)
+	return nil, s.besReq.err
+}
+
+type shDescribeStandardsControlsReq struct {
+	output *securityhub.DescribeStandardsControlsOutput
+	err    error
+}
+
+func (s *mockSHMemberClient) DescribeStandardsControls(input *securityhub.DescribeStandardsControlsInput) (*securityhub.DescribeStandardsControlsOutput, error) {
+	require.Less(s.t, s.dscCalls, len(s.dscReqs), "unexpected controls describing")
+	// every page after the first one is requested with the token of the previous page
+	expected := &securityhub.DescribeStandardsControlsInput{StandardsSubscriptionArn: aws.String(fsbpSubscriptionARN)}
+	if s.dscCalls > 0 {
+		expected.NextToken = s.dscReqs[s.dscCalls-1].output.NextToken
+	}
+	assert.Equal(s.t, expected, input)
+	req := s.dscReqs[s.dscCalls]
+	s.dscCalls++
+	return req.output, req.err
+}
+
+func (s *mockSHMemberClient) UpdateStandardsControl(input *securityhub.UpdateStandardsControlInput) (*securityhub.UpdateStandardsControlOutput, error) {
+	assert.Equal(s.t, aws.String(securityhub.ControlStatusDisabled), input.ControlStatus)
+	assert.Equal(s.t, aws.String("Disabled by aws-security-connectors"), input.DisabledReason)
+	*s.disabled = append(*s.disabled, aws.StringValue(input.StandardsControlArn))
+	return &securityhub.UpdateStandardsControlOutput{}, s.uscErr
+}
+
+type shListOrgAdminsReq struct {
+	output *securityhub.ListOrganizationAdminAccountsOutput
+	err    error
+}
+type shEnableOrgAdminReq struct {
+	expected bool
+	err      error
+}
+
+func (s mockSHMasterClient) ListOrganizationAdminAccounts(input *securityhub.ListOrganizationAdminAccountsInput) (*securityhub.ListOrganizationAdminAccountsOutput, error) {
+	assert.Equal(s.t, &securityhub.ListOrganizationAdminAccountsInput{}, input)
+	return s.loaReq.output, s.loaReq.err
+}
+
+func (s mockSHMasterClient) EnableOrganizationAdminAccount(input *securityhub.EnableOrganizationAdminAccountInput) (*securityhub.EnableOrganizationAdminAccountOutput, error) {
+	assert.True(s.t, s.eoaReq.expected, "unexpected delegated administrator enabling")
+	assert.Equal(s.t, &securityhub.EnableOrganizationAdminAccountInput{AdminAccountId: s.memberAccID}, input)
+	return &securityhub.EnableOrganizationAdminAccountOutput{}, s.eoaReq.err
+}
+
+type shListAggregatorsReq struct {
+	output *securityhub.ListFindingAggregatorsOutput
+	err    error
+}
+type shGetAggregatorReq struct {
+	output *securityhub.GetFindingAggregatorOutput
+	err    error
+}
+type shCreateAggregatorReq struct {
+	expected *securityhub.CreateFindingAggregatorInput
+	err      error
+}
+type shUpdateAggregatorReq struct {
+	expected *securityhub.UpdateFindingAggregatorInput
+	err      error
+}
+
+func (s mockSHMasterClient) ListFindingAggregators(input *securityhub.ListFindingAggregatorsInput) (*securityhub.ListFindingAggregatorsOutput, error) {
+	assert.Equal(s.t, &securityhub.ListFindingAggregatorsInput{}, input)
+	return s.lfaReq.output, s.lfaReq.err
+}
+
+func (s mockSHMasterClient) GetFindingAggregator(input *securityhub.GetFindingAggregatorInput) (*securityhub.GetFindingAggregatorOutput, error) {
+	assert.Equal(s.t, &securityhub.GetFindingAggregatorInput{FindingAggregatorArn: aws.String("mock_aggregator")}, input)
+	return s.gfaReq.output, s.gfaReq.err
+}
+
+func (s mockSHMasterClient) CreateFindingAggregator(input *securityhub.CreateFindingAggregatorInput) (*securityhub.CreateFindingAggregatorOutput, error) {
+	assert.NotNil(s.t, s.cfaReq.expected, "unexpected finding aggregator creation")
+	assert.Equal(s.t, s.cfaReq.expected, input)
+	return &securityhub.CreateFindingAggregatorOutput{}, s.cfaReq.err
+}
+
+func (s mockSHMasterClient) UpdateFindingAggregator(input *securityhub.UpdateFindingAggregatorInput) (*securityhub.UpdateFindingAggregatorOutput, error) {
+	assert.NotNil(s.t, s.ufaReq.expected, "unexpected finding aggregator update")
+	assert.Equal(s.t, s.ufaReq.expected, input)
+	return &securityhub.UpdateFindingAggregatorOutput{}, s.ufaReq.err
+}
+
+func TestSecurityHubInviter_ConfigureFindingAggregation(t *testing.T) {
+	var (
+		arn         = aws.String("mock_aggregator")
+		emptyLFAReq = shListAggregatorsReq{output: &securityhub.ListFindingAggregatorsOutput{}}
+		existLFAReq = shListAggregatorsReq{output: &securityhub.ListFindingAggregatorsOutput{
+			FindingAggregators: []*securityhub.FindingAggregator{{FindingAggregatorArn: arn}}}}
+		specifiedGFAReq = shGetAggregatorReq{output: &securityhub.GetFindingAggregatorOutput{
+			FindingAggregationRegion: aws.String("eu-west-1"),
+			FindingAggregatorArn:     arn,
+			RegionLinkingMode:        aws.String("SPECIFIED_REGIONS"),
+			Regions:                  aws.StringSlice([]string{"us-east-1", "eu-central-1"})}}
+	)
+
+	var testData = []struct {
+		description   string
+		error         string
+		linkedRegions []string
+		lfaReq        shListAggregatorsReq
+		gfaReq        shGetAggregatorReq
+		cfaReq        shCreateAggregatorReq
+		ufaReq        shUpdateAggregatorReq
+	}{
+		{description: "problem listing aggregators",
+			linkedRegions: []string{"eu-west-1", "us-east-1"},
+			lfaReq:        shListAggregatorsReq{err: fmt.Errorf("mock err")},
+			error:         "error listing finding aggregators: mock err"},
+		{description: "only home region is linked",
+			linkedRegions: []string{"eu-west-1"},
+			error:         "no regions to link to aggregation region eu-west-1"},
+		{description: "aggregator created for specified regions",
+			linkedRegions: []string{"us-east-1", "eu-west-1", "eu-central-1"},
+			lfaReq:        emptyLFAReq,
+			cfaReq: shCreateAggregatorReq{expected: &securityhub.CreateFindingAggregatorInput{
+				RegionLinkingMode: aws.String("SPECIFIED_REGIONS"),
+				Regions:           aws.StringSlice([]string{"eu-central-1", "us-east-1"})}}},
+		{description: "aggregator created for all regions",
+			lfaReq: emptyLFAReq,
+			cfaReq: shCreateAggregatorReq{expected: &securityhub.CreateFindingAggregatorInput{
+				RegionLinkingMode: aws.String("ALL_REGIONS")}}},
+		{description: "problem creating aggregator",
+			lfaReq: emptyLFAReq,
+			cfaReq: shCreateAggregatorReq{
+				expected: &securityhub.CreateFindingAggregatorInput{RegionLinkingMode: aws.String("ALL_REGIONS")},
+				err:      fmt.Errorf("mock err")},
+			error: "error creating finding aggregator: mock err"},
+		{description: "problem getting aggregator",
+			lfaReq: existLFAReq,
+			gfaReq: shGetAggregatorReq{err: fmt.Errorf("mock err")},
+			error:  "error getting finding aggregator: mock err"},
+		{description: "aggregator in other region",
+			lfaReq: existLFAReq,
+			gfaReq: shGetAggregatorReq{output: &securityhub.GetFindingAggregatorOutput{
+				FindingAggregationRegion: aws.String("us-east-1"), RegionLinkingMode: aws.String("ALL_REGIONS")}},
+			error: "finding aggregation region is us-east-1 already"},
+		{description: "aggregator configured already",
+			linkedRegions: []string{"eu-central-1", "eu-west-1", "us-east-1"},
+			lfaReq:        existLFAReq,
+			gfaReq:        specifiedGFAReq},
+		{description: "aggregator updated with other regions",
+			linkedRegions: []string{"eu-west-1", "us-east-1"},
+			lfaReq:        existLFAReq,
+			gfaReq:        specifiedGFAReq,
+			ufaReq: shUpdateAggregatorReq{expected: &securityhub.UpdateFindingAggregatorInput{
+				FindingAggregatorArn: arn,
+				RegionLinkingMode:    aws.String("SPECIFIED_REGIONS"),
+				Regions:              aws.StringSlice([]string{"us-east-1"})}}},
+		{description: "aggregator updated to all regions",
+			lfaReq: existLFAReq,
+			gfaReq: specifiedGFAReq,
+			ufaReq: shUpdateAggregatorReq{expected: &securityhub.UpdateFindingAggregatorInput{
+				FindingAggregatorArn: arn,
+				RegionLinkingMode:    aws.String("ALL_REGIONS")}}},
+		{description: "problem updating aggregator",
+			lfaReq: existLFAReq,
+			gfaReq: specifiedGFAReq,
+			ufaReq: shUpdateAggregatorReq{
+				expected: &securityhub.UpdateFindingAggregatorInput{FindingAggregatorArn: arn, RegionLinkingMode: aws.String("ALL_REGIONS")},
+				err:      fmt.Errorf("mock err")},
+			error: "error updating finding aggregator: mock err"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			s := SecurityHubInviter{masterSvc: mockSHMasterClient{t: t, lfaReq: x.lfaReq, gfaReq: x.gfaReq, cfaReq: x.cfaReq, ufaReq: x.ufaReq}}
+			err := s.ConfigureFindingAggregation("eu-west-1", x.linkedRegions)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				var serviceErr *ServiceError
+				if assert.True(t, errors.As(err, &serviceErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "security_hub", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+		})
+	}
+}
+
+func TestSecurityHubInviter_EnableOrgAdmin(t *testing.T) {
+	adminAccID := "123456789012"
+	var testData = []struct {
+		description string
+		error       string
+		loaReq      shListOrgAdminsReq
+		eoaReq      shEnableOrgAdminReq
+	}{
+		{description: "problem listing administrators",
+			loaReq: shListOrgAdminsReq{err: fmt.Errorf("mock err")},
+			error:  "error listing delegated administrators: mock err"},
+		{description: "already configured",
+			loaReq: shListOrgAdminsReq{output: &securityhub.ListOrganizationAdminAccountsOutput{
+				AdminAccounts: []*securityhub.AdminAccount{{AccountId: aws.String(adminAccID), Status: aws.String("ENABLED")}}}}},
+		{description: "other account is administrator",
+			loaReq: shListOrgAdminsReq{output: &securityhub.ListOrganizationAdminAccountsOutput{
+				AdminAccounts: []*securityhub.AdminAccount{{AccountId: aws.String("210987654321"), Status: aws.String("ENABLED")}}}},
+			error: "account 210987654321 is delegated administrator already"},
+		{description: "newly configured",
+			loaReq: shListOrgAdminsReq{output: &securityhub.ListOrganizationAdminAccountsOutput{}},
+			eoaReq: shEnableOrgAdminReq{expected: true}},
+		{description: "problem enabling administrator",
+			loaReq: shListOrgAdminsReq{output: &securityhub.ListOrganizationAdminAccountsOutput{}},
+			eoaReq: shEnableOrgAdminReq{expected: true, err: fmt.Errorf("mock err")},
+			error:  "error enabling delegated administrator: mock err"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			s := SecurityHubInviter{masterSvc: mockSHMasterClient{t: t, memberAccID: &adminAccID, loaReq: x.loaReq, eoaReq: x.eoaReq}}
+			err := s.EnableOrgAdmin(adminAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				var serviceErr *ServiceError
+				if assert.True(t, errors.As(err, &serviceErr), "Test case %d error type check failed", i) {
+					assert.Equal(t, "security_hub", serviceErr.Service, "Test case %d error service check failed", i)
+				}
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+		})
+	}
+}
+
+func TestSecurityHubInviter_Preflight(t *testing.T) {
+	memberAccID := "112233445566"
+	var testData = []struct {
+		description string
+		error       string
+		gmReq       shGetMembersReq
+		liReq       shListInvitationsReq
+	}{
+		{description: "problem getting members",
+			gmReq: shGetMembersReq{err: fmt.Errorf("mock err")},
+			error: "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "problem listing invitations",
+			gmReq: shGetMembersReq{output: &securityhub.GetMembersOutput{}},
+			liReq: shListInvitationsReq{err: fmt.Errorf("mock err")},
+			error: "error retrieving list of invitations: mock err"},
+		{description: "all checks passed",
+			gmReq: shGetMembersReq{output: &securityhub.GetMembersOutput{}},
+			liReq: shListInvitationsReq{output: &securityhub.ListInvitationsOutput{}}},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			s := SecurityHubInviter{
+				masterSvc: readOnlySHMasterClient{t: t, memberAccID: &memberAccID, gmReq: x.gmReq},
+				memberSvc: readOnlySHMemberClient{t: t, liReq: x.liReq},
+			}
+			err := s.Preflight(memberAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+		})
+	}
+}
+
+func TestSecurityHubInviter_MemberStatus(t *testing.T) {
+	memberAccID := "112233445566"
+	var testData = []struct {
+		description string
+		error       string
+		gmReq       shGetMembersReq
+		status      string
+	}{
+		{description: "problem getting members",
+			gmReq: shGetMembersReq{err: fmt.Errorf("mock err")},
+			error: "error retrieving information about existing member account: error getting existing members: mock err"},
+		{description: "not a member",
+			gmReq:  shGetMembersReq{output: &securityhub.GetMembersOutput{}},
+			status: MemberStatusNotMember},
+		{description: "member without status",
+			gmReq:  shGetMembersReq{output: &securityhub.GetMembersOutput{Members: []*securityhub.Member{{}}}},
+			status: MemberStatusNotMember},
+		{description: "invited member",
+			gmReq: shGetMembersReq{output: &securityhub.GetMembersOutput{
+				Members: []*securityhub.Member{{MemberStatus: aws.String("Invited")}}}},
+			status: "Invited"},
+		{description: "enabled member",
+			gmReq: shGetMembersReq{output: &securityhub.GetMembersOutput{
+				Members: []*securityhub.Member{{MemberStatus: aws.String("Enabled")}}}},
+			status: "Enabled"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			// read-only clients panic on any mutating call
+			s := SecurityHubInviter{
+				masterSvc: readOnlySHMasterClient{t: t, memberAccID: &memberAccID, gmReq: x.gmReq},
+				memberSvc: readOnlySHMemberClient{t: t},
+			}
+			status, err := s.MemberStatus(memberAccID)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+			assert.Equal(t, x.status, status, "Test case %d status check failed", i)
+		})
+	}
+}
+
+// readOnlySHMasterClient implements only read-only calls, any other call panics on nil embedded interface
+type readOnlySHMasterClient struct {
+	SecurityHubMasterClient
+	t           *testing.T
+	memberAccID *string
+	gmReq       shGetMembersReq
+}
+
+func (s readOnlySHMasterClient) GetMembers(input *securityhub.GetMembersInput) (*securityhub.GetMembersOutput, error) {
+	assert.Equal(s.t, &securityhub.GetMembersInput{AccountIds: []*string{s.memberAccID}}, input)
+	return s.gmReq.output, s.gmReq.err
+}
+
+// readOnlySHMemberClient implements only read-only calls, any other call panics on nil embedded interface
+type readOnlySHMemberClient struct {
+	SecurityHubMemberClient
+	t     *testing.T
+	liReq shListInvitationsReq
+}
+
+func (s readOnlySHMemberClient) ListInvitations(input *securityhub.ListInvitationsInput) (*securityhub.ListInvitationsOutput, error) {
+	assert.Nil(s.t, input)
+	return s.liReq.output, s.liReq.err
+}
@@ -18,43 +18,281 @@ package connectors
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/detective"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/securityhub"
 	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
 )
 
-// return valid AWS role ARN for provided accountID and role name
-func buildRoleARN(accountID, roleName string) string {
-	return fmt.Sprintf("arn:aws:iam::%s:role/%s", accountID, roleName)
+// userAgentName identifies requests of this program to AWS and Prisma, followed by the version
+const userAgentName = "bookingcom-aws-security-connectors"
+
+// UserAgent returns User-Agent value identifying requests of provided version of this program
+func UserAgent(version string) string {
+	return userAgentName + "/" + version
+}
+
+// return valid AWS role ARN for provided partition, accountID and role name
+func buildRoleARN(partition, accountID, roleName string) string {
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, accountID, roleName)
+}
+
+// IsValidAccountID returns true if provided string is a valid AWS account ID, which consists of exactly 12 digits
+func IsValidAccountID(id string) bool {
+	if len(id) != 12 {
+		return false
+	}
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
 }
 
-// GetAccountID returns AWS account ID using provided session, without error handling because in case of problem
-// with credentials we'll see it on the first use
+// GetAccountID returns AWS account ID of provided session credentials.
 func GetAccountID(session client.ConfigProvider) (string, error) {
-	arn, err := sts.New(session).GetCallerIdentity(nil)
+	return getAccountID(sts.New(session))
+}
+
+func getAccountID(s CallerIdentityClient) (string, error) {
+	identity, err := s.GetCallerIdentity(nil)
 	if err != nil {
 		return "", fmt.Errorf("problem retrieving account id: %w", err)
 	}
-	return *arn.Account, nil
+	if identity == nil || identity.Account == nil {
+		return "", fmt.Errorf("problem retrieving account id: no account in caller identity")
+	}
+	return *identity.Account, nil
+}
+
+// CallerIdentityClient is a subset of aws-sdk-go/service/sts which is used for checking account of credentials.
+type CallerIdentityClient interface {
+	GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error)
+}
+
+// VerifyMemberAccount makes sure that member role can be assumed with provided member session,
+// and that it belongs to the expected account. Member session credentials are lazy, so without this check
+// problem with the role surfaces only on the first call to a service.
+func VerifyMemberAccount(memberSess client.ConfigProvider, accountID, roleName string) error {
+	return verifyMemberAccount(sts.New(memberSess), accountID, roleName)
+}
+
+func verifyMemberAccount(s CallerIdentityClient, accountID, roleName string) error {
+	identity, err := s.GetCallerIdentity(nil)
+	if err != nil {
+		return fmt.Errorf("cannot assume role %s in account %s: %w", roleName, accountID, err)
+	}
+	if aws.StringValue(identity.Account) != accountID {
+		return fmt.Errorf("role %s is assumed in account %s instead of %s",
+			roleName, aws.StringValue(identity.Account), accountID)
+	}
+	return nil
+}
+
+// SessionConfig contains parameters of master and member sessions creation
+type SessionConfig struct {
+	Region    string
+	Partition string
+	// MemberAccountID and MemberRole are used to build ARN of the member role to assume
+	MemberAccountID string
+	MemberRole      string
+	// Profile is a named profile to use for master session instead of default credentials chain
+	Profile string
+	// RoleSessionName and RoleDuration are used for assuming the member role, SDK defaults are used if they are empty
+	RoleSessionName string
+	RoleDuration    time.Duration
+	// ExternalID is required by trust policy of the member role in some organizations, it's not used if empty
+	ExternalID string
+	// MFASerial is a serial number of MFA device required for assuming the member role, or the first role
+	// of RoleChain in case it's set. MFA is not used if empty
+	MFASerial string
+	// MFATokenProvider returns MFA token code, by default token is read from stdin
+	MFATokenProvider func() (string, error)
+	// MemberWebIdentity makes the member role assumed with web identity token from AWS_WEB_IDENTITY_TOKEN_FILE,
+	// which EKS sets for pods using IAM Roles for Service Accounts, instead of master credentials
+	MemberWebIdentity bool
+	// MemberCredentials are used for member session instead of assuming the member role anew in case they are set
+	MemberCredentials *credentials.Credentials
+	// RoleChain is a list of ARNs of intermediate roles which are assumed in order, each using credentials
+	// of the previous one, before assuming the member role with credentials of the last one
+	RoleChain []string
+	// Endpoint is a custom URL used for GuardDuty, Security Hub, Detective, AWS Config, Organizations and STS
+	// instead of AWS one, for example for testing against LocalStack
+	Endpoint string
+	// Proxy is a URL of HTTP proxy used for AWS calls, proxy from environment variables is used if nil
+	Proxy *url.URL
+	// UserAgent is appended to User-Agent header of all AWS requests, nothing is appended if empty
+	UserAgent string
+	// RateLimiter limits rate of AWS requests of all sessions it's shared by, requests are not limited if nil
+	RateLimiter *RateLimiter
+}
+
+// NewMasterSess returns AWS session.Session object for specified region for master account
+func NewMasterSess(cfg SessionConfig) *session.Session {
+	return newSess(session.Must(session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region:           aws.String(cfg.Region),
+			EndpointResolver: endpointResolver(cfg.Endpoint),
+			HTTPClient:       newHTTPClient(cfg.Proxy),
+		},
+		Profile: cfg.Profile,
+	})), cfg)
 }
 
 // NewMasterMemberSess returns AWS session.Session object for specified region for master account and
-// provided role in member account
-func NewMasterMemberSess(region, memberAccountID, memberRole string) (*session.Session, *session.Session) {
-	masterSess := session.Must(session.NewSession(
-		&aws.Config{
-			Region: aws.String(region),
-		}))
+// provided role in member account, which is reached through cfg.RoleChain roles in case it's set
+func NewMasterMemberSess(cfg SessionConfig) (*session.Session, *session.Session) {
+	masterSess := NewMasterSess(cfg)
+	return masterSess, NewMemberSess(masterSess, cfg)
+}
 
-	assumeArn := buildRoleARN(memberAccountID, memberRole)
-	stsCreds := stscreds.NewCredentials(masterSess, assumeArn)
-	memberSess := session.Must(session.NewSession(
+// NewMemberSess returns AWS session.Session object for specified region for provided role in member account,
+// which is assumed using provided master session
+func NewMemberSess(masterSess client.ConfigProvider, cfg SessionConfig) *session.Session {
+	stsCreds := cfg.MemberCredentials
+	if stsCreds == nil {
+		chainSess := roleChainSess(masterSess, cfg)
+		if len(cfg.RoleChain) > 0 {
+			// MFA is only used for the first role of the chain
+			cfg.MFASerial = ""
+		}
+		stsCreds = credentials.NewCredentials(memberCredentialsProvider(sts.New(chainSess), cfg))
+	}
+	return newSess(session.Must(session.NewSession(
 		&aws.Config{
-			Credentials: stsCreds,
-			Region:      aws.String(region),
-		}))
-	return masterSess, memberSess
+			Credentials:      stsCreds,
+			Region:           aws.String(cfg.Region),
+			EndpointResolver: endpointResolver(cfg.Endpoint),
+			HTTPClient:       newHTTPClient(cfg.Proxy),
+		})), cfg)
+}
+
+// roleChainSess returns session with credentials of the last role of cfg.RoleChain, each role of which is assumed
+// using credentials of the previous one, starting with provided master session.
+// Master session is returned as is in case the chain is empty.
+func roleChainSess(masterSess client.ConfigProvider, cfg SessionConfig) client.ConfigProvider {
+	sess := masterSess
+	for _, roleARN := range cfg.RoleChain {
+		sess = newSess(session.Must(session.NewSession(
+			&aws.Config{
+				Credentials:      stscreds.NewCredentials(sess, roleARN, assumeRoleOptions(cfg)),
+				Region:           aws.String(cfg.Region),
+				EndpointResolver: endpointResolver(cfg.Endpoint),
+				HTTPClient:       newHTTPClient(cfg.Proxy),
+			})), cfg)
+		cfg.MFASerial = ""
+	}
+	return sess
+}
+
+// newSess adds user agent and rate limiter of provided config to the session
+func newSess(sess *session.Session, cfg SessionConfig) *session.Session {
+	return addRateLimiter(addUserAgent(sess, cfg.UserAgent), cfg.RateLimiter)
+}
+
+// addUserAgent appends provided user agent to User-Agent header of all requests made by clients
+// of the session, session is returned as is in case user agent is empty
+func addUserAgent(sess *session.Session, userAgent string) *session.Session {
+	if userAgent != "" {
+		sess.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(userAgent))
+	}
+	return sess
+}
+
+// endpointResolver returns resolver which uses provided endpoint URL for services connected by this program,
+// and default AWS endpoints for the rest. Default resolver is returned in case endpoint is empty.
+func endpointResolver(endpoint string) endpoints.Resolver {
+	if endpoint == "" {
+		return endpoints.DefaultResolver()
+	}
+	return endpoints.ResolverFunc(func(service, region string, opts ...func(*endpoints.Options)) (endpoints.ResolvedEndpoint, error) {
+		switch service {
+		case guardduty.EndpointsID, securityhub.EndpointsID, detective.EndpointsID, configservice.EndpointsID,
+			organizations.EndpointsID, sts.EndpointsID:
+			return endpoints.ResolvedEndpoint{URL: endpoint, SigningRegion: region}, nil
+		}
+		return endpoints.DefaultResolver().EndpointFor(service, region, opts...)
+	})
+}
+
+// newHTTPClient returns HTTP client sending requests through provided proxy,
+// or through the one set in HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables in case proxy is nil
+func newHTTPClient(proxy *url.URL) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Transport: transport}
+}
+
+// memberCredentialsProvider returns provider of the member role credentials. In case member web identity
+// is enabled, the member role is assumed with the web identity token directly, otherwise it's assumed
+// using credentials of provided STS client.
+func memberCredentialsProvider(stsSvc stsiface.STSAPI, cfg SessionConfig) credentials.Provider {
+	assumeArn := buildRoleARN(cfg.Partition, cfg.MemberAccountID, cfg.MemberRole)
+
+	if cfg.MemberWebIdentity {
+		p := stscreds.NewWebIdentityRoleProvider(stsSvc, assumeArn, cfg.RoleSessionName, os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+		p.Duration = cfg.RoleDuration
+		return p
+	}
+
+	p := &stscreds.AssumeRoleProvider{
+		Client:   stsSvc,
+		RoleARN:  assumeArn,
+		Duration: stscreds.DefaultDuration,
+	}
+	assumeRoleOptions(cfg)(p)
+	if cfg.ExternalID != "" {
+		p.ExternalID = aws.String(cfg.ExternalID)
+	}
+	return p
+}
+
+// assumeRoleOptions returns function which sets member role assuming options from provided config
+func assumeRoleOptions(cfg SessionConfig) func(*stscreds.AssumeRoleProvider) {
+	return func(p *stscreds.AssumeRoleProvider) {
+		if cfg.RoleSessionName != "" {
+			p.RoleSessionName = cfg.RoleSessionName
+		}
+		if cfg.RoleDuration != 0 {
+			p.Duration = cfg.RoleDuration
+		}
+		if cfg.MFASerial != "" {
+			p.SerialNumber = aws.String(cfg.MFASerial)
+			p.TokenProvider = cfg.MFATokenProvider
+			if p.TokenProvider == nil {
+				p.TokenProvider = stscreds.StdinTokenProvider
+			}
+		}
+	}
+}
+
+// sortedCopy returns sorted copy of provided slice, keeping nil as is
+func sortedCopy(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	res := make([]string, len(s))
+	copy(res, s)
+	sort.Strings(res)
+	return res
 }
@@ -0,0 +1,360 @@
+// Copyright 2020 Booking.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/detective"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/guardduty"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsValidAccountID(t *testing.T) {
+	testData := []struct {
+		id    string
+		valid bool
+	}{
+		{id: "112233445566", valid: true},
+		{id: "000000000001", valid: true},
+		{id: "012345678901", valid: true},
+		{id: ""},
+		{id: "11223344556"},
+		{id: "1122334455667"},
+		{id: "1122-3344-5566"},
+		{id: "11223344556a"},
+		{id: " 12233445566"},
+		{id: "１１２２３３４４５５６６"},
+	}
+
+	for i, x := range testData {
+		assert.Equal(t, x.valid, IsValidAccountID(x.id), "Test case %d (%q) check failed", i, x.id)
+	}
+}
+
+func TestBuildRoleARN(t *testing.T) {
+	var testDataset = []struct {
+		partition string
+		arn       string
+	}{
+		{partition: "aws", arn: "arn:aws:iam::112233445566:role/test_role"},
+		{partition: "aws-us-gov", arn: "arn:aws-us-gov:iam::112233445566:role/test_role"},
+		{partition: "aws-cn", arn: "arn:aws-cn:iam::112233445566:role/test_role"},
+	}
+
+	for _, x := range testDataset {
+		assert.Equal(t, x.arn, buildRoleARN(x.partition, "112233445566", "test_role"))
+	}
+}
+
+func TestNewMasterMemberSess(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	require.NoError(t, os.WriteFile(credentialsFile, []byte(`[default]
+aws_access_key_id = default_key
+aws_secret_access_key = default_secret
+
+[test_profile]
+aws_access_key_id = profile_key
+aws_secret_access_key = profile_secret
+`), 0o600))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	t.Setenv("AWS_PROFILE", "")
+
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws"})
+	creds, err := masterSess.Config.Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "default_key", creds.AccessKeyID)
+	assert.Equal(t, "us-west-2", *memberSess.Config.Region)
+
+	masterSess, _ = NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws", Profile: "test_profile"})
+	creds, err = masterSess.Config.Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "profile_key", creds.AccessKeyID)
+	assert.Equal(t, "profile_secret", creds.SecretAccessKey)
+}
+
+func TestNewMasterMemberSess_RoleChain(t *testing.T) {
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+	t.Setenv("AWS_ACCESS_KEY_ID", "master_key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "master_secret")
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	t.Setenv("AWS_ROLE_ARN", "")
+
+	// fake STS returns credentials with access key named after the assumed role,
+	// and records which access key was used to assume each role
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		roleARN := r.Form.Get("RoleArn")
+		accessKey := strings.SplitN(strings.SplitN(r.Header.Get("Authorization"), "Credential=", 2)[1], "/", 2)[0]
+		calls = append(calls, fmt.Sprintf("%s %s %s", accessKey, roleARN, r.Form.Get("SerialNumber")))
+		roleName := roleARN[strings.LastIndex(roleARN, "/")+1:]
+		fmt.Fprintf(w, `<AssumeRoleResponse><AssumeRoleResult><Credentials>
+<AccessKeyId>%s_key</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+<Expiration>%s</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+			roleName, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	_, memberSess := NewMasterMemberSess(SessionConfig{
+		Region:           "us-west-2",
+		Partition:        "aws",
+		MemberAccountID:  "112233445566",
+		MemberRole:       "member_role",
+		MFASerial:        "arn:aws:iam::665544332211:mfa/test_user",
+		MFATokenProvider: func() (string, error) { return "123456", nil },
+		RoleChain: []string{
+			"arn:aws:iam::111111111111:role/hub_role",
+			"arn:aws:iam::222222222222:role/second_hub_role",
+		},
+		Endpoint: ts.URL,
+	})
+	creds, err := memberSess.Config.Credentials.Get()
+	require.NoError(t, err)
+	assert.Equal(t, "member_role_key", creds.AccessKeyID)
+	assert.Equal(t, []string{
+		"master_key arn:aws:iam::111111111111:role/hub_role arn:aws:iam::665544332211:mfa/test_user",
+		"hub_role_key arn:aws:iam::222222222222:role/second_hub_role ",
+		"second_hub_role_key arn:aws:iam::112233445566:role/member_role ",
+	}, calls)
+}
+
+func TestEndpointResolver(t *testing.T) {
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws",
+		Endpoint: "http://localhost:4566"})
+	assert.Equal(t, "http://localhost:4566", guardduty.New(masterSess).Endpoint)
+	assert.Equal(t, "http://localhost:4566", securityhub.New(memberSess).Endpoint)
+	assert.Equal(t, "http://localhost:4566", detective.New(masterSess).Endpoint)
+	assert.Equal(t, "http://localhost:4566", organizations.New(masterSess).Endpoint)
+	assert.Equal(t, "http://localhost:4566", sts.New(masterSess).Endpoint)
+	assert.Equal(t, "us-west-2", guardduty.New(masterSess).SigningRegion)
+	// other services are not overridden
+	assert.Equal(t, "https://ec2.us-west-2.amazonaws.com", ec2.New(masterSess).Endpoint)
+
+	masterSess, _ = NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws"})
+	assert.Equal(t, "https://guardduty.us-west-2.amazonaws.com", guardduty.New(masterSess).Endpoint)
+}
+
+func TestAssumeRoleOptions(t *testing.T) {
+	p := &stscreds.AssumeRoleProvider{}
+	assumeRoleOptions(SessionConfig{})(p)
+	assert.Equal(t, &stscreds.AssumeRoleProvider{}, p)
+
+	p = &stscreds.AssumeRoleProvider{}
+	assumeRoleOptions(SessionConfig{RoleSessionName: "test_session", RoleDuration: time.Hour})(p)
+	assert.Equal(t, &stscreds.AssumeRoleProvider{RoleSessionName: "test_session", Duration: time.Hour}, p)
+
+	p = &stscreds.AssumeRoleProvider{}
+	assumeRoleOptions(SessionConfig{MFASerial: "arn:aws:iam::112233445566:mfa/test_user"})(p)
+	assert.Equal(t, aws.String("arn:aws:iam::112233445566:mfa/test_user"), p.SerialNumber)
+	assert.NotNil(t, p.TokenProvider)
+
+	p = &stscreds.AssumeRoleProvider{}
+	assumeRoleOptions(SessionConfig{
+		MFASerial:        "arn:aws:iam::112233445566:mfa/test_user",
+		MFATokenProvider: func() (string, error) { return "123456", nil },
+	})(p)
+	require.NotNil(t, p.TokenProvider)
+	token, err := p.TokenProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "123456", token)
+}
+
+func TestMemberCredentialsProvider(t *testing.T) {
+	cfg := SessionConfig{Partition: "aws", MemberAccountID: "112233445566", MemberRole: "test_role",
+		RoleSessionName: "test_session", RoleDuration: time.Hour}
+	stsSvc := sts.New(unit.Session)
+
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "")
+	t.Setenv("AWS_ROLE_ARN", "")
+	assert.Equal(t, &stscreds.AssumeRoleProvider{
+		Client:          stsSvc,
+		RoleARN:         "arn:aws:iam::112233445566:role/test_role",
+		RoleSessionName: "test_session",
+		Duration:        time.Hour,
+	}, memberCredentialsProvider(stsSvc, cfg))
+
+	externalIDCfg := cfg
+	externalIDCfg.ExternalID = "test_external_id"
+	assert.Equal(t, &stscreds.AssumeRoleProvider{
+		Client:          stsSvc,
+		RoleARN:         "arn:aws:iam::112233445566:role/test_role",
+		RoleSessionName: "test_session",
+		Duration:        time.Hour,
+		ExternalID:      aws.String("test_external_id"),
+	}, memberCredentialsProvider(stsSvc, externalIDCfg))
+
+	// web identity set in environment, e.g. by EKS, isn't used for the member role unless it's enabled
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/token")
+	t.Setenv("AWS_ROLE_ARN", "arn:aws:iam::665544332211:role/master_role")
+	assert.IsType(t, &stscreds.AssumeRoleProvider{}, memberCredentialsProvider(stsSvc, cfg))
+
+	webIdentityCfg := cfg
+	webIdentityCfg.MemberWebIdentity = true
+	expected := stscreds.NewWebIdentityRoleProvider(stsSvc, "arn:aws:iam::112233445566:role/test_role",
+		"test_session", "/var/run/secrets/token")
+	expected.Duration = time.Hour
+	assert.Equal(t, expected, memberCredentialsProvider(stsSvc, webIdentityCfg))
+}
+
+func TestNewHTTPClient(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://guardduty.us-west-2.amazonaws.com", nil)
+
+	transport, ok := newHTTPClient(nil).Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, reflect.ValueOf(http.ProxyFromEnvironment).Pointer(), reflect.ValueOf(transport.Proxy).Pointer())
+
+	proxy, err := url.Parse("http://proxy.example.com:3128")
+	require.NoError(t, err)
+	transport, ok = newHTTPClient(proxy).Transport.(*http.Transport)
+	require.True(t, ok)
+	proxyURL, err := transport.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, proxy, proxyURL)
+
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws", Proxy: proxy})
+	for _, sess := range []*session.Session{masterSess, memberSess} {
+		transport, ok = sess.Config.HTTPClient.Transport.(*http.Transport)
+		require.True(t, ok)
+		proxyURL, err = transport.Proxy(req)
+		require.NoError(t, err)
+		assert.Equal(t, proxy, proxyURL)
+	}
+
+	prismaTransport, ok := newPrismaClient(context.Background(), "", "", "", "", time.Second, proxy).httpClient.Transport.(*http.Transport)
+	require.True(t, ok)
+	proxyURL, err = prismaTransport.Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, proxy, proxyURL)
+}
+
+func TestAddUserAgent(t *testing.T) {
+	userAgent := UserAgent("1.2.3")
+	assert.Equal(t, "bookingcom-aws-security-connectors/1.2.3", userAgent)
+
+	masterSess, memberSess := NewMasterMemberSess(SessionConfig{Region: "us-west-2", Partition: "aws", UserAgent: userAgent})
+	for _, sess := range []*session.Session{masterSess, memberSess} {
+		req, _ := guardduty.New(sess).ListDetectorsRequest(&guardduty.ListDetectorsInput{})
+		require.NoError(t, req.Build())
+		assert.Contains(t, req.HTTPRequest.Header.Get("User-Agent"), " "+userAgent)
+	}
+
+	// nothing is appended in case user agent is not set
+	req, _ := guardduty.New(NewMasterSess(SessionConfig{Region: "us-west-2"})).ListDetectorsRequest(&guardduty.ListDetectorsInput{})
+	require.NoError(t, req.Build())
+	assert.NotContains(t, req.HTTPRequest.Header.Get("User-Agent"), userAgentName)
+}
+
+type mockCallerIdentityClient struct {
+	account string
+	err     error
+	// noAccount makes identity returned without account
+	noAccount bool
+}
+
+func (m mockCallerIdentityClient) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.noAccount {
+		return &sts.GetCallerIdentityOutput{}, nil
+	}
+	return &sts.GetCallerIdentityOutput{Account: aws.String(m.account)}, nil
+}
+
+func TestGetAccountID(t *testing.T) {
+	testData := []struct {
+		description string
+		client      mockCallerIdentityClient
+		accountID   string
+		error       string
+	}{
+		{description: "account retrieved",
+			client:    mockCallerIdentityClient{account: "665544332211"},
+			accountID: "665544332211"},
+		{description: "problem getting caller identity",
+			client: mockCallerIdentityClient{err: fmt.Errorf("ExpiredToken")},
+			error:  "problem retrieving account id: ExpiredToken"},
+		{description: "no account in caller identity",
+			client: mockCallerIdentityClient{noAccount: true},
+			error:  "problem retrieving account id: no account in caller identity"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			accountID, err := getAccountID(x.client)
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+				return
+			}
+			assert.NoError(t, err, "Test case %d error check failed", i)
+			assert.Equal(t, x.accountID, accountID, "Test case %d account check failed", i)
+		})
+	}
+}
+
+func TestVerifyMemberAccount(t *testing.T) {
+	testData := []struct {
+		description string
+		client      mockCallerIdentityClient
+		error       string
+	}{
+		{description: "role assumed in expected account",
+			client: mockCallerIdentityClient{account: "112233445566"}},
+		{description: "role can't be assumed",
+			client: mockCallerIdentityClient{err: fmt.Errorf("AccessDenied")},
+			error:  "cannot assume role test_role in account 112233445566: AccessDenied"},
+		{description: "role assumed in other account",
+			client: mockCallerIdentityClient{account: "665544332211"},
+			error:  "role test_role is assumed in account 665544332211 instead of 112233445566"},
+	}
+
+	for i, x := range testData {
+		i := i
+		x := x
+		t.Run(x.description, func(t *testing.T) {
+			err := verifyMemberAccount(x.client, "112233445566", "test_role")
+			if x.error != "" {
+				assert.EqualError(t, err, x.error, "Test case %d error check failed", i)
+			} else {
+				assert.NoError(t, err, "Test case %d error check failed", i)
+			}
+		})
+	}
+}
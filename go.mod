@@ -6,7 +6,7 @@ require (
 	github.com/aws/aws-sdk-go v1.44.209
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/jessevdk/go-flags v1.5.0
-	github.com/paskal/go-prisma v1.0.0
+	github.com/prometheus/client_golang v1.12.2
 	github.com/sirupsen/logrus v1.9.0
 	github.com/stretchr/testify v1.8.2
 )
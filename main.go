@@ -15,11 +15,22 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/hashicorp/go-multierror"
 	"github.com/jessevdk/go-flags"
 	log "github.com/sirupsen/logrus"
@@ -27,109 +38,960 @@ import (
 	"github.com/bookingcom/aws-security-connectors/connectors"
 )
 
+// build metadata is set at build time with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=...",
+// version is also sent to AWS and Prisma in User-Agent
+var (
+	version   = "dev"     // nolint:gochecknoglobals
+	commit    = "unknown" // nolint:gochecknoglobals
+	buildDate = "unknown" // nolint:gochecknoglobals
+)
+
+// exit codes of the run, exit code 1 is returned for invalid parameters
+const (
+	exitCodeSuccess        = 0
+	exitCodeFailure        = 3
+	exitCodePartialFailure = 4
+)
+
+const exitCodesDescription = `Exit codes:
+  0  all operations succeeded
+  1  invalid parameters
+  3  all operations failed
+  4  some operations failed while others succeeded`
+
+// defaultRegionExceptions returns opt-in regions skipped unless regions to process or skip are set explicitly,
+// or opt-in regions enabling is requested
+func defaultRegionExceptions() []string {
+	return []string{"ap-east-1", "me-south-1"}
+}
+
 //nolint:staticcheck
 type opts struct {
 	Prisma struct {
-		AccountName string `long:"account_name" env:"ACCOUNT_NAME" description:"Name for AWS connection"`
-		ExternalID  string `long:"external_id" env:"EXTERNAL_ID" description:"An UUID that is used to enable the trust relationship in the role's trust policy"`
-		RoleName    string `long:"role_name" env:"ROLE_NAME" description:"Name of AWS role, created for Prisma"`
-		APIUrl      string `long:"api_url" env:"API_URL" default:"https://api.eu.prismacloud.io" description:"Prisma API URL"`
-		APIKey      string `long:"api_key" env:"API_KEY" description:"Prisma API key"`
-		APIPassword string `long:"api_password" env:"API_PASSWORD" description:"Prisma API password"`
+		AccountName    string        `long:"account_name" env:"ACCOUNT_NAME" description:"Name for AWS connection"`
+		Accounts       []string      `long:"account" env:"ACCOUNTS" env-delim:"," description:"AWS account to add to Prisma in account_id[:name] format, can be repeated to add several accounts in one run"`
+		ExternalID     string        `long:"external_id" env:"EXTERNAL_ID" description:"An UUID that is used to enable the trust relationship in the role's trust policy"`
+		ExtIDFile      string        `long:"external_id_file" env:"EXTERNAL_ID_FILE" description:"File to read external ID from in case it's not set explicitly"`
+		ExtIDSecretARN string        `long:"external_id_secret_arn" env:"EXTERNAL_ID_SECRET_ARN" description:"ARN of AWS Secrets Manager secret to read external ID from in case it's set neither explicitly nor by file"`
+		RoleName       string        `long:"role_name" env:"ROLE_NAME" description:"Name of AWS role, created for Prisma"`
+		APIUrl         string        `long:"api_url" env:"API_URL" description:"Prisma API URL, https://api.eu.prismacloud.io if neither URL nor region is set"`
+		Region         string        `long:"region" env:"REGION" description:"Prisma region to use API URL of, e.g. eu, us, app2 or anz; ignored if API URL is set"`
+		APIKey         string        `long:"api_key" env:"API_KEY" description:"Prisma API key"`
+		APIPassword    string        `long:"api_password" env:"API_PASSWORD" description:"Prisma API password"`
+		SecretARN      string        `long:"secret_arn" env:"SECRET_ARN" description:"ARN of AWS Secrets Manager secret with Prisma API key and password in api_key and api_password JSON fields, used instead of API key and password"`
+		GroupIDs       []string      `long:"group_ids" env:"GROUP_IDS" env-delim:"," description:"IDs of Prisma account groups to put AWS account into"`
+		MaxRetries     int           `long:"max_retries" env:"MAX_RETRIES" default:"3" description:"Number of retries of requests throttled by Prisma API"`
+		ProtectionMode string        `long:"protection_mode" env:"PROTECTION_MODE" choice:"MONITOR" choice:"MONITOR_AND_PROTECT" description:"Protection mode of AWS account in Prisma, existing account mode is kept if not set"`
+		Timeout        time.Duration `long:"timeout" env:"TIMEOUT" default:"30s" description:"Timeout of a single Prisma API request"`
+		APIVersion     string        `long:"api_version" env:"API_VERSION" default:"legacy" choice:"legacy" choice:"v2" description:"Prisma API version defining AWS account creation request schema, v2 one has cloud type and nested account details"`
+		NoUpdate       bool          `long:"no_update" env:"NO_UPDATE" description:"Only create AWS accounts missing in Prisma, leaving existing ones as is"`
+		ForceUpdate    bool          `long:"force_update" env:"FORCE_UPDATE" description:"Update existing Prisma AWS accounts even in case they are equal to desired ones"`
+		CheckOnly      bool          `long:"check_only" env:"CHECK_ONLY" description:"Only report fields of existing Prisma AWS account which differ from desired ones, without changing anything"`
 	} `group:"Prisma parameters" namespace:"prisma" env-namespace:"PRISMA"`
 	AWS struct {
-		AccountID        string   `long:"account_id" env:"ACCOUNT_ID" required:"true" description:"ID of AWS account to add"`
-		Email            string   `long:"account_email" env:"ACCOUNT_EMAIL" description:"Member account email for invitation sending"`
-		RoleName         string   `long:"role_name" env:"ROLE_NAME" description:"Name of member account AWS role to assume for invitation accepting"`
-		RegionExceptions []string `long:"region_exceptions" env:"REGION_EXCEPTIONS" default:"ap-east-1" default:"me-south-1" description:"Regions to skip" env-delim:","`
-		Detective        bool     `long:"detective" env:"DETECTIVE" description:"Connect Detective"`
-		GuardDuty        bool     `long:"guardduty" env:"GUARDDUTY" description:"Connect GuardDuty"`
-		SecurityHub      bool     `long:"security_hub" env:"SECURITY_HUB" description:"Connect Security Hub"`
+		AccountID            string        `long:"account_id" env:"ACCOUNT_ID" description:"ID of AWS account to add"`
+		Endpoint             string        `long:"endpoint" env:"ENDPOINT" description:"Custom endpoint URL for GuardDuty, Security Hub, Detective, AWS Config, Organizations and STS, e.g. LocalStack one"`
+		APIRateLimit         float64       `long:"api_rate_limit" env:"API_RATE_LIMIT" description:"Maximum number of AWS API requests per second made across all regions and accounts, not limited if not set"`
+		MasterAccountID      string        `long:"master_account_id" env:"MASTER_ACCOUNT_ID" description:"ID of master AWS account, retrieved using STS if not set"`
+		Email                string        `long:"account_email" env:"ACCOUNT_EMAIL" description:"Member account email for invitation sending"`
+		RoleName             string        `long:"role_name" env:"ROLE_NAME" description:"Name of member account AWS role to assume for invitation accepting"`
+		Profile              string        `long:"profile" env:"PROFILE" description:"Named AWS profile to use for master account instead of default credentials chain"`
+		RoleSessionName      string        `long:"role_session_name" env:"ROLE_SESSION_NAME" default:"aws-security-connectors" description:"Session name for assuming member account role"`
+		RoleDuration         time.Duration `long:"role_duration" env:"ROLE_DURATION" default:"15m" description:"Duration of member account role session"`
+		MemberExternalID     string        `long:"member_external_id" env:"MEMBER_EXTERNAL_ID" description:"External ID required to assume member account role, not used if not set"`
+		MFASerial            string        `long:"mfa_serial" env:"MFA_SERIAL" description:"Serial number of MFA device required to assume member account role, token is asked interactively"`
+		MemberWebIdentity    bool          `long:"member_web_identity" env:"MEMBER_WEB_IDENTITY" description:"Assume member account role with web identity token from AWS_WEB_IDENTITY_TOKEN_FILE, e.g. in EKS with IAM Roles for Service Accounts, instead of master account credentials"`
+		RoleChain            []string      `long:"assume_role_chain" env:"ASSUME_ROLE_CHAIN" env-delim:"," description:"ARN of intermediate role to assume before member account role, can be repeated to assume several roles in order"`
+		Regions              []string      `long:"regions" env:"REGIONS" description:"Regions to process, all regions of the partition are processed if not set" env-delim:","`
+		OnlyEnabledRegions   bool          `long:"only_enabled_regions" env:"ONLY_ENABLED_REGIONS" description:"Process only regions enabled for master account"`
+		RegionExceptions     []string      `long:"region_exceptions" env:"REGION_EXCEPTIONS" description:"Regions to skip, ap-east-1 and me-south-1 if not set and opt-in regions are not enabled" env-delim:","`
+		ServiceExceptions    string        `long:"service_region_exceptions" env:"SERVICE_REGION_EXCEPTIONS" description:"Comma-separated service:region pairs to skip particular services in some regions, e.g. detective:ap-east-1"`
+		EnableOptInRegions   bool          `long:"enable_opt_in_regions" env:"ENABLE_OPT_IN_REGIONS" description:"Enable opt-in regions for member account before connecting services there"`
+		OptInTimeout         time.Duration `long:"opt_in_timeout" env:"OPT_IN_TIMEOUT" default:"30m" description:"Time to wait for opt-in region to be enabled"`
+		Detective            bool          `long:"detective" env:"DETECTIVE" description:"Connect Detective"`
+		DetectivePackages    string        `long:"detective_packages" env:"DETECTIVE_PACKAGES" description:"Comma-separated optional Detective data source packages to enable for master graph, which applies to all its members: eks_audit"`
+		DetectiveConnected   []string      `long:"detective_connected_statuses" env:"DETECTIVE_CONNECTED_STATUSES" env-delim:"," default:"ENABLED" description:"Detective member status meaning that member is connected, can be repeated"`
+		GuardDuty            bool          `long:"guardduty" env:"GUARDDUTY" description:"Connect GuardDuty"`
+		GuardDutyFeatures    string        `long:"guardduty_features" env:"GUARDDUTY_FEATURES" description:"Comma-separated GuardDuty features to enable on member: s3_logs, kubernetes_audit_logs, malware_protection"`
+		GuardDutyConnected   []string      `long:"guardduty_connected_statuses" env:"GUARDDUTY_CONNECTED_STATUSES" env-delim:"," default:"Enabled" description:"GuardDuty member relationship status meaning that member is connected, can be repeated"`
+		CreateMemberDetector bool          `long:"create_member_detector" env:"CREATE_MEMBER_DETECTOR" description:"Create and enable GuardDuty detector in member account before accepting invitation in case it has none"`
+		PublishFrequency     string        `long:"guardduty_publish_frequency" env:"GUARDDUTY_PUBLISH_FREQUENCY" description:"GuardDuty finding publishing frequency to set on master detector, which members inherit: FIFTEEN_MINUTES, ONE_HOUR or SIX_HOURS"`
+		FindingsBucket       string        `long:"guardduty_findings_bucket" env:"GUARDDUTY_FINDINGS_BUCKET" description:"ARN of S3 bucket to export master account GuardDuty findings to, e.g. arn:aws:s3:::findings-bucket"`
+		FindingsKMSKey       string        `long:"guardduty_findings_kms_key" env:"GUARDDUTY_FINDINGS_KMS_KEY" description:"ARN of KMS key to encrypt exported GuardDuty findings with, required with findings bucket"`
+		ConfigAggregator     bool          `long:"config_aggregator" env:"CONFIG_AGGREGATOR" description:"Configure AWS Config organization aggregator of processed regions in master account, which should be AWS Config delegated administrator or organization management account"`
+		AggregatorName       string        `long:"config_aggregator_name" env:"CONFIG_AGGREGATOR_NAME" default:"organization" description:"Name of AWS Config organization aggregator"`
+		AggregatorRole       string        `long:"config_aggregator_role" env:"CONFIG_AGGREGATOR_ROLE" description:"ARN of IAM role which AWS Config assumes to read the organization, required with AWS Config aggregator"`
+		AggregatorRegion     string        `long:"config_aggregator_region" env:"CONFIG_AGGREGATOR_REGION" description:"Region to configure AWS Config organization aggregator in, required with AWS Config aggregator"`
+		InviteMessage        string        `long:"invite_message" env:"INVITE_MESSAGE" description:"Message to add to GuardDuty invitation"`
+		EnableInviteEmails   bool          `long:"enable_invite_emails" env:"ENABLE_INVITE_EMAILS" description:"Notify member account about GuardDuty invitation by email"`
+		WaitForEnabled       bool          `long:"wait_for_enabled" env:"WAIT_FOR_ENABLED" description:"Wait for member account to become enabled in master after accepting invitation"`
+		WaitTimeout          time.Duration `long:"wait_for_enabled_timeout" env:"WAIT_FOR_ENABLED_TIMEOUT" default:"5m" description:"Time to wait for member account to become enabled"`
+		InviteAttempts       int           `long:"invitation_attempts" env:"INVITATION_ATTEMPTS" default:"3" description:"Number of times to look for just sent invitation in member account"`
+		InviteRetryDelay     time.Duration `long:"invitation_retry_delay" env:"INVITATION_RETRY_DELAY" default:"5s" description:"Delay before looking for invitation again, doubled after every attempt"`
+		OrgMode              bool          `long:"org_mode" env:"ORG_MODE" description:"Make master account delegated administrator of the organization for enabled services: GuardDuty and Security Hub with new accounts auto-enabled, and Detective"`
+		OrgDefaultStandards  bool          `long:"org_default_standards" env:"ORG_DEFAULT_STANDARDS" description:"Enable default Security Hub standards for new organization accounts in organization mode"`
+		OrgManagementProfile string        `long:"org_management_profile" env:"ORG_MANAGEMENT_PROFILE" description:"Named AWS profile of organization management account, default credentials chain is used if not set"`
+		AllOrgAccounts       bool          `long:"all_org_accounts" env:"ALL_ORG_ACCOUNTS" description:"Connect all active organization accounts instead of provided account ID"`
+		AccountInclude       []string      `long:"account_include" env:"ACCOUNT_INCLUDE" env-delim:"," description:"Connect only these organization accounts, can be repeated; management account is connected only if included"`
+		AccountExclude       []string      `long:"account_exclude" env:"ACCOUNT_EXCLUDE" env-delim:"," description:"Never connect these organization accounts, can be repeated; takes precedence over account_include"`
+		AccountDelay         time.Duration `long:"account_delay" env:"ACCOUNT_DELAY" description:"Pause between organization accounts in every region, randomized between its half and full value, e.g. 2s; no pause if not set"`
+		AccountTagFilter     string        `long:"account_tag_filter" env:"ACCOUNT_TAG_FILTER" description:"Connect only organization accounts having the tag, in key=value format, e.g. security-managed=true"`
+		Services             string        `long:"services" env:"SERVICES" description:"Comma-separated services to connect in addition to ones enabled by separate flags: guardduty, securityhub, detective"`
+		SecurityHub          bool          `long:"security_hub" env:"SECURITY_HUB" description:"Connect Security Hub"`
+		AggregationRegion    string        `long:"security_hub_aggregation_region" env:"SECURITY_HUB_AGGREGATION_REGION" description:"Region to aggregate Security Hub findings of all processed regions in"`
+		SecurityHubStandards []string      `long:"security_hub_standards" env:"SECURITY_HUB_STANDARDS" env-delim:"," description:"Security Hub standards to enable on member, e.g. aws-foundational-security-best-practices/v/1.0.0"`
+		SecurityHubConnected []string      `long:"security_hub_connected_statuses" env:"SECURITY_HUB_CONNECTED_STATUSES" env-delim:"," default:"Associated" description:"Security Hub member status meaning that member is connected, can be repeated"`
+		DisabledControls     []string      `long:"security_hub_disabled_controls" env:"SECURITY_HUB_DISABLED_CONTROLS" env-delim:"," description:"Security Hub control to disable on member in standard:control_id format, e.g. aws-foundational-security-best-practices/v/1.0.0:IAM.6; can be repeated"`
+		// boolean flags can't default to true, so string with choice is used
+		VerifyMemberAccount  string `long:"verify_member_account" env:"VERIFY_MEMBER_ACCOUNT" default:"true" choice:"true" choice:"false" optional:"yes" optional-value:"true" description:"Make sure member role can be assumed and belongs to member account before connecting services"`
+		SuppressInviteEmails string `long:"suppress_invite_emails" env:"SUPPRESS_INVITE_EMAILS" default:"true" choice:"true" choice:"false" optional:"yes" optional-value:"true" description:"Create Security Hub members without email so that invitation emails are not sent"`
 	} `group:"AWS security services parameters" namespace:"aws" env-namespace:"AWS"`
-	Dbg bool `long:"dbg" env:"DEBUG" description:"debug mode"`
+	Azure struct {
+		SubscriptionID     string `long:"subscription_id" env:"SUBSCRIPTION_ID" description:"ID of Azure subscription to add to Prisma"`
+		AccountName        string `long:"account_name" env:"ACCOUNT_NAME" description:"Name for Azure connection in Prisma"`
+		TenantID           string `long:"tenant_id" env:"TENANT_ID" description:"Azure Active Directory tenant ID"`
+		ClientID           string `long:"client_id" env:"CLIENT_ID" description:"Application (client) ID of Azure app registered for Prisma"`
+		Key                string `long:"application_key" env:"APPLICATION_KEY" description:"Application key (client secret) of Azure app registered for Prisma"`
+		ServicePrincipalID string `long:"service_principal_id" env:"SERVICE_PRINCIPAL_ID" description:"Object ID of the service principal of Azure app registered for Prisma"`
+		MonitorFlowLogs    bool   `long:"monitor_flow_logs" env:"MONITOR_FLOW_LOGS" description:"Ingest Azure flow logs to Prisma"`
+	} `group:"Azure parameters" namespace:"azure" env-namespace:"AZURE"`
+	GCP struct {
+		ProjectID          string `long:"project_id" env:"PROJECT_ID" description:"ID of GCP project to add to Prisma"`
+		AccountName        string `long:"account_name" env:"ACCOUNT_NAME" description:"Name for GCP connection in Prisma"`
+		CredentialsFile    string `long:"credentials_file" env:"CREDENTIALS_FILE" description:"Path to JSON credentials file of GCP service account created for Prisma"`
+		CompressionEnabled bool   `long:"compression_enabled" env:"COMPRESSION_ENABLED" description:"Enable flow logs compression"`
+		DataflowProject    string `long:"dataflow_project" env:"DATAFLOW_PROJECT" description:"GCP project to run Dataflow flow logs compression jobs in"`
+		FlowLogBucket      string `long:"flow_log_bucket" env:"FLOW_LOG_BUCKET" description:"GCS bucket with flow logs"`
+	} `group:"GCP parameters" namespace:"gcp" env-namespace:"GCP"`
+	Partition   string `long:"partition" env:"PARTITION" default:"aws" choice:"aws" choice:"aws-us-gov" choice:"aws-cn" description:"AWS partition of the account"`
+	Preflight   bool   `long:"preflight" env:"PREFLIGHT" description:"Only verify permissions with read-only calls, without changing anything"`
+	Status      bool   `long:"status" env:"STATUS" description:"Only report current status of member account in enabled AWS services, without changing anything"`
+	FailFast    bool   `long:"fail_fast" env:"FAIL_FAST" description:"Stop the run once member account fails to connect to any AWS service, instead of trying the rest"`
+	Proxy       string `long:"proxy" env:"PROXY" description:"URL of HTTP proxy for AWS and Prisma calls, HTTP_PROXY and HTTPS_PROXY environment variables are used if not set"`
+	MetricsAddr string `long:"metrics_addr" env:"METRICS_ADDR" description:"Address to expose Prometheus metrics on during the run, e.g. :9090"`
+	ReportFile  string `long:"report_file" env:"REPORT_FILE" description:"File to write JSON report of AWS services connection results to"`
+	LogFormat   string `long:"log_format" env:"LOG_FORMAT" default:"text" choice:"text" choice:"json" description:"Format of log messages"`
+	Dbg         bool   `long:"dbg" env:"DEBUG" description:"debug mode"`
+	LogLevel    string `long:"log_level" env:"LOG_LEVEL" description:"Level of log messages: panic, fatal, error, warn, info, debug or trace; info if not set"`
+	Quiet       bool   `long:"quiet" env:"QUIET" description:"Log only errors, same as error log level"`
+	Version     bool   `long:"version" description:"Print version, git commit and build date and exit"`
 }
 
 func main() {
 	var opts = opts{}
-	if _, err := flags.Parse(&opts); err != nil {
+	parser := flags.NewParser(&opts, flags.Default)
+	parser.LongDescription = exitCodesDescription
+	if _, err := parser.Parse(); err != nil {
 		os.Exit(1)
 	}
 
+	if opts.Version {
+		fmt.Println(versionString(version, commit, buildDate))
+		os.Exit(exitCodeSuccess)
+	}
+
+	if opts.LogFormat == "json" {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	level, err := logLevel(opts.LogLevel, opts.Quiet, opts.Dbg)
+	if err != nil {
+		log.Errorf("Problem with log level: %s", err)
+		os.Exit(1)
+	}
+	log.SetLevel(level)
 	if opts.Dbg {
-		log.SetLevel(log.DebugLevel)
 		log.SetReportCaller(true)
 	}
 
-	log.Infof("Starting account %s adding to cloud security tools", opts.AWS.AccountID)
+	// run ID is attached to all messages to find the ones of a single run in logs aggregator
+	runID, err := newRunID()
+	if err != nil {
+		log.Errorf("Problem generating run ID: %s", err)
+		os.Exit(1)
+	}
+	logger := log.WithField("run_id", runID)
 
-	var result error
+	if opts.AWS.AccountID == "" && opts.Azure.SubscriptionID == "" && opts.GCP.ProjectID == "" &&
+		len(opts.Prisma.Accounts) == 0 && !opts.AWS.OrgMode && !opts.AWS.AllOrgAccounts {
+		logger.Error("Either AWS account ID, Prisma accounts, Azure subscription ID or GCP project ID should be provided, " +
+			"or organization mode enabled")
+		os.Exit(1)
+	}
+	prismaAccounts, err := parsePrismaAccounts(opts.Prisma.Accounts)
+	if err != nil {
+		logger.Errorf("Problem parsing Prisma accounts: %s", err)
+		os.Exit(1)
+	}
+	prismaUpdates, err := prismaUpdatePolicy(opts.Prisma.NoUpdate, opts.Prisma.ForceUpdate)
+	if err != nil {
+		logger.Errorf("Problem with Prisma update flags: %s", err)
+		os.Exit(1)
+	}
+	if opts.AWS.AccountID != "" && !connectors.IsValidAccountID(opts.AWS.AccountID) {
+		logger.Errorf("Invalid AWS account ID %q, it should consist of exactly 12 digits", opts.AWS.AccountID)
+		os.Exit(1)
+	}
+	if opts.AWS.MasterAccountID != "" && !connectors.IsValidAccountID(opts.AWS.MasterAccountID) {
+		logger.Errorf("Invalid master AWS account ID %q, it should consist of exactly 12 digits", opts.AWS.MasterAccountID)
+		os.Exit(1)
+	}
+	if opts.Preflight && opts.Status {
+		logger.Error("Preflight and status modes can't be used together")
+		os.Exit(1)
+	}
+	// nothing is changed in preflight and status modes
+	readOnly := opts.Preflight || opts.Status
 
-	if opts.Prisma.APIKey != "" && opts.Prisma.APIPassword != "" {
-		p := connectors.NewPrisma(opts.Prisma.APIKey, opts.Prisma.APIPassword, opts.Prisma.APIUrl)
-		if err := p.AddAWSAccount(
-			opts.AWS.AccountID,
-			opts.Prisma.AccountName,
-			opts.Prisma.ExternalID,
-			opts.Prisma.RoleName,
-		); err != nil {
-			result = multierror.Append(result,
-				fmt.Errorf("problem adding account to Prisma: %w", err))
+	// services list is merged with the ones enabled by separate flags
+	invitersCfg := connectors.InvitersConfig{
+		GuardDuty:              opts.AWS.GuardDuty,
+		GuardDutyInviteMessage: opts.AWS.InviteMessage,
+		GuardDutyInviteEmails:  opts.AWS.EnableInviteEmails,
+		GuardDutyConnected:     opts.AWS.GuardDutyConnected,
+		CreateMemberDetector:   opts.AWS.CreateMemberDetector,
+		SecurityHub:            opts.AWS.SecurityHub,
+		SuppressInviteEmails:   opts.AWS.SuppressInviteEmails == "true",
+		SecurityHubStandards:   opts.AWS.SecurityHubStandards,
+		SecurityHubConnected:   opts.AWS.SecurityHubConnected,
+		Detective:              opts.AWS.Detective,
+		DetectiveConnected:     opts.AWS.DetectiveConnected,
+		WaitForEnabled:         opts.AWS.WaitForEnabled,
+		WaitForEnabledTimeout:  opts.AWS.WaitTimeout,
+		InvitationAttempts:     opts.AWS.InviteAttempts,
+		InvitationRetryDelay:   opts.AWS.InviteRetryDelay,
+	}
+	if err := invitersCfg.EnableServices(opts.AWS.Services); err != nil {
+		logger.Errorf("Problem parsing services: %s", err)
+		os.Exit(1)
+	}
+	if err := validateTargets(&opts, invitersCfg.Enabled()); err != nil {
+		logger.Error(err)
+		os.Exit(1)
+	}
+	serviceExceptions, err := connectors.ParseServiceRegionExceptions(opts.AWS.ServiceExceptions)
+	if err != nil {
+		logger.Errorf("Problem parsing service region exceptions: %s", err)
+		os.Exit(1)
+	}
+	accountTagFilter, err := connectors.ParseTagFilter(opts.AWS.AccountTagFilter)
+	if err != nil {
+		logger.Errorf("Problem parsing account tag filter: %s", err)
+		os.Exit(1)
+	}
+	if accountTagFilter != nil && !opts.AWS.AllOrgAccounts {
+		logger.Error("Account tag filter can only be used together with all organization accounts connecting")
+		os.Exit(1)
+	}
+	if (len(opts.AWS.AccountInclude) != 0 || len(opts.AWS.AccountExclude) != 0) && !opts.AWS.AllOrgAccounts {
+		logger.Error("Account include and exclude lists can only be used together with all organization accounts connecting")
+		os.Exit(1)
+	}
+	for _, id := range append(append([]string{}, opts.AWS.AccountInclude...), opts.AWS.AccountExclude...) {
+		if !connectors.IsValidAccountID(id) {
+			logger.Errorf("Invalid AWS account ID %q in account include or exclude list, it should consist of exactly 12 digits", id)
+			os.Exit(1)
 		}
 	}
+	if opts.AWS.AccountDelay < 0 || (opts.AWS.AccountDelay > 0 && !opts.AWS.AllOrgAccounts) {
+		logger.Error("Account delay can't be negative and requires all organization accounts to be connected")
+		os.Exit(1)
+	}
+	if (opts.AWS.FindingsBucket == "") != (opts.AWS.FindingsKMSKey == "") {
+		logger.Error("GuardDuty findings bucket and KMS key should be set together")
+		os.Exit(1)
+	}
+	for _, findingsARN := range []string{opts.AWS.FindingsBucket, opts.AWS.FindingsKMSKey} {
+		if _, err := arn.Parse(findingsARN); findingsARN != "" && err != nil {
+			logger.Errorf("Invalid GuardDuty findings export ARN %q: %s", findingsARN, err)
+			os.Exit(1)
+		}
+	}
+	if opts.AWS.ConfigAggregator && (opts.AWS.AggregatorName == "" || opts.AWS.AggregatorRole == "" ||
+		opts.AWS.AggregatorRegion == "") {
+		logger.Error("AWS Config aggregator requires its name, role and region to be set")
+		os.Exit(1)
+	}
+	if _, err := arn.Parse(opts.AWS.AggregatorRole); opts.AWS.ConfigAggregator && err != nil {
+		logger.Errorf("Invalid AWS Config aggregator role ARN %q: %s", opts.AWS.AggregatorRole, err)
+		os.Exit(1)
+	}
+	if opts.AWS.AccountID == "" && !opts.AWS.AllOrgAccounts && (invitersCfg.Enabled() || opts.AWS.EnableOptInRegions) {
+		logger.Error("AWS account ID is required for connecting AWS security services")
+		os.Exit(1)
+	}
 
-	if opts.AWS.GuardDuty || opts.AWS.SecurityHub || opts.AWS.Detective {
-		var masterAccountID string
-		var memberSess client.ConfigProvider
-		var masterSess client.ConfigProvider
+	// in case of all organization accounts processing, emails are taken from the organization
+	emailRequired := !opts.AWS.AllOrgAccounts && !opts.Status && (invitersCfg.GuardDuty || invitersCfg.Detective ||
+		(invitersCfg.SecurityHub && !invitersCfg.SuppressInviteEmails))
+	if err := validateEmail(opts.AWS.Email, emailRequired); err != nil {
+		logger.Errorf("Problem with member account email: %s", err)
+		os.Exit(1)
+	}
 
-		for region := range endpoints.AwsPartition().Regions() {
-			if contains(opts.AWS.RegionExceptions, region) {
-				continue
-			}
+	prismaAPIURL, err := selectPrismaAPIURL(opts.Prisma.APIUrl, opts.Prisma.Region)
+	if err != nil {
+		logger.Errorf("Problem with Prisma API URL: %s", err)
+		os.Exit(1)
+	}
 
-			masterSess, memberSess = connectors.NewMasterMemberSess(region, opts.AWS.AccountID, opts.AWS.RoleName)
+	proxy, err := parseProxy(opts.Proxy)
+	if err != nil {
+		logger.Errorf("Problem with proxy URL: %s", err)
+		os.Exit(1)
+	}
 
-			// retrieve master account ID once
-			if masterAccountID == "" {
-				var err error
-				if masterAccountID, err = connectors.GetAccountID(masterSess); err != nil {
-					result = multierror.Append(result,
-						fmt.Errorf("problem retrieving master account ID, aborting AWS services adding: %w", err))
-					break
-				}
+	if opts.AWS.APIRateLimit < 0 {
+		logger.Errorf("Invalid AWS API rate limit %v, it should not be negative", opts.AWS.APIRateLimit)
+		os.Exit(1)
+	}
+	var rateLimiter *connectors.RateLimiter
+	if opts.AWS.APIRateLimit > 0 {
+		rateLimiter = connectors.NewRateLimiter(opts.AWS.APIRateLimit)
+	}
+
+	if err := validateMemberWebIdentity(&opts, os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")); err != nil {
+		logger.Errorf("Problem with member web identity: %s", err)
+		os.Exit(1)
+	}
+
+	for _, roleARN := range opts.AWS.RoleChain {
+		if _, err := arn.Parse(roleARN); err != nil {
+			logger.Errorf("Problem with role chain ARN: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	for _, secretARN := range []string{opts.Prisma.SecretARN, opts.Prisma.ExtIDSecretARN} {
+		if _, err := arn.Parse(secretARN); secretARN != "" && err != nil {
+			logger.Errorf("Problem with Prisma secret ARN: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	invitersCfg.GuardDutyFeatures, err = connectors.ParseGuardDutyFeatures(opts.AWS.GuardDutyFeatures)
+	if err != nil {
+		logger.Errorf("Problem parsing GuardDuty features: %s", err)
+		os.Exit(1)
+	}
+
+	invitersCfg.GuardDutyFrequency, err = connectors.ParseGuardDutyPublishFrequency(opts.AWS.PublishFrequency)
+	if err != nil {
+		logger.Errorf("Problem parsing GuardDuty finding publishing frequency: %s", err)
+		os.Exit(1)
+	}
+
+	invitersCfg.DisabledControls, err = connectors.ParseSecurityHubDisabledControls(opts.AWS.DisabledControls)
+	if err != nil {
+		logger.Errorf("Problem parsing Security Hub controls to disable: %s", err)
+		os.Exit(1)
+	}
+
+	invitersCfg.DetectivePackages, err = connectors.ParseDetectivePackages(opts.AWS.DetectivePackages)
+	if err != nil {
+		logger.Errorf("Problem parsing Detective data source packages: %s", err)
+		os.Exit(1)
+	}
+
+	regionExceptions := opts.AWS.RegionExceptions
+	if len(opts.AWS.Regions) == 0 && len(regionExceptions) == 0 && !opts.AWS.EnableOptInRegions {
+		regionExceptions = defaultRegionExceptions()
+	}
+	regions, err := selectRegions(opts.Partition, opts.AWS.Regions, regionExceptions)
+	if err != nil {
+		logger.Errorf("Problem selecting regions: %s", err)
+		os.Exit(1)
+	}
+	if opts.AWS.AggregationRegion != "" && (!invitersCfg.SecurityHub || !contains(regions, opts.AWS.AggregationRegion)) {
+		logger.Error("Security Hub aggregation region requires Security Hub to be enabled and the region to be processed")
+		os.Exit(1)
+	}
+	if opts.AWS.ConfigAggregator && !contains(regions, opts.AWS.AggregatorRegion) {
+		logger.Error("AWS Config aggregator region should be one of processed regions")
+		os.Exit(1)
+	}
+	configAggregatorName := ""
+	if opts.AWS.ConfigAggregator {
+		configAggregatorName = opts.AWS.AggregatorName
+	}
+
+	if opts.AWS.AllOrgAccounts {
+		logger.Infof("Starting organization accounts adding to cloud security tools, version %s", version)
+	} else {
+		logger.Infof("Starting account %s adding to cloud security tools, version %s", opts.AWS.AccountID, version)
+	}
+	userAgent := connectors.UserAgent(version)
+	// on SIGINT or SIGTERM the run stops after the current operation, and report of what's done is still written
+	ctx := cancelOnSignal(context.Background(), logger, os.Interrupt, syscall.SIGTERM)
+
+	metrics := connectors.NewMetrics()
+	metrics.SetLogger(logger)
+	var metricsSrv *http.Server
+	if opts.MetricsAddr != "" {
+		if metricsSrv, err = metrics.Serve(opts.MetricsAddr); err != nil {
+			logger.Errorf("Problem starting metrics server: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	var result *multierror.Error
+	// attempted is a number of operations which could fail, used to tell total failure from partial one
+	var attempted int
+	// Prisma results are put into the report of the AWS account once AWS services are connected
+	prismaReport := connectors.NewReport(opts.AWS.AccountID)
+
+	// secrets are read with master account credentials in the region they're stored in
+	secretSess := func(secretARN string) *session.Session {
+		parsed, _ := arn.Parse(secretARN) // validated already
+		return connectors.NewMasterSess(connectors.SessionConfig{
+			Region:      parsed.Region,
+			Profile:     opts.AWS.Profile,
+			Proxy:       proxy,
+			UserAgent:   userAgent,
+			RateLimiter: rateLimiter,
+		})
+	}
+	if opts.Prisma.SecretARN != "" && !opts.Status {
+		secret, err := connectors.LoadPrismaSecret(secretSess(opts.Prisma.SecretARN), opts.Prisma.SecretARN)
+		if err != nil {
+			attempted++
+			result = multierror.Append(result, fmt.Errorf("problem loading Prisma API credentials from secret: %w", err))
+			opts.Prisma.APIKey, opts.Prisma.APIPassword = "", ""
+		} else {
+			opts.Prisma.APIKey, opts.Prisma.APIPassword = secret.APIKey, secret.APIPassword
+		}
+	}
+
+	// Prisma has no member status to report
+	if opts.Prisma.APIKey != "" && opts.Prisma.APIPassword != "" && !opts.Status {
+		logger.Infof("Creating Prisma connection using API key %s", opts.Prisma.APIKey)
+		p := connectors.NewPrismaWithContext(ctx, opts.Prisma.APIKey, opts.Prisma.APIPassword, prismaAPIURL, userAgent, opts.Prisma.MaxRetries, opts.Prisma.Timeout, proxy)
+		if err := p.SetAPIVersion(opts.Prisma.APIVersion); err != nil {
+			logger.Errorf("Problem setting Prisma API version: %s", err)
+			os.Exit(1)
+		}
+		p.SetUpdatePolicy(prismaUpdates)
+		p.SetLogger(logger)
+		if opts.Preflight {
+			attempted++
+			err := p.Preflight()
+			prismaReport.Add("prisma", "global", connectors.Result{Status: connectors.StatusChecked}, err)
+			if err != nil {
+				result = multierror.Append(result, fmt.Errorf("preflight check of Prisma failed: %w", err))
 			}
+		}
+		// external ID is resolved once for all AWS accounts, so that its secret is read once
+		// and the same ID is used for all of them even if the secret is rotated during the run
+		addAccount := opts.AWS.AccountID != "" && !readOnly
+		addAccounts := len(prismaAccounts) > 0 && !readOnly && !opts.Prisma.CheckOnly
+		var externalID string
+		if addAccount || addAccounts {
+			var err error
+			externalID, err = resolveExternalID(opts.Prisma.ExternalID, opts.Prisma.ExtIDFile, opts.Prisma.ExtIDSecretARN,
+				func(secretARN string) (string, error) {
+					return connectors.LoadSecretString(secretSess(secretARN), secretARN)
+				})
+			if err != nil {
+				attempted++
+				result = multierror.Append(result, fmt.Errorf("problem resolving Prisma external ID: %w", err))
+				addAccount, addAccounts = false, false
+			}
+		}
 
-			if opts.AWS.GuardDuty {
-				g := connectors.NewGuardDutyInviter(masterSess, memberSess)
-				if err := g.AddMember(opts.AWS.AccountID, opts.AWS.Email, masterAccountID); err != nil {
-					result = multierror.Append(result,
-						fmt.Errorf("problem adding member account to AWS GuardDuty in %s: %w", region, err))
+		if addAccount {
+			attempted++
+			if opts.Prisma.CheckOnly {
+				drift, err := p.CheckAWSAccount(connectors.AWSAccountSpec{
+					AccountID:      opts.AWS.AccountID,
+					Partition:      opts.Partition,
+					Name:           opts.Prisma.AccountName,
+					ExternalID:     externalID,
+					RoleName:       opts.Prisma.RoleName,
+					GroupIDs:       opts.Prisma.GroupIDs,
+					ProtectionMode: opts.Prisma.ProtectionMode,
+				})
+				prismaReport.PrismaDrift = drift
+				if err == nil && len(drift) > 0 {
+					err = fmt.Errorf("fields differ from desired: %s", strings.Join(drift, ", "))
 				}
+				if err != nil {
+					result = multierror.Append(result, fmt.Errorf("problem checking account in Prisma: %w", err))
+				} else {
+					logger.Info("Prisma account matches desired settings")
+				}
+			} else if err := p.AddAWSAccount(
+				opts.AWS.AccountID,
+				opts.Partition,
+				opts.Prisma.AccountName,
+				externalID,
+				opts.Prisma.RoleName,
+				opts.Prisma.GroupIDs,
+				opts.Prisma.ProtectionMode,
+			); err != nil {
+				result = multierror.Append(result,
+					fmt.Errorf("problem adding account to Prisma: %w", err))
+			} else {
+				// status is informational, so problem getting it doesn't fail the run
+				status, err := p.GetAWSAccountStatus(opts.AWS.AccountID)
+				switch {
+				case err != nil:
+					logger.Warnf("Problem getting account status from Prisma: %s", err)
+				case status != connectors.PrismaAccountStatusOK:
+					logger.Warnf("Account status in Prisma is %s", status)
+				}
+				prismaReport.PrismaStatus = status
 			}
+		}
 
-			if opts.AWS.SecurityHub {
-				s := connectors.NewSecurityHubInviter(masterSess, memberSess)
-				if err := s.AddMember(opts.AWS.AccountID, opts.AWS.Email, masterAccountID); err != nil {
-					result = multierror.Append(result,
-						fmt.Errorf("problem adding member account to AWS Security Hub in %s: %w", region, err))
-				}
+		if addAccounts {
+			attempted++
+			for i := range prismaAccounts {
+				prismaAccounts[i].Partition = opts.Partition
+				prismaAccounts[i].ExternalID = externalID
+				prismaAccounts[i].RoleName = opts.Prisma.RoleName
+				prismaAccounts[i].GroupIDs = opts.Prisma.GroupIDs
+				prismaAccounts[i].ProtectionMode = opts.Prisma.ProtectionMode
 			}
+			if err := p.AddAWSAccounts(prismaAccounts); err != nil {
+				result = multierror.Append(result, fmt.Errorf("problem adding accounts to Prisma: %w", err))
+			}
+		}
 
-			if opts.AWS.Detective {
-				d := connectors.NewDetectiveInviter(masterSess, memberSess)
-				if err := d.AddMember(opts.AWS.AccountID, opts.AWS.Email, masterAccountID); err != nil {
-					result = multierror.Append(result,
-						fmt.Errorf("problem adding member account to AWS Detective in %s: %w", region, err))
-				}
+		if opts.Azure.SubscriptionID != "" && !readOnly && !opts.Prisma.CheckOnly {
+			attempted++
+			if err := p.AddAzureAccount(
+				opts.Azure.SubscriptionID,
+				opts.Azure.AccountName,
+				opts.Azure.TenantID,
+				opts.Azure.ClientID,
+				opts.Azure.Key,
+				opts.Azure.ServicePrincipalID,
+				opts.Azure.MonitorFlowLogs,
+			); err != nil {
+				result = multierror.Append(result,
+					fmt.Errorf("problem adding Azure subscription to Prisma: %w", err))
 			}
 		}
+
+		if opts.GCP.ProjectID != "" && !readOnly && !opts.Prisma.CheckOnly {
+			attempted++
+			if err := addGCPAccount(p, opts.GCP.ProjectID, opts.GCP.AccountName, opts.GCP.CredentialsFile,
+				opts.GCP.CompressionEnabled, opts.GCP.DataflowProject, opts.GCP.FlowLogBucket); err != nil {
+				result = multierror.Append(result,
+					fmt.Errorf("problem adding GCP project to Prisma: %w", err))
+			}
+		}
+	}
+
+	onboardReport, err := connectors.Onboard(ctx, connectors.Config{
+		AccountID:            opts.AWS.AccountID,
+		Email:                opts.AWS.Email,
+		MasterAccountID:      opts.AWS.MasterAccountID,
+		AllOrgAccounts:       opts.AWS.AllOrgAccounts,
+		OrgManagementProfile: opts.AWS.OrgManagementProfile,
+		AccountTagFilter:     accountTagFilter,
+		AccountFilter:        connectors.AccountFilter{Include: opts.AWS.AccountInclude, Exclude: opts.AWS.AccountExclude},
+		AccountDelay:         opts.AWS.AccountDelay,
+		Regions:              regions,
+		OnlyEnabledRegions:   opts.AWS.OnlyEnabledRegions,
+		Session: connectors.SessionConfig{
+			Partition:         opts.Partition,
+			Profile:           opts.AWS.Profile,
+			MemberRole:        opts.AWS.RoleName,
+			RoleSessionName:   opts.AWS.RoleSessionName,
+			RoleDuration:      opts.AWS.RoleDuration,
+			ExternalID:        opts.AWS.MemberExternalID,
+			MFASerial:         opts.AWS.MFASerial,
+			MemberWebIdentity: opts.AWS.MemberWebIdentity,
+			RoleChain:         opts.AWS.RoleChain,
+			Endpoint:          opts.AWS.Endpoint,
+			Proxy:             proxy,
+			UserAgent:         userAgent,
+			RateLimiter:       rateLimiter,
+		},
+		Inviters:                invitersCfg,
+		ServiceRegionExceptions: serviceExceptions,
+		Preflight:               opts.Preflight,
+		Status:                  opts.Status,
+		FailFast:                opts.FailFast,
+		OrgMode:                 opts.AWS.OrgMode,
+		OrgDefaultStandards:     opts.AWS.OrgDefaultStandards,
+		GuardDutyFindingsBucket: opts.AWS.FindingsBucket,
+		GuardDutyFindingsKMSKey: opts.AWS.FindingsKMSKey,
+		EnableOptInRegions:      opts.AWS.EnableOptInRegions,
+		OptInTimeout:            opts.AWS.OptInTimeout,
+		VerifyMemberAccount:     opts.AWS.VerifyMemberAccount == "true",
+		AggregationRegion:       opts.AWS.AggregationRegion,
+		ConfigAggregatorName:    configAggregatorName,
+		ConfigAggregatorRegion:  opts.AWS.AggregatorRegion,
+		ConfigAggregatorRole:    opts.AWS.AggregatorRole,
+		Metrics:                 metrics,
+		Logger:                  logger,
+	})
+	attempted += onboardReport.Attempted
+	if err != nil {
+		result = multierror.Append(result, err)
 	}
+	reports := mergeReport(onboardReport.Accounts, prismaReport)
 
+	if opts.ReportFile != "" {
+		attempted++
+		var err error
+		if opts.AWS.AllOrgAccounts {
+			err = connectors.WriteReportsFile(opts.ReportFile, reports)
+		} else {
+			err = reports[0].WriteFile(opts.ReportFile)
+		}
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("problem writing report: %w", err))
+		}
+	}
+
+	if metricsSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			logger.Warnf("Problem shutting down metrics server: %s", err)
+		}
+		cancel()
+	}
+
+	logger.Infof("%s, run ID %s", connectors.SummarizeReports(reports), runID)
 	if result != nil {
-		log.Errorf("Problem(s) with adding member account to security tools:\n%s", result)
-		os.Exit(3)
+		logger.Errorf("Problem(s) with adding member account to security tools:\n%s", result)
+		os.Exit(exitCode(len(result.Errors), attempted))
+	}
+	logger.Info("Done without errors")
+}
+
+// newRunID returns random UUID (version 4) identifying the run in logs
+func newRunID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error reading random bytes: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// cancelOnSignal returns context which is cancelled once one of provided signals is received,
+// which is logged with provided logger
+func cancelOnSignal(parent context.Context, logger *log.Entry, signals ...os.Signal) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	go func() {
+		select {
+		case sig := <-ch:
+			logger.Warnf("Received %s, stopping after current operation", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(ch)
+	}()
+	return ctx
+}
+
+// mergeReport puts entries and Prisma status of provided report into the report of the same account from the list,
+// the report is appended to the list in case there is no such account there and the report is not empty
+func mergeReport(reports []*connectors.Report, report *connectors.Report) []*connectors.Report {
+	for _, r := range reports {
+		if r.AccountID != report.AccountID {
+			continue
+		}
+		for service, regions := range report.Services {
+			for region, entry := range regions {
+				if _, ok := r.Services[service]; !ok {
+					r.Services[service] = map[string]connectors.ReportRegionEntry{}
+				}
+				r.Services[service][region] = entry
+			}
+		}
+		if report.PrismaStatus != "" {
+			r.PrismaStatus = report.PrismaStatus
+		}
+		if report.PrismaDrift != nil {
+			r.PrismaDrift = report.PrismaDrift
+		}
+		return reports
+	}
+	if len(report.Services) == 0 && report.PrismaStatus == "" && report.PrismaDrift == nil {
+		return reports
+	}
+	return append(reports, report)
+}
+
+// exitCode returns process exit code for provided numbers of failed and attempted operations
+func exitCode(failed, attempted int) int {
+	switch {
+	case failed == 0:
+		return exitCodeSuccess
+	case failed >= attempted:
+		return exitCodeFailure
+	default:
+		return exitCodePartialFailure
+	}
+}
+
+// addGCPAccount reads GCP service account credentials from provided file and adds GCP project to Prisma
+func addGCPAccount(p *connectors.Prisma, projectID, name, credentialsFile string,
+	compressionEnabled bool, dataflowProject, flowLogBucket string) error {
+	credentials, err := os.ReadFile(credentialsFile) // nolint:gosec
+	if err != nil {
+		return fmt.Errorf("error reading GCP credentials file: %w", err)
+	}
+	return p.AddGCPAccount(projectID, name, credentials, compressionEnabled, dataflowProject, flowLogBucket)
+}
+
+// partition returns AWS partition for provided partition ID
+func partition(id string) endpoints.Partition {
+	switch id {
+	case endpoints.AwsUsGovPartitionID:
+		return endpoints.AwsUsGovPartition()
+	case endpoints.AwsCnPartitionID:
+		return endpoints.AwsCnPartition()
+	default:
+		return endpoints.AwsPartition()
+	}
+}
+
+// parsePrismaAccounts parses AWS accounts to add to Prisma in account_id[:name] format,
+// returning error in case of invalid account ID
+func parsePrismaAccounts(specs []string) ([]connectors.AWSAccountSpec, error) {
+	var accounts []connectors.AWSAccountSpec
+	for _, spec := range specs {
+		parts := strings.SplitN(strings.TrimSpace(spec), ":", 2)
+		acc := connectors.AWSAccountSpec{AccountID: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			acc.Name = strings.TrimSpace(parts[1])
+		}
+		if !connectors.IsValidAccountID(acc.AccountID) {
+			return nil, fmt.Errorf("invalid AWS account ID %q, it should consist of exactly 12 digits", acc.AccountID)
+		}
+		accounts = append(accounts, acc)
+	}
+	return accounts, nil
+}
+
+// validateTargets returns error in case the run has nothing to do: neither AWS service is enabled, nor AWS Config
+// aggregator is configured, nor Prisma credentials are provided. Opt-in regions are enabled only for accounts
+// connected to services, the aggregator is not configured in preflight and status modes, and Prisma is not used
+// in status mode.
+func validateTargets(o *opts, invitersEnabled bool) error {
+	if invitersEnabled {
+		return nil
+	}
+	if o.Status {
+		return fmt.Errorf("nothing to check: enable at least one AWS service with --aws.services, " +
+			"--aws.guardduty, --aws.security_hub or --aws.detective")
+	}
+	if o.AWS.ConfigAggregator && !o.Preflight {
+		return nil
+	}
+	apiKey, apiPassword := o.Prisma.APIKey, o.Prisma.APIPassword
+	if o.Prisma.SecretARN != "" || apiKey != "" && apiPassword != "" {
+		return nil
+	}
+	if apiKey != "" || apiPassword != "" {
+		return fmt.Errorf("both Prisma API key and password should be provided")
+	}
+	return fmt.Errorf("nothing to do: enable at least one AWS service with --aws.services, --aws.guardduty, " +
+		"--aws.security_hub or --aws.detective, AWS Config aggregator with --aws.config_aggregator, " +
+		"or provide both Prisma API key and password")
+}
+
+// validateMemberWebIdentity returns error in case member web identity is enabled without web identity token file,
+// or together with options of assuming member role from master account credentials, which would be ignored
+func validateMemberWebIdentity(o *opts, tokenFile string) error {
+	if !o.AWS.MemberWebIdentity {
+		return nil
+	}
+	if tokenFile == "" {
+		return fmt.Errorf("AWS_WEB_IDENTITY_TOKEN_FILE should be set")
+	}
+	if len(o.AWS.RoleChain) > 0 || o.AWS.MemberExternalID != "" || o.AWS.MFASerial != "" {
+		return fmt.Errorf("it can't be used with role chain, member external ID or MFA")
+	}
+	return nil
+}
+
+// validateEmail returns error in case provided email is set but malformed, or is not set while required
+func validateEmail(email string, required bool) error {
+	if email == "" {
+		if required {
+			return fmt.Errorf("email is required for inviting member account to GuardDuty, Detective or Security Hub with emails sent")
+		}
+		return nil
 	}
-	log.Info("Done without errors")
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return fmt.Errorf("invalid email %q", email)
+	}
+	return nil
+}
+
+// resolveExternalID returns Prisma external ID from the first source which is set: explicit value,
+// file or AWS Secrets Manager secret read with provided function. Empty ID is returned if none is set.
+func resolveExternalID(externalID, file, secretARN string, loadSecret func(string) (string, error)) (string, error) {
+	switch {
+	case externalID != "":
+		return externalID, nil
+	case file != "":
+		b, err := os.ReadFile(file) // nolint:gosec
+		if err != nil {
+			return "", fmt.Errorf("error reading external ID file: %w", err)
+		}
+		if externalID = strings.TrimSpace(string(b)); externalID == "" {
+			return "", fmt.Errorf("external ID file %s is empty", file)
+		}
+	case secretARN != "":
+		value, err := loadSecret(secretARN)
+		if err != nil {
+			return "", fmt.Errorf("error reading external ID secret: %w", err)
+		}
+		if externalID = strings.TrimSpace(value); externalID == "" {
+			return "", fmt.Errorf("external ID secret %s is empty", secretARN)
+		}
+	}
+	return externalID, nil
+}
+
+// selectPrismaAPIURL returns explicitly provided Prisma API URL, or the one of provided region,
+// or default one in case neither is set. Region is validated even if it's not used.
+func selectPrismaAPIURL(apiURL, region string) (string, error) {
+	if region != "" {
+		regionURL, err := connectors.PrismaAPIURL(region)
+		if err != nil {
+			return "", err
+		}
+		if apiURL == "" {
+			return regionURL, nil
+		}
+	}
+	if apiURL == "" {
+		return connectors.PrismaDefaultAPIURL, nil
+	}
+	return apiURL, nil
+}
+
+// parseProxy returns parsed proxy URL, or nil in case it's empty
+func parseProxy(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("%q should be an absolute URL, e.g. http://proxy:3128", proxy)
+	}
+	return u, nil
+}
+
+// prismaUpdatePolicy returns policy of updating existing Prisma accounts set by flags, which can't be set together
+func prismaUpdatePolicy(noUpdate, forceUpdate bool) (connectors.PrismaUpdatePolicy, error) {
+	switch {
+	case noUpdate && forceUpdate:
+		return connectors.PrismaUpdateChanged, fmt.Errorf("no update and force update can't be set at the same time")
+	case noUpdate:
+		return connectors.PrismaUpdateNever, nil
+	case forceUpdate:
+		return connectors.PrismaUpdateAlways, nil
+	default:
+		return connectors.PrismaUpdateChanged, nil
+	}
+}
+
+// logLevel returns level of log messages set by flags, only one of which can be set:
+// level name, quiet mode for errors only or debug mode
+func logLevel(level string, quiet, dbg bool) (log.Level, error) {
+	set := 0
+	for _, isSet := range []bool{level != "", quiet, dbg} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return log.InfoLevel, fmt.Errorf("only one of log level, quiet and debug modes can be set")
+	}
+
+	switch {
+	case quiet:
+		return log.ErrorLevel, nil
+	case dbg:
+		return log.DebugLevel, nil
+	case level == "":
+		return log.InfoLevel, nil
+	}
+	parsed, err := log.ParseLevel(level)
+	if err != nil {
+		return log.InfoLevel, fmt.Errorf("invalid log level %q, it should be one of panic, fatal, error, warn, info, debug or trace", level)
+	}
+	return parsed, nil
+}
+
+// versionString returns human-readable build metadata printed with --version
+func versionString(version, commit, buildDate string) string {
+	return fmt.Sprintf("aws-security-connectors %s, commit %s, built %s", version, commit, buildDate)
+}
+
+// selectRegions returns sorted list of regions of provided partition to process: either only included ones,
+// or all except excluded ones. Included regions should belong to the partition, while excluded ones
+// could belong to any partition, as default exceptions are shared by all of them.
+func selectRegions(partitionID string, include, exclude []string) ([]string, error) {
+	if len(include) > 0 && len(exclude) > 0 {
+		return nil, fmt.Errorf("regions and region exceptions can't be set at the same time")
+	}
+	if !isKnownPartition(partitionID) {
+		return nil, fmt.Errorf("unknown partition %q", partitionID)
+	}
+	for _, region := range exclude {
+		if !isKnownRegion(region) {
+			return nil, fmt.Errorf("region exception %q is not present in any partition", region)
+		}
+	}
+
+	available := partition(partitionID).Regions()
+	var regions []string
+	if len(include) > 0 {
+		for _, region := range include {
+			if _, ok := available[region]; !ok {
+				return nil, fmt.Errorf("region %q is not present in partition %q", region, partitionID)
+			}
+			if !contains(regions, region) {
+				regions = append(regions, region)
+			}
+		}
+	} else {
+		for region := range available {
+			if !contains(exclude, region) {
+				regions = append(regions, region)
+			}
+		}
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
+
+// isKnownPartition returns if provided ID is one of AWS partitions known to SDK
+func isKnownPartition(id string) bool {
+	for _, p := range endpoints.DefaultPartitions() {
+		if p.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isKnownRegion returns if provided region belongs to any of AWS partitions known to SDK
+func isKnownRegion(region string) bool {
+	for _, p := range endpoints.DefaultPartitions() {
+		if _, ok := p.Regions()[region]; ok {
+			return true
+		}
+	}
+	return false
 }
 
 func contains(s []string, e string) bool {